@@ -0,0 +1,111 @@
+package prism
+
+import (
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/lut"
+)
+
+// DefaultSize is the points per axis of the CUBEs HALDs and LUTs of
+// registered formats are sampled into, unless told otherwise.
+const DefaultSize = 33
+
+// BlendOptions are the parameters of Blend. The zero value blends with
+// the normal mode, mixing in RGB.
+type BlendOptions struct {
+	// Mode is the blend mode.
+	Mode cube.Mode
+	// Mix is the space the normal mode mixes colors in.
+	Mix lut.Mix
+	// Size is the points per axis of the blended CUBE. If zero, CUBEs of
+	// the same size and domain are blended as they are, and other LUTs
+	// are sampled on a lattice of DefaultSize points, grown to that of
+	// the largest CUBE blended.
+	Size int
+	// Workers is the number of goroutines the LUTs are sampled and
+	// blended on, GOMAXPROCS if zero. The blended CUBE keeps it.
+	Workers int
+}
+
+// Blend blends the LUTs, of any format, into a CUBE. With the normal mode
+// they are weighted by the weights; with the other modes each LUT is
+// blended in turn over the result of the previous ones, with its weight
+// as opacity. Unless they are all CUBEs of the same size and domain,
+// and opt.Size is zero or that size, the LUTs are sampled onto the
+// lattice of the blended size over the unit domain first.
+func Blend(luts []lut.LUT, weights []float64, opt BlendOptions) (cube.Cube, error) {
+	if len(luts) == 0 {
+		return cube.Cube{}, cube.ErrEmptyLut
+	}
+	if cubes, ok := sameLattice(luts); ok && (opt.Size == 0 || opt.Size == cubes[0].LUT3Dsize) {
+		for i := range cubes {
+			cubes[i].Workers = opt.Workers
+		}
+		return BlendCubes(cubes, weights, opt.Mode, opt.Mix)
+	}
+
+	size := opt.Size
+	if size == 0 {
+		size = DefaultSize
+		for _, l := range luts {
+			if c, ok := l.(cube.Cube); ok {
+				size = max(size, c.LUT3Dsize)
+			}
+		}
+	}
+
+	unit := cube.Cube{LUT3Dsize: size, DomainMax: cube.Sample{R: 1, G: 1, B: 1}, Workers: opt.Workers}
+	cubes := make([]cube.Cube, len(luts))
+	for i, l := range luts {
+		c, ok := l.(cube.Cube)
+		if !ok || c.LUT3Dsize != size || c.DomainMin != unit.DomainMin || c.DomainMax != unit.DomainMax {
+			c = unit.Resample(l)
+		}
+		c.Workers = opt.Workers
+		cubes[i] = c
+	}
+	return BlendCubes(cubes, weights, opt.Mode, opt.Mix)
+}
+
+// sameLattice returns the LUTs as CUBEs if they all are CUBEs of the same
+// size and domain.
+func sameLattice(luts []lut.LUT) ([]cube.Cube, bool) {
+	cubes := make([]cube.Cube, len(luts))
+	for i, l := range luts {
+		c, ok := l.(cube.Cube)
+		if !ok {
+			return nil, false
+		}
+		first := cubes[0]
+		if i > 0 && (c.LUT3Dsize != first.LUT3Dsize || c.DomainMin != first.DomainMin || c.DomainMax != first.DomainMax) {
+			return nil, false
+		}
+		cubes[i] = c
+	}
+	return cubes, true
+}
+
+// BlendCubes blends the cubes as Blend does, without resampling them.
+func BlendCubes(cubes []cube.Cube, weights []float64, mode cube.Mode, mix lut.Mix) (cube.Cube, error) {
+	if mode == cube.ModeNormal {
+		c, err := cube.BlendAllIn(cubes, weights, mix)
+		if err != nil {
+			return cube.Cube{}, err
+		}
+		return *c, nil
+	}
+	if len(cubes) == 0 {
+		return cube.Cube{}, cube.ErrEmptyLut
+	}
+	if len(weights) != len(cubes) {
+		return cube.Cube{}, cube.ErrInvalidWeights
+	}
+
+	// BlendMode blends in place.
+	blended := cubes[0].Clone()
+	for i, c := range cubes[1:] {
+		if _, err := blended.BlendMode(c, mode, weights[i+1]); err != nil {
+			return cube.Cube{}, err
+		}
+	}
+	return blended, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isArchive reports whether the argument p is a ZIP archive, told by its
+// extension.
+func isArchive(p string) bool {
+	return strings.EqualFold(path.Ext(p), ".zip")
+}
+
+// archiveImages returns the images in the ZIP archive at p, downloading
+// it if it is a URL or an object, keyed by their path in the archive.
+// The returned function closes the archive and removes the download.
+func archiveImages(p string) ([]batchItem, func(), error) {
+	local, release, err := localImage(p)
+	if err != nil {
+		return nil, release, err
+	}
+	zr, err := zip.OpenReader(local)
+	if err != nil {
+		release()
+		return nil, func() {}, parseError(err)
+	}
+	closeArchive := func() {
+		zr.Close()
+		release()
+	}
+
+	var items []batchItem
+	for _, f := range zr.File {
+		name := path.Base(f.Name)
+		switch {
+		case f.FileInfo().IsDir(),
+			// Entries escaping the archive would be written outside of
+			// the output directory.
+			!filepath.IsLocal(filepath.FromSlash(f.Name)),
+			// Resource forks of archives made on macOS.
+			strings.HasPrefix(f.Name, "__MACOSX/"), strings.HasPrefix(name, "._"),
+			!imageExts[strings.ToLower(path.Ext(name))], strings.Contains(name, ".prism."):
+			continue
+		}
+		items = append(items, batchItem{
+			path:    p + "/" + f.Name,
+			rel:     filepath.FromSlash(f.Name),
+			archive: p,
+			entry:   f,
+		})
+	}
+	return items, closeArchive, nil
+}
+
+// extractImage extracts the image of the archive entry f to a temporary
+// directory, under its name. The returned function removes it.
+func extractImage(f *zip.File) (string, func(), error) {
+	// The reader fails on entries larger than they claim to be.
+	if f.UncompressedSize64 > maxImageFetch {
+		return "", func() {}, exitError{exitIO, fmt.Errorf("%s: larger than %d MiB", f.Name, maxImageFetch>>20)}
+	}
+	dir, err := os.MkdirTemp("", "prism-")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	r, err := f.Open()
+	if err != nil {
+		cleanup()
+		return "", func() {}, parseError(err)
+	}
+	defer r.Close()
+
+	p := filepath.Join(dir, path.Base(f.Name))
+	w, err := os.Create(p)
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, parseError(err)
+	}
+	return p, cleanup, nil
+}
+
+// archiveOutput returns the archive the images of the archive at p are
+// graded to by default: NAME.prism.zip next to it.
+func archiveOutput(p string) string {
+	name := filepath.Base(p)
+	if isRemote(p) {
+		name = cmp.Or(urlBase(p), "images.zip")
+	}
+	return outputPath(inputDir(p), defaultOutput(name, ""))
+}
+
+// archiveEntry returns the name in the output archive of the output of
+// the image at rel, which keeps its path. Raw files are rendered to PNG.
+func archiveEntry(rel string) string {
+	name := filepath.ToSlash(rel)
+	if ext := path.Ext(name); strings.ToLower(ext) == ".dng" {
+		name = strings.TrimSuffix(name, ext) + ".png"
+	}
+	return name
+}
+
+// archiveWriter writes the outputs of a batch run to a ZIP archive, a
+// file or an object, which replaces any previous one once written whole.
+type archiveWriter struct {
+	path   string
+	tmp    string
+	f      *os.File
+	zw     *zip.Writer
+	commit func(ok bool) error
+}
+
+// createArchive starts the archive at dest.
+func createArchive(dest string) (*archiveWriter, error) {
+	local, commit, err := stageOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	tmp := local
+	if !isObjectURI(dest) {
+		if err := makeOutputDir(dest); err != nil {
+			return nil, err
+		}
+		tmp = local + ".tmp"
+	}
+	f, err := os.Create(tmp)
+	if err != nil {
+		commit(false)
+		return nil, err
+	}
+	return &archiveWriter{path: dest, tmp: tmp, f: f, zw: zip.NewWriter(f), commit: commit}, nil
+}
+
+// stage returns the temporary path the output named name in the archive
+// is written to, as stageOutput does. The returned function adds it to
+// the archive if ok and removes it.
+func (a *archiveWriter) stage(name string) (string, func(ok bool) error, error) {
+	dir, err := os.MkdirTemp("", "prism-")
+	if err != nil {
+		return "", nil, err
+	}
+	p := filepath.Join(dir, path.Base(name))
+
+	return p, func(ok bool) error {
+		defer os.RemoveAll(dir)
+		if !ok {
+			return nil
+		}
+		return a.add(name, p)
+	}, nil
+}
+
+// add writes the file at src to the archive as name. JPEG, PNG and GIF
+// outputs are compressed already and stored as they are.
+func (a *archiveWriter) add(name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Store
+	if ext := strings.ToLower(path.Ext(name)); ext == ".tif" || ext == ".tiff" {
+		hdr.Method = zip.Deflate
+	}
+	w, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// close finishes the archive and, if ok, puts it in place. Otherwise it
+// is discarded, leaving any previous archive untouched.
+func (a *archiveWriter) close(ok bool) error {
+	err := a.zw.Close()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	ok = ok && err == nil
+	if !isObjectURI(a.path) {
+		if ok {
+			err = os.Rename(a.tmp, a.path)
+		} else {
+			os.Remove(a.tmp)
+		}
+	}
+	if cerr := a.commit(ok && err == nil); err == nil {
+		err = cerr
+	}
+	return err
+}
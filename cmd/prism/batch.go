@@ -0,0 +1,517 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/NicoNex/prism/exif"
+	"github.com/NicoNex/prism/lut"
+)
+
+// imageExts are the extensions of the images picked up in directories.
+var imageExts = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".tif":  true,
+	".tiff": true,
+	".dng":  true,
+}
+
+// isBatch reports whether the apply arguments select a batch run, that
+// is more than one image, a directory, an object prefix or an archive.
+func isBatch(paths []string) bool {
+	if len(paths) > 1 || isObjectPrefix(paths[0]) || isArchive(paths[0]) {
+		return true
+	}
+	fi, err := os.Stat(paths[0])
+	return err == nil && fi.IsDir()
+}
+
+// batchItem is an image of a batch run.
+type batchItem struct {
+	path    string
+	rel     string    // path relative to the directory argument it was found in
+	archive string    // archive argument it was found in, if any
+	entry   *zip.File // entry of the archive
+}
+
+// local returns the path of the image, downloading or extracting it if
+// needed, as localImage does.
+func (it batchItem) local() (string, func(), error) {
+	if it.entry != nil {
+		return extractImage(it.entry)
+	}
+	return localImage(it.path)
+}
+
+// collectImages expands the directories and archives in paths to the
+// images they contain, skipping the outputs of previous runs. The
+// returned function closes the archives.
+func collectImages(paths []string) ([]batchItem, func(), error) {
+	var (
+		items   []batchItem
+		closers []func()
+	)
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	for _, root := range paths {
+		if isArchive(root) {
+			imgs, c, err := archiveImages(root)
+			if err != nil {
+				closeAll()
+				return nil, func() {}, err
+			}
+			items = append(items, imgs...)
+			closers = append(closers, c)
+			continue
+		}
+		if o, ok := parseObjectURI(root); ok && o.isPrefix() {
+			objs, err := listObjects(o)
+			if err != nil {
+				closeAll()
+				return nil, func() {}, err
+			}
+			for _, obj := range objs {
+				name := path.Base(obj.key)
+				if imageExts[strings.ToLower(path.Ext(name))] && !strings.Contains(name, ".prism.") {
+					rel := filepath.FromSlash(strings.TrimPrefix(obj.key, o.key))
+					items = append(items, batchItem{path: obj.String(), rel: rel})
+				}
+			}
+			continue
+		}
+		if isRemote(root) {
+			items = append(items, batchItem{path: root, rel: cmp.Or(urlBase(root), "image")})
+			continue
+		}
+		// Files that cannot be read are reported when processed.
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			items = append(items, batchItem{path: root, rel: filepath.Base(root)})
+			continue
+		}
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name := d.Name()
+			if !imageExts[strings.ToLower(filepath.Ext(name))] || strings.Contains(name, ".prism.") {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			items = append(items, batchItem{path: path, rel: rel})
+			return nil
+		})
+		if err != nil {
+			closeAll()
+			return nil, func() {}, err
+		}
+	}
+	return items, closeAll, nil
+}
+
+// imageFilter selects the images graded in batch runs, so that
+// thumbnails, previews and screenshots sharing a folder with photos are
+// left alone.
+type imageFilter struct {
+	minSize         int
+	maxAspect       float64
+	skipScreenshots bool
+}
+
+// skip returns the reason to skip the image at path, or an empty string
+// if the image passes the filter.
+func (flt imageFilter) skip(path string) (string, error) {
+	if flt.skipScreenshots && screenshotName(path) {
+		return "screenshot", nil
+	}
+	// Raw files are camera photos by definition.
+	if strings.ToLower(filepath.Ext(path)) == ".dng" {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+
+	short, long := min(cfg.Width, cfg.Height), max(cfg.Width, cfg.Height)
+	if short < flt.minSize {
+		return fmt.Sprintf("too small (%dx%d)", cfg.Width, cfg.Height), nil
+	}
+	if flt.maxAspect > 0 && short > 0 && float64(long)/float64(short) > flt.maxAspect {
+		return fmt.Sprintf("aspect ratio %.2f exceeds %.2f", float64(long)/float64(short), flt.maxAspect), nil
+	}
+
+	if flt.skipScreenshots {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		ok, err := screenshotMetadata(f, format)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return "screenshot", nil
+		}
+	}
+	return "", nil
+}
+
+// screenshotName reports whether the file name is the default one of a
+// screenshot tool.
+func screenshotName(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	return strings.HasPrefix(name, "screenshot") || strings.HasPrefix(name, "screen shot")
+}
+
+// screenshotMetadata reports whether the metadata of the image mentions
+// a screenshot, as written by the screenshot tools of the major desktop
+// and mobile platforms in the EXIF Software or UserComment tags, PNG
+// text chunks or XMP packets.
+func screenshotMetadata(r io.Reader, format string) (bool, error) {
+	var (
+		meta []byte
+		err  error
+	)
+	switch format {
+	case "jpeg":
+		meta, err = exif.Extract(r)
+		if errors.Is(err, exif.ErrNoExif) {
+			return false, nil
+		}
+	case "png":
+		meta, err = pngText(r)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(bytes.ToLower(meta), []byte("screenshot")), nil
+}
+
+// pngText returns the contents of the text chunks preceding the image
+// data of a PNG stream, decompressed and joined together.
+func pngText(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	if _, err := br.Discard(8); err != nil {
+		return nil, err
+	}
+
+	var text []byte
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[:4])
+		typ := string(hdr[4:])
+
+		if typ == "IDAT" || typ == "IEND" {
+			return text, nil
+		}
+		if typ != "tEXt" && typ != "zTXt" && typ != "iTXt" {
+			if _, err := br.Discard(int(n) + 4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data := make([]byte, n+4)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		data = data[:n]
+
+		keyword, value, _ := bytes.Cut(data, []byte{0})
+		compressed := typ == "zTXt"
+		switch {
+		case typ == "zTXt" && len(value) > 0:
+			value = value[1:]
+		case typ == "iTXt" && len(value) > 2:
+			// Compression flag and method, language tag and translated keyword.
+			compressed = value[0] == 1
+			parts := bytes.SplitN(value[2:], []byte{0}, 3)
+			if len(parts) < 3 {
+				continue
+			}
+			value = parts[2]
+		}
+
+		if compressed {
+			zr, err := zlib.NewReader(bytes.NewReader(value))
+			if err != nil {
+				continue
+			}
+			value, _ = io.ReadAll(zr)
+			zr.Close()
+		}
+		text = append(text, keyword...)
+		text = append(text, '\n')
+		text = append(text, value...)
+		text = append(text, '\n')
+	}
+}
+
+// overwriteOpt is the policy of batch runs on the outputs left by
+// previous runs.
+type overwriteOpt struct {
+	force        bool // overwrite them
+	skipExisting bool // skip their inputs
+}
+
+// register adds the overwrite flags to cmd.
+func (o *overwriteOpt) register(cmd *flag.FlagSet) {
+	cmd.BoolVar(&o.force, "force", false, "Batch runs: overwrite the outputs of previous runs")
+	cmd.BoolVar(&o.skipExisting, "skip-existing", false, "Batch runs: skip the inputs whose output already exists")
+}
+
+// validate checks that the flags do not conflict.
+func (o overwriteOpt) validate() error {
+	if o.force && o.skipExisting {
+		return usagef("--force and --skip-existing cannot be used together")
+	}
+	return nil
+}
+
+// check reports which of the outputs of a batch run already exist. Unless
+// they are to be overwritten or skipped, any existing output fails the
+// run before it starts, so that it neither clobbers previous results nor
+// stops halfway.
+func (o overwriteOpt) check(outs []string) ([]bool, error) {
+	exists := make([]bool, len(outs))
+	var found []string
+	for i, out := range outs {
+		ok, err := outputExists(out)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			exists[i] = true
+			// Outputs in archives share its path.
+			if !slices.Contains(found, out) {
+				found = append(found, out)
+			}
+		}
+	}
+	if len(found) == 0 || o.force || o.skipExisting {
+		return exists, nil
+	}
+	return nil, fmt.Errorf("%d outputs already exist, such as %s: use --force to overwrite them or --skip-existing to skip them", len(found), found[0])
+}
+
+// applyBatch applies the LUT to every image selected by opt, writing the
+// outputs next to the inputs, in the output directory or in the output
+// archive. The images of archives are graded to NAME.prism.zip by
+// default.
+func applyBatch(opt applyOpt, l LUTApplicator, depth lut.Depth) error {
+	items, closeInputs, err := collectImages(opt.imgPaths)
+	if err != nil {
+		return err
+	}
+	defer closeInputs()
+
+	outRoot := cmp.Or(opt.output, opt.outDir)
+	var (
+		outs    = make([]string, len(items))
+		entries = make([]string, len(items)) // names in the output archive, if any
+	)
+	for i, it := range items {
+		rel := defaultOutput(it.rel, filepath.Dir(it.rel))
+		switch {
+		case isArchive(outRoot):
+			outs[i], entries[i] = outRoot, archiveEntry(it.rel)
+		case outRoot != "":
+			outs[i] = outputPath(outRoot, rel)
+		case it.archive != "":
+			outs[i], entries[i] = archiveOutput(it.archive), archiveEntry(it.rel)
+		default:
+			outs[i] = outputPath(inputDir(it.path), filepath.Base(rel))
+		}
+	}
+	toArchive := slices.ContainsFunc(entries, func(e string) bool { return e != "" })
+	if toArchive && (opt.resume || opt.overwrite.skipExisting) {
+		return usagef("--resume and --skip-existing cannot be used with archive outputs, which are written whole")
+	}
+	overwrite := opt.overwrite
+	if opt.resume && !overwrite.skipExisting {
+		// Outputs left by the interrupted run may be cut short.
+		overwrite.force = true
+	}
+	exists, err := overwrite.check(outs)
+	if err != nil {
+		return err
+	}
+	state, err := openState(opt)
+	if err != nil {
+		return err
+	}
+
+	if outRoot != "" && !isObjectURI(outRoot) && !isArchive(outRoot) {
+		if err := os.MkdirAll(outRoot, 0o755); err != nil {
+			return err
+		}
+	}
+	archives := map[string]*archiveWriter{}
+	closeArchives := func(ok bool) error {
+		var err error
+		for _, a := range archives {
+			if cerr := a.close(ok); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	for i, out := range outs {
+		if entries[i] == "" || archives[out] != nil {
+			continue
+		}
+		a, err := createArchive(out)
+		if err != nil {
+			closeArchives(false)
+			return err
+		}
+		archives[out] = a
+	}
+
+	var (
+		outputs []string
+		skipped []map[string]string
+		failed  []map[string]string
+		bar     = newProgressBar(opt.commonOpt)
+	)
+	for i, it := range items {
+		label := fmt.Sprintf("%d/%d %s", i+1, len(items), it.path)
+		bar.set(float64(i)/float64(len(items)), label)
+
+		var (
+			reason  string
+			src     string
+			release = func() {}
+			err     error
+		)
+		switch {
+		case state.done[it.path]:
+			reason = "graded by the interrupted run"
+		case exists[i] && opt.overwrite.skipExisting:
+			reason = "output exists"
+		default:
+			if src, release, err = it.local(); err == nil {
+				reason, err = opt.filter.skip(src)
+			}
+		}
+		if err == nil && reason != "" {
+			release()
+			skipped = append(skipped, map[string]string{"path": it.path, "reason": reason})
+			bar.clear()
+			logger.Info("skipped", "path", it.path, "reason", reason)
+			continue
+		}
+
+		if err == nil && outRoot != "" && entries[i] == "" {
+			err = makeOutputDir(outs[i])
+		}
+
+		var (
+			out, start = "", time.Now()
+			commit     func(ok bool) error
+			o          = opt
+		)
+		switch {
+		case err != nil:
+		case entries[i] != "":
+			o.output, commit, err = archives[outs[i]].stage(entries[i])
+		default:
+			o.output, commit, err = stageOutput(outs[i])
+		}
+		if err == nil {
+			o.imgPath = src
+			o.progress = func(done, total int) {
+				bar.set((float64(i)+float64(done)/float64(total))/float64(len(items)), label)
+			}
+			out, err = applyImage(o, l, depth, "")
+			if cerr := commit(err == nil); err == nil {
+				err = cerr
+			}
+			switch {
+			case entries[i] != "":
+				out = outs[i] + "/" + entries[i]
+			case isObjectURI(outs[i]):
+				out = outs[i]
+			}
+		}
+		release()
+		if err != nil {
+			failed = append(failed, map[string]string{"path": it.path, "error": err.Error()})
+			bar.clear()
+			logError(it.path, err)
+			continue
+		}
+		logger.Info("graded", "path", it.path, "output", out, "elapsed", time.Since(start).Round(time.Millisecond))
+		outputs = append(outputs, out)
+		if err := state.record(it.path); err != nil {
+			bar.clear()
+			state.close(false)
+			closeArchives(false)
+			return err
+		}
+	}
+	bar.clear()
+	if err := closeArchives(true); err != nil {
+		state.close(false)
+		return err
+	}
+	if err := state.close(len(failed) == 0); err != nil {
+		return err
+	}
+
+	if !opt.quiet && !opt.json {
+		fmt.Fprintf(os.Stderr, "%d graded, %d skipped, %d failed\n", len(outputs), len(skipped), len(failed))
+	}
+
+	res := result("apply", "", opt.lut)
+	res["outputs"] = outputs
+	res["skipped"] = skipped
+	res["failed"] = failed
+	if err := report(opt.commonOpt, res); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d images failed", len(failed), len(items))
+	}
+	return nil
+}
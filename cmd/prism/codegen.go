@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/cube"
+)
+
+// loadCube loads the LUT at path as a CUBE, sampling HALDs and the other
+// LUTs on size points per axis, or on their own lattice if size is 0,
+// such as level² points for HALDs.
+func loadCube(path string, size int, opt commonOpt) (cube.Cube, error) {
+	l, err := loadLut(path)
+	if err != nil {
+		return cube.Cube{}, err
+	}
+	logLut(path, l)
+
+	if c, ok := l.(cube.Cube); ok {
+		return c, nil
+	}
+	if size == 0 {
+		size = l.Size()
+	}
+	c := prism.ToCube(l, size)
+	c.Title = lutTitle(path)
+	return c, nil
+}
+
+// identifier turns the file name of path in an exported Go identifier.
+func identifier(path string) string {
+	base := filepath.Base(path)
+	base = base[:len(base)-len(filepath.Ext(base))]
+
+	var sb strings.Builder
+	upper := true
+	for _, r := range base {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if upper {
+				r = unicode.ToUpper(r)
+			}
+			sb.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+
+	id := sb.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "LUT" + id
+	}
+	return id
+}
+
+// generate returns the Go source declaring the variable name holding
+// the LUT in the package pkg.
+func generate(c cube.Cube, pkg, name, source string) ([]byte, error) {
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	dataName := strings.ToLower(name[:1]) + name[1:] + "Data"
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by prism codegen from %s; DO NOT EDIT.\n\n", filepath.Base(source))
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	fmt.Fprintf(&buf, "import (\n\t\"strings\"\n\n\t\"github.com/NicoNex/prism/cube\"\n)\n\n")
+	fmt.Fprintf(&buf, "// %s is the %q LUT, %d points per axis.\n", name, c.Title, c.LUT3Dsize)
+	fmt.Fprintf(&buf, "var %s = func() cube.Cube {\n", name)
+	fmt.Fprintf(&buf, "\tvar c cube.Cube\n")
+	fmt.Fprintf(&buf, "\tif err := c.UnmarshalBinary([]byte(strings.Join(%s, \"\"))); err != nil {\n", dataName)
+	fmt.Fprintf(&buf, "\t\tpanic(err)\n")
+	fmt.Fprintf(&buf, "\t}\n")
+	fmt.Fprintf(&buf, "\treturn c\n")
+	fmt.Fprintf(&buf, "}()\n\n")
+
+	// The binary form of the LUT, split in lines of 32 bytes. They are
+	// joined at run time: a constant concatenating them nests too deep
+	// for go/format and the compiler past about 3 MB, as for CUBEs of
+	// 64 points per axis.
+	fmt.Fprintf(&buf, "var %s = []string{\n", dataName)
+	for i := 0; i < len(data); i += 32 {
+		fmt.Fprintf(&buf, "\t\"")
+		for _, b := range data[i:min(i+32, len(data))] {
+			fmt.Fprintf(&buf, "\\x%02x", b)
+		}
+		fmt.Fprintf(&buf, "\",\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	return format.Source(buf.Bytes())
+}
+
+func codegen() error {
+	opt := parseCodegenOpts()
+	if opt.lut == "" {
+		return usagef("missing LUT argument")
+	}
+
+	if opt.size != 0 && (opt.size < 2 || opt.size > 256) {
+		return usagef("invalid size %d: must be between 2 and 256", opt.size)
+	}
+
+	c, err := loadCube(opt.lut, opt.size, opt.commonOpt)
+	if err != nil {
+		return err
+	}
+	if opt.title != "" {
+		c.Title = opt.title
+	}
+
+	if opt.name == "" {
+		opt.name = identifier(opt.lut)
+	}
+	if !token.IsIdentifier(opt.name) {
+		return usagef("invalid variable name %q", opt.name)
+	}
+	if !token.IsIdentifier(opt.pkg) {
+		return usagef("invalid package name %q", opt.pkg)
+	}
+	if opt.output == "" {
+		base := filepath.Base(opt.lut)
+		opt.output = strings.ToLower(base[:len(base)-len(filepath.Ext(base))]) + "_gen.go"
+	}
+
+	src, err := generate(c, opt.pkg, opt.name, opt.lut)
+	if err != nil {
+		return fmt.Errorf("cannot generate source: %w", err)
+	}
+	if err := os.WriteFile(opt.output, src, 0o644); err != nil {
+		return err
+	}
+	return report(opt.commonOpt, result("codegen", opt.output, opt.lut))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// command is a prism subcommand.
+type command struct {
+	name    string
+	aliases []string
+	run     func() error
+	usage   func()
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "apply", aliases: []string{"a"}, run: apply, usage: usageApply},
+		{name: "convert", aliases: []string{"c"}, run: convert, usage: usageConvert},
+		{name: "blend", aliases: []string{"b"}, run: blend, usage: usageBlend},
+		{name: "identity", aliases: []string{"i"}, run: identity, usage: usageIdentity},
+		{name: "identify", run: identify, usage: usageIdentify},
+		{name: "morph", run: morph, usage: usageMorph},
+		{name: "ramp", run: ramp, usage: usageRamp},
+		{name: "preview", run: preview, usage: usagePreview},
+		{name: "compose", run: compose, usage: usageCompose},
+		{name: "generate", run: generateLUT, usage: usageGenerate},
+		{name: "codegen", run: codegen, usage: usageCodegen},
+		{name: "lut", run: lutLibrary, usage: usageLut},
+		{name: "run", run: run, usage: usageRun},
+		{name: "help", aliases: []string{"h"}, run: help, usage: usageHelp},
+	}
+}
+
+// findCommand returns the command with the given name or alias.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// result builds the JSON result of a command writing output from the
+// given inputs.
+func result(cmd, output string, inputs ...string) map[string]any {
+	res := map[string]any{"command": cmd}
+	if len(inputs) > 0 {
+		res["inputs"] = inputs
+	}
+	if output != "" {
+		res["output"] = output
+	}
+	return res
+}
+
+// report prints the result of a command as JSON on stdout when --json
+// is set.
+func report(opt commonOpt, result map[string]any) error {
+	if !opt.json {
+		return nil
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return fmt.Errorf("cannot encode result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/colorspace"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/lut"
+)
+
+// loadStage loads a compose step: a LUT or a color matrix
+// specification, with an optional intensity.
+func loadStage(arg string, opt commonOpt) (lut.Transform, error) {
+	path, intensity, err := pathAndIntensity(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var t lut.Transform
+	switch _, err := lutType(path); {
+	case err == nil:
+		l, err := loadLut(path)
+		if err != nil {
+			return nil, err
+		}
+		logLut(path, l)
+		t = l.Compile(lut.Options{Intensity: 1}).Interpolate
+
+	case errors.Is(err, prism.ErrUnknownFormat):
+		m, err := colorspace.LoadAffine(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		t = m.Apply
+
+	default:
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if intensity == 1 {
+		return t, nil
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		tr, tg, tb := t(r, g, b)
+		return r + (tr-r)*intensity, g + (tg-g)*intensity, b + (tb-b)*intensity
+	}, nil
+}
+
+func compose() error {
+	opt := parseComposeOpts()
+	if len(opt.steps) == 0 {
+		return usagef("missing STEP arguments")
+	}
+	if opt.size < 2 || opt.size > 256 {
+		return usagef("invalid size %d: must be between 2 and 256", opt.size)
+	}
+
+	var err error
+	stages := make([]lut.Transform, len(opt.steps))
+	names := make([]string, len(opt.steps))
+	for i, step := range opt.steps {
+		if stages[i], err = loadStage(step, opt.commonOpt); err != nil {
+			return err
+		}
+		base := filepath.Base(step)
+		names[i] = base[:len(base)-len(filepath.Ext(base))]
+	}
+
+	c := cube.FromTransform(lut.Chain(stages...), opt.size)
+	c.Title = opt.title
+	if c.Title == "" {
+		c.Title = strings.Join(names, " + ")
+	}
+
+	return writeCube(opt.commonOpt, "compose", c, opt.steps...)
+}
+
+// writeCube writes the LUT built by the command from the given inputs
+// to opt.output, as a CUBE or a PNG HALD, or to stdout.
+func writeCube(opt commonOpt, command string, c cube.Cube, inputs ...string) error {
+	if opt.output == "" {
+		if opt.json {
+			res := result(command, "", inputs...)
+			res["lut"] = c.String()
+			return report(opt, res)
+		}
+		fmt.Println(c)
+		return nil
+	}
+
+	if err := saveCube(opt.output, c); err != nil {
+		return err
+	}
+	return report(opt, result(command, opt.output, inputs...))
+}
+
+// saveCube writes c to path, as a PNG HALD if its extension is .png and
+// as a CUBE otherwise.
+func saveCube(path string, c cube.Cube) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".png" {
+		var h hald.HALD
+		if h, err = hald.FromCube(c, 12); err != nil {
+			return err
+		}
+		if h.Text == nil {
+			h.Text = make(map[string]string)
+		}
+		h.Text[hald.TextSoftware] = "prism"
+		err = (&hald.Encoder{Bits: 8}).Encode(f, h)
+	} else {
+		_, err = c.WriteTo(f)
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// levelsSection is the section of the configuration file naming
+// intensity levels.
+const levelsSection = "levels"
+
+// configAliases map the configuration keys that are not option names to
+// the options they set.
+var configAliases = map[string]string{
+	"jpeg_quality": "quality",
+	"workers":      "jobs",
+	"output_dir":   "out-dir",
+}
+
+// configValue is a value of the configuration file.
+type configValue struct {
+	key, val string
+	line     int
+}
+
+// config holds the values of the configuration file by section, the
+// values outside of any section under the empty name.
+type config struct {
+	path     string
+	sections map[string][]configValue
+}
+
+// configPath returns the path of the configuration file: $PRISM_CONFIG,
+// or prism/config.toml in the user configuration directory, such as
+// ~/.config on Linux.
+func configPath() string {
+	if path := os.Getenv("PRISM_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "prism", "config.toml")
+}
+
+// loadConfig reads the configuration file once.
+var loadConfig = sync.OnceValues(func() (config, error) {
+	return readConfig(configPath())
+})
+
+// readConfig reads the configuration file at path. A missing file, or an
+// empty path, is an empty configuration.
+func readConfig(path string) (config, error) {
+	cfg := config{path: path, sections: map[string][]configValue{}}
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+	return parseConfig(path, f)
+}
+
+// parseConfig parses the configuration file read from r, naming it path
+// in errors.
+func parseConfig(path string, r io.Reader) (config, error) {
+	cfg := config{path: path, sections: map[string][]configValue{}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "["); ok {
+			name, rest, ok := strings.Cut(name, "]")
+			if !ok || !isComment(rest) {
+				return cfg, fmt.Errorf("%s:%d: invalid section header", cfg.path, n)
+			}
+			section = strings.TrimSpace(name)
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if !ok || key == "" {
+			return cfg, fmt.Errorf("%s:%d: expected key = value", cfg.path, n)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(val))
+		if err != nil {
+			return cfg, fmt.Errorf("%s:%d: %s: %w", cfg.path, n, key, err)
+		}
+		cfg.sections[section] = append(cfg.sections[section], configValue{key: key, val: v, line: n})
+	}
+	return cfg, scanner.Err()
+}
+
+// parseTOMLValue parses a TOML string, number or boolean, followed by an
+// optional comment, and returns it as an option value.
+func parseTOMLValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				if !isComment(s[i+1:]) {
+					return "", errors.New("unexpected text after the string")
+				}
+				return strconv.Unquote(s[:i+1])
+			}
+		}
+		return "", errors.New("unterminated string")
+
+	case strings.HasPrefix(s, "'"):
+		val, rest, ok := strings.Cut(s[1:], "'")
+		if !ok || !isComment(rest) {
+			return "", errors.New("unterminated string")
+		}
+		return val, nil
+	}
+
+	val, _, _ := strings.Cut(s, "#")
+	val = strings.TrimSpace(val)
+	if val == "true" || val == "false" {
+		return val, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(val, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("unsupported value %q: must be a string, a number or a boolean", val)
+	}
+	return strings.ReplaceAll(val, "_", ""), nil
+}
+
+// isComment reports whether s is blank or a comment.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// applyConfig sets the defaults of the options of cmd from the
+// configuration file, as config.apply does.
+func applyConfig(cmd *flag.FlagSet) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return parseError(err)
+	}
+	return cfg.apply(cmd)
+}
+
+// apply sets the defaults of the options of cmd from the values outside
+// of any section, then from those of the section named after the
+// command, before the command line is parsed and overrides them. Values
+// outside of sections only set the options the command has.
+func (cfg config) apply(cmd *flag.FlagSet) error {
+	for section := range cfg.sections {
+		if c, ok := findCommand(section); section != "" && section != converterSection && section != levelsSection && (!ok || c.name != section) {
+			return usagef("%s: unknown section [%s]: must be a command name, converters or levels", cfg.path, section)
+		}
+	}
+	if _, err := cfg.levels(); err != nil {
+		return err
+	}
+
+	for _, section := range []string{"", cmd.Name()} {
+		for _, v := range cfg.sections[section] {
+			name := configAliases[v.key]
+			if name == "" {
+				name = strings.ReplaceAll(v.key, "_", "-")
+			}
+			if cmd.Lookup(name) == nil {
+				if section == "" {
+					continue
+				}
+				return usagef("%s:%d: %s has no option %q", cfg.path, v.line, section, v.key)
+			}
+			if err := cmd.Set(name, v.val); err != nil {
+				return usagef("%s:%d: %s: %w", cfg.path, v.line, v.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// levels returns the named intensity levels: intensityLevels with those
+// of the [levels] section over them. Their values are numbers or
+// percentages, or the names of the default levels.
+func (cfg config) levels() (map[string]float64, error) {
+	levels := maps.Clone(intensityLevels)
+	for _, v := range cfg.sections[levelsSection] {
+		f, err := parseIntensityIn(v.val, intensityLevels)
+		if err != nil {
+			return nil, usagef("%s:%d: level %s: %w", cfg.path, v.line, v.key, err)
+		}
+		levels[strings.ToLower(v.key)] = f
+	}
+	return levels, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOMLValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{in: `"film look"`, want: "film look"},
+		{in: `""`, want: ""},
+		{in: `"a \"quoted\" word"`, want: `a "quoted" word`},
+		{in: `"tab\tand\\backslash\n"`, want: "tab\tand\\backslash\n"},
+		{in: `"\u00e9t\u00E9"`, want: "été"},
+		{in: `"# not a comment"`, want: "# not a comment"},
+		{in: `"value" # comment`, want: "value"},
+		{in: `"value"#comment`, want: "value"},
+		{in: `'C:\luts\film.cube'`, want: `C:\luts\film.cube`},
+		{in: `'literal "quotes"' # comment`, want: `literal "quotes"`},
+		{in: `42`, want: "42"},
+		{in: `-0.5`, want: "-0.5"},
+		{in: `1e3`, want: "1e3"},
+		{in: `1_000_000`, want: "1000000"},
+		{in: `95 # quality`, want: "95"},
+		{in: `true`, want: "true"},
+		{in: `false # comment`, want: "false"},
+
+		{in: `"unterminated`, err: true},
+		{in: `"escaped quote\"`, err: true},
+		{in: `'unterminated`, err: true},
+		{in: `"value" trailing`, err: true},
+		{in: `'value' trailing`, err: true},
+		{in: `"bad \q escape"`, err: true},
+		{in: `yes`, err: true},
+		{in: `True`, err: true},
+		{in: `[1, 2]`, err: true},
+		{in: `{ a = 1 }`, err: true},
+		{in: ``, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTOMLValue(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	const text = `# prism configuration
+
+quality = 90   # top level
+"workers" = 4
+	indented = 'yes'
+
+[apply]
+interpolation = "tetrahedral"
+strict = true
+
+[ blend ] # comment
+clamp = false
+[apply]
+gpu = true
+`
+	cfg, err := parseConfig("config.toml", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]configValue{
+		"": {
+			{"quality", "90", 3},
+			{"workers", "4", 4},
+			{"indented", "yes", 5},
+		},
+		"apply": {
+			{"interpolation", "tetrahedral", 8},
+			{"strict", "true", 9},
+			{"gpu", "true", 14},
+		},
+		"blend": {
+			{"clamp", "false", 12},
+		},
+	}
+	if !reflect.DeepEqual(cfg.sections, want) {
+		t.Errorf("got %v, want %v", cfg.sections, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"[apply", "config.toml:1: invalid section header"},
+		{"[apply] quality = 1", "config.toml:1: invalid section header"},
+		{"# comment\nquality", "config.toml:2: expected key = value"},
+		{"= 1", "config.toml:1: expected key = value"},
+		{`"" = 1`, "config.toml:1: expected key = value"},
+		{"\n\nquality = high", `config.toml:3: quality: unsupported value "high"`},
+		{`title = "open`, "config.toml:1: title: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseConfig("config.toml", strings.NewReader(tt.text))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want %s", tt.text, err, tt.want)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := readConfig(filepath.Join(dir, "missing.toml"))
+	if err != nil || len(cfg.sections) != 0 {
+		t.Errorf("missing file: got %v, %v, want an empty configuration", cfg.sections, err)
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("[apply]\nquality = 80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []configValue{{"quality", "80", 2}}; cfg.path != path || !reflect.DeepEqual(cfg.sections["apply"], want) {
+		t.Errorf("got %s: %v, want %v", cfg.path, cfg.sections, want)
+	}
+}
+
+// configOpt holds the options set by the configuration in the tests.
+type configOpt struct {
+	quality, jobs int
+	outDir        string
+	interpolation string
+	strict        bool
+}
+
+// configFlags returns a flag set for the named command with the options
+// of o.
+func configFlags(name string, o *configOpt) *flag.FlagSet {
+	cmd := flag.NewFlagSet(name, flag.ContinueOnError)
+	cmd.IntVar(&o.quality, "quality", 75, "")
+	cmd.IntVar(&o.jobs, "jobs", 0, "")
+	cmd.StringVar(&o.outDir, "out-dir", "", "")
+	cmd.StringVar(&o.interpolation, "interpolation", "trilinear", "")
+	cmd.BoolVar(&o.strict, "strict", false, "")
+	return cmd
+}
+
+func TestConfigApply(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       configOpt
+	}{
+		{
+			"option names",
+			"quality = 90\nout-dir = 'graded'\ninterpolation = \"tetrahedral\"",
+			configOpt{quality: 90, outDir: "graded", interpolation: "tetrahedral"},
+		},
+		{
+			"underscores",
+			"out_dir = \"graded\"",
+			configOpt{quality: 75, outDir: "graded", interpolation: "trilinear"},
+		},
+		{
+			"aliases",
+			"jpeg_quality = 60\nworkers = 3\noutput_dir = \"out\"",
+			configOpt{quality: 60, jobs: 3, outDir: "out", interpolation: "trilinear"},
+		},
+		{
+			"section over top level",
+			"[apply]\nquality = 95\n\n[blend]\nquality = 10\n\n[converters]\n'.3dl' = 'conv {}'\n\n[]\nquality = 50\nstrict = true",
+			configOpt{quality: 95, interpolation: "trilinear", strict: true},
+		},
+		{
+			"unknown top level keys",
+			"title = 'look'\nsize = 33\nquality = 85",
+			configOpt{quality: 85, interpolation: "trilinear"},
+		},
+	}
+
+	for _, tt := range tests {
+		cfg, err := parseConfig("config.toml", strings.NewReader(tt.text))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got configOpt
+		if err := cfg.apply(configFlags("apply", &got)); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfigApplyErrors(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"[apply]\nsize = 33", `config.toml:2: apply has no option "size"`},
+		{"[apply]\n\njpeg-quality = 80", `config.toml:3: apply has no option "jpeg-quality"`},
+		{"quality = 'high'", "config.toml:1: quality: "},
+		{"[apply]\nstrict = 2", "config.toml:2: strict: "},
+		{"[aply]\nquality = 1", "config.toml: unknown section [aply]"},
+		{"[a]\nquality = 1", "config.toml: unknown section [a]"},
+		{"[levels]\nloud = 'very'", "config.toml:2: level loud: must be a number"},
+		{"[levels]\nsoft = 0.2\nloud = 2", "config.toml:3: level loud: must be between"},
+	}
+
+	for _, tt := range tests {
+		cfg, err := parseConfig("config.toml", strings.NewReader(tt.text))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.text, err)
+		}
+		var o configOpt
+		err = cfg.apply(configFlags("apply", &o))
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%q: got %v, want %s", tt.text, err, tt.want)
+		}
+		if exitCode(err) != exitUsage {
+			t.Errorf("%q: got exit code %d, want %d", tt.text, exitCode(err), exitUsage)
+		}
+	}
+}
+
+func TestConfigLevels(t *testing.T) {
+	const text = "[levels]\nsubtle = 0.1\nWhisper = '5%'\nfilm = 'strong'"
+	cfg, err := parseConfig("config.toml", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var o configOpt
+	if err := cfg.apply(configFlags("apply", &o)); err != nil {
+		t.Fatal(err)
+	}
+	levels, err := cfg.levels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"subtle": 0.1, "medium": 0.5, "strong": 0.75, "full": 1, "whisper": 0.05, "film": 0.75}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("got levels %v, want %v", levels, want)
+	}
+
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"Subtle", 0.1},
+		{"whisper", 0.05},
+		{"medium", 0.5},
+		{"30%", 0.3},
+	}
+	for _, tt := range tests {
+		if got, err := parseIntensityIn(tt.in, levels); err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseIntensityIn("loud", levels); err == nil || !strings.Contains(err.Error(), "one of whisper, subtle, medium") {
+		t.Errorf("got %v, want an error naming the levels", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lutExts are the extensions of the formats LUTs are converted to.
+var lutExts = map[string]bool{
+	".cube": true,
+	".png":  true,
+}
+
+// convertBatch converts every LUT in the directory opt.lut, and its
+// subdirectories, to the format opt.to in the directory opt.output,
+// keeping their names and relative paths. LUTs are told by their
+// contents: other files and LUTs already in the target format are
+// skipped, as are LUTs already converted with --skip-existing.
+func convertBatch(opt convertOpt) error {
+	if opt.lut == "" || opt.output == "" {
+		return usagef("batch conversion needs an input and an output directory")
+	}
+	ext := "." + opt.to
+	if !lutExts[ext] {
+		return usagef("invalid format %q: must be cube or png", opt.to)
+	}
+
+	var ins, outs []string
+	if o, ok := parseObjectURI(opt.lut); ok {
+		// Objects are told by their extension, not to download them all.
+		if !o.isPrefix() {
+			o.key += "/"
+		}
+		objs, err := listObjects(o)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			lutExt := path.Ext(obj.key)
+			if lutExts[strings.ToLower(lutExt)] && strings.ToLower(lutExt) != ext {
+				rel := strings.TrimPrefix(obj.key, o.key)
+				ins = append(ins, obj.String())
+				outs = append(outs, outputPath(opt.output, rel[:len(rel)-len(lutExt)]+ext))
+			}
+		}
+	} else {
+		err := filepath.WalkDir(opt.lut, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			typ, err := lutType(path)
+			if err != nil || typ == ext {
+				return nil
+			}
+			lutExt := filepath.Ext(path)
+			rel, err := filepath.Rel(opt.lut, path)
+			if err != nil {
+				return err
+			}
+			ins = append(ins, path)
+			outs = append(outs, outputPath(opt.output, rel[:len(rel)-len(lutExt)]+ext))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	exists, err := opt.overwrite.check(outs)
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs = make([]error, len(ins))
+		jobs = make(chan int)
+		wg   sync.WaitGroup
+	)
+	for range min(runtime.GOMAXPROCS(0), max(1, len(ins))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				err := makeOutputDir(outs[i])
+				if err == nil {
+					err = convertLut(opt, ins[i], outs[i])
+					forgetLut(ins[i])
+				}
+				if err != nil {
+					logError(ins[i], err)
+				} else {
+					logger.Info("converted", "path", ins[i], "output", outs[i], "elapsed", time.Since(start).Round(time.Millisecond))
+				}
+				errs[i] = err
+			}
+		}()
+	}
+	for i := range ins {
+		if !exists[i] || !opt.overwrite.skipExisting {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var (
+		outputs []string
+		skipped []map[string]string
+		failed  []map[string]string
+	)
+	for i, err := range errs {
+		if exists[i] && opt.overwrite.skipExisting {
+			skipped = append(skipped, map[string]string{"path": ins[i], "reason": "output exists"})
+			continue
+		}
+		if err != nil {
+			failed = append(failed, map[string]string{"path": ins[i], "error": err.Error()})
+			continue
+		}
+		outputs = append(outputs, outs[i])
+	}
+
+	if !opt.quiet && !opt.json {
+		fmt.Fprintf(os.Stderr, "%d converted, %d skipped, %d failed\n", len(outputs), len(skipped), len(failed))
+	}
+
+	res := result("convert", "", opt.lut)
+	res["outputs"] = outputs
+	res["skipped"] = skipped
+	res["failed"] = failed
+	if err := report(opt.commonOpt, res); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d LUTs failed", len(failed), len(ins))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/dng"
+	"github.com/NicoNex/prism/exif"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/icc"
+	"github.com/NicoNex/prism/pngstream"
+	"github.com/NicoNex/prism/tiff"
+)
+
+// Exit codes, one per kind of failure, so that scripts can branch on
+// them. They do not change between releases.
+const (
+	exitFailure     = 1 // Any other failure, such as images of a batch failing.
+	exitUsage       = 2 // Invalid arguments, options, blend weights or configuration keys.
+	exitUnsupported = 3 // A file format or conversion prism does not support.
+	exitParse       = 4 // A malformed LUT, image, ICC profile or configuration file.
+	exitIO          = 5 // A file that cannot be read or written.
+)
+
+// errorTypes name the kinds of failure in JSON errors.
+var errorTypes = map[int]string{
+	exitFailure:     "failure",
+	exitUsage:       "usage",
+	exitUnsupported: "unsupported",
+	exitParse:       "parse",
+	exitIO:          "io",
+}
+
+// jsonErrors prints errors as JSON objects, for --json-errors.
+var jsonErrors bool
+
+// exitError is an error of a known kind, given by the exit code it
+// makes prism exit with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// usagef formats an error in the arguments or options of a command.
+func usagef(format string, a ...any) error {
+	return exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+// unsupportedf formats an error for a format or conversion prism does
+// not support.
+func unsupportedf(format string, a ...any) error {
+	return exitError{exitUnsupported, fmt.Errorf(format, a...)}
+}
+
+// parseError returns err, found while decoding a file, as a parse error
+// unless it is of a known kind, such as a read error.
+func parseError(err error) error {
+	if err == nil || exitCode(err) != exitFailure {
+		return err
+	}
+	return exitError{exitParse, err}
+}
+
+// exitCode returns the exit code of err: that of the kind of failure it
+// is, or exitFailure.
+func exitCode(err error) int {
+	var (
+		exitErr        exitError
+		pathErr        *fs.PathError
+		pngFormat      png.FormatError
+		pngUnsupported png.UnsupportedError
+		jpgFormat      jpeg.FormatError
+		jpgUnsupported jpeg.UnsupportedError
+	)
+
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+
+	case errors.Is(err, cube.ErrInvalidWeights),
+		errors.Is(err, hald.ErrInvalidWeights):
+		return exitUsage
+
+	case errors.Is(err, prism.ErrUnknownFormat),
+		errors.Is(err, prism.ErrUnsupportedFormat),
+		errors.Is(err, prism.ErrUnsupportedImage),
+		errors.Is(err, image.ErrFormat),
+		errors.As(err, &pngUnsupported),
+		errors.As(err, &jpgUnsupported),
+		errors.Is(err, icc.ErrUnsupported),
+		errors.Is(err, tiff.ErrUnsupported),
+		errors.Is(err, pngstream.ErrUnsupported),
+		errors.Is(err, dng.ErrUnsupportedDNG):
+		return exitUnsupported
+
+	case errors.As(err, &pathErr),
+		errors.Is(err, fs.ErrNotExist),
+		errors.Is(err, fs.ErrPermission):
+		return exitIO
+
+	case errors.As(err, &pngFormat),
+		errors.As(err, &jpgFormat),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, cube.ErrUnrecognisedLine),
+		errors.Is(err, cube.ErrInvalidBinary),
+		errors.Is(err, hald.ErrInvalidDimensions),
+		errors.Is(err, icc.ErrInvalidProfile),
+		errors.Is(err, pngstream.ErrInvalid),
+		errors.Is(err, tiff.ErrInvalidHeader),
+		errors.Is(err, tiff.ErrInvalidIFD),
+		errors.Is(err, tiff.ErrMissingTag),
+		errors.Is(err, exif.ErrInvalidExif),
+		errors.Is(err, dng.ErrNotDNG),
+		errors.Is(err, dng.ErrInvalidLJPEG):
+		return exitParse
+	}
+	return exitFailure
+}
+
+// errorAttrs returns the attributes logged with err, naming its kind in
+// JSON errors.
+func errorAttrs(path string, err error) []any {
+	attrs := []any{"type", errorTypes[exitCode(err)]}
+	if path != "" {
+		attrs = append(attrs, "path", path)
+	}
+	return attrs
+}
+
+// fail reports err and exits with its exit code.
+func fail(err error) {
+	code := exitCode(err)
+	logger.Error(err.Error(), append(errorAttrs("", err), "exit_code", code)...)
+	os.Exit(code)
+}
+
+// logError reports err, found processing the file at path, without
+// exiting, as for the images of a batch.
+func logError(path string, err error) {
+	logger.Error(err.Error(), errorAttrs(path, err)...)
+}
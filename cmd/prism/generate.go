@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/NicoNex/prism/colorspace"
+	"github.com/NicoNex/prism/cube"
+)
+
+// generators build the LUTs of the kinds supported by generate.
+var generators = map[string]func(generateOpt) (cube.Cube, error){
+	"gamut": gamutLUT,
+}
+
+// gamutLUT builds the LUT converting colors from the space --from to
+// the space --to. Colors outside the target gamut are clipped.
+func gamutLUT(opt generateOpt) (cube.Cube, error) {
+	if opt.from == "" || opt.to == "" {
+		return cube.Cube{}, usagef("missing --from or --to color space")
+	}
+	from, err := lookupSpace(opt.from)
+	if err != nil {
+		return cube.Cube{}, err
+	}
+	to, err := lookupSpace(opt.to)
+	if err != nil {
+		return cube.Cube{}, err
+	}
+
+	conv := colorspace.Conversion(from, to)
+	c := cube.FromTransform(func(r, g, b float64) (float64, float64, float64) {
+		r, g, b = conv(r, g, b)
+		return max(0, min(1, r)), max(0, min(1, g)), max(0, min(1, b))
+	}, opt.size)
+	c.Title = fmt.Sprintf("%s to %s", from.Name, to.Name)
+	return c, nil
+}
+
+func generateLUT() error {
+	opt := parseGenerateOpts()
+	if opt.kind == "" {
+		return usagef("missing KIND argument")
+	}
+	gen, ok := generators[opt.kind]
+	if !ok {
+		return usagef("unknown kind %q: must be one of %s", opt.kind, strings.Join(slices.Sorted(maps.Keys(generators)), ", "))
+	}
+	if opt.size < 2 || opt.size > 256 {
+		return usagef("invalid size %d: must be between 2 and 256", opt.size)
+	}
+
+	c, err := gen(opt)
+	if err != nil {
+		return err
+	}
+	if opt.title != "" {
+		c.Title = opt.title
+	}
+	return writeCube(opt.commonOpt, "generate", c)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/lut"
+)
+
+// Formats of identified LUTs.
+const (
+	formatCube       = "cube"
+	formatBinaryCube = "binary-cube"
+	formatHALD       = "hald"
+)
+
+// formatNames are the names of the formats in the descriptions.
+var formatNames = map[string]string{
+	formatCube:       "CUBE",
+	formatBinaryCube: "binary CUBE",
+	formatHALD:       "PNG HALD",
+}
+
+// lutInfo describes a LUT file.
+type lutInfo struct {
+	format string
+	title  string
+	// Text metadata of HALDs, besides the title.
+	text map[string]string
+	// Points per axis of the lattice.
+	size int
+	// Level of HALDs, 0 for CUBEs.
+	level int
+	// Input range of each channel.
+	domainMin, domainMax [3]float64
+	samples              int
+	inVideo, outVideo    bool
+	// Statistics of the samples, nil when their number does not match
+	// the size.
+	stats    *lutStats
+	warnings []lut.Warning
+}
+
+// lutStats are statistics of the output samples of a LUT, in its units.
+type lutStats struct {
+	min, max, mean [3]float64
+	// Mean and largest distance of the samples from the colors they are
+	// looked up at, on the channel that moves most, as a fraction of the
+	// domain: both are 0 for identity LUTs.
+	meanShift, maxShift float64
+	// Number of samples outside the domain.
+	outside int
+}
+
+// sampleStats computes the statistics of the n^3 samples of a lattice of
+// size n over the domain lo to hi, at returning the sample of index i,
+// red changing fastest.
+func sampleStats(n int, lo, hi [3]float64, at func(i int) [3]float64) *lutStats {
+	s := &lutStats{min: [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, max: [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}}
+	count := n * n * n
+	for i := range count {
+		out := at(i)
+		idx := [3]int{i % n, i / n % n, i / (n * n)}
+
+		var shift float64
+		outside := false
+		for c := range 3 {
+			s.min[c] = min(s.min[c], out[c])
+			s.max[c] = max(s.max[c], out[c])
+			s.mean[c] += out[c]
+
+			if hi[c] > lo[c] {
+				in := lo[c] + float64(idx[c])/float64(n-1)*(hi[c]-lo[c])
+				shift = max(shift, math.Abs(out[c]-in)/(hi[c]-lo[c]))
+			}
+			outside = outside || out[c] < lo[c] || out[c] > hi[c]
+		}
+		s.meanShift += shift
+		s.maxShift = max(s.maxShift, shift)
+		if outside {
+			s.outside++
+		}
+	}
+	for c := range 3 {
+		s.mean[c] /= float64(count)
+	}
+	s.meanShift /= float64(count)
+	return s
+}
+
+// identifyLut loads the LUT at path and describes it.
+func identifyLut(path string) (lutInfo, error) {
+	l, err := loadLut(path)
+	if err != nil {
+		return lutInfo{}, err
+	}
+
+	info := lutInfo{text: map[string]string{}, warnings: l.Warnings()}
+	switch l := l.(type) {
+	case cube.Cube:
+		info.format = formatCube
+		if binary, err := isBinaryCube(path); err != nil {
+			return lutInfo{}, err
+		} else if binary {
+			info.format = formatBinaryCube
+		}
+		info.title = l.Title
+		info.size = l.LUT3Dsize
+		info.domainMin = [3]float64{l.DomainMin.R, l.DomainMin.G, l.DomainMin.B}
+		info.domainMax = [3]float64{l.DomainMax.R, l.DomainMax.G, l.DomainMax.B}
+		info.samples = len(l.Samples)
+		info.inVideo, info.outVideo = l.InVideoRange, l.OutVideoRange
+		if n := l.LUT3Dsize; n >= 2 && len(l.Samples) == n*n*n {
+			info.stats = sampleStats(n, info.domainMin, info.domainMax, func(i int) [3]float64 {
+				s := l.Samples[i]
+				return [3]float64{s.R, s.G, s.B}
+			})
+		}
+
+	case hald.HALD:
+		info.format = formatHALD
+		info.title = l.Text[hald.TextTitle]
+		for k, v := range l.Text {
+			if k != hald.TextTitle {
+				info.text[k] = v
+			}
+		}
+		info.level = l.Level()
+		info.size = info.level * info.level
+		info.domainMax = [3]float64{1, 1, 1}
+		info.samples = info.size * info.size * info.size
+		b := l.Bounds()
+		info.stats = sampleStats(info.size, info.domainMin, info.domainMax, func(i int) [3]float64 {
+			c := color.RGBA64Model.Convert(l.At(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx())).(color.RGBA64)
+			return [3]float64{float64(c.R) / 0xffff, float64(c.G) / 0xffff, float64(c.B) / 0xffff}
+		})
+	}
+	return info, nil
+}
+
+// isBinaryCube reports whether the CUBE at path is in binary form.
+func isBinaryCube(path string) (bool, error) {
+	f, err := openLut(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	return cube.IsBinary(head[:n]), nil
+}
+
+// print writes the description of the LUT at path to w.
+func (info lutInfo) print(w io.Writer, path string) {
+	fmt.Fprintln(w, path)
+	field := func(name, format string, args ...any) {
+		fmt.Fprintf(w, "  %-9s %s\n", name+":", fmt.Sprintf(format, args...))
+	}
+	channels := func(v [3]float64) string {
+		return fmt.Sprintf("R %.4f, G %.4f, B %.4f", v[0], v[1], v[2])
+	}
+
+	field("Format", "%s", formatNames[info.format])
+	if info.title != "" {
+		field("Title", "%q", info.title)
+	}
+	for _, k := range slices.Sorted(maps.Keys(info.text)) {
+		field("Text", "%s: %q", k, info.text[k])
+	}
+	if info.level > 0 {
+		side := info.level * info.level * info.level
+		field("Size", "%d points per axis (level %d, %dx%d pixels)", info.size, info.level, side, side)
+	} else {
+		field("Size", "%d points per axis", info.size)
+	}
+	field("Domain", "%g %g %g to %g %g %g",
+		info.domainMin[0], info.domainMin[1], info.domainMin[2],
+		info.domainMax[0], info.domainMax[1], info.domainMax[2])
+	if expected := info.size * info.size * info.size; info.samples != expected {
+		field("Samples", "%d (expected %d)", info.samples, expected)
+	} else {
+		field("Samples", "%d", info.samples)
+	}
+
+	var video []string
+	if info.inVideo {
+		video = append(video, "input")
+	}
+	if info.outVideo {
+		video = append(video, "output")
+	}
+	if len(video) > 0 {
+		field("Video", "%s in video range", strings.Join(video, " and "))
+	}
+
+	if s := info.stats; s != nil {
+		field("Min", "%s", channels(s.min))
+		field("Max", "%s", channels(s.max))
+		field("Mean", "%s", channels(s.mean))
+		field("Shift", "%.1f%% mean, %.1f%% max from identity", s.meanShift*100, s.maxShift*100)
+		field("Outside", "%d samples outside the domain", s.outside)
+	}
+	for _, warning := range info.warnings {
+		field("Warning", "%s", warning)
+	}
+}
+
+// json returns the description of the LUT at path in the schema of
+// identify --json. Every key is always present: stats is null when the
+// samples do not match the size.
+func (info lutInfo) json(path string) map[string]any {
+	warnings := make([]map[string]any, len(info.warnings))
+	for i, w := range info.warnings {
+		warnings[i] = map[string]any{"line": w.Line, "message": w.Message}
+	}
+
+	var stats map[string]any
+	if s := info.stats; s != nil {
+		stats = map[string]any{
+			"min":        s.min,
+			"max":        s.max,
+			"mean":       s.mean,
+			"mean_shift": s.meanShift,
+			"max_shift":  s.maxShift,
+			"outside":    s.outside,
+		}
+	}
+
+	return map[string]any{
+		"path":        path,
+		"format":      info.format,
+		"title":       info.title,
+		"text":        info.text,
+		"size":        info.size,
+		"level":       info.level,
+		"domain":      map[string]any{"min": info.domainMin, "max": info.domainMax},
+		"samples":     info.samples,
+		"video_range": map[string]any{"input": info.inVideo, "output": info.outVideo},
+		"stats":       stats,
+		"warnings":    warnings,
+	}
+}
+
+func identify() error {
+	opt := parseIdentifyOpts()
+	if len(opt.luts) == 0 {
+		return usagef("missing LUT arguments")
+	}
+
+	var (
+		luts   = []map[string]any{}
+		failed = []map[string]string{}
+	)
+	for _, path := range opt.luts {
+		info, err := identifyLut(path)
+		if err != nil {
+			logError(path, err)
+			failed = append(failed, map[string]string{"path": path, "error": err.Error()})
+			continue
+		}
+		luts = append(luts, info.json(path))
+		if opt.json {
+			continue
+		}
+		if len(luts) > 1 {
+			fmt.Println()
+		}
+		info.print(os.Stdout, path)
+	}
+
+	res := result("identify", "", opt.luts...)
+	res["luts"] = luts
+	res["failed"] = failed
+	if err := report(opt.commonOpt, res); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d LUTs failed", len(failed), len(opt.luts))
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// libraryIndex is the name of the index of the LUT library, in its
+// directory along with the LUT files.
+const libraryIndex = "library.json"
+
+// libraryEntry is a LUT of the library, stored under the file named
+// after its hash.
+type libraryEntry struct {
+	Name   string    `json:"name"`
+	File   string    `json:"file"`
+	SHA256 string    `json:"sha256"`
+	Format string    `json:"format"`
+	Title  string    `json:"title"`
+	Size   int       `json:"size"`
+	Tags   []string  `json:"tags"`
+	Source string    `json:"source"`
+	Added  time.Time `json:"added"`
+}
+
+// hasTags reports whether the entry has every tag in tags.
+func (e libraryEntry) hasTags(tags []string) bool {
+	for _, t := range tags {
+		if !slices.Contains(e.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// library is the store of named LUTs, which LUT arguments refer to as
+// @NAME.
+type library struct {
+	dir     string
+	entries []libraryEntry
+}
+
+// libraryDir returns the directory of the LUT library: $PRISM_LIBRARY,
+// or prism/luts in the user configuration directory.
+func libraryDir() (string, error) {
+	if dir := os.Getenv("PRISM_LIBRARY"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "prism", "luts"), nil
+}
+
+// openLibrary reads the index of the LUT library. A missing index is an
+// empty library.
+func openLibrary() (*library, error) {
+	dir, err := libraryDir()
+	if err != nil {
+		return nil, err
+	}
+	lib := &library{dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, libraryIndex))
+	if errors.Is(err, fs.ErrNotExist) {
+		return lib, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &lib.entries); err != nil {
+		return nil, parseError(fmt.Errorf("%s: %w", filepath.Join(dir, libraryIndex), err))
+	}
+	return lib, nil
+}
+
+// save writes the index of the library, replacing the previous one only
+// once it is written whole.
+func (lib *library) save() error {
+	slices.SortFunc(lib.entries, func(a, b libraryEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	data, err := json.MarshalIndent(lib.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(lib.dir, libraryIndex)
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// find returns the entry named name, with or without the leading @.
+func (lib *library) find(name string) (libraryEntry, error) {
+	name = strings.TrimPrefix(name, "@")
+	for _, e := range lib.entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return libraryEntry{}, usagef("no LUT named @%s in the library, see prism lut list", name)
+}
+
+// path returns the path of the file of the entry.
+func (lib *library) path(e libraryEntry) string {
+	return filepath.Join(lib.dir, e.File)
+}
+
+// add copies the LUT at path into the library under name, with the
+// given tags. A LUT already in the library under another name is not
+// added twice.
+func (lib *library) add(path, name string, tags []string) (libraryEntry, error) {
+	if err := validLutName(name); err != nil {
+		return libraryEntry{}, err
+	}
+	if _, err := lib.find(name); err == nil {
+		return libraryEntry{}, usagef("@%s already exists, remove it first", name)
+	}
+
+	f, err := openLut(path)
+	if err != nil {
+		return libraryEntry{}, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return libraryEntry{}, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	for _, e := range lib.entries {
+		if e.SHA256 == hash {
+			return libraryEntry{}, usagef("%s is already in the library as @%s", path, e.Name)
+		}
+	}
+
+	info, err := identifyLut(path)
+	if err != nil {
+		return libraryEntry{}, err
+	}
+	ext := ".cube"
+	if info.format == formatHALD {
+		ext = ".png"
+	}
+	e := libraryEntry{
+		Name:   name,
+		File:   hash[:16] + ext,
+		SHA256: hash,
+		Format: info.format,
+		Title:  info.title,
+		Size:   info.size,
+		Tags:   tags,
+		Source: path,
+		Added:  time.Now().UTC().Truncate(time.Second),
+	}
+	if abs, err := filepath.Abs(path); err == nil && path != "-" && !isRemote(path) {
+		e.Source = abs
+	}
+
+	if err := os.MkdirAll(lib.dir, 0o755); err != nil {
+		return libraryEntry{}, err
+	}
+	if err := os.WriteFile(lib.path(e), data, 0o644); err != nil {
+		return libraryEntry{}, err
+	}
+	lib.entries = append(lib.entries, e)
+	return e, lib.save()
+}
+
+// remove removes the entry named name and its file from the library.
+func (lib *library) remove(name string) error {
+	e, err := lib.find(name)
+	if err != nil {
+		return err
+	}
+	lib.entries = slices.DeleteFunc(lib.entries, func(x libraryEntry) bool {
+		return x.Name == e.Name
+	})
+	if err := lib.save(); err != nil {
+		return err
+	}
+	if err := os.Remove(lib.path(e)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// validLutName returns an error unless name can name a LUT of the
+// library: letters, digits, dots, dashes and underscores, not starting
+// with a dot.
+func validLutName(name string) error {
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return usagef("invalid name %q: must hold only letters, digits, dots, dashes and underscores", name)
+		}
+	}
+	if name == "" || name[0] == '.' {
+		return usagef("invalid name %q: must not be empty or start with a dot", name)
+	}
+	return nil
+}
+
+// libraryPath returns the path of the file of the LUT of the library
+// named by ref, @NAME.
+func libraryPath(ref string) (string, error) {
+	lib, err := openLibrary()
+	if err != nil {
+		return "", err
+	}
+	e, err := lib.find(ref)
+	if err != nil {
+		return "", err
+	}
+	return lib.path(e), nil
+}
+
+func lutLibrary() error {
+	opt, err := parseLutOpts()
+	if err != nil {
+		return err
+	}
+	lib, err := openLibrary()
+	if err != nil {
+		return err
+	}
+
+	switch opt.sub {
+	case "add":
+		return libraryAdd(opt, lib)
+	case "list", "ls":
+		return libraryList(opt, lib)
+	case "rm", "remove":
+		return libraryRemove(opt, lib)
+	case "show":
+		return libraryShow(opt, lib)
+	case "":
+		return usagef("missing SUBCOMMAND argument: must be add, list, rm or show")
+	default:
+		return usagef("unknown subcommand %q: must be add, list, rm or show", opt.sub)
+	}
+}
+
+func libraryAdd(opt lutOpt, lib *library) error {
+	if len(opt.args) != 1 {
+		return usagef("lut add needs one LUT, got %d", len(opt.args))
+	}
+	path := opt.args[0]
+	name := opt.name
+	if name == "" {
+		if path == "-" {
+			return usagef("--name is required for LUTs read from standard input")
+		}
+		base := filepath.Base(path)
+		if isRemote(path) {
+			base = urlBase(path)
+		}
+		name = base[:len(base)-len(filepath.Ext(base))]
+	}
+
+	e, err := lib.add(path, name, opt.tags)
+	if err != nil {
+		return err
+	}
+	if !opt.quiet && !opt.json {
+		fmt.Fprintf(os.Stderr, "added %s as @%s\n", path, e.Name)
+	}
+	res := result("lut add", lib.path(e), path)
+	res["lut"] = e
+	return report(opt.commonOpt, res)
+}
+
+func libraryList(opt lutOpt, lib *library) error {
+	entries := []libraryEntry{}
+	for _, e := range lib.entries {
+		if e.hasTags(opt.tags) && (len(opt.args) == 0 || slices.Contains(opt.args, e.Name)) {
+			entries = append(entries, e)
+		}
+	}
+
+	if opt.json {
+		res := result("lut list", "")
+		res["luts"] = entries
+		return report(opt.commonOpt, res)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFORMAT\tSIZE\tTAGS\tTITLE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "@%s\t%s\t%d\t%s\t%s\n", e.Name, formatNames[e.Format], e.Size, strings.Join(e.Tags, ","), e.Title)
+	}
+	return w.Flush()
+}
+
+func libraryRemove(opt lutOpt, lib *library) error {
+	if len(opt.args) == 0 {
+		return usagef("missing NAME arguments")
+	}
+	for _, name := range opt.args {
+		if err := lib.remove(name); err != nil {
+			return err
+		}
+	}
+	return report(opt.commonOpt, result("lut rm", "", opt.args...))
+}
+
+func libraryShow(opt lutOpt, lib *library) error {
+	if len(opt.args) != 1 {
+		return usagef("lut show needs one NAME, got %d", len(opt.args))
+	}
+	e, err := lib.find(opt.args[0])
+	if err != nil {
+		return err
+	}
+	info, err := identifyLut(lib.path(e))
+	if err != nil {
+		return err
+	}
+
+	if opt.json {
+		desc := info.json(lib.path(e))
+		desc["name"] = e.Name
+		desc["sha256"] = e.SHA256
+		desc["tags"] = e.Tags
+		desc["source"] = e.Source
+		desc["added"] = e.Added
+		res := result("lut show", "")
+		res["lut"] = desc
+		return report(opt.commonOpt, res)
+	}
+
+	info.print(os.Stdout, "@"+e.Name)
+	field := func(name, value string) {
+		fmt.Printf("  %-9s %s\n", name+":", value)
+	}
+	if len(e.Tags) > 0 {
+		field("Tags", strings.Join(e.Tags, ", "))
+	}
+	field("File", lib.path(e))
+	field("Source", e.Source)
+	field("Added", e.Added.Local().Format(time.DateTime))
+	field("SHA-256", e.SHA256)
+	return nil
+}
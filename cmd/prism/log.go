@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/lut"
+)
+
+// logger reports errors, warnings and, with --verbose, per-file timings
+// and LUT details on stderr. It logs warnings and errors until the
+// options of the command are parsed.
+var logger = slog.New(newCLIHandler(os.Stderr, slog.LevelWarn))
+
+// optLevel is the log level selected by the common options: errors only
+// with --quiet, everything with --verbose and warnings otherwise. It is
+// read at each record, as options may follow the arguments and be parsed
+// after the logger is set up.
+type optLevel struct {
+	o *commonOpt
+}
+
+func (l optLevel) Level() slog.Level {
+	switch {
+	case l.o.quiet:
+		return slog.LevelError
+	case l.o.verbose:
+		return slog.LevelDebug
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// cliHandler writes log records as lines meant for people, in the form
+// "path: warning: message key=value", the path being the value of the
+// path attribute, if any. Errors are written as "path: message", their
+// attributes naming their kind for --json-errors, which writes them as
+// JSON objects instead.
+type cliHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+	group string
+}
+
+func newCLIHandler(w io.Writer, level slog.Leveler) *cliHandler {
+	return &cliHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError && jsonErrors {
+		return h.handleJSON(r)
+	}
+
+	var (
+		path  string
+		attrs strings.Builder
+	)
+	add := func(a slog.Attr) bool {
+		a.Value = a.Value.Resolve()
+		switch {
+		case a.Equal(slog.Attr{}):
+		case r.Level >= slog.LevelError && a.Key != "path":
+		case a.Key == "path" && h.group == "":
+			path = a.Value.String()
+		default:
+			val := a.Value.String()
+			if strings.ContainsAny(val, " \t\n\"=") || val == "" {
+				val = strconv.Quote(val)
+			}
+			fmt.Fprintf(&attrs, " %s%s=%s", h.group, a.Key, val)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	var line strings.Builder
+	if path != "" {
+		line.WriteString(path + ": ")
+	}
+	switch {
+	case r.Level >= slog.LevelError:
+	case r.Level >= slog.LevelWarn:
+		line.WriteString("warning: ")
+	case r.Level < slog.LevelInfo:
+		line.WriteString("debug: ")
+	}
+	line.WriteString(r.Message)
+	line.WriteString(attrs.String())
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+// handleJSON writes the error record r as a JSON object on one line,
+// holding the message as error and the attributes.
+func (h *cliHandler) handleJSON(r slog.Record) error {
+	obj := map[string]any{"error": r.Message}
+	add := func(a slog.Attr) bool {
+		if !a.Equal(slog.Attr{}) {
+			obj[h.group+a.Key] = a.Value.Resolve().Any()
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	line, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err = h.w.Write(append(line, '\n'))
+	return err
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return &h2
+}
+
+func (h *cliHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// logLut logs the details of the LUT loaded from path and the warnings
+// found while loading it.
+func logLut(path string, l LUTApplicator) {
+	switch l := l.(type) {
+	case cube.Cube:
+		logger.Info("loaded CUBE", "path", path, "title", l.Title, "size", l.LUT3Dsize, "samples", len(l.Samples))
+	case hald.HALD:
+		logger.Info("loaded HALD", "path", path, "level", l.Level(), "size", l.Size())
+	default:
+		logger.Info("loaded LUT", "path", path, "size", l.Size())
+	}
+	logWarnings(path, l.Warnings())
+}
+
+// logWarnings logs the warnings found while loading the LUT at path,
+// such as samples clipped by the domain. They are informational: LUTs
+// load in spite of them.
+func logWarnings(path string, warnings []lut.Warning) {
+	for _, w := range warnings {
+		logger.Info("warning: "+w.String(), "path", path)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/lut"
+)
+
+// readStdin reads the whole standard input once, so that a LUT read
+// from it can be sniffed and then loaded.
+var readStdin = sync.OnceValues(func() ([]byte, error) {
+	return io.ReadAll(os.Stdin)
+})
+
+// openLut opens the file at path, the standard input if path is -, the
+// download of path if it is an http or https URL or the LUT of the
+// library named NAME if path is @NAME. LUTs whose extension has an
+// external converter are read as the CUBE it writes.
+func openLut(path string) (io.ReadCloser, error) {
+	args, err := converterFor(path)
+	if err != nil {
+		return nil, err
+	}
+	if args != nil {
+		return memLut(convertExternal(path, args))
+	}
+
+	switch {
+	case path == "-":
+		return memLut(readStdin())
+	case isRemote(path):
+		return memLut(fetchLut(path))
+	case strings.HasPrefix(path, "@"):
+		p, err := libraryPath(path)
+		if err != nil {
+			return nil, err
+		}
+		path = p
+	}
+	return os.Open(path)
+}
+
+// memLut returns a reader of the LUT data read in memory.
+func memLut(data []byte, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// lutType returns the type of the LUT at path as the extension of its
+// format: .cube for CUBEs, in text or binary form, .png for HALDs and
+// the extension of the format for those added with lut.RegisterFormat.
+// The format is told by the contents of the file, so that mislabeled
+// files and files without an extension load, and the extension is used
+// only when the contents are not recognized.
+func lutType(path string) (string, error) {
+	f, err := openLut(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, prism.SniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	name := path
+	if isRemote(path) {
+		name = urlBase(path)
+	}
+	return prism.Detect(head[:n], name)
+}
+
+// strict rejects the LUTs loaded with warnings, for --strict.
+var strict bool
+
+// cacheMinSize is the size of the smallest CUBE text kept in the cache.
+const cacheMinSize = 1 << 20
+
+// cubeCache returns the cache of parsed CUBEs, in $PRISM_CACHE or in
+// prism/cubes in the user cache directory, or nil if $PRISM_CACHE is off
+// or there is no such directory.
+var cubeCache = sync.OnceValue(func() *cube.Cache {
+	dir := os.Getenv("PRISM_CACHE")
+	switch dir {
+	case "off":
+		return nil
+	case "":
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(base, "prism", "cubes")
+	}
+	return &cube.Cache{Dir: dir, MinSize: cacheMinSize}
+})
+
+// loadLut loads the LUT at path, or from the standard input if path is
+// -, in the format told by its contents. With --strict, LUTs deviating
+// from the specification of their format fail to load.
+func loadLut(path string) (LUTApplicator, error) {
+	typ, err := lutType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := openLut(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var l LUTApplicator
+	if cache := cubeCache(); typ == ".cube" && cache != nil {
+		var c cube.Cube
+		if c, err = cache.Load(f); err == nil {
+			l = c
+		}
+	} else {
+		l, err = prism.Decode(f, typ)
+	}
+	if err == nil && strict {
+		err = lut.Strict(l)
+	}
+	return l, parseError(err)
+}
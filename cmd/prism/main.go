@@ -0,0 +1,1254 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/colorspace"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/exif"
+	"github.com/NicoNex/prism/gpu"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/icc"
+	"github.com/NicoNex/prism/jpegenc"
+	"github.com/NicoNex/prism/lut"
+	"github.com/NicoNex/prism/sheet"
+	"github.com/NicoNex/prism/termimg"
+	"github.com/NicoNex/prism/tiff"
+	"github.com/NicoNex/prism/transform"
+)
+
+// intensityLevels maps the named intensity levels to their value. The
+// [levels] section of the configuration file adds levels or overrides
+// these.
+var intensityLevels = map[string]float64{
+	"subtle": 0.25,
+	"medium": 0.5,
+	"strong": 0.75,
+	"full":   1,
+}
+
+// namedLevels returns the named intensity levels of the configuration
+// file, or the defaults if it cannot be read: config.apply reports its
+// errors before any intensity is parsed.
+var namedLevels = sync.OnceValue(func() map[string]float64 {
+	cfg, err := loadConfig()
+	if err != nil {
+		return intensityLevels
+	}
+	levels, err := cfg.levels()
+	if err != nil {
+		return intensityLevels
+	}
+	return levels
+})
+
+// pathAndIntensity splits a LUT[:INTENSITY] argument. The intensity is
+// a number in [0, 1], a percentage or a named level and defaults to 1.
+func pathAndIntensity(s string) (string, float64, error) {
+	i := strings.LastIndexByte(s, ':')
+	// A colon followed by a path separator is part of the path, as in
+	// a Windows drive letter.
+	if i < 0 || strings.ContainsAny(s[i+1:], `/\`) {
+		return s, 1, nil
+	}
+
+	path, val := s[:i], strings.TrimSpace(s[i+1:])
+	f, err := parseIntensity(val)
+	if err != nil {
+		return path, 0, usagef("invalid intensity %q for %s: %w", val, path, err)
+	}
+	return path, f, nil
+}
+
+// parseIntensity parses an intensity value, with the named levels of
+// the configuration file.
+func parseIntensity(s string) (float64, error) {
+	return parseIntensityIn(s, namedLevels())
+}
+
+// parseIntensityIn parses an intensity value, looking up the named
+// levels in levels.
+func parseIntensityIn(s string, levels map[string]float64) (float64, error) {
+	if f, ok := levels[strings.ToLower(s)]; ok {
+		return f, nil
+	}
+
+	scale := 1.0
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		s, scale = pct, 100
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, fmt.Errorf("must be a number between 0 and 1, a percentage or one of %s", levelNames(levels))
+	}
+	if f /= scale; f < 0 || f > 1 {
+		return 0, errors.New("must be between 0 and 1 (0% and 100%)")
+	}
+	return f, nil
+}
+
+// levelNames returns the names of levels sorted by value.
+func levelNames(levels map[string]float64) string {
+	names := slices.Collect(maps.Keys(levels))
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Compare(levels[a], levels[b])
+	})
+	return strings.Join(names, ", ")
+}
+
+// blendHALDs blends HALDs of the same level as HALDs.
+func blendHALDs(opt blendOpt, halds []hald.HALD) error {
+	for i := range halds {
+		halds[i].Workers = opt.jobs
+	}
+	blended, err := hald.BlendAllIn(halds, opt.intensities, opt.mix)
+	if err != nil {
+		return err
+	}
+	blended.Text = haldText(opt.title, opt.luts, opt.intensities)
+
+	if opt.output == "" {
+		opt.output = haldOutput(opt.luts)
+	}
+
+	f, err := os.Create(opt.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = blended.WriteTo(f); err != nil {
+		return err
+	}
+	return report(opt.commonOpt, result("blend", opt.output, opt.luts...))
+}
+
+// haldText returns the text metadata of a HALD made from the LUTs at
+// sources, blended with weights if it has more than one. The title
+// defaults to the names of the sources.
+func haldText(title string, sources []string, weights []float64) map[string]string {
+	names := make([]string, len(sources))
+	titles := make([]string, len(sources))
+	for i, path := range sources {
+		names[i] = filepath.Base(path)
+		titles[i] = strings.TrimSuffix(names[i], filepath.Ext(names[i]))
+	}
+	text := map[string]string{
+		hald.TextTitle:    cmp.Or(title, strings.Join(titles, " + ")),
+		hald.TextSoftware: "prism",
+		hald.TextSources:  strings.Join(names, ", "),
+	}
+	if len(weights) > 1 {
+		w := make([]string, len(weights))
+		for i, v := range weights {
+			w[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		text[hald.TextWeights] = strings.Join(w, ", ")
+	}
+	return text
+}
+
+// haldOutput returns the default output of a blend of HALDs, naming the
+// inputs.
+func haldOutput(luts []string) string {
+	names := make([]string, len(luts))
+	for i, path := range luts {
+		base := filepath.Base(path)
+		names[i] = base[:len(base)-len(filepath.Ext(base))]
+	}
+	return strings.Join(names, " and ") + filepath.Ext(luts[0])
+}
+
+// blend blends LUTs of any format. HALDs of the same level blended with
+// the normal mode stay HALDs, as CUBEs of the same size and domain stay
+// CUBEs of that lattice; other LUTs are sampled on a common CUBE lattice
+// as large as the largest CUBE and of at least the 33 points HALDs are
+// converted to, or of --size points, then clamped or rescaled to their
+// domain with --clamp and --rescale. Blended HALDs are always within
+// range. The output format follows -o.
+func blend() error {
+	opt, err := parseBlendOpts()
+	if err != nil {
+		return err
+	}
+
+	var (
+		luts  = make([]LUTApplicator, len(opt.luts))
+		names = make([]string, len(opt.luts))
+		halds []hald.HALD
+		cubes int
+	)
+	for i, path := range opt.luts {
+		l, err := loadLut(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		logLut(path, l)
+		luts[i] = l
+		base := filepath.Base(path)
+		names[i] = base[:len(base)-len(filepath.Ext(base))]
+
+		switch l := l.(type) {
+		case hald.HALD:
+			halds = append(halds, l)
+		case cube.Cube:
+			cubes++
+		}
+	}
+
+	allHALDs := len(halds) == len(luts)
+	sameLevel := allHALDs && !slices.ContainsFunc(halds, func(h hald.HALD) bool { return h.Level() != halds[0].Level() })
+	outExt := strings.ToLower(filepath.Ext(opt.output))
+	if sameLevel && outExt != ".cube" && opt.mode == cube.ModeNormal {
+		return blendHALDs(opt, halds)
+	}
+
+	blended, err := prism.Blend(luts, opt.intensities, prism.BlendOptions{Mode: opt.mode, Mix: opt.mix, Size: opt.size, Workers: opt.jobs})
+	if err != nil {
+		return err
+	}
+	switch {
+	case opt.rescale:
+		if outOfDomain(blended) {
+			blended.Rescale()
+		}
+	case opt.clamp:
+		blended.ClampToDomain()
+	}
+	// Blends of CUBEs keep the header of the first one.
+	if opt.title != "" || cubes < len(luts) {
+		blended.Title = cmp.Or(opt.title, strings.Join(names, " + "))
+	}
+	if opt.output == "" && allHALDs {
+		opt.output = haldOutput(opt.luts)
+	}
+	return writeCube(opt.commonOpt, "blend", blended, opt.luts...)
+}
+
+// outOfDomain reports whether a sample of c is out of its domain.
+func outOfDomain(c cube.Cube) bool {
+	lo, hi := c.DomainMin, c.DomainMax
+	return slices.ContainsFunc(c.Samples, func(s cube.Sample) bool {
+		return s.R < lo.R || s.G < lo.G || s.B < lo.B || s.R > hi.R || s.G > hi.G || s.B > hi.B
+	})
+}
+
+// LUTApplicator is a LUT loaded in any format: a CUBE, a HALD or one of
+// the formats added with lut.RegisterFormat.
+type LUTApplicator = lut.LUT
+
+func parseRounding(s string) (lut.Rounding, error) {
+	switch s {
+	case "", "nearest":
+		return lut.RoundNearest, nil
+	case "truncate":
+		return lut.RoundTruncate, nil
+	default:
+		return 0, usagef("invalid rounding %q: must be nearest or truncate", s)
+	}
+}
+
+func parseMix(s string) (lut.Mix, error) {
+	switch s {
+	case "", "rgb":
+		return lut.MixRGB, nil
+	case "hsl":
+		return lut.MixHSL, nil
+	case "oklab":
+		return lut.MixOklab, nil
+	default:
+		return 0, usagef("invalid mix %q: must be rgb, hsl or oklab", s)
+	}
+}
+
+// parseLumaRange parses a --luma-range of the form MIN:MAX[:FEATHER],
+// or returns the preset selected by --shadows, --midtones or
+// --highlights. It returns nil when none is set.
+func parseLumaRange(s string, shadows, midtones, highlights bool) (*lut.LumaRange, error) {
+	var (
+		lr lut.LumaRange
+		n  int
+	)
+	for _, p := range []struct {
+		set bool
+		lr  lut.LumaRange
+	}{{s != "", lut.LumaRange{}}, {shadows, lut.Shadows}, {midtones, lut.Midtones}, {highlights, lut.Highlights}} {
+		if p.set {
+			lr = p.lr
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return nil, nil
+	case n > 1:
+		return nil, usagef("only one of --luma-range, --shadows, --midtones and --highlights can be set")
+	case s == "":
+		return &lr, nil
+	}
+
+	fields := strings.Split(s, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, usagef("invalid luma range %q: must be MIN:MAX[:FEATHER]", s)
+	}
+	vals := []float64{0, 0, 0.1}
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil || v < 0 || v > 1 {
+			return nil, usagef("invalid luma range %q: values must be between 0 and 1", s)
+		}
+		vals[i] = v
+	}
+	if vals[0] > vals[1] {
+		return nil, usagef("invalid luma range %q: MIN is above MAX", s)
+	}
+	return &lut.LumaRange{Min: vals[0], Max: vals[1], Feather: vals[2]}, nil
+}
+
+func parseComponent(s string) (lut.Component, error) {
+	switch s {
+	case "", "all":
+		return lut.ComponentAll, nil
+	case "luma":
+		return lut.ComponentLuma, nil
+	case "chroma":
+		return lut.ComponentChroma, nil
+	default:
+		return 0, usagef("invalid component %q: must be all, luma or chroma", s)
+	}
+}
+
+// parseGradient parses a --gradient direction, returning nil when it is
+// not set.
+func parseGradient(s string) (*transform.Direction, error) {
+	var dir transform.Direction
+	switch s {
+	case "":
+		return nil, nil
+	case "left-right":
+		dir = transform.LeftRight
+	case "right-left":
+		dir = transform.RightLeft
+	case "top-bottom":
+		dir = transform.TopBottom
+	case "bottom-top":
+		dir = transform.BottomTop
+	case "radial":
+		dir = transform.Radial
+	default:
+		return nil, usagef("invalid gradient %q: must be left-right, right-left, top-bottom, bottom-top or radial", s)
+	}
+	return &dir, nil
+}
+
+func parseMode(s string) (cube.Mode, error) {
+	switch s {
+	case "", "normal":
+		return cube.ModeNormal, nil
+	case "multiply":
+		return cube.ModeMultiply, nil
+	case "screen":
+		return cube.ModeScreen, nil
+	case "overlay":
+		return cube.ModeOverlay, nil
+	case "darken":
+		return cube.ModeDarken, nil
+	case "lighten":
+		return cube.ModeLighten, nil
+	default:
+		return 0, usagef("invalid blend mode %q: must be normal, multiply, screen, overlay, darken or lighten", s)
+	}
+}
+
+func parseInterpolation(s string) (lut.Interpolation, error) {
+	switch s {
+	case "", "trilinear":
+		return lut.Trilinear, nil
+	case "tetrahedral":
+		return lut.Tetrahedral, nil
+	default:
+		return 0, usagef("invalid interpolation %q: must be trilinear or tetrahedral", s)
+	}
+}
+
+func parseDither(s string) (lut.Dither, error) {
+	switch s {
+	case "", "none":
+		return lut.DitherNone, nil
+	case "ordered":
+		return lut.DitherOrdered, nil
+	case "diffusion":
+		return lut.DitherDiffusion, nil
+	default:
+		return 0, usagef("invalid dither %q: must be none, ordered or diffusion", s)
+	}
+}
+
+func parseSubsampling(s string) (jpegenc.Subsampling, error) {
+	switch s {
+	case "", "420", "4:2:0":
+		return jpegenc.Subsample420, nil
+	case "422", "4:2:2":
+		return jpegenc.Subsample422, nil
+	case "444", "4:4:4":
+		return jpegenc.Subsample444, nil
+	default:
+		return 0, usagef("invalid subsampling %q: must be 420, 422 or 444", s)
+	}
+}
+
+func parseCompression(s string) (png.CompressionLevel, error) {
+	switch s {
+	case "", "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "fast":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	default:
+		return 0, usagef("invalid compression %q: must be default, fast, best or none", s)
+	}
+}
+
+func parseDepth(s string) (lut.Depth, error) {
+	switch s {
+	case "", "float":
+		return lut.DepthFloat, nil
+	case "8":
+		return lut.Depth8, nil
+	case "table":
+		return lut.DepthTable, nil
+	default:
+		return 0, usagef("invalid depth %q: must be 8, table or float", s)
+	}
+}
+
+// imgMeta is the metadata carried over to the output image.
+type imgMeta struct {
+	exif []byte // EXIF payload, JPEG only
+	icc  []byte // ICC profile, JPEG, PNG and TIFF only
+}
+
+// writeImg encodes the image to out with the encoder parameters,
+// embedding the metadata supported by the format.
+func writeImg(format string, out io.Writer, img image.Image, meta imgMeta, enc prism.EncodeOptions) error {
+	if format != "jpeg" {
+		meta.exif = nil
+	}
+	if format != "jpeg" && format != "png" && format != "tiff" {
+		meta.icc = nil
+	}
+	if meta.exif == nil && meta.icc == nil {
+		return prism.Encode(out, img, format, &enc)
+	}
+	if format == "tiff" {
+		return tiff.EncodeProfile(out, img, meta.icc)
+	}
+
+	var buf bytes.Buffer
+	if err := prism.Encode(&buf, img, format, &enc); err != nil {
+		return err
+	}
+
+	if format == "png" {
+		return icc.InsertPNG(out, buf.Bytes(), meta.icc)
+	}
+
+	data := buf.Bytes()
+	if meta.icc != nil {
+		var tagged bytes.Buffer
+		if err := icc.InsertJPEG(&tagged, data, meta.icc); err != nil {
+			return err
+		}
+		data = tagged.Bytes()
+	}
+	if meta.exif != nil {
+		return exif.Insert(out, data, meta.exif)
+	}
+	_, err := out.Write(data)
+	return err
+}
+
+// jpegMetadata returns the EXIF payload of the JPEG read from f, or nil
+// if it has none. The orientation is reset when the output pixels are
+// reoriented.
+func jpegMetadata(f io.ReadSeeker, reoriented bool) ([]byte, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	meta, err := exif.Extract(f)
+	switch {
+	case errors.Is(err, exif.ErrNoExif):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if reoriented {
+		if err := exif.SetOrientation(meta, 1); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// inputProfile returns the ICC profile embedded in the image read from
+// f, or nil if it has none or it can be treated as sRGB.
+func inputProfile(opt applyOpt, f io.ReadSeeker, format string) (*icc.Profile, error) {
+	switch opt.icc {
+	case "ignore":
+		return nil, nil
+	case "convert", "embed":
+	default:
+		return nil, usagef("invalid ICC mode %q: must be convert, embed or ignore", opt.icc)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case "jpeg":
+		data, err = icc.ExtractJPEG(f)
+	case "png":
+		data, err = icc.ExtractPNG(f)
+	default:
+		return nil, nil
+	}
+	if errors.Is(err, icc.ErrNoProfile) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	p, err := icc.Parse(data)
+	if err != nil {
+		// Grade the image as sRGB rather than failing.
+		logger.Warn(err.Error()+", treating image as sRGB", "path", opt.imgPath)
+		return nil, nil
+	}
+	if p.IsSRGB() {
+		return nil, nil
+	}
+
+	logger.Info("converting from ICC profile", "path", opt.imgPath, "profile", p.Description)
+	return p, nil
+}
+
+// loadProfile returns the ICC profile named by s: sRGB for srgb,
+// otherwise the profile at path s. It returns nil for an empty s.
+func loadProfile(s string) (*icc.Profile, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data := icc.SRGB()
+	if !strings.EqualFold(s, "srgb") {
+		var err error
+		if data, err = os.ReadFile(s); err != nil {
+			return nil, err
+		}
+	}
+	p, err := icc.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	return p, nil
+}
+
+// outputProfile returns the ICC profile the output of an image with the
+// input profile in is converted to and tagged with: the --embed-profile
+// one, or the input one with --icc embed. It returns nil for outputs left
+// untagged in sRGB, as in formats that cannot carry a profile.
+func outputProfile(opt applyOpt, in *icc.Profile, format string) *icc.Profile {
+	switch {
+	case format != "jpeg" && format != "png" && format != "tiff":
+		return nil
+	case opt.outProfile != nil:
+		return opt.outProfile
+	case opt.icc == "embed":
+		return in
+	default:
+		return nil
+	}
+}
+
+// lookupSpace returns the color space with the given name, defaulting
+// to sRGB.
+func lookupSpace(name string) (colorspace.Space, error) {
+	s, ok := colorspace.Lookup(cmp.Or(name, colorspace.SRGB.Name))
+	if !ok {
+		return s, usagef("unknown color space %q: must be one of %s", name, strings.Join(colorspace.Names(), ", "))
+	}
+	return s, nil
+}
+
+// spaceTransforms returns the transforms converting the input from
+// --in-space to the --lut-space the LUT works in, and the output from
+// there to --out-space. Spaces left empty default to sRGB.
+func spaceTransforms(opt applyOpt) (in, out lut.Transform, err error) {
+	inSpace, err := lookupSpace(opt.inSpace)
+	if err != nil {
+		return nil, nil, err
+	}
+	lutSpace, err := lookupSpace(opt.lutSpace)
+	if err != nil {
+		return nil, nil, err
+	}
+	outSpace, err := lookupSpace(opt.outSpace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if inSpace.Name != lutSpace.Name {
+		in = colorspace.Conversion(inSpace, lutSpace)
+	}
+	if outSpace.Name != lutSpace.Name {
+		out = colorspace.Conversion(lutSpace, outSpace)
+	}
+	return in, out, nil
+}
+
+// stage is a processing step run on the image after the LUT is applied.
+type stage func(image.Image) image.Image
+
+// orientStage returns the stage applying the transformation described
+// by the EXIF orientation o, keeping the pixel format of the result.
+func orientStage(o int) stage {
+	return func(img image.Image) image.Image {
+		switch i := img.(type) {
+		case *image.NRGBA:
+			return transform.Orient(i, o)
+		case *image.RGBA64:
+			return transform.Orient(i, o)
+		case *image.NRGBA64:
+			return transform.Orient(i, o)
+		default:
+			return transform.Orient(transform.ToRGBA(img), o)
+		}
+	}
+}
+
+// autoOrient turns the image decoded from the JPEG read from f upright
+// according to its EXIF orientation. It reports whether the image was
+// changed.
+func autoOrient(img image.Image, f io.ReadSeeker) (image.Image, bool, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	o, err := exif.Orientation(f)
+	if err != nil {
+		return nil, false, err
+	}
+	if o == 1 {
+		return img, false, nil
+	}
+	return transform.Orient(transform.ToRGBA(img), o), true, nil
+}
+
+// orientationStages returns the rotate and flip stages requested in opt.
+// The EXIF orientation is handled when decoding, see autoOrient.
+func orientationStages(opt applyOpt) ([]stage, error) {
+	var stages []stage
+
+	switch opt.rotate {
+	case "", "0", "auto":
+	case "90":
+		stages = append(stages, orientStage(6))
+	case "180":
+		stages = append(stages, orientStage(3))
+	case "270":
+		stages = append(stages, orientStage(8))
+	default:
+		return nil, usagef("invalid rotation %q: must be 90, 180, 270 or auto", opt.rotate)
+	}
+
+	switch opt.flip {
+	case "":
+	case "h":
+		stages = append(stages, orientStage(2))
+	case "v":
+		stages = append(stages, orientStage(4))
+	default:
+		return nil, usagef("invalid flip %q: must be h or v", opt.flip)
+	}
+
+	return stages, nil
+}
+
+func apply() error {
+	opt, err := parseApplyOpts()
+	if err != nil {
+		return err
+	}
+	depth, err := parseDepth(opt.depth)
+	if err != nil {
+		return err
+	}
+
+	l, err := loadLut(opt.lut)
+	if err != nil {
+		return err
+	}
+	logLut(opt.lut, l)
+
+	if len(opt.imgPaths) == 0 {
+		return usagef("missing IMAGE argument")
+	}
+	if opt.maskPath != "" {
+		if opt.mask, err = loadMask(opt.maskPath); err != nil {
+			return err
+		}
+	}
+	if opt.underPath != "" {
+		if opt.under, err = loadUnder(opt); err != nil {
+			return err
+		}
+	}
+	opt.tables = map[string]*lut.Table{}
+	if isBatch(opt.imgPaths) {
+		return applyBatch(opt, l, depth)
+	}
+
+	bar := newProgressBar(opt.commonOpt)
+	opt.progress = func(done, total int) {
+		bar.set(float64(done)/float64(total), filepath.Base(opt.imgPaths[0]))
+	}
+	start := time.Now()
+	out, err := applyPath(opt, l, depth, opt.imgPaths[0])
+	bar.clear()
+	if err != nil {
+		return err
+	}
+	logger.Info("graded", "path", opt.imgPaths[0], "output", out, "elapsed", time.Since(start).Round(time.Millisecond))
+	return report(opt.commonOpt, result("apply", out, opt.lut, opt.imgPaths[0]))
+}
+
+// applyPath applies the LUT to the image argument input, a path, URL or
+// object, and returns the output: opt.output, or a file named after the
+// input in opt.outDir or next to the input.
+func applyPath(opt applyOpt, l LUTApplicator, depth lut.Depth, input string) (string, error) {
+	var (
+		cleanup func()
+		err     error
+	)
+	opt.imgPath, cleanup, err = localImage(input)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	// Outputs of downloaded images and outputs to buckets are named
+	// here, the image being graded from a temporary file.
+	dest := opt.output
+	if dest == "" && (isRemote(input) || isObjectURI(opt.outDir)) {
+		name := filepath.Base(defaultOutput(filepath.Base(opt.imgPath), ""))
+		dest = outputPath(cmp.Or(opt.outDir, inputDir(input)), name)
+	}
+	var commit func(ok bool) error
+	if opt.output, commit, err = stageOutput(dest); err != nil {
+		return "", err
+	}
+	if opt.outDir != "" && !isObjectURI(opt.outDir) {
+		if err := os.MkdirAll(opt.outDir, 0o755); err != nil {
+			commit(false)
+			return "", err
+		}
+	}
+	out, err := applyImage(opt, l, depth, opt.outDir)
+	if cerr := commit(err == nil); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if isObjectURI(dest) {
+		out = dest
+	}
+	return out, nil
+}
+
+// loadUnder loads and compiles the --under LUT.
+func loadUnder(opt applyOpt) (*lut.Applier, error) {
+	path, intensity, err := pathAndIntensity(opt.underPath)
+	if err != nil {
+		return nil, err
+	}
+	l, err := loadLut(path)
+	if err != nil {
+		return nil, err
+	}
+	logLut(path, l)
+	return l.Compile(lut.Options{Intensity: intensity, Extended: opt.extended, Mix: opt.mix, Interpolation: opt.interpolation}), nil
+}
+
+// loadMask decodes the grayscale mask at path.
+func loadMask(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := prism.DecodeImage(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("mask %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// imageMask sets up the mask of the image with bounds b: the gradient
+// across it with --gradient, or the mask checked to be as large as it.
+func imageMask(opt *applyOpt, b image.Rectangle) error {
+	if opt.gradient != nil {
+		opt.mask = transform.NewGradient(b, *opt.gradient)
+	}
+	if opt.mask == nil || opt.mask.Bounds() == b {
+		return nil
+	}
+	m := opt.mask.Bounds()
+	return fmt.Errorf("mask is %dx%d, image is %dx%d", m.Dx(), m.Dy(), b.Dx(), b.Dy())
+}
+
+// defaultOutput returns the default output path in dir for the image at
+// path.
+func defaultOutput(path, dir string) string {
+	imgExt := filepath.Ext(path)
+	imgBase := filepath.Base(path)
+	imgName := imgBase[:len(imgBase)-len(imgExt)]
+	// Raw files, told by their extension, are rendered to PNG.
+	if strings.ToLower(imgExt) == ".dng" {
+		imgExt = ".png"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.prism%s", imgName, imgExt))
+}
+
+// applyImage applies the LUT to the image at opt.imgPath and returns the
+// path of the output, which defaults to a file in outDir.
+func applyImage(opt applyOpt, l LUTApplicator, depth lut.Depth, outDir string) (string, error) {
+	f, err := os.Open(opt.imgPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if opt.stripRows > 0 {
+		out, ok, err := applyStrips(opt, l, depth, f, outDir)
+		if ok || err != nil {
+			return out, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+
+	img, format, err := prism.DecodeImage(f, opt.imgPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Turn JPEGs upright before grading so the output is not sideways.
+	var oriented bool
+	if format == "jpeg" && (!opt.noAutorotate || opt.rotate == "auto") {
+		if img, oriented, err = autoOrient(img, f); err != nil {
+			return "", err
+		}
+	}
+	if opt.preview > 0 {
+		img = lut.NewPreview(img, opt.preview).Image()
+		if opt.mask != nil {
+			opt.mask = lut.NewPreview(opt.mask, opt.preview).Image()
+		}
+	}
+	if err := imageMask(&opt, img.Bounds()); err != nil {
+		return "", err
+	}
+
+	stages, err := orientationStages(opt)
+	if err != nil {
+		return "", err
+	}
+
+	if opt.output == "" {
+		opt.output = defaultOutput(opt.imgPath, outDir)
+	}
+
+	profile, err := inputProfile(opt, f, format)
+	if err != nil {
+		return "", err
+	}
+
+	outFormat := prism.ImageFormat(opt.output, format)
+	if opt.bits == 16 && outFormat != "png" && outFormat != "tiff" {
+		return "", usagef("16-bit output is not supported in %s, use PNG or TIFF", strings.ToUpper(outFormat))
+	}
+
+	lutOpt, err := lutOptions(opt, depth)
+	if err != nil {
+		return "", err
+	}
+
+	var meta imgMeta
+	if profile != nil {
+		lutOpt.Input = lut.Chain(profile.ToSRGB, lutOpt.Input)
+	}
+	if out := outputProfile(opt, profile, outFormat); out != nil {
+		if !out.IsSRGB() {
+			lutOpt.Output = lut.Chain(lutOpt.Output, out.FromSRGB)
+		}
+		meta.icc = out.Data
+	}
+
+	applier := compileLut(opt, l, lutOpt, profile, meta.icc)
+
+	if format == "gif" && outFormat == "gif" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		g, err := gif.DecodeAll(f)
+		if err != nil {
+			return "", err
+		}
+
+		if len(g.Image) > 1 {
+			if len(stages) > 0 {
+				return "", usagef("rotate and flip are not supported on animated GIFs")
+			}
+			if opt.mask != nil {
+				return "", usagef("masks and gradients are not supported on animated GIFs")
+			}
+			if opt.compare != "" {
+				return "", usagef("compare is not supported on animated GIFs")
+			}
+			// Palette entries are single colors, not worth dithering.
+			lutOpt.Dither = lut.DitherNone
+			applier = l.Compile(lutOpt)
+			if err := applyGIF(applier, g, opt.output); err != nil {
+				return "", err
+			}
+			if opt.show {
+				if err := termimg.Show(os.Stdout, applier.Apply(g.Image[0])); err != nil {
+					return "", err
+				}
+			}
+			return opt.output, nil
+		}
+	}
+
+	if format == "jpeg" && outFormat == "jpeg" && !opt.stripMetadata {
+		if meta.exif, err = jpegMetadata(f, oriented || len(stages) > 0); err != nil {
+			return "", err
+		}
+	}
+
+	res := gradeImage(applier, img, opt)
+	for _, s := range stages {
+		res = s(res)
+	}
+	if opt.compare != "" {
+		for _, s := range stages {
+			img = s(img)
+		}
+		res = compareImages(opt.compare, img, res)
+	}
+
+	outf, err := os.Create(opt.output)
+	if err != nil {
+		return "", err
+	}
+	defer outf.Close()
+
+	if err := writeImg(outFormat, outf, res, meta, opt.encode); err != nil {
+		return "", err
+	}
+	if opt.show {
+		if err := termimg.Show(os.Stdout, transform.ToRGBA(res)); err != nil {
+			return "", err
+		}
+	}
+	return opt.output, nil
+}
+
+// gradeImage grades img with applier at the output depth of opt, on the
+// GPU with --gpu when the backend is built in and supports the options,
+// and on the CPU otherwise.
+func gradeImage(applier *lut.Applier, img image.Image, opt applyOpt) image.Image {
+	if opt.gpu && opt.bits == 8 {
+		res, err := gpu.Apply(applier, img)
+		if err == nil {
+			return res
+		}
+		logger.Warn(err.Error()+", grading on the CPU", "path", opt.imgPath)
+	}
+	return prism.ApplyCompiled(applier, img, opt.bits)
+}
+
+// compareImages renders the original and the graded image in one, as
+// selected by --compare.
+func compareImages(mode string, before, after image.Image) image.Image {
+	switch mode {
+	case "split":
+		return sheet.Split(before, after)
+	case "sidebyside":
+		return sheet.SideBySide(before, after)
+	default:
+		return sheet.Grid([]sheet.Tile{{Image: before, Label: "Before"}, {Image: after, Label: "After"}}, 2)
+	}
+}
+
+// lutOptions returns the options to compile the LUT with for opt.
+func lutOptions(opt applyOpt, depth lut.Depth) (lut.Options, error) {
+	lutOpt := lut.Options{
+		Intensity:     opt.lutIntensity,
+		Depth:         depth,
+		Linear:        opt.linear,
+		Extended:      opt.extended,
+		VideoRange:    opt.videoRange,
+		Rounding:      opt.rounding,
+		Dither:        opt.dither,
+		Workers:       opt.jobs,
+		OnProgress:    opt.progress,
+		Memo:          opt.memo,
+		Mix:           opt.mix,
+		Mask:          opt.mask,
+		LumaRange:     opt.lumaRange,
+		Under:         opt.under,
+		Component:     opt.component,
+		Interpolation: opt.interpolation,
+	}
+	var err error
+	lutOpt.Input, lutOpt.Output, err = spaceTransforms(opt)
+	return lutOpt, err
+}
+
+// compileLut compiles the LUT for an image with the given input profile
+// and the output profile it is tagged with, if any. Images with the same
+// color profiles share the precomputed table.
+func compileLut(opt applyOpt, l LUTApplicator, lutOpt lut.Options, profile *icc.Profile, outProfile []byte) *lut.Applier {
+	tableKey := ""
+	if profile != nil || outProfile != nil {
+		var in []byte
+		if profile != nil {
+			in = profile.Data
+		}
+		tableKey = fmt.Sprintf("%s %s", in, outProfile)
+	}
+	lutOpt.Table = opt.tables[tableKey]
+	applier := l.Compile(lutOpt)
+	if t := applier.Table(); t != nil && opt.tables != nil {
+		opt.tables[tableKey] = t
+	}
+	return applier
+}
+
+// applyGIF applies the LUT to every frame of an animated GIF and writes
+// it to outPath. The frame palettes are mapped through the LUT, so
+// timings, disposal methods and loop count are preserved as they are.
+func applyGIF(a *lut.Applier, g *gif.GIF, outPath string) error {
+	if p, ok := g.Config.ColorModel.(color.Palette); ok {
+		g.Config.ColorModel = applyPalette(a, p)
+	}
+	for _, frame := range g.Image {
+		frame.Palette = applyPalette(a, frame.Palette)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gif.EncodeAll(f, g)
+}
+
+// applyPalette returns a copy of the palette with each color mapped
+// through the LUT. Fully transparent entries are left unchanged.
+func applyPalette(a *lut.Applier, p color.Palette) color.Palette {
+	img := image.NewRGBA(image.Rect(0, 0, len(p), 1))
+	for i, c := range p {
+		img.Set(i, 0, c)
+	}
+
+	res := a.Apply(img)
+	mapped := make(color.Palette, len(p))
+	for i, c := range p {
+		if _, _, _, alpha := c.RGBA(); alpha == 0 {
+			mapped[i] = c
+			continue
+		}
+		mapped[i] = res.RGBAAt(i, 0)
+	}
+	return mapped
+}
+
+// lutTitle returns the title of the CUBEs made from the LUT at path: its
+// path without the extension.
+func lutTitle(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// ownTitle returns the title stored in l: the TITLE of CUBEs or the
+// Title text of HALDs, "" if it has none.
+func ownTitle(l LUTApplicator) string {
+	switch l := l.(type) {
+	case cube.Cube:
+		return l.Title
+	case hald.HALD:
+		return l.Text[hald.TextTitle]
+	default:
+		return ""
+	}
+}
+
+func convert() error {
+	opt, err := parseConvertOpts()
+	if err != nil {
+		return err
+	}
+	if opt.level < 2 || opt.level > 16 {
+		return usagef("invalid level %d: must be between 2 and 16", opt.level)
+	}
+	if opt.size < 2 || opt.size > 256 {
+		return usagef("invalid size %d: must be between 2 and 256", opt.size)
+	}
+	if opt.batch {
+		return convertBatch(opt)
+	}
+
+	if err := convertLut(opt, opt.lut, opt.output); err != nil {
+		return err
+	}
+	return report(opt.commonOpt, result("convert", opt.output, opt.lut))
+}
+
+// convertLut converts the LUT at lutPath to the format of outPath, a
+// file or an object.
+func convertLut(opt convertOpt, lutPath, outPath string) error {
+	lutExt, err := lutType(lutPath)
+	if err != nil {
+		return err
+	}
+	outExt := strings.ToLower(filepath.Ext(outPath))
+	// LUTs of registered formats convert to either format.
+	_, registered := lut.LookupFormat(lutExt)
+	toHald := outExt == ".png" && (lutExt == ".cube" || registered)
+	toCube := outExt == ".cube" && (lutExt == ".png" || registered)
+	if !toHald && !toCube {
+		return unsupportedf("unsupported conversion from %q to %q", lutExt, outExt)
+	}
+
+	l, err := loadLut(lutPath)
+	if err != nil {
+		return err
+	}
+	logLut(lutPath, l)
+
+	local, commit, err := stageOutput(outPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(local)
+	if err == nil {
+		err = prism.Convert(f, l, outExt, prism.ConvertOptions{
+			Size:        opt.size,
+			Level:       opt.level,
+			Bits:        opt.bits,
+			Compression: opt.compression,
+			Title:       cmp.Or(opt.title, ownTitle(l), lutTitle(lutPath)),
+			Text:        haldText("", []string{lutPath}, nil),
+		})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := commit(err == nil); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func identity() error {
+	opt, err := parseIdentityOpts()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(opt.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	id, err := hald.IdentityDepth(12, opt.bits)
+	if err != nil {
+		return err
+	}
+	enc := hald.Encoder{CompressionLevel: opt.compression}
+	if err := enc.Encode(f, id); err != nil {
+		return err
+	}
+	return report(opt.commonOpt, result("identity", opt.output))
+}
+
+func check(err error) {
+	if err != nil {
+		fail(err)
+	}
+}
+
+func help() error {
+	if len(os.Args) < 3 {
+		usageHelp()
+		return nil
+	}
+
+	if cmd, ok := findCommand(os.Args[2]); ok {
+		cmd.usage()
+	} else {
+		usageGeneral()
+	}
+	return nil
+}
+
+func main() {
+	jsonErrors = slices.Contains(os.Args, "--json-errors") || slices.Contains(os.Args, "-json-errors")
+	if len(os.Args) < 2 {
+		usageGeneral()
+		os.Exit(exitUsage)
+	}
+
+	cmd, ok := findCommand(os.Args[1])
+	if !ok {
+		if !jsonErrors {
+			usageGeneral()
+		}
+		fail(usagef("unsupported command %q", os.Args[1]))
+	}
+	check(cmd.run())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/lut"
+)
+
+// sameLattice resamples a and b on a common lattice as large as the
+// larger of the two when their sizes or domains differ.
+func sameLattice(a, b cube.Cube) (cube.Cube, cube.Cube) {
+	if a.LUT3Dsize == b.LUT3Dsize && a.DomainMin == b.DomainMin && a.DomainMax == b.DomainMax {
+		return a, b
+	}
+	size := max(a.LUT3Dsize, b.LUT3Dsize)
+	resample := func(c cube.Cube) cube.Cube {
+		r := cube.FromTransform(c.Compile(lut.Options{Intensity: 1}).Interpolate, size)
+		r.Title = c.Title
+		return r
+	}
+	return resample(a), resample(b)
+}
+
+func morph() error {
+	opt, err := parseMorphOpts()
+	if err != nil {
+		return err
+	}
+	if len(opt.luts) != 2 {
+		return usagef("morph needs two LUTs, got %d", len(opt.luts))
+	}
+	if opt.steps < 2 {
+		return usagef("invalid steps %d: must be at least 2", opt.steps)
+	}
+	if opt.output == "" {
+		opt.output = "morph_%02d" + filepath.Ext(opt.luts[0])
+	}
+
+	var luts [2]cube.Cube
+	for i, path := range opt.luts {
+		if luts[i], err = loadCube(path, prism.DefaultSize, opt.commonOpt); err != nil {
+			return err
+		}
+	}
+	a, b := sameLattice(luts[0], luts[1])
+
+	title := opt.title
+	if title == "" {
+		names := make([]string, 2)
+		for i, path := range opt.luts {
+			base := filepath.Base(path)
+			names[i] = base[:len(base)-len(filepath.Ext(base))]
+		}
+		title = strings.Join(names, " to ")
+	}
+
+	steps := make([]step, opt.steps)
+	for i := range steps {
+		steps[i] = step{
+			t:     float64(i) / float64(opt.steps-1),
+			num:   i + 1,
+			title: fmt.Sprintf("%s %d/%d", title, i+1, opt.steps),
+		}
+	}
+	return writeSteps(opt.commonOpt, "morph", a, b, opt.mix, steps, opt.luts...)
+}
+
+// step is a LUT of a sequence blending two LUTs.
+type step struct {
+	// Weight of the second LUT.
+	t float64
+	// Number the output pattern is formatted with.
+	num   int
+	title string
+}
+
+// writeSteps writes the blends of a and b of the steps to the files
+// named by the output pattern, formatted with their numbers, and
+// reports them.
+func writeSteps(opt commonOpt, command string, a, b cube.Cube, mix lut.Mix, steps []step, inputs ...string) error {
+	outputs := make([]string, len(steps))
+	for i, s := range steps {
+		outputs[i] = fmt.Sprintf(opt.output, s.num)
+	}
+	if (len(outputs) > 1 && outputs[0] == outputs[1]) || strings.Contains(outputs[0], "%!") {
+		return usagef("invalid output %q: must hold one number verb, such as %%02d", opt.output)
+	}
+
+	for i, s := range steps {
+		c, err := cube.BlendAllIn([]cube.Cube{a, b}, []float64{1 - s.t, s.t}, mix)
+		if err != nil {
+			return err
+		}
+		c.Title = s.title
+		if err := saveCube(outputs[i], *c); err != nil {
+			return err
+		}
+	}
+	res := result(command, "", inputs...)
+	res["outputs"] = outputs
+	return report(opt, res)
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadTimeout bounds each upload to an object store.
+const uploadTimeout = 10 * time.Minute
+
+// objectClient sends the requests to object stores, each bounded by its
+// own deadline.
+var objectClient = &http.Client{}
+
+// objectURI is an object of an S3 or GCS bucket, s3://BUCKET/KEY or
+// gs://BUCKET/KEY. A key that is empty or ends with a slash is a
+// prefix, standing for the objects under it as a directory does.
+type objectURI struct {
+	scheme, bucket, key string
+}
+
+// parseObjectURI parses s as an object URI.
+func parseObjectURI(s string) (objectURI, bool) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok || (scheme != "s3" && scheme != "gs") {
+		return objectURI{}, false
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	return objectURI{scheme, bucket, key}, bucket != ""
+}
+
+// isObjectURI reports whether the argument s is an S3 or GCS URI.
+func isObjectURI(s string) bool {
+	_, ok := parseObjectURI(s)
+	return ok
+}
+
+// isObjectPrefix reports whether the argument s is an S3 or GCS prefix.
+func isObjectPrefix(s string) bool {
+	o, ok := parseObjectURI(s)
+	return ok && o.isPrefix()
+}
+
+func (o objectURI) String() string {
+	return o.scheme + "://" + o.bucket + "/" + o.key
+}
+
+func (o objectURI) isPrefix() bool {
+	return o.key == "" || strings.HasSuffix(o.key, "/")
+}
+
+// outputPath joins the elements to the output directory dir, a local
+// directory or an object prefix.
+func outputPath(dir string, elem ...string) string {
+	o, ok := parseObjectURI(dir)
+	if !ok {
+		return filepath.Join(append([]string{dir}, elem...)...)
+	}
+	for _, e := range elem {
+		o.key = path.Join(o.key, filepath.ToSlash(e))
+	}
+	return o.String()
+}
+
+// outputExists reports whether the output at path, a file or an object,
+// exists.
+func outputExists(path string) (bool, error) {
+	o, ok := parseObjectURI(path)
+	if !ok {
+		_, err := os.Stat(path)
+		return err == nil, nil
+	}
+	store, err := objectStoreFor(o)
+	if err != nil {
+		return false, err
+	}
+	return store.exists(o)
+}
+
+// makeOutputDir creates the directory of the output at path. Objects
+// need none.
+func makeOutputDir(path string) error {
+	if isObjectURI(path) {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(path), 0o755)
+}
+
+// inputDir returns the directory the outputs of the input at path are
+// written to by default: that of the file, the prefix of the object, or
+// the current directory for URLs.
+func inputDir(p string) string {
+	if o, ok := parseObjectURI(p); ok {
+		o.key = strings.TrimSuffix(o.key, path.Base(o.key))
+		return o.String()
+	}
+	if isURL(p) {
+		return ""
+	}
+	return filepath.Dir(p)
+}
+
+// stageOutput returns the local path the output dest is written to:
+// dest itself, or a temporary file named as the object dest. The
+// returned function, called once the output is written or failed,
+// uploads it if ok and removes the temporary file.
+func stageOutput(dest string) (string, func(ok bool) error, error) {
+	o, isObject := parseObjectURI(dest)
+	if !isObject {
+		return dest, func(bool) error { return nil }, nil
+	}
+	store, err := objectStoreFor(o)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "prism-")
+	if err != nil {
+		return "", nil, err
+	}
+	p := filepath.Join(dir, path.Base(o.key))
+
+	return p, func(ok bool) error {
+		defer os.RemoveAll(dir)
+		if !ok {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := store.put(o, f, fi.Size()); err != nil {
+			return err
+		}
+		logger.Info("uploaded", "path", dest, "size", fi.Size())
+		return nil
+	}, nil
+}
+
+// listObjects returns the objects under the prefix p.
+func listObjects(p objectURI) ([]objectURI, error) {
+	store, err := objectStoreFor(p)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := store.list(p)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]objectURI, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			objs = append(objs, objectURI{p.scheme, p.bucket, key})
+		}
+	}
+	return objs, nil
+}
+
+// getObject downloads the object o to w, failing for objects larger
+// than limit bytes.
+func getObject(o objectURI, w io.Writer, limit int64) error {
+	store, err := objectStoreFor(o)
+	if err != nil {
+		return err
+	}
+	return store.get(o, w, limit)
+}
+
+// objectStore is the client of S3 or GCS.
+type objectStore interface {
+	get(o objectURI, w io.Writer, limit int64) error
+	put(o objectURI, r io.Reader, size int64) error
+	exists(o objectURI) (bool, error)
+	list(prefix objectURI) ([]string, error)
+}
+
+// objectStoreFor returns the client of the store of o.
+func objectStoreFor(o objectURI) (objectStore, error) {
+	if o.scheme == "gs" {
+		return gcs, nil
+	}
+	return newS3Store()
+}
+
+// copyLimited copies the response body r to w, failing past limit
+// bytes.
+func copyLimited(o objectURI, w io.Writer, r io.Reader, limit int64) error {
+	n, err := io.Copy(w, io.LimitReader(r, limit+1))
+	if err != nil {
+		return exitError{exitIO, fmt.Errorf("%s: %w", o, err)}
+	}
+	if n > limit {
+		return exitError{exitIO, fmt.Errorf("%s: larger than %d MiB", o, limit>>20)}
+	}
+	return nil
+}
+
+// objectError returns the error of the failed response resp to the
+// request of o, with the message the store sent, if any.
+func objectError(o objectURI, resp *http.Response, message string) error {
+	err := fmt.Errorf("%s: %s", o, resp.Status)
+	if message != "" {
+		err = fmt.Errorf("%s: %s", o, message)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+	}
+	return exitError{exitIO, err}
+}
+
+// s3Store is the client of S3, or of an S3 compatible store at the
+// endpoint in $AWS_ENDPOINT_URL_S3 or $AWS_ENDPOINT_URL. Requests are
+// signed with the credentials in the AWS environment variables, and
+// anonymous without them.
+type s3Store struct {
+	endpoint *url.URL
+	region   string
+	keyID    string
+	secret   string
+	token    string
+}
+
+func newS3Store() (*s3Store, error) {
+	s := &s3Store{
+		region: cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		keyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if ep := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); ep != "" {
+		u, err := url.Parse(ep)
+		if err != nil || u.Host == "" {
+			return nil, usagef("invalid S3 endpoint %q", ep)
+		}
+		s.endpoint = u
+	}
+	return s, nil
+}
+
+// s3Escape escapes s as S3 signatures expect, keeping the slashes if
+// path is set.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// do sends the request of o with the query and body, signed.
+func (s *s3Store) do(ctx context.Context, method string, o objectURI, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	// Virtual-hosted buckets on AWS, path-style ones elsewhere.
+	scheme, host, p := "https", o.bucket+".s3."+s.region+".amazonaws.com", "/"+o.key
+	if s.endpoint != nil {
+		scheme, host = s.endpoint.Scheme, s.endpoint.Host
+		p = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + o.bucket + "/" + o.key
+	}
+
+	canonicalPath, canonicalQuery := s3Escape(p, true), s3Query(query)
+
+	u := scheme + "://" + host + canonicalPath
+	if canonicalQuery != "" {
+		u += "?" + canonicalQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", objectContentType(o.key))
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	if s.keyID != "" {
+		r := sigV4Request{
+			method: method,
+			path:   canonicalPath,
+			query:  canonicalQuery,
+			headers: [][2]string{
+				{"host", host},
+				{"x-amz-content-sha256", "UNSIGNED-PAYLOAD"},
+				{"x-amz-date", amzDate},
+			},
+			payload: "UNSIGNED-PAYLOAD",
+		}
+		if s.token != "" {
+			r.headers = append(r.headers, [2]string{"x-amz-security-token", s.token})
+		}
+		req.Header.Set("Authorization", r.authorization(s.keyID, s.secret, s.region, "s3", amzDate))
+	}
+
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, exitError{exitIO, err}
+	}
+	return resp, nil
+}
+
+// s3Query returns the canonical form of the query of an S3 request, with
+// the parameters sorted by name and escaped.
+func s3Query(query url.Values) string {
+	keys := slices.Sorted(func(yield func(string) bool) {
+		for k := range query {
+			if !yield(k) {
+				return
+			}
+		}
+	})
+	var params []string
+	for _, k := range keys {
+		params = append(params, s3Escape(k, false)+"="+s3Escape(query.Get(k), false))
+	}
+	return strings.Join(params, "&")
+}
+
+// sigV4Request is a request as AWS Signature Version 4 signs it: its
+// canonical path and query, the headers signed, with lowercase names
+// sorted, and the hex SHA-256 hash of the body or UNSIGNED-PAYLOAD.
+type sigV4Request struct {
+	method, path, query string
+	headers             [][2]string
+	payload             string
+}
+
+// canonical returns the canonical request and the names of the signed
+// headers.
+func (r sigV4Request) canonical() (canonical, signed string) {
+	var headers, names []string
+	for _, h := range r.headers {
+		headers = append(headers, h[0]+":"+strings.TrimSpace(h[1]))
+		names = append(names, h[0])
+	}
+	signed = strings.Join(names, ";")
+	return strings.Join([]string{
+		r.method, r.path, r.query,
+		strings.Join(headers, "\n") + "\n", signed, r.payload,
+	}, "\n"), signed
+}
+
+// authorization returns the Authorization header of the request signed
+// at amzDate with the secret key of keyID for the service in region.
+func (r sigV4Request) authorization(keyID, secret, region, service, amzDate string) string {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	canonical, signed := r.canonical()
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := mac(mac(mac(mac([]byte("AWS4"+secret), amzDate[:8]), region), service), "aws4_request")
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keyID, scope, signed, hex.EncodeToString(mac(key, toSign)))
+}
+
+// s3Error returns the error of the failed response resp to the request
+// of o.
+func s3Error(o objectURI, resp *http.Response) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return objectError(o, resp, strings.TrimSuffix(e.Code+": "+e.Message, ": "))
+	}
+	return objectError(o, resp, "")
+}
+
+func (s *s3Store) get(o objectURI, w io.Writer, limit int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodGet, o, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(o, resp)
+	}
+	return copyLimited(o, w, resp.Body, limit)
+}
+
+func (s *s3Store) put(o objectURI, r io.Reader, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodPut, o, nil, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(o, resp)
+	}
+	return nil
+}
+
+func (s *s3Store) exists(o objectURI) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodHead, o, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, objectError(o, resp, "")
+	}
+}
+
+func (s *s3Store) list(prefix objectURI) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix.key}}
+	for {
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err := func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+			defer cancel()
+			resp, err := s.do(ctx, http.MethodGet, objectURI{prefix.scheme, prefix.bucket, ""}, query, nil, 0)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return s3Error(prefix, resp)
+			}
+			return xml.NewDecoder(resp.Body).Decode(&page)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !page.IsTruncated {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// gcsStore is the client of Google Cloud Storage, or of the emulator at
+// $STORAGE_EMULATOR_HOST, through its JSON API.
+type gcsStore struct {
+	// token returns the OAuth access token requests are authorized with,
+	// empty for anonymous requests.
+	token func() (string, error)
+}
+
+var gcs = &gcsStore{token: sync.OnceValues(gcsToken)}
+
+// gcsScope is the OAuth scope of the tokens of the service accounts.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsToken returns an access token: that in $GOOGLE_OAUTH_ACCESS_TOKEN,
+// one for the service account whose key is in the file in
+// $GOOGLE_APPLICATION_CREDENTIALS or one from the metadata server of
+// Google Cloud machines. Requests are anonymous, for public buckets and
+// emulators, when none is available.
+func gcsToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		return "", nil
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return serviceAccountToken(path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	host := cmp.Or(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		// Not on Google Cloud.
+		return "", nil
+	}
+	defer resp.Body.Close()
+	return readToken(resp)
+}
+
+// serviceAccountToken exchanges a JWT signed with the key of the service
+// account in the credentials file at path for an access token.
+func serviceAccountToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var creds struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", parseError(fmt.Errorf("%s: %w", path, err))
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", parseError(fmt.Errorf("%s: invalid private key", path))
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", parseError(fmt.Errorf("%s: %w", path, err))
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", parseError(fmt.Errorf("%s: private key is not RSA", path))
+	}
+
+	tokenURI := cmp.Or(creds.TokenURI, "https://oauth2.googleapis.com/token")
+	now := time.Now().Unix()
+	enc := base64.RawURLEncoding
+	claims, _ := json.Marshal(map[string]any{
+		"iss": creds.ClientEmail, "scope": gcsScope, "aud": tokenURI,
+		"iat": now, "exp": now + 3600,
+	})
+	jwt := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(jwt))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	jwt += "." + enc.EncodeToString(sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {jwt}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return "", exitError{exitIO, err}
+	}
+	defer resp.Body.Close()
+	return readToken(resp)
+}
+
+// readToken reads the access token of an OAuth token response.
+func readToken(resp *http.Response) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", exitError{exitIO, fmt.Errorf("cannot get a Google Cloud access token: %s", resp.Status)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", exitError{exitIO, fmt.Errorf("cannot get a Google Cloud access token: %w", err)}
+	}
+	return token.AccessToken, nil
+}
+
+// do sends the request of the path of the JSON API with the query and
+// body, of the given content type, authorized.
+func (g *gcsStore) do(ctx context.Context, method, p string, query url.Values, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	base := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		base = host
+		if !strings.Contains(host, "://") {
+			base = "http://" + host
+		}
+	}
+	u := strings.TrimSuffix(base, "/") + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+	token, err := g.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := objectClient.Do(req)
+	if err != nil {
+		return nil, exitError{exitIO, err}
+	}
+	return resp, nil
+}
+
+// gcsError returns the error of the failed response resp to the request
+// of o.
+func gcsError(o objectURI, resp *http.Response) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &e) == nil {
+		return objectError(o, resp, e.Error.Message)
+	}
+	return objectError(o, resp, string(bytes.TrimSpace(data)))
+}
+
+// objectPath returns the path of o in the JSON API.
+func (g *gcsStore) objectPath(o objectURI) string {
+	return "/storage/v1/b/" + url.PathEscape(o.bucket) + "/o/" + url.PathEscape(o.key)
+}
+
+func (g *gcsStore) get(o objectURI, w io.Writer, limit int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := g.do(ctx, http.MethodGet, g.objectPath(o), url.Values{"alt": {"media"}}, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return gcsError(o, resp)
+	}
+	return copyLimited(o, w, resp.Body, limit)
+}
+
+func (g *gcsStore) put(o objectURI, r io.Reader, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	query := url.Values{"uploadType": {"media"}, "name": {o.key}}
+	resp, err := g.do(ctx, http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(o.bucket)+"/o", query, r, size, objectContentType(o.key))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return gcsError(o, resp)
+	}
+	return nil
+}
+
+func (g *gcsStore) exists(o objectURI) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	resp, err := g.do(ctx, http.MethodGet, g.objectPath(o), nil, nil, 0, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, gcsError(o, resp)
+	}
+}
+
+func (g *gcsStore) list(prefix objectURI) ([]string, error) {
+	var keys []string
+	query := url.Values{"prefix": {prefix.key}, "fields": {"items(name),nextPageToken"}}
+	for {
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err := func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+			defer cancel()
+			resp, err := g.do(ctx, http.MethodGet, "/storage/v1/b/"+url.PathEscape(prefix.bucket)+"/o", query, nil, 0, "")
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return gcsError(prefix, resp)
+			}
+			return json.NewDecoder(resp.Body).Decode(&page)
+		}()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			keys = append(keys, item.Name)
+		}
+		if page.NextPageToken == "" {
+			return keys, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// objectContentType returns the content type uploads of the object at
+// key are tagged with.
+func objectContentType(key string) string {
+	return cmp.Or(mime.TypeByExtension(path.Ext(key)), "application/octet-stream")
+}
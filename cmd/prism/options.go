@@ -0,0 +1,1277 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/colorspace"
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/icc"
+	"github.com/NicoNex/prism/jpegenc"
+	"github.com/NicoNex/prism/lut"
+	"github.com/NicoNex/prism/transform"
+)
+
+// commonOpt holds the options shared by every command.
+type commonOpt struct {
+	output  string
+	title   string
+	jobs    int
+	json    bool
+	quiet   bool
+	verbose bool
+}
+
+// register adds the common flags to cmd, using defOutput as the default
+// output path.
+func (o *commonOpt) register(cmd *flag.FlagSet, defOutput string) {
+	cmd.StringVar(&o.output, "o", defOutput, "Write the output in the given file")
+	cmd.StringVar(&o.output, "out", defOutput, "Write the output in the given file (same as -o)")
+	cmd.StringVar(&o.title, "t", "", "Specify the title to use for the generated lut")
+	cmd.StringVar(&o.title, "title", "", "Specify the title to use for the generated lut (same as -t)")
+	cmd.IntVar(&o.jobs, "j", 0, "Number of parallel jobs (default: number of CPUs)")
+	cmd.IntVar(&o.jobs, "jobs", 0, "Number of parallel jobs (same as -j)")
+	cmd.BoolVar(&o.json, "json", false, "Print the result as JSON on stdout")
+	cmd.BoolVar(&o.quiet, "q", false, "Suppress warnings and informational output, printing only errors")
+	cmd.BoolVar(&o.quiet, "quiet", false, "Suppress warnings and informational output, printing only errors (same as -q)")
+	cmd.BoolVar(&o.verbose, "v", false, "Print per-file timings, LUT details and the warnings found while loading LUTs")
+	cmd.BoolVar(&o.verbose, "verbose", false, "Print per-file timings, LUT details and the warnings found while loading LUTs (same as -v)")
+	cmd.BoolVar(&jsonErrors, "json-errors", jsonErrors, "Print errors as JSON objects on stderr")
+	cmd.BoolVar(&strict, "strict", false, "Fail on LUTs deviating from the specification of their format")
+	cmd.Init(cmd.Name(), flag.ContinueOnError)
+	cmd.SetOutput(io.Discard)
+}
+
+// parse parses the command line arguments of the command, over the
+// defaults of the configuration file, and applies the common options.
+func (o *commonOpt) parse(cmd *flag.FlagSet) {
+	if err := applyConfig(cmd); err != nil {
+		fail(err)
+	}
+	o.parseArgs(cmd, os.Args[2:])
+	logger = slog.New(newCLIHandler(os.Stderr, optLevel{o}))
+	if o.jobs > 0 {
+		runtime.GOMAXPROCS(o.jobs)
+	}
+}
+
+// parseArgs parses args with cmd, printing the usage of the command and
+// exiting on -h and on invalid options. Commands call it again on the
+// arguments after the first one left to take options after arguments.
+func (o *commonOpt) parseArgs(cmd *flag.FlagSet, args []string) {
+	usage := cmd.Usage
+	cmd.Usage = func() {}
+	err := cmd.Parse(args)
+	cmd.Usage = usage
+
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		usage()
+		os.Exit(0)
+	case err != nil:
+		if !jsonErrors {
+			usage()
+		}
+		fail(exitError{exitUsage, err})
+	}
+}
+
+type convertOpt struct {
+	commonOpt
+	lut         string
+	level       int
+	size        int
+	batch       bool
+	to          string
+	bits        int
+	compression png.CompressionLevel
+	overwrite   overwriteOpt
+}
+
+type applyOpt struct {
+	commonOpt
+	imgPaths      []string
+	imgPath       string
+	lut           string
+	lutIntensity  float64
+	outDir        string
+	rotate        string
+	flip          string
+	depth         string
+	noAutorotate  bool
+	linear        bool
+	inSpace       string
+	outSpace      string
+	lutSpace      string
+	extended      bool
+	videoRange    bool
+	bits          int
+	encode        prism.EncodeOptions
+	rounding      lut.Rounding
+	interpolation lut.Interpolation
+	dither        lut.Dither
+	mix           lut.Mix
+	component     lut.Component
+	maskPath      string
+	mask          image.Image
+	lumaRange     *lut.LumaRange
+	gradient      *transform.Direction
+	underPath     string
+	under         *lut.Applier
+	compare       string
+	preview       int
+	progress      func(done, total int)
+	tables        map[string]*lut.Table
+	stripMetadata bool
+	show          bool
+	icc           string
+	outProfile    *icc.Profile
+	stripRows     int
+	lowMemory     bool
+	memo          bool
+	gpu           bool
+	filter        imageFilter
+	overwrite     overwriteOpt
+	resume        bool
+	statePath     string
+}
+
+type composeOpt struct {
+	commonOpt
+	size  int
+	steps []string
+}
+
+type generateOpt struct {
+	commonOpt
+	kind string
+	size int
+	from string
+	to   string
+}
+
+type lutOpt struct {
+	commonOpt
+	sub  string
+	args []string
+	name string
+	tags []string
+}
+
+type runOpt struct {
+	applyOpt
+	manifest string
+}
+
+type codegenOpt struct {
+	commonOpt
+	lut  string
+	pkg  string
+	name string
+	size int
+}
+
+type morphOpt struct {
+	commonOpt
+	steps int
+	mix   lut.Mix
+	luts  []string
+}
+
+type rampOpt struct {
+	commonOpt
+	steps int
+	mix   lut.Mix
+	lut   string
+}
+
+type identifyOpt struct {
+	commonOpt
+	luts []string
+}
+
+type previewOpt struct {
+	commonOpt
+	size        int
+	cols        int
+	noOriginal  bool
+	show        bool
+	image       string
+	luts        []string
+	intensities []float64
+}
+
+type identityOpt struct {
+	commonOpt
+	bits        int
+	compression png.CompressionLevel
+}
+
+type blendOpt struct {
+	commonOpt
+	clamp       bool
+	rescale     bool
+	size        int
+	mode        cube.Mode
+	mix         lut.Mix
+	luts        []string
+	intensities []float64
+}
+
+func parseConvertOpts() (opt convertOpt, err error) {
+	var compression string
+
+	cmd := flag.NewFlagSet("convert", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.level, "level", 12, "Level of the HALD written from a CUBE, from 2 to 16")
+	cmd.IntVar(&opt.size, "s", 33, "Points per axis of the CUBE written from a HALD, such as 17, 33 or 65")
+	cmd.IntVar(&opt.size, "size", 33, "Points per axis of the CUBE written from a HALD (same as -s)")
+	cmd.BoolVar(&opt.batch, "batch", false, "Convert every LUT in the LUT directory to the OUTPUT directory")
+	cmd.StringVar(&opt.to, "to", "", "Format batch conversions write: cube or png")
+	cmd.IntVar(&opt.bits, "bits", 8, "Bits per channel of the HALD written from a CUBE: 8 or 16")
+	cmd.StringVar(&compression, "compression", "default", "Compression of the HALD written from a CUBE: default, fast, best or none")
+	opt.overwrite.register(cmd)
+	cmd.Usage = usageConvert
+	opt.parse(cmd)
+
+	// Options may also follow the arguments.
+	var args []string
+	for cmd.NArg() > 0 {
+		args = append(args, cmd.Arg(0))
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	if len(args) > 0 {
+		opt.lut = args[0]
+	}
+	if opt.output == "" && len(args) > 1 {
+		opt.output = args[1]
+	}
+
+	if opt.bits != 8 && opt.bits != 16 {
+		return opt, usagef("invalid bits %d: must be 8 or 16", opt.bits)
+	}
+	if err := opt.overwrite.validate(); err != nil {
+		return opt, err
+	}
+	opt.compression, err = parseCompression(compression)
+	return
+}
+
+// registerApply adds the options of apply to cmd, along with the common
+// ones. The returned function checks and converts their values once cmd
+// is parsed.
+func (opt *applyOpt) registerApply(cmd *flag.FlagSet) func() error {
+	var (
+		rounding, dither, mix, component string
+		lumaRange, gradient, subsampling string
+		compression, profile, intensity  string
+		interpolation                    string
+		shadows, midtones, highlights    bool
+	)
+
+	opt.register(cmd, "")
+	cmd.StringVar(&opt.outDir, "out-dir", "", "Directory the outputs are written to when -o is not given")
+	cmd.StringVar(&intensity, "intensity", "1", "Intensity of the LUT when not given after its path")
+	cmd.StringVar(&opt.rotate, "rotate", "", "Rotate the output clockwise by 90, 180 or 270 degrees")
+	cmd.BoolVar(&opt.noAutorotate, "no-autorotate", false, "Do not rotate JPEG inputs according to their EXIF orientation")
+	cmd.StringVar(&opt.flip, "flip", "", "Flip the output horizontally (h) or vertically (v)")
+	cmd.StringVar(&opt.depth, "depth", "float", "Working color depth: 8 (fast), table (precomputed, for batches) or float (high quality)")
+	cmd.BoolVar(&opt.linear, "linear", false, "Convert pixels to linear light before the lookup, for LUTs designed for linear input")
+	cmd.StringVar(&opt.inSpace, "in-space", "", "Color space of the input image, converted to the LUT space before the lookup (srgb, linear, rec709, rec2020, pq, hlg, slog3, vlog, logc, clog)")
+	cmd.StringVar(&opt.outSpace, "out-space", "", "Color space to encode the output in after the lookup")
+	cmd.StringVar(&opt.lutSpace, "lut-space", "", "Color space the LUT expects its input in (default: srgb)")
+	cmd.BoolVar(&opt.extended, "extended", false, "Look up values in the units of the LUT domain, for HDR and scene-linear LUTs")
+	cmd.BoolVar(&opt.videoRange, "video-range", false, "Treat the image as video (legal) range, with black at 16 and white at 235")
+	cmd.StringVar(&rounding, "rounding", "nearest", "Rounding when quantizing the result: nearest or truncate")
+	cmd.StringVar(&interpolation, "interpolation", "trilinear", "Interpolation between the points of the LUT: trilinear or tetrahedral")
+	cmd.StringVar(&dither, "dither", "none", "Dithering when quantizing to 8 bits: none, ordered or diffusion")
+	cmd.StringVar(&opt.maskPath, "mask", "", "Grayscale image weighting the LUT intensity of each pixel, from black (ungraded) to white")
+	cmd.StringVar(&mix, "mix", "rgb", "Color space the LUT result is mixed with the original in below full intensity: rgb, hsl or oklab")
+	cmd.StringVar(&component, "component", "all", "Part of the color the LUT changes: all, luma (contrast without the color cast) or chroma (color cast without the contrast)")
+	cmd.StringVar(&lumaRange, "luma-range", "", "Grade only the pixels whose luma is in MIN:MAX, from 0 to 1, fading out over an optional :FEATHER")
+	cmd.BoolVar(&shadows, "shadows", false, "Grade only the shadows (same as --luma-range 0:0.2:0.3)")
+	cmd.BoolVar(&midtones, "midtones", false, "Grade only the midtones (same as --luma-range 0.35:0.65:0.25)")
+	cmd.BoolVar(&highlights, "highlights", false, "Grade only the highlights (same as --luma-range 0.8:1:0.3)")
+	cmd.StringVar(&gradient, "gradient", "", "Fade the LUT out across the image to the --under LUT or the original: left-right, right-left, top-bottom, bottom-top or radial")
+	cmd.StringVar(&opt.underPath, "under", "", "LUT graded where the LUT is faded out by --gradient, --mask or a luma range, instead of the original image")
+	cmd.IntVar(&opt.preview, "preview", 0, "Downscale the image to fit in the given pixels before grading, for fast previews")
+	cmd.StringVar(&opt.compare, "compare", "", "Render the original and the graded image in one: split (vertical split), sidebyside or slider (labeled panels)")
+	cmd.IntVar(&opt.bits, "bits", 8, "Output bits per channel: 8 or 16 (PNG and TIFF only)")
+	cmd.IntVar(&opt.encode.JPEG.Quality, "quality", jpegenc.DefaultQuality, "Quality of JPEG outputs, from 1 to 100")
+	cmd.BoolVar(&opt.encode.JPEG.Progressive, "progressive", false, "Write progressive JPEGs, loading coarse to fine in browsers")
+	cmd.StringVar(&subsampling, "subsampling", "420", "Chroma subsampling of JPEG outputs: 420, 422 or 444 (full resolution)")
+	cmd.StringVar(&compression, "compression", "default", "Compression of PNG outputs: default, fast, best or none")
+	cmd.BoolVar(&opt.stripMetadata, "strip-metadata", false, "Do not copy the EXIF metadata of JPEG inputs to the output")
+	cmd.StringVar(&opt.icc, "icc", "convert", "Handling of embedded ICC profiles: convert (to sRGB), embed or ignore")
+	cmd.StringVar(&profile, "embed-profile", "", "ICC profile to tag JPEG, PNG and TIFF outputs with: srgb or the path of an RGB profile")
+	cmd.IntVar(&opt.filter.minSize, "min-size", 0, "Batch runs: skip images whose shorter side is smaller than the given pixels")
+	cmd.Float64Var(&opt.filter.maxAspect, "max-aspect", 0, "Batch runs: skip images whose long to short side ratio exceeds the given value")
+	cmd.BoolVar(&opt.filter.skipScreenshots, "skip-screenshots", false, "Batch runs: skip screenshots detected by name and metadata")
+	opt.overwrite.register(cmd)
+	cmd.BoolVar(&opt.resume, "resume", false, "Batch runs: continue an interrupted run, skipping the images it graded")
+	cmd.StringVar(&opt.statePath, "state", "", "Batch runs: state file recording the images graded (default: .prism-batch.state in the output directory)")
+	cmd.BoolVar(&opt.show, "show", false, "Display the result inline in the terminal (kitty, iTerm2 or sixel)")
+	cmd.IntVar(&opt.stripRows, "strip-rows", 0, "Grade TIFF and PNG images the given number of rows at a time, streaming them to the output")
+	cmd.BoolVar(&opt.lowMemory, "low-memory", false, "Grade TIFF and PNG images in strips, reading TIFFs through a memory mapping")
+	cmd.BoolVar(&opt.memo, "memo", false, "Cache the results of repeated colors, for screenshots and graphics with large flat areas")
+	cmd.BoolVar(&opt.gpu, "gpu", false, "Grade 8-bit outputs on the GPU, in builds with the gpu tag, falling back to the CPU")
+
+	return func() (err error) {
+		if opt.bits != 8 && opt.bits != 16 {
+			return usagef("invalid bits %d: must be 8 or 16", opt.bits)
+		}
+		if err := opt.overwrite.validate(); err != nil {
+			return err
+		}
+		if q := opt.encode.JPEG.Quality; q < 1 || q > 100 {
+			return usagef("invalid quality %d: must be between 1 and 100", q)
+		}
+		if opt.stripRows < 0 {
+			return usagef("invalid strip rows %d: must be positive", opt.stripRows)
+		}
+		if opt.lowMemory && opt.stripRows == 0 {
+			opt.stripRows = lowMemoryRows
+		}
+		switch opt.compare {
+		case "", "split", "sidebyside", "slider":
+		default:
+			return usagef("invalid compare %q: must be split, sidebyside or slider", opt.compare)
+		}
+		if opt.preview < 0 {
+			return usagef("invalid preview size %d: must be positive", opt.preview)
+		}
+		if opt.preview > 0 && opt.stripRows > 0 {
+			return usagef("--preview is not supported with strips")
+		}
+		if opt.compare != "" && opt.stripRows > 0 {
+			return usagef("--compare is not supported with strips")
+		}
+		if opt.rounding, err = parseRounding(rounding); err != nil {
+			return err
+		}
+		if opt.interpolation, err = parseInterpolation(interpolation); err != nil {
+			return err
+		}
+		if opt.encode.JPEG.Subsampling, err = parseSubsampling(subsampling); err != nil {
+			return err
+		}
+		if opt.encode.PNGCompression, err = parseCompression(compression); err != nil {
+			return err
+		}
+		if opt.outProfile, err = loadProfile(profile); err != nil {
+			return err
+		}
+		if opt.dither, err = parseDither(dither); err != nil {
+			return err
+		}
+		if opt.mix, err = parseMix(mix); err != nil {
+			return err
+		}
+		if opt.component, err = parseComponent(component); err != nil {
+			return err
+		}
+		if opt.lumaRange, err = parseLumaRange(lumaRange, shadows, midtones, highlights); err != nil {
+			return err
+		}
+		if opt.gradient, err = parseGradient(gradient); err != nil {
+			return err
+		}
+		if opt.gradient != nil && opt.maskPath != "" {
+			return usagef("only one of --gradient and --mask can be set")
+		}
+		if opt.lutIntensity, err = parseIntensity(intensity); err != nil {
+			return usagef("invalid intensity %q: %w", intensity, err)
+		}
+		return nil
+	}
+}
+
+func parseApplyOpts() (opt applyOpt, err error) {
+	cmd := flag.NewFlagSet("apply", flag.ExitOnError)
+	finish := opt.registerApply(cmd)
+	cmd.Usage = usageApply
+	opt.parse(cmd)
+	if err = finish(); err != nil {
+		return
+	}
+
+	// An intensity after the path overrides --intensity.
+	var intensity float64
+	if opt.lut, intensity, err = pathAndIntensity(cmd.Arg(0)); err != nil {
+		return
+	}
+	if opt.lut != cmd.Arg(0) {
+		opt.lutIntensity = intensity
+	}
+	opt.imgPaths = cmd.Args()[min(1, cmd.NArg()):]
+	return
+}
+
+func parseBlendOpts() (opt blendOpt, err error) {
+	var mode, mix string
+
+	cmd := flag.NewFlagSet("blend", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.BoolVar(&opt.clamp, "c", false, "Clamp the blended LUT to its domain")
+	cmd.BoolVar(&opt.clamp, "clamp", false, "Clamp the blended LUT to its domain (same as -c)")
+	cmd.IntVar(&opt.size, "s", 0, "Points per axis of the blended CUBE")
+	cmd.IntVar(&opt.size, "size", 0, "Points per axis of the blended CUBE (same as -s)")
+	cmd.BoolVar(&opt.rescale, "rescale", false, "Rescale a blended LUT out of its domain into it instead of clamping it")
+	cmd.StringVar(&mode, "mode", "normal", "Blend mode: normal, multiply, screen, overlay, darken or lighten")
+	cmd.StringVar(&mix, "mix", "rgb", "Color space the normal mode averages the LUTs in: rgb, hsl or oklab")
+	cmd.Usage = usageBlend
+	opt.parse(cmd)
+
+	if opt.mode, err = parseMode(mode); err != nil {
+		return
+	}
+	if opt.mix, err = parseMix(mix); err != nil {
+		return
+	}
+	if opt.size != 0 && (opt.size < 2 || opt.size > 256) {
+		return opt, usagef("invalid size %d: must be between 2 and 256", opt.size)
+	}
+	if cmd.NArg() < 2 {
+		return opt, usagef("blend needs at least two LUTs, got %d", cmd.NArg())
+	}
+	for _, arg := range cmd.Args() {
+		path, intensity, err := pathAndIntensity(arg)
+		if err != nil {
+			return opt, err
+		}
+		opt.luts = append(opt.luts, path)
+		opt.intensities = append(opt.intensities, intensity)
+	}
+	return
+}
+
+func parseComposeOpts() (opt composeOpt) {
+	cmd := flag.NewFlagSet("compose", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.size, "s", 33, "Number of points per axis of the composed LUT")
+	cmd.IntVar(&opt.size, "size", 33, "Number of points per axis of the composed LUT (same as -s)")
+	cmd.Usage = usageCompose
+	opt.parse(cmd)
+
+	opt.steps = cmd.Args()
+	return
+}
+
+func parseGenerateOpts() (opt generateOpt) {
+	cmd := flag.NewFlagSet("generate", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.size, "s", 33, "Number of points per axis of the generated LUT")
+	cmd.IntVar(&opt.size, "size", 33, "Number of points per axis of the generated LUT (same as -s)")
+	cmd.StringVar(&opt.from, "from", "", "Color space the LUT converts from")
+	cmd.StringVar(&opt.to, "to", "", "Color space the LUT converts to")
+	cmd.Usage = usageGenerate
+	opt.parse(cmd)
+
+	// Options may also follow the kind.
+	opt.kind = cmd.Arg(0)
+	if cmd.NArg() > 1 {
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	return
+}
+
+func parseRunOpts() (opt runOpt, err error) {
+	cmd := flag.NewFlagSet("run", flag.ExitOnError)
+	finish := opt.registerApply(cmd)
+	cmd.StringVar(&opt.manifest, "manifest", "-", "JSON Lines file of the jobs, or - for standard input")
+	cmd.Usage = usageRun
+	opt.parse(cmd)
+
+	// Options may also follow the manifest.
+	var args []string
+	for cmd.NArg() > 0 {
+		args = append(args, cmd.Arg(0))
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	if err = finish(); err != nil {
+		return
+	}
+	if len(args) > 1 {
+		return opt, usagef("run takes one manifest, got %d", len(args))
+	}
+	if len(args) == 1 {
+		opt.manifest = args[0]
+	}
+	if opt.output != "" {
+		return opt, usagef("-o is not supported by run: give each job its output, or use --out-dir")
+	}
+	return
+}
+
+func parseLutOpts() (opt lutOpt, err error) {
+	opt.tags = []string{}
+
+	cmd := flag.NewFlagSet("lut", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.StringVar(&opt.name, "name", "", "Name of the added LUT (default: the file name without extension)")
+	cmd.Func("tag", "Tag of the added LUT, or of the listed LUTs; may be repeated or comma separated", func(s string) error {
+		for t := range strings.SplitSeq(s, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				opt.tags = append(opt.tags, t)
+			}
+		}
+		return nil
+	})
+	cmd.Usage = usageLut
+	opt.parse(cmd)
+
+	// Options may also follow the subcommand and its arguments.
+	var args []string
+	for cmd.NArg() > 0 {
+		args = append(args, cmd.Arg(0))
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	if len(args) > 0 {
+		opt.sub, opt.args = args[0], args[1:]
+	}
+	if opt.name != "" && opt.sub != "add" {
+		return opt, usagef("--name is only supported by lut add")
+	}
+	return
+}
+
+func parseCodegenOpts() (opt codegenOpt) {
+	cmd := flag.NewFlagSet("codegen", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.StringVar(&opt.pkg, "pkg", "main", "Package of the generated file")
+	cmd.StringVar(&opt.name, "name", "", "Name of the generated variable (default: from the LUT file name)")
+	cmd.IntVar(&opt.size, "s", 0, "Points per axis of the CUBE sampled from a HALD (default: the square of its level)")
+	cmd.IntVar(&opt.size, "size", 0, "Points per axis of the CUBE sampled from a HALD (same as -s)")
+	cmd.Usage = usageCodegen
+	opt.parse(cmd)
+
+	opt.lut = cmd.Arg(0)
+	return
+}
+
+func parseMorphOpts() (opt morphOpt, err error) {
+	var mix string
+
+	cmd := flag.NewFlagSet("morph", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.steps, "steps", 10, "Number of LUTs in the sequence, including the two given")
+	cmd.StringVar(&mix, "mix", "rgb", "Color space the LUTs are interpolated in: rgb, hsl or oklab")
+	cmd.Usage = usageMorph
+	opt.parse(cmd)
+
+	// Options may also follow the LUTs.
+	for cmd.NArg() > 0 {
+		opt.luts = append(opt.luts, cmd.Arg(0))
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	opt.mix, err = parseMix(mix)
+	return
+}
+
+func parseRampOpts() (opt rampOpt, err error) {
+	var mix string
+
+	cmd := flag.NewFlagSet("ramp", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.steps, "steps", 5, "Number of variants, at evenly spaced intensities up to 100%")
+	cmd.StringVar(&mix, "mix", "rgb", "Color space the LUT is mixed with the identity in: rgb, hsl or oklab")
+	cmd.Usage = usageRamp
+	opt.parse(cmd)
+
+	// Options may also follow the LUT.
+	opt.lut = cmd.Arg(0)
+	if cmd.NArg() > 1 {
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	opt.mix, err = parseMix(mix)
+	return
+}
+
+func parseIdentifyOpts() (opt identifyOpt) {
+	cmd := flag.NewFlagSet("identify", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.Usage = usageIdentify
+	opt.parse(cmd)
+
+	// Options may also follow the LUTs.
+	for cmd.NArg() > 0 {
+		opt.luts = append(opt.luts, cmd.Arg(0))
+		opt.parseArgs(cmd, cmd.Args()[1:])
+	}
+	return
+}
+
+func parsePreviewOpts() (opt previewOpt, err error) {
+	cmd := flag.NewFlagSet("preview", flag.ExitOnError)
+	opt.register(cmd, "")
+	cmd.IntVar(&opt.size, "s", 320, "Size in pixels of the longest side of the previews")
+	cmd.IntVar(&opt.size, "size", 320, "Size in pixels of the longest side of the previews (same as -s)")
+	cmd.IntVar(&opt.cols, "cols", 0, "Number of previews per row (default: as many as rows)")
+	cmd.BoolVar(&opt.noOriginal, "no-original", false, "Do not include the original image in the sheet")
+	cmd.BoolVar(&opt.show, "show", false, "Display the sheet inline in the terminal (kitty, iTerm2 or sixel)")
+	cmd.Usage = usagePreview
+	opt.parse(cmd)
+
+	opt.image = cmd.Arg(0)
+	for _, arg := range cmd.Args()[min(1, cmd.NArg()):] {
+		path, intensity, err := pathAndIntensity(arg)
+		if err != nil {
+			return opt, err
+		}
+		opt.luts = append(opt.luts, path)
+		opt.intensities = append(opt.intensities, intensity)
+	}
+	return
+}
+
+func parseIdentityOpts() (opt identityOpt, err error) {
+	var compression string
+
+	cmd := flag.NewFlagSet("identity", flag.ExitOnError)
+	opt.register(cmd, "prism-identity.png")
+	cmd.IntVar(&opt.bits, "bits", 8, "Bits per channel of the HALD: 8 or 16")
+	cmd.StringVar(&compression, "compression", "default", "Compression of the HALD: default, fast, best or none")
+	cmd.Usage = usageIdentity
+	opt.parse(cmd)
+
+	if opt.bits != 8 && opt.bits != 16 {
+		return opt, usagef("invalid bits %d: must be 8 or 16", opt.bits)
+	}
+	opt.compression, err = parseCompression(compression)
+	return
+}
+
+// commonUsage documents the options accepted by every command.
+const commonUsage = `
+Common options:
+  -o, --out FILE       Write output to FILE
+  -t, --title TITLE    Specify title for generated LUTs
+  -j, --jobs N         Number of parallel jobs (default: number of CPUs)
+  --json               Print the result as JSON on stdout
+  -q, --quiet          Suppress warnings and informational output, printing
+                       only errors
+  -v, --verbose        Print per-file timings, LUT details and the warnings
+                       found while loading LUTs
+  --json-errors        Print errors as JSON objects on stderr
+  --strict             Fail on LUTs deviating from the specification of
+                       their format, instead of recovering with warnings
+`
+
+func usageGeneral() {
+	fmt.Fprintf(os.Stderr, `Usage: %s COMMAND [OPTIONS] ARGS
+
+Commands:
+  apply, a      Apply a LUT to an image
+  convert, c    Convert between LUT formats (CUBE <-> PNG HALD)
+  blend, b      Blend two or more LUTs together
+  identity, i   Generate an identity PNG HALD LUT
+  identify      Describe LUTs: format, size, domain and statistics
+  preview       Preview many LUTs on an image in a contact sheet
+  morph         Interpolate between two LUTs in a sequence of steps
+  ramp          Write variants of a LUT at increasing intensities
+  compose       Compose LUTs and color matrices into a single LUT
+  generate      Generate technical LUTs, such as gamut conversions
+  codegen       Export a LUT as Go source
+  lut           Manage a library of named LUTs, used as @NAME
+  run           Run the grading jobs of a manifest concurrently
+  help, h       Display help for a command
+
+Use '%s help COMMAND' for more information on a command.
+
+Defaults of the options are read from ~/.config/prism/config.toml, or the
+file in $PRISM_CONFIG: keys outside of any section set the options of every
+command having them, keys under [COMMAND] those of that command. Options on
+the command line override them:
+
+  jobs = 4
+  output_dir = "graded"     # --out-dir
+  intensity = "medium"
+
+  [apply]
+  jpeg_quality = 90         # --quality
+  depth = "table"
+  interpolation = "tetrahedral"
+
+The [converters] section maps LUT extensions to external commands reading
+the LUT at the path replacing {}, or appended, and writing a CUBE on
+stdout, so that every command takes formats prism does not read:
+
+  [converters]
+  ".3dl" = "lut3dl2cube --in {}"
+
+LUTs and images may be given as http or https URLs: LUTs are downloaded
+to memory, up to 64 MiB, images to temporary files, up to 1 GiB, each
+download timing out after 2 minutes. Inputs and outputs of apply and
+convert may also be s3://BUCKET/KEY or gs://BUCKET/KEY objects, keys ending
+in / standing for directories; credentials are read from the AWS_* and
+GOOGLE_* environment variables, as the AWS and Google Cloud tools do.
+
+Exit codes: 0 on success, 2 for invalid arguments, 3 for unsupported
+formats, 4 for malformed files, 5 for files that cannot be read or written
+and 1 for other failures, such as images of a batch failing.
+`, os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageApply() {
+	fmt.Fprintf(os.Stderr, `Usage: %s apply [OPTIONS] LUT[:INTENSITY] IMAGE...
+
+Apply a LUT (CUBE or PNG HALD) to one or more images.
+
+Options:
+  -o, --out FILE    Write output to FILE (default: IMAGE.prism.EXT)
+  --out-dir DIR     Write the outputs to DIR when -o is not given
+  --intensity I     Intensity of the LUT when not given after its path
+                    (default: 1)
+  --rotate DEG      Rotate output clockwise by 90, 180 or 270 degrees
+  --no-autorotate   Do not rotate JPEG inputs according to their EXIF
+                    orientation tag
+  --flip DIR        Flip output horizontally (h) or vertically (v)
+  --depth DEPTH     Working color depth: 8 (fast), table (precomputed
+                    8-bit results, for batches) or float (default: float)
+  --linear          Convert pixels to linear light before the lookup and
+                    back afterwards, for LUTs designed for linear input
+  --in-space SPACE  Color space of the input image, converted to the LUT
+                    space before the lookup: srgb, linear (Rec.709),
+                    rec709 or rec2020 (gamma 2.4), pq or hlg (Rec.2100),
+                    slog3 (S-Log3/S-Gamut3.Cine), vlog (V-Log/V-Gamut),
+                    logc (LogC3/AWG3) or clog (Canon Log/Cinema Gamut)
+                    (default: srgb)
+  --out-space SPACE Color space the output is converted to after the
+                    lookup, from the LUT space (default: srgb)
+  --lut-space SPACE Color space the LUT expects its input in and
+                    returns its output in (default: srgb)
+  --extended        Look up values in the units of the LUT domain
+                    (DOMAIN_MIN/MAX) so that HDR and scene-linear values
+                    above 1 are not clipped before the lookup
+  --video-range     Treat the image as video (legal) range, with black at
+                    16 and white at 235, instead of full range
+  --rounding MODE   Rounding when quantizing the result: nearest
+                    (default) or truncate
+  --interpolation M Interpolation between the points of the LUT:
+                    trilinear (default) or tetrahedral, closer to the
+                    interpolation of grading applications on strong LUTs
+  --dither MODE     Dithering when quantizing the result to 8 bits, to
+                    avoid banding in smooth gradients: none (default),
+                    ordered or diffusion (Floyd-Steinberg)
+  --mix SPACE       Color space the LUT result is mixed with the original
+                    in below full intensity: rgb (default), hsl or oklab
+                    (perceptual), keeping hue and saturation better
+  --mask FILE       Grayscale image as large as IMAGE weighting the LUT
+                    intensity of each pixel, from black (ungraded) to
+                    white (full intensity), to grade only the subject or
+                    the background
+  --component PART  Part of the color the LUT changes, the rest being kept
+                    from the original: all (default), luma (the contrast
+                    of the LUT without its color cast) or chroma (the
+                    color cast without the contrast), separated in Oklab
+  --gradient DIR    Fade the LUT out linearly across the image, from full
+                    intensity at the start to none at the end: left-right,
+                    right-left, top-bottom, bottom-top or radial (from the
+                    center to the corners)
+  --under LUT[:INTENSITY]
+                    LUT graded where the LUT is faded out by --gradient,
+                    --mask or a luma range, instead of the original image,
+                    to grade the sky and the ground or the shadows and
+                    the highlights with different LUTs in one pass
+  --luma-range MIN:MAX[:FEATHER]
+                    Grade only the pixels whose luma, from 0 to 1, is
+                    between MIN and MAX, fading the intensity out
+                    smoothly over FEATHER (default: 0.1) beyond them
+  --shadows         Grade only the shadows (--luma-range 0:0.2:0.3)
+  --midtones        Grade only the midtones (--luma-range 0.35:0.65:0.25)
+  --highlights      Grade only the highlights (--luma-range 0.8:1:0.3)
+  --preview N       Downscale the image to fit in N x N pixels before
+                    grading, for fast previews
+  --compare MODE    Render the original and the graded image in one, for
+                    review: split (original on the left half, graded on
+                    the right), sidebyside (both in full, next to each
+                    other) or slider (labeled before and after panels)
+  --bits N          Output bits per channel: 8 or 16 (default: 8).
+                    16-bit output requires PNG or TIFF
+  --quality N       Quality of JPEG outputs, from 1 to 100 (default: 95)
+  --progressive     Write progressive JPEGs, which browsers show coarse
+                    first and refine as they load
+  --subsampling S   Chroma subsampling of JPEG outputs: 420 (default),
+                    422 or 444, keeping the color at full resolution for
+                    graphics, text and saturated edges
+  --compression C   Compression of PNG outputs: default, fast (larger
+                    files written quickly), best (smallest files, slower)
+                    or none
+  --strip-metadata  Do not copy the EXIF metadata of JPEG inputs
+  --icc MODE        Handling of the ICC profile embedded in PNG and JPEG
+                    inputs: convert the output to sRGB (convert, default),
+                    convert back and embed the input profile (embed), or
+                    treat the image as sRGB (ignore)
+  --embed-profile P Tag JPEG, PNG and TIFF outputs with the ICC profile P,
+                    srgb or the path of an RGB matrix/TRC profile, so that
+                    color managed applications show the graded colors as
+                    intended. The output is converted to the profile,
+                    which takes precedence over --icc embed
+  --show            Display the result inline in the terminal using the
+                    kitty, iTerm2 or sixel image protocols
+  --strip-rows N    Grade TIFF and PNG images N rows at a time, streaming
+                    them from the input to a TIFF or PNG output so that
+                    huge scans never sit whole in memory
+  --low-memory      Grade TIFF and PNG images in strips of 256 rows, or
+                    --strip-rows, mapping TIFF inputs in memory instead
+                    of reading them
+  --memo            Cache the results of the colors already looked up,
+                    faster on screenshots, graphics and skies with large
+                    areas of identical pixels, slightly slower on photos
+  --gpu             Grade 8-bit outputs on the GPU in builds with the gpu
+                    tag, falling back to the CPU for options it lacks
+
+Batch options:
+  --min-size PX     Skip images whose shorter side is smaller than PX
+  --max-aspect R    Skip images whose long to short side ratio exceeds R
+  --skip-screenshots
+                    Skip screenshots, detected by file name and metadata
+  --force           Overwrite the outputs of previous runs
+  --skip-existing   Skip the images whose output already exists
+  --resume          Continue an interrupted run where it left off,
+                    skipping the images its state file records as graded
+  --state FILE      State file recording the images graded, removed
+                    once every image is (default: .prism-batch.state in
+                    the -o directory, or in the current one)
+
+Batch runs stop before grading anything when some outputs already exist,
+unless --force, --skip-existing or --resume is given. The images of ZIP
+archives are graded to NAME.prism.zip, or to the archive or directory
+given by -o, keeping their paths.
+
+Arguments:
+  LUT[:INTENSITY]  Path, URL or object of LUT file (CUBE or PNG HALD, told
+                   by its contents), or - for standard input, with optional
+                   intensity: 0-1, a percentage (75%%) or subtle, medium,
+                   strong, full (default: 1)
+  IMAGE            Path, URL or object of input image (PNG, JPEG, GIF, TIFF
+                   or DNG), or directory, object prefix or ZIP archive of
+                   images. Images given by URL are graded to the current
+                   directory, or --out-dir, objects next to themselves
+
+The output format is chosen from the extension of the output file
+(PNG, JPEG, GIF or TIFF) and defaults to the input format. Camera raw DNG
+files are rendered to PNG unless a different output is given. Animated
+GIFs are graded frame by frame keeping their timings and loop count.
+JPEG inputs are turned upright according to their EXIF orientation.
+Images tagged with an RGB ICC profile, such as Adobe RGB or Display P3,
+are converted to sRGB before the LUT is applied.
+
+Given several images or a directory, apply runs in batch mode: the
+outputs are written next to the inputs, or in the directory given with
+-o mirroring the input tree. Outputs of previous runs (*.prism.*) are
+never picked up from directories.
+
+Examples:
+  %s apply lut.cube image.png
+  %s apply lut.cube:75%% image.png
+  %s apply lut.cube:subtle image.png
+  %s apply -o output.jpg lut.png image.jpg
+  %s apply --rotate 90 lut.cube photo.jpg
+  %s apply --icc embed lut.cube p3-photo.jpg
+  %s apply --in-space slog3 rec709-look.cube slog3-still.png
+  %s apply -o graded --min-size 1024 --skip-screenshots lut.cube photos/
+  %s a -j 4 lut.cube image.png
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageIdentity() {
+	fmt.Fprintf(os.Stderr, `Usage: %s identity [OPTIONS]
+
+Generate an identity PNG HALD LUT.
+
+Options:
+  -o, --out FILE    Write output to FILE (default: prism-identity.png)
+  --bits N          Bits per channel: 8 (default) or 16, for HALDs graded
+                    in editors that keep 16 bits
+  --compression C   Compression of the HALD: default, fast, best or none
+
+Examples:
+  %s identity
+  %s identity -o identity.png
+  %s identity --bits 16 -o identity16.png
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageConvert() {
+	fmt.Fprintf(os.Stderr, `Usage: %s convert [OPTIONS] LUT [OUTPUT]
+
+Convert between LUT formats.
+
+Supported conversions:
+  CUBE to PNG HALD    : %s convert lut.cube lut.png
+  PNG HALD to CUBE    : %s convert lut.png lut.cube
+
+With --batch, every LUT in the LUT directory and its subdirectories is
+converted concurrently to the format --to in the OUTPUT directory,
+keeping its name.
+
+Options:
+  -o, --out FILE       Write output to FILE (alternative to OUTPUT)
+  -t, --title TITLE    Specify title for generated LUT (HALD->CUBE only)
+  --level N            Level of the HALD written from a CUBE, from 2 to 16:
+                       N^3 x N^3 pixels holding N^2 points per axis
+                       (default: 12)
+  -s, --size N         Points per axis of the CUBE written from a HALD,
+                       from 2 to 256, such as 17 or 65 for hardware LUT
+                       boxes (default: 33)
+  --batch              Convert every LUT in the LUT directory to the
+                       OUTPUT directory
+  --to FORMAT          Format batch conversions write: cube or png
+  --force              Overwrite the outputs of previous batch conversions,
+                       which otherwise stop the run before it starts
+  --skip-existing      Skip the LUTs whose output already exists
+  --bits N             Bits per channel of the HALD written from a CUBE:
+                       8 or 16, for smooth gradients after further
+                       editing (default: 8)
+  --compression C      Compression of the HALD written from a CUBE:
+                       default, fast, best (smallest files, for HALDs
+                       shared or archived) or none
+
+Arguments:
+  LUT                 Path or object of input LUT file, its format told by
+                      its contents, or - for standard input
+  OUTPUT              Path or object of output LUT file, its format told
+                      by its extension
+
+Examples:
+  %s convert input.cube output.png
+  %s convert -t "My LUT" input.png output.cube
+  %s c -o output.png input.cube
+  %s convert --level 8 input.cube output.png
+  %s convert -s 17 input.png output.cube
+  %s convert --batch ./luts_png/ ./luts_cube/ --to cube
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageBlend() {
+	fmt.Fprintf(os.Stderr, `Usage: %s blend [OPTIONS] LUT1[:INTENSITY1] LUT2[:INTENSITY2] [LUT[:INTENSITY]...]
+
+Blend two or more CUBE or HALD LUTs together with optional intensity
+weighting. LUTs of different formats are sampled on a common lattice,
+as large as the largest CUBE, and the output format follows -o.
+
+Options:
+  -c, --clamp         Clamp the samples of the output LUT to its domain,
+                      which are otherwise kept as blended
+  --rescale           Rescale an output LUT with samples out of its
+                      domain linearly into it, instead of clamping it
+  --mode MODE         Blend mode: normal (default), multiply, screen,
+                      overlay, darken or lighten
+  --mix SPACE         Color space the normal mode averages the LUTs in:
+                      rgb (default), hsl or oklab (perceptual)
+  -o, --out FILE      Write output to FILE
+  -s, --size N        Points per axis of the blended CUBE; by default
+                      CUBEs of the same size and domain are blended as
+                      they are
+  -t, --title TITLE   Specify title for generated LUT
+
+Arguments:
+  LUT1[:INTENSITY1]   First LUT file with optional intensity (0-1)
+  LUT2[:INTENSITY2]   Second LUT file with optional intensity (0-1)
+  LUT[:INTENSITY]     Further LUT files, blended the same way
+
+Intensities are numbers between 0 and 1, percentages (70%%) or one of
+subtle, medium, strong and full. With the normal mode each LUT is
+weighted by its intensity over the sum of all the intensities. With the
+other modes the LUTs are layered as in an image editor: each LUT is
+blended over the result of the previous ones with its intensity as
+opacity, and the intensity of the first LUT is ignored.
+
+Examples:
+  %s blend lut1.cube lut2.cube
+  %s blend lut1.cube:0.5 lut2.cube:0.5
+  %s blend -o output.cube lut1.cube lut2.cube
+  %s blend -t "Blended" lut1.cube:0.7 lut2.cube:0.3
+  %s blend -o recipe.cube base.cube:60%% fade.cube:25%% grain.cube:15%%
+  %s blend -o film.png film.cube grain.png:30%%
+  %s blend --mode overlay -o punchy.cube base.cube contrast.cube:50%%
+  %s b -o output.cube lut1.cube lut2.cube
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageCompose() {
+	fmt.Fprintf(os.Stderr, `Usage: %s compose [OPTIONS] STEP[:INTENSITY]...
+
+Compose LUTs and color matrices, applied in the given order, into a
+single LUT. Matrices are evaluated exactly and only the result is
+sampled on the lattice.
+
+Options:
+  -o, --out FILE      Write output to FILE, CUBE or PNG HALD (default: stdout)
+  -t, --title TITLE   Specify title for the composed LUT
+  -s, --size N        Points per axis of the composed LUT (default: 33)
+
+Arguments:
+  STEP[:INTENSITY]    LUT file (CUBE or PNG HALD) or color matrix file,
+                      with optional intensity
+
+Matrix files hold 3 rows of 3 numbers, each optionally followed by an
+offset, or the same rows as JSON: [[...], [...], [...]] or
+{"matrix": [[...], [...], [...]], "offset": [...]}.
+
+Examples:
+  %s compose -o look.cube camera.json log-to-709.cube look.cube:0.8
+  %s compose -s 65 -o out.cube rec709-to-2020.txt
+`, os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageGenerate() {
+	fmt.Fprintf(os.Stderr, `Usage: %s generate KIND [OPTIONS]
+
+Generate a technical LUT, to use as is or as a building block for
+compose.
+
+Kinds:
+  gamut               Convert colors between two color spaces, baking the
+                      primaries matrix and the transfer functions into
+                      the lattice. Colors outside the target gamut are
+                      clipped
+
+Options:
+  -o, --out FILE      Write output to FILE, CUBE or PNG HALD (default: stdout)
+  -t, --title TITLE   Specify title for the LUT (default: FROM to TO)
+  -s, --size N        Points per axis of the LUT (default: 33)
+  --from SPACE        Color space to convert from (gamut)
+  --to SPACE          Color space to convert to (gamut)
+
+Color spaces: %s. rec709 and rec2020 use the BT.1886 display gamma.
+
+Examples:
+  %s generate gamut --from rec709 --to rec2020 -o 709-to-2020.cube
+  %s generate gamut -s 65 --from slog3 --to rec709 -o slog3-to-709.cube
+`, os.Args[0], strings.Join(colorspace.Names(), ", "), os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageMorph() {
+	fmt.Fprintf(os.Stderr, `Usage: %s morph [OPTIONS] LUT1 LUT2
+
+Interpolate between two LUTs in a sequence of LUTs, from LUT1 to LUT2
+included, to animate a look transition in editors accepting only static
+LUTs. LUTs of different sizes are sampled on the larger lattice.
+
+Options:
+  -o, --out PATTERN Write the steps to PATTERN, a file name holding a
+                    number verb such as %%02d replaced by the step from 1,
+                    CUBE or PNG HALD (default: morph_%%02d.EXT)
+  -t, --title TITLE Title of the LUTs, followed by the step
+  --steps N         Number of LUTs in the sequence (default: 10)
+  --mix SPACE       Color space the LUTs are interpolated in: rgb
+                    (default), hsl or oklab
+
+Examples:
+  %s morph a.cube b.cube --steps 10 -o "mix_%%02d.cube"
+  %s morph --mix oklab -o day-night_%%03d.png day.png night.png
+`, os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageRamp() {
+	fmt.Fprintf(os.Stderr, `Usage: %s ramp [OPTIONS] LUT
+
+Write variants of a LUT at evenly spaced intensities, 20%%, 40%%, ... 100%%
+by default, with the blend with the original image baked in the lattice,
+for applications with no intensity control.
+
+Options:
+  -o, --out PATTERN Write the variants to PATTERN, a file name holding a
+                    number verb such as %%03d replaced by the intensity in
+                    percent, CUBE or PNG HALD (default: LUT_%%03d.EXT)
+  -t, --title TITLE Title of the LUTs, followed by the intensity
+  --steps N         Number of variants (default: 5)
+  --mix SPACE       Color space the LUT is mixed with the original in:
+                    rgb (default), hsl or oklab
+
+Examples:
+  %s ramp look.cube
+  %s ramp --steps 10 -o "look-%%d.cube" look.cube
+`, os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageIdentify() {
+	fmt.Fprintf(os.Stderr, `Usage: %s identify [OPTIONS] LUT...
+
+Describe LUTs without applying them: their format, title, size or HALD
+level, domain and number of samples, with statistics of the samples:
+their range and mean on each channel, how far they move colors from
+the identity, as a percentage of the domain, and how many fall outside
+the domain. Warnings found while loading are listed as well.
+
+With --json the descriptions are printed as JSON, for build pipelines
+and asset managers: "luts" holds one object per LUT with the keys path,
+format (cube, binary-cube or hald), title, size, level (0 for CUBEs),
+domain (min and max), samples, video_range (input and output), stats
+(min, max and mean per channel, mean_shift and max_shift as fractions
+of the domain and outside, or null when the samples do not match the
+size) and warnings (line and message), and "failed" the path and error
+of the LUTs that could not be read.
+
+Arguments:
+  LUT               Path to LUT file (CUBE or PNG HALD), or - for
+                    standard input
+
+Examples:
+  %s identify look.cube
+  %s identify luts/*.cube luts/*.png
+  %s identify --json luts/*.cube > luts.json
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usagePreview() {
+	fmt.Fprintf(os.Stderr, `Usage: %s preview [OPTIONS] IMAGE LUT[:INTENSITY]...
+
+Apply each LUT to a downscaled copy of the image and lay the results out
+in a labeled grid, to choose a look from a LUT pack at a glance.
+
+Options:
+  -o, --out FILE    Write output to FILE (default: IMAGE.sheet.jpg)
+  -s, --size N      Longest side of the previews in pixels (default: 320)
+  --cols N          Previews per row (default: as many as rows)
+  --no-original     Do not include the original image in the sheet
+  --show            Display the sheet inline in the terminal using the
+                    kitty, iTerm2 or sixel image protocols
+
+Arguments:
+  IMAGE             Path or http(s) URL of the image to preview the LUTs on
+  LUT[:INTENSITY]   Path to LUT file (CUBE or PNG HALD), with optional
+                    intensity
+
+Examples:
+  %s preview photo.jpg luts/*.cube
+  %s preview -o sheet.jpg -s 480 --cols 4 photo.jpg luts/*.cube
+  %s preview --show photo.jpg luts/*.cube
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageCodegen() {
+	fmt.Fprintf(os.Stderr, `Usage: %s codegen [OPTIONS] LUT
+
+Export a LUT (CUBE or PNG HALD) as a Go source file declaring a cube.Cube
+variable, so that applications can ship looks compiled in. The LUT is
+stored in its compact binary form.
+
+Options:
+  -o, --out FILE    Write output to FILE (default: LUT_gen.go)
+  -t, --title TITLE Override the title of the LUT
+  --pkg NAME        Package of the generated file (default: main)
+  --name NAME       Name of the generated variable (default: from the
+                    LUT file name, e.g. Look for look.cube)
+  -s, --size N      Points per axis of the CUBE sampled from a HALD
+                    (default: the square of its level, e.g. 64 for a
+                    level 8 HALD)
+
+Arguments:
+  LUT               Path to LUT file (CUBE or PNG HALD)
+
+Examples:
+  %s codegen look.cube
+  %s codegen -o look_gen.go --pkg assets look.cube
+  %s codegen -s 33 film.png
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageLut() {
+	fmt.Fprintf(os.Stderr, `Usage: %s lut SUBCOMMAND [OPTIONS] [ARGS]
+
+Manage a library of named LUTs, so that any command takes @NAME in place
+of the path of a LUT. The library is kept in ~/.config/prism/luts, or the
+directory in $PRISM_LIBRARY, one file per LUT named after its SHA-256 hash
+along with an index: a LUT already in the library is not added again.
+
+Subcommands:
+  add LUT           Copy LUT into the library
+  list, ls [NAME...]
+                    List the LUTs of the library, or the given ones
+  rm, remove NAME...
+                    Remove LUTs from the library
+  show NAME         Describe a LUT of the library, as identify does
+
+Options:
+  --name NAME       Name of the added LUT (default: the file name without
+                    extension)
+  --tag TAG         Tag the added LUT, or list only the LUTs with the tag;
+                    may be repeated or hold tags separated by commas
+
+Examples:
+  %s lut add --tag film,print Kodak_2383.cube --name kodak2383
+  %s lut list --tag film
+  %s apply @kodak2383 photo.jpg
+  %s lut rm kodak2383
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageRun() {
+	fmt.Fprintf(os.Stderr, `Usage: %s run [OPTIONS] [--manifest] [MANIFEST]
+
+Run the grading jobs of a manifest concurrently, printing a status line
+on stdout as each one ends, so that other tools can drive prism. The
+manifest holds one JSON object per line, read from standard input by
+default; jobs start as their line is read:
+
+  {"id": "a", "input": "a.jpg", "lut": "film.cube", "output": "out/a.jpg"}
+  {"input": "b.png", "luts": ["base.cube", "film.cube:0.5"], "intensity": "strong"}
+
+Job fields:
+  id                Name of the job in its status line (default: its line
+                    number)
+  input             Path, URL or object of the image
+  lut               LUT[:INTENSITY] applied to the image
+  luts              LUT[:INTENSITY] list applied in turn, composed into a
+                    65 point LUT, in place of lut
+  intensity         Intensity of the LUT, as --intensity (default: 1)
+  output            Output of the image, its parent directories created
+                    (default: as apply, next to the input or in --out-dir)
+
+Status lines hold the id, ok or failed, then the output and the time taken
+or the error, separated by tabs; with --json they are JSON objects with
+the id, status, input, output, elapsed_ms, error and type fields. Every
+option of apply except -o is accepted and applies to every job.
+
+Options:
+  --manifest FILE   JSON Lines file of the jobs, or - for standard input
+                    (default: -)
+
+Examples:
+  %s run --manifest jobs.jsonl
+  generate-jobs | %s run --json --out-dir graded
+`, os.Args[0], os.Args[0], os.Args[0])
+	fmt.Fprint(os.Stderr, commonUsage)
+}
+
+func usageHelp() {
+	fmt.Fprintf(os.Stderr, `Usage: %s help [COMMAND]
+
+Display help for a command.
+
+Arguments:
+  COMMAND    Command to get help for (apply, convert, blend, identity,
+             compose, generate or codegen)
+
+Examples:
+  %s help
+  %s help apply
+  %s help convert
+  %s help identity
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+}
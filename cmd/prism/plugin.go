@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NicoNex/prism"
+)
+
+const (
+	// converterSection is the section of the configuration file mapping
+	// LUT extensions to the commands converting them to CUBEs.
+	converterSection = "converters"
+	// converterTimeout bounds each run of an external converter.
+	converterTimeout = 2 * time.Minute
+)
+
+// convertedLuts holds the outputs of external converters, by path.
+var convertedLuts = &lutCache{data: map[string]func() ([]byte, error){}}
+
+// converterFor returns the command line of the external converter of the
+// LUT at path, told by its extension, or nil if none is configured.
+//
+// Converters are configured in the [converters] section of the
+// configuration file, as in ".3dl" = "lut3dl2cube --in {}": the command
+// reads the LUT at the path replacing {}, or appended to the arguments,
+// and writes a CUBE on stdout.
+func converterFor(path string) ([]string, error) {
+	name := path
+	if isRemote(path) {
+		name = urlBase(path)
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" || path == "-" || strings.HasPrefix(path, "@") {
+		return nil, nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, parseError(err)
+	}
+	for _, v := range cfg.sections[converterSection] {
+		if "."+strings.TrimPrefix(strings.ToLower(v.key), ".") != ext {
+			continue
+		}
+		args, err := splitCommand(v.val)
+		if err == nil && len(args) == 0 {
+			err = errors.New("empty command")
+		}
+		if err != nil {
+			return nil, usagef("%s:%d: invalid converter %q: %w", cfg.path, v.line, v.val, err)
+		}
+		return args, nil
+	}
+	return nil, nil
+}
+
+// splitCommand splits the command line s into arguments, separated by
+// blanks outside of single or double quotes. Backslashes escape the next
+// character outside of single quotes.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+		esc   bool
+	)
+	for _, r := range s {
+		switch {
+		case esc:
+			arg.WriteRune(r)
+			esc = false
+		case r == '\\' && quote != '\'':
+			esc, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || esc {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// convertExternal converts the LUT at path to a CUBE with the external
+// converter args, once, downloading the LUT first if it is remote.
+func convertExternal(path string, args []string) ([]byte, error) {
+	return convertedLuts.get(path, func() ([]byte, error) {
+		input := path
+		if isRemote(path) {
+			data, err := fetchLut(path)
+			if err != nil {
+				return nil, err
+			}
+			dir, err := os.MkdirTemp("", "prism-")
+			if err != nil {
+				return nil, err
+			}
+			defer os.RemoveAll(dir)
+			input = filepath.Join(dir, urlBase(path))
+			if err := os.WriteFile(input, data, 0o644); err != nil {
+				return nil, err
+			}
+		}
+		return runConverter(path, input, args)
+	})
+}
+
+// runConverter runs the converter args on the file at input, the LUT at
+// path, and returns the CUBE it writes on stdout.
+func runConverter(path, input string, args []string) ([]byte, error) {
+	args = append([]string(nil), args...)
+	replaced := false
+	for i, a := range args[1:] {
+		if strings.Contains(a, "{}") {
+			args[i+1] = strings.ReplaceAll(a, "{}", input)
+			replaced = true
+		}
+	}
+	if !replaced {
+		args = append(args, input)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), converterTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = exitErr.String()
+		}
+		return nil, exitError{exitParse, fmt.Errorf("converter %s failed: %s", args[0], msg)}
+	case err != nil:
+		return nil, usagef("cannot run converter: %w", err)
+	}
+	logger.Info("converted with "+args[0], "path", path, "elapsed", time.Since(start).Round(time.Millisecond))
+
+	if typ, _ := prism.Detect(stdout.Bytes(), ""); typ != ".cube" {
+		return nil, exitError{exitParse, fmt.Errorf("converter %s did not write a CUBE on stdout", args[0])}
+	}
+	return stdout.Bytes(), nil
+}
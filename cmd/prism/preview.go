@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/lut"
+	"github.com/NicoNex/prism/sheet"
+	"github.com/NicoNex/prism/termimg"
+)
+
+// loadPreview decodes the image at path, upright, and downscales it to
+// fit in a size x size square.
+func loadPreview(path string, size int) (*lut.Preview, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, format, err := prism.DecodeImage(f, path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" {
+		if img, _, err = autoOrient(img, f); err != nil {
+			return nil, err
+		}
+	}
+	return lut.NewPreview(img, size), nil
+}
+
+// lutLabel returns the label of the LUT at path applied at intensity.
+func lutLabel(path string, intensity float64) string {
+	base := filepath.Base(path)
+	label := base[:len(base)-len(filepath.Ext(base))]
+	if intensity < 1 {
+		label += fmt.Sprintf(" %.0f%%", intensity*100)
+	}
+	return label
+}
+
+func preview() error {
+	opt, err := parsePreviewOpts()
+	if err != nil {
+		return err
+	}
+	if opt.image == "" {
+		return usagef("missing IMAGE argument")
+	}
+	if len(opt.luts) == 0 {
+		return usagef("missing LUT arguments")
+	}
+	if opt.size < 16 {
+		return usagef("invalid size %d: must be at least 16", opt.size)
+	}
+
+	img, cleanup, err := localImage(opt.image)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	p, err := loadPreview(img, opt.size)
+	if err != nil {
+		return err
+	}
+
+	var tiles []sheet.Tile
+	if !opt.noOriginal {
+		tiles = append(tiles, sheet.Tile{Image: p.Image(), Label: "Original"})
+	}
+	for i, path := range opt.luts {
+		l, err := loadLut(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		logLut(path, l)
+		a := l.Compile(lut.Options{Intensity: opt.intensities[i]})
+		tiles = append(tiles, sheet.Tile{Image: p.Apply(a), Label: lutLabel(path, opt.intensities[i])})
+	}
+
+	cols := opt.cols
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(tiles)))))
+	}
+	if opt.output == "" {
+		base := filepath.Base(opt.image)
+		opt.output = base[:len(base)-len(filepath.Ext(base))] + ".sheet.jpg"
+	}
+
+	f, err := os.Create(opt.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Contact sheets are looked at once, so PNG ones favor speed over size.
+	enc := prism.EncodeOptions{PNGCompression: png.BestSpeed}
+	grid := sheet.Grid(tiles, cols)
+	if err := writeImg(prism.ImageFormat(opt.output, "jpeg"), f, grid, imgMeta{}, enc); err != nil {
+		return err
+	}
+	if opt.show {
+		if err := termimg.Show(os.Stdout, grid); err != nil {
+			return err
+		}
+	}
+	return report(opt.commonOpt, result("preview", opt.output, append([]string{opt.image}, opt.luts...)...))
+}
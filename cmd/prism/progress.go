@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressDelay is how long an operation runs before its progress
+	// bar is shown, so that quick runs print nothing.
+	progressDelay = 250 * time.Millisecond
+	// progressRate is the minimum interval between redraws.
+	progressRate  = 100 * time.Millisecond
+	progressWidth = 30
+)
+
+// progressBar draws the progress of a long running command on stderr.
+// A nil progressBar draws nothing.
+type progressBar struct {
+	mu    sync.Mutex
+	start time.Time
+	last  time.Time
+	drawn bool
+}
+
+// newProgressBar returns a progress bar, or nil when opt asks for quiet
+// or JSON output or stderr is not a terminal.
+func newProgressBar(opt commonOpt) *progressBar {
+	if opt.quiet || opt.json {
+		return nil
+	}
+	if fi, err := os.Stderr.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{start: time.Now()}
+}
+
+// set draws the bar at the completed fraction f, followed by label.
+func (p *progressBar) set(f float64, label string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.start) < progressDelay || now.Sub(p.last) < progressRate {
+		return
+	}
+	p.last, p.drawn = now, true
+
+	f = max(0, min(1, f))
+	n := int(f * progressWidth)
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %s\x1b[K",
+		strings.Repeat("=", n), strings.Repeat(" ", progressWidth-n), f*100, label)
+}
+
+// clear removes the bar from the terminal, so that other messages can
+// be printed. It is drawn again by the next call to set.
+func (p *progressBar) clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		p.drawn = false
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/cube"
+)
+
+// ramp writes variants of a LUT at increasing intensities, with the
+// blend with the identity baked in the lattice, for applications with
+// no intensity control.
+func ramp() error {
+	opt, err := parseRampOpts()
+	if err != nil {
+		return err
+	}
+	if opt.lut == "" {
+		return usagef("missing LUT argument")
+	}
+	if opt.steps < 1 || opt.steps > 100 {
+		return usagef("invalid steps %d: must be between 1 and 100", opt.steps)
+	}
+
+	c, err := loadCube(opt.lut, prism.DefaultSize, opt.commonOpt)
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(opt.lut)
+	name := base[:len(base)-len(filepath.Ext(base))]
+	if opt.output == "" {
+		opt.output = name + "_%03d" + filepath.Ext(base)
+	}
+	title := opt.title
+	if title == "" {
+		title = c.Title
+	}
+	if title == "" {
+		title = name
+	}
+
+	identity := cube.FromTransform(func(r, g, b float64) (float64, float64, float64) {
+		return r, g, b
+	}, c.LUT3Dsize)
+	identity, c = sameLattice(identity, c)
+
+	steps := make([]step, opt.steps)
+	for i := range steps {
+		t := float64(i+1) / float64(opt.steps)
+		pct := int(math.Round(t * 100))
+		steps[i] = step{t: t, num: pct, title: fmt.Sprintf("%s %d%%", title, pct)}
+	}
+	return writeSteps(opt.commonOpt, "ramp", identity, c, opt.mix, steps, opt.lut)
+}
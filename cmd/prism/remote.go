@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fetchTimeout bounds each download, from connecting to reading the
+	// last byte.
+	fetchTimeout = 2 * time.Minute
+	// maxLutFetch and maxImageFetch bound the size of downloaded LUTs,
+	// kept in memory, and images, written to temporary files.
+	maxLutFetch   = 64 << 20
+	maxImageFetch = 1 << 30
+)
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// imageTypes are the extensions of the image types servers send, for
+// URLs whose path has none.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/tiff": ".tif",
+}
+
+// isURL reports whether the argument s is an http or https URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// isRemote reports whether the argument s is downloaded: an http or
+// https URL, or an S3 or GCS URI.
+func isRemote(s string) bool {
+	return isURL(s) || isObjectURI(s)
+}
+
+// urlBase returns the last element of the path of the URL s, the name
+// of the file it is downloaded to.
+func urlBase(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	if base == "." || base == "/" {
+		return ""
+	}
+	return base
+}
+
+// fetch downloads the URL or object s to w, failing for responses
+// larger than limit bytes. It returns the content type of the response,
+// empty for objects.
+func fetch(s string, w io.Writer, limit int64) (string, error) {
+	if o, ok := parseObjectURI(s); ok {
+		return "", getObject(o, w, limit)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s, nil)
+	if err != nil {
+		return "", usagef("invalid URL %q: %w", s, err)
+	}
+	req.Header.Set("User-Agent", "prism")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", exitError{exitIO, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", exitError{exitIO, fmt.Errorf("%s: %s", s, resp.Status)}
+	}
+	tooLarge := exitError{exitIO, fmt.Errorf("%s: larger than %d MiB", s, limit>>20)}
+	if resp.ContentLength > limit {
+		return "", tooLarge
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return "", exitError{exitIO, fmt.Errorf("%s: %w", s, err)}
+	}
+	if n > limit {
+		return "", tooLarge
+	}
+	return resp.Header.Get("Content-Type"), nil
+}
+
+// lutCache holds LUT data read to memory by path, read once so that it
+// can be sniffed and then loaded.
+type lutCache struct {
+	mu   sync.Mutex
+	data map[string]func() ([]byte, error)
+}
+
+// get returns the data of the LUT at path, read by read the first time.
+func (c *lutCache) get(path string, read func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	get, ok := c.data[path]
+	if !ok {
+		get = sync.OnceValues(read)
+		c.data[path] = get
+	}
+	c.mu.Unlock()
+	return get()
+}
+
+// forget drops the data of the LUT at path.
+func (c *lutCache) forget(path string) {
+	c.mu.Lock()
+	delete(c.data, path)
+	c.mu.Unlock()
+}
+
+// fetchedLuts holds the downloads of fetchLut, by URL.
+var fetchedLuts = &lutCache{data: map[string]func() ([]byte, error){}}
+
+// fetchLut downloads the LUT at the URL or object s to memory, once, so
+// that it can be sniffed and then loaded.
+func fetchLut(s string) ([]byte, error) {
+	return fetchedLuts.get(s, func() ([]byte, error) {
+		var buf bytes.Buffer
+		_, err := fetch(s, &buf, maxLutFetch)
+		return buf.Bytes(), err
+	})
+}
+
+// forgetLut drops the download or the conversion of the LUT at s, once
+// loaded for good.
+func forgetLut(s string) {
+	fetchedLuts.forget(s)
+	convertedLuts.forget(s)
+}
+
+// localImage returns the path of the image argument path, downloading
+// it if it is a URL or an object. The returned function removes the
+// download.
+func localImage(path string) (string, func(), error) {
+	if !isRemote(path) {
+		return path, func() {}, nil
+	}
+	return fetchImage(path)
+}
+
+// fetchImage downloads the image at the URL or object s to a temporary directory,
+// under the name of the URL, so that the default output is named after
+// it. The returned function removes the download, if any.
+func fetchImage(s string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "prism-")
+	if err != nil {
+		return "", func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	name := urlBase(s)
+	if name == "" {
+		name = "image"
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	typ, err := fetch(s, f, maxImageFetch)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+
+	// Outputs take the format of their extension.
+	p := f.Name()
+	if ext, ok := imageTypes[strings.TrimSpace(strings.Split(typ, ";")[0])]; ok && filepath.Ext(name) == "" {
+		if err := os.Rename(p, p+ext); err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+		p += ext
+	}
+	logger.Info("downloaded", "path", s, "file", p)
+	return p, cleanup, nil
+}
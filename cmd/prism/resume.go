@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// stateFile is the name of the state file of batch runs, written in the
+// output directory or in the current one.
+const stateFile = ".prism-batch.state"
+
+// stateHeader identifies the run a state file belongs to.
+type stateHeader struct {
+	Lut    string   `json:"lut"`
+	Inputs []string `json:"inputs"`
+	Output string   `json:"output"`
+}
+
+// batchState records the images a batch run has graded, one per line
+// after the header, as soon as their output is written, so that an
+// interrupted run can be resumed with --resume.
+type batchState struct {
+	path string
+	f    *os.File
+	done map[string]bool
+}
+
+// statePath returns the path of the state file of a batch run.
+func statePath(opt applyOpt) string {
+	if opt.statePath != "" {
+		return opt.statePath
+	}
+	// Runs writing to buckets or archives keep their state in the current
+	// directory.
+	if root := cmp.Or(opt.output, opt.outDir); !isObjectURI(root) && !isArchive(root) {
+		return filepath.Join(root, stateFile)
+	}
+	return stateFile
+}
+
+// openState opens the state file of a batch run. When resuming, the
+// images it records are done and new ones are appended to it; a missing
+// state file resumes nothing. Otherwise the state file is started over.
+func openState(opt applyOpt) (*batchState, error) {
+	s := &batchState{path: statePath(opt), done: map[string]bool{}}
+	hdr := stateHeader{Lut: opt.lut, Inputs: opt.imgPaths, Output: cmp.Or(opt.output, opt.outDir)}
+
+	if opt.resume {
+		err := s.load(hdr)
+		if err == nil {
+			s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+			return s, err
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Create(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	if err := s.writeLine(hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the images done by the run of the state file, which must
+// have the given header.
+func (s *batchState) load(hdr stateHeader) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		return fmt.Errorf("%s: empty state file", s.path)
+	}
+	var got stateHeader
+	if err := json.Unmarshal(scanner.Bytes(), &got); err != nil ||
+		got.Lut != hdr.Lut || got.Output != hdr.Output || !slices.Equal(got.Inputs, hdr.Inputs) {
+		return usagef("%s: state of a different run, remove it or run without --resume", s.path)
+	}
+
+	for scanner.Scan() {
+		// The last line is cut short if the run was killed writing it.
+		var path string
+		if json.Unmarshal(scanner.Bytes(), &path) == nil {
+			s.done[path] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// writeLine appends v to the state file as a line of JSON.
+func (s *batchState) writeLine(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// record records the image at path as done.
+func (s *batchState) record(path string) error {
+	s.done[path] = true
+	return s.writeLine(path)
+}
+
+// close closes the state file, removing it once every image is done.
+func (s *batchState) close(complete bool) error {
+	err := s.f.Close()
+	if complete {
+		return os.Remove(s.path)
+	}
+	return err
+}
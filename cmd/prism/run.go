@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/lut"
+)
+
+// chainSize is the points per axis of the LUT the LUTs of a job are
+// composed into.
+const chainSize = 65
+
+// job is a line of a run manifest: an image graded with one LUT, or with
+// several applied in turn.
+type job struct {
+	ID        string   `json:"id"`
+	Input     string   `json:"input"`
+	LUT       string   `json:"lut"`
+	LUTs      []string `json:"luts"`
+	Intensity any      `json:"intensity"`
+	Output    string   `json:"output"`
+}
+
+// jobStatus is the status line of a finished job.
+type jobStatus struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Input     string `json:"input,omitempty"`
+	Output    string `json:"output,omitempty"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+	Error     string `json:"error,omitempty"`
+	Type      string `json:"type,omitempty"`
+}
+
+// parseJob decodes the manifest line data, rejecting unknown fields so
+// that misspelled ones do not go unnoticed.
+func parseJob(data []byte) (job, error) {
+	var j job
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&j); err != nil {
+		return j, exitError{exitParse, err}
+	}
+	switch {
+	case j.Input == "":
+		return j, usagef("missing input")
+	case j.LUT == "" && len(j.LUTs) == 0:
+		return j, usagef("missing lut or luts")
+	case j.LUT != "" && len(j.LUTs) > 0:
+		return j, usagef("only one of lut and luts can be set")
+	}
+	return j, nil
+}
+
+// intensity returns the intensity of the job, def if it sets none.
+func (j job) intensity(def float64) (float64, error) {
+	var (
+		f   float64
+		err error
+	)
+	switch v := j.Intensity.(type) {
+	case nil:
+		return def, nil
+	case float64:
+		f, err = parseIntensity(strconv.FormatFloat(v, 'g', -1, 64))
+	case string:
+		f, err = parseIntensity(v)
+	default:
+		err = fmt.Errorf("must be a number or a string")
+	}
+	if err != nil {
+		return 0, usagef("invalid intensity %v: %w", j.Intensity, err)
+	}
+	return f, nil
+}
+
+// runLuts loads the LUTs of the jobs of a run once each, as jobs tend to
+// share them.
+type runLuts struct {
+	opt  commonOpt
+	mu   sync.Mutex
+	luts map[string]func() (LUTApplicator, error)
+}
+
+// get returns the LUT at path, or the LUTs of paths composed in turn,
+// each with an optional intensity.
+func (r *runLuts) get(paths []string) (LUTApplicator, error) {
+	key := strings.Join(paths, "\x00")
+	r.mu.Lock()
+	load, ok := r.luts[key]
+	if !ok {
+		load = sync.OnceValues(func() (LUTApplicator, error) {
+			if len(paths) == 1 {
+				l, err := loadLut(paths[0])
+				if err == nil {
+					logLut(paths[0], l)
+				}
+				return l, err
+			}
+			stages := make([]lut.Transform, len(paths))
+			for i, p := range paths {
+				var err error
+				if stages[i], err = loadStage(p, r.opt); err != nil {
+					return nil, err
+				}
+			}
+			return cube.FromTransform(lut.Chain(stages...), chainSize), nil
+		})
+		r.luts[key] = load
+	}
+	r.mu.Unlock()
+	return load()
+}
+
+// runJob grades the image of j and returns its output.
+func runJob(opt applyOpt, luts *runLuts, depth lut.Depth, j job) (string, error) {
+	paths := j.LUTs
+	if j.LUT != "" {
+		// An intensity after the path overrides that of the job.
+		path, intensity, err := pathAndIntensity(j.LUT)
+		if err != nil {
+			return "", err
+		}
+		if opt.lutIntensity, err = j.intensity(opt.lutIntensity); err != nil {
+			return "", err
+		}
+		if path != j.LUT {
+			opt.lutIntensity = intensity
+		}
+		paths = []string{path}
+	} else {
+		var err error
+		if opt.lutIntensity, err = j.intensity(opt.lutIntensity); err != nil {
+			return "", err
+		}
+	}
+
+	l, err := luts.get(paths)
+	if err != nil {
+		return "", err
+	}
+	opt.lut = strings.Join(paths, ",")
+	opt.output = j.Output
+	if opt.output != "" {
+		if err := makeOutputDir(opt.output); err != nil {
+			return "", err
+		}
+	}
+	return applyPath(opt, l, depth, j.Input)
+}
+
+// runJobs runs the jobs of the manifest r concurrently, as they are
+// read, and prints the status line of each one on stdout as it ends.
+func runJobs(opt applyOpt, r io.Reader, depth lut.Depth) (done, failed int, err error) {
+	var (
+		jobs   = make(chan func() jobStatus)
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		luts   = &runLuts{opt: opt.commonOpt, luts: map[string]func() (LUTApplicator, error){}}
+		stdout = json.NewEncoder(os.Stdout)
+	)
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for run := range jobs {
+				st := run()
+				mu.Lock()
+				if st.Status == "ok" {
+					done++
+				} else {
+					failed++
+				}
+				if opt.json {
+					stdout.Encode(st)
+				} else if st.Status == "ok" {
+					fmt.Printf("%s\tok\t%s\t%.2fs\n", st.ID, st.Output, float64(st.ElapsedMS)/1000)
+				} else {
+					fmt.Printf("%s\tfailed\t%s\n", st.ID, st.Error)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		j, jerr := parseJob(line)
+		if jerr != nil {
+			jerr = fmt.Errorf("line %d: %w", n, jerr)
+		}
+		id := j.ID
+		if id == "" {
+			id = strconv.Itoa(n)
+		}
+		jobs <- func() jobStatus {
+			st := jobStatus{ID: id, Status: "ok", Input: j.Input}
+			start := time.Now()
+			err := jerr
+			if err == nil {
+				st.Output, err = runJob(opt, luts, depth, j)
+			}
+			st.ElapsedMS = time.Since(start).Milliseconds()
+			if err != nil {
+				st.Status, st.Error, st.Type = "failed", err.Error(), errorTypes[exitCode(err)]
+				logError(j.Input, err)
+				return st
+			}
+			logger.Info("graded", "path", j.Input, "output", st.Output, "elapsed", time.Since(start).Round(time.Millisecond))
+			return st
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := sc.Err(); err != nil {
+		return done, failed, exitError{exitIO, err}
+	}
+	return done, failed, nil
+}
+
+func run() error {
+	opt, err := parseRunOpts()
+	if err != nil {
+		return err
+	}
+	depth, err := parseDepth(opt.depth)
+	if err != nil {
+		return err
+	}
+	if opt.maskPath != "" {
+		if opt.mask, err = loadMask(opt.maskPath); err != nil {
+			return err
+		}
+	}
+	if opt.underPath != "" {
+		if opt.under, err = loadUnder(opt.applyOpt); err != nil {
+			return err
+		}
+	}
+
+	r := io.Reader(os.Stdin)
+	if opt.manifest != "-" {
+		f, err := os.Open(opt.manifest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	done, failed, err := runJobs(opt.applyOpt, r, depth)
+	if err != nil {
+		return err
+	}
+	if !opt.quiet && !opt.json {
+		fmt.Fprintf(os.Stderr, "%d done, %d failed\n", done, failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, done+failed)
+	}
+	return nil
+}
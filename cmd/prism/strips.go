@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NicoNex/prism"
+	"github.com/NicoNex/prism/lut"
+	"github.com/NicoNex/prism/pngstream"
+	"github.com/NicoNex/prism/tiff"
+)
+
+// lowMemoryRows is the height of the strips graded with --low-memory.
+const lowMemoryRows = 256
+
+// stripReader reads an image a strip of rows at a time, from top to
+// bottom.
+type stripReader interface {
+	Bounds() image.Rectangle
+	Alpha() bool
+	ReadRows(y0, y1 int) (image.Image, error)
+}
+
+// stripWriter writes an image a strip of rows at a time.
+type stripWriter interface {
+	WriteRows(img image.Image) error
+	Close() error
+}
+
+// stripFormat returns the format of the image at path read from f if it
+// can be read in strips, or an empty string.
+func stripFormat(f io.ReaderAt, path string) (string, error) {
+	// Camera raw files are TIFFs too, but need rendering.
+	if strings.ToLower(filepath.Ext(path)) == ".dng" {
+		return "", nil
+	}
+
+	var sig [8]byte
+	if _, err := f.ReadAt(sig[:], 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	switch s := string(sig[:]); {
+	case strings.HasPrefix(s, "II*\x00"), strings.HasPrefix(s, "MM\x00*"):
+		return "tiff", nil
+	case s == "\x89PNG\r\n\x1a\n":
+		return "png", nil
+	default:
+		return "", nil
+	}
+}
+
+// openStrips returns a reader of the rows of the image read from f, or
+// nil if the image uses a layout only the full decoders support. With
+// --low-memory TIFFs are mapped in memory, and must be closed after use.
+func openStrips(opt applyOpt, f *os.File, format string) (stripReader, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		src stripReader
+		err error
+	)
+	switch {
+	case format == "tiff" && opt.lowMemory:
+		src, err = tiff.OpenFile(opt.imgPath)
+	case format == "tiff":
+		src, err = tiff.Open(f)
+	default:
+		src, err = pngstream.Open(f)
+	}
+	if errors.Is(err, tiff.ErrUnsupported) || errors.Is(err, pngstream.ErrUnsupported) {
+		return nil, nil
+	}
+	return src, err
+}
+
+// newStripWriter writes the header of an image of the given format to w,
+// compressing PNGs at level and embedding the ICC profile unless it is
+// nil.
+func newStripWriter(w io.Writer, format string, b image.Rectangle, deep, alpha bool, level png.CompressionLevel, profile []byte) (stripWriter, error) {
+	if format == "tiff" {
+		return tiff.NewEncoderProfile(w, b.Dx(), b.Dy(), deep, alpha, profile)
+	}
+	e, err := pngstream.NewEncoderLevel(w, b.Dx(), b.Dy(), deep, alpha, level)
+	if err != nil || profile == nil {
+		return e, err
+	}
+	return e, e.WriteProfile(profile)
+}
+
+// applyStrips applies the LUT to the image at opt.imgPath opt.stripRows
+// rows at a time, streaming them from f to the output so that only a
+// strip is held in memory. It reports false when the image or the output
+// format cannot be processed in strips.
+func applyStrips(opt applyOpt, l LUTApplicator, depth lut.Depth, f *os.File, outDir string) (string, bool, error) {
+	format, err := stripFormat(f, opt.imgPath)
+	if err != nil {
+		return "", true, err
+	}
+	if format == "" {
+		return "", false, nil
+	}
+
+	if opt.output == "" {
+		opt.output = defaultOutput(opt.imgPath, outDir)
+	}
+	outFormat := prism.ImageFormat(opt.output, format)
+	if outFormat != "tiff" && outFormat != "png" {
+		return "", false, nil
+	}
+
+	switch {
+	case opt.rotate != "" || opt.flip != "":
+		return "", true, usagef("rotate and flip are not supported with --strip-rows")
+	case opt.show:
+		return "", true, usagef("show is not supported with --strip-rows")
+	case opt.dither == lut.DitherDiffusion:
+		return "", true, usagef("diffusion dithering is not supported with --strip-rows")
+	}
+
+	profile, err := inputProfile(opt, f, format)
+	if err != nil {
+		return "", true, err
+	}
+
+	src, err := openStrips(opt, f, format)
+	if err != nil {
+		return "", true, err
+	}
+	if src == nil {
+		return "", false, nil
+	}
+	if c, ok := src.(io.Closer); ok {
+		defer c.Close()
+	}
+	b := src.Bounds()
+	if err := imageMask(&opt, b); err != nil {
+		return "", true, err
+	}
+
+	lutOpt, err := lutOptions(opt, depth)
+	if err != nil {
+		return "", true, err
+	}
+	if profile != nil {
+		lutOpt.Input = lut.Chain(profile.ToSRGB, lutOpt.Input)
+	}
+	var tagged []byte
+	if out := outputProfile(opt, profile, outFormat); out != nil {
+		if !out.IsSRGB() {
+			lutOpt.Output = lut.Chain(lutOpt.Output, out.FromSRGB)
+		}
+		tagged = out.Data
+	}
+
+	// Progress is reported across the whole image, counting the rows of
+	// the strips already graded.
+	base, rows := 0, b.Dy()
+	if opt.progress != nil {
+		lutOpt.OnProgress = func(done, total int) {
+			opt.progress(base*total+done*rows, b.Dy()*total)
+		}
+	}
+	applier := compileLut(opt, l, lutOpt, profile, tagged)
+
+	outf, err := os.Create(opt.output)
+	if err != nil {
+		return "", true, err
+	}
+	defer outf.Close()
+
+	fail := func(err error) (string, bool, error) {
+		outf.Close()
+		os.Remove(opt.output)
+		return "", true, err
+	}
+
+	bw := bufio.NewWriter(outf)
+	dst, err := newStripWriter(bw, outFormat, b, opt.bits == 16, src.Alpha(), opt.encode.PNGCompression, tagged)
+	if err != nil {
+		return fail(err)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += opt.stripRows {
+		base, rows = y-b.Min.Y, min(opt.stripRows, b.Max.Y-y)
+		strip, err := src.ReadRows(y, y+rows)
+		if err != nil {
+			return fail(err)
+		}
+		if err := dst.WriteRows(prism.ApplyCompiled(applier, strip, opt.bits)); err != nil {
+			return fail(err)
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return fail(err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fail(err)
+	}
+	return opt.output, true, nil
+}
@@ -0,0 +1,114 @@
+package colorspace
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidMatrix = errors.New("invalid matrix: expected 3 rows of 3 or 4 numbers")
+
+// Affine is a 3x4 color matrix: a 3x3 matrix followed by an offset
+// added to each output channel.
+type Affine struct {
+	Matrix Matrix
+	Offset [3]float64
+}
+
+// Apply transforms the color (r, g, b).
+func (a Affine) Apply(r, g, b float64) (float64, float64, float64) {
+	r, g, b = a.Matrix.Apply(r, g, b)
+	return r + a.Offset[0], g + a.Offset[1], b + a.Offset[2]
+}
+
+// ParseAffine parses a matrix specification. The text form holds three
+// rows of three numbers, optionally followed by the offset of the row,
+// separated by spaces or commas; lines starting with # are comments:
+//
+//	# Rec.709 to Rec.2020
+//	0.6274 0.3293 0.0433
+//	0.0691 0.9195 0.0114
+//	0.0164 0.0880 0.8956
+//
+// The JSON form is either the array of rows or an object with the
+// "matrix" rows and an optional "offset".
+func ParseAffine(data []byte) (Affine, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && (data[0] == '[' || data[0] == '{') {
+		return parseAffineJSON(data)
+	}
+
+	var rows [][]float64
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var row []float64
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' }) {
+			v, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return Affine{}, fmt.Errorf("invalid matrix value %q", f)
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+	}
+	return affineRows(rows, nil)
+}
+
+func parseAffineJSON(data []byte) (Affine, error) {
+	var spec struct {
+		Matrix [][]float64 `json:"matrix"`
+		Offset []float64   `json:"offset"`
+	}
+
+	var err error
+	if data[0] == '[' {
+		err = json.Unmarshal(data, &spec.Matrix)
+	} else {
+		err = json.Unmarshal(data, &spec)
+	}
+	if err != nil {
+		return Affine{}, fmt.Errorf("invalid matrix: %w", err)
+	}
+	return affineRows(spec.Matrix, spec.Offset)
+}
+
+// affineRows builds an Affine from 3 rows of 3 or 4 values and an
+// optional offset.
+func affineRows(rows [][]float64, offset []float64) (Affine, error) {
+	if len(rows) != 3 || (offset != nil && len(offset) != 3) {
+		return Affine{}, ErrInvalidMatrix
+	}
+
+	var a Affine
+	for i, row := range rows {
+		switch len(row) {
+		case 4:
+			a.Offset[i] = row[3]
+		case 3:
+		default:
+			return Affine{}, ErrInvalidMatrix
+		}
+		copy(a.Matrix[i][:], row[:3])
+	}
+	if offset != nil {
+		copy(a.Offset[:], offset)
+	}
+	return a, nil
+}
+
+// LoadAffine reads a matrix specification from the file at path.
+func LoadAffine(path string) (Affine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Affine{}, err
+	}
+	return ParseAffine(data)
+}
@@ -0,0 +1,90 @@
+package colorspace
+
+import "math"
+
+// Matrix is a 3x3 matrix transforming RGB or XYZ column vectors.
+type Matrix [3][3]float64
+
+// Identity is the identity matrix.
+var Identity = Matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+// Linear sRGB to CIE XYZ matrices, with the D65 white point and with
+// the Bradford adaptation to the D50 ICC profile connection space.
+var (
+	SRGBToXYZ = Matrix{
+		{0.4124564, 0.3575761, 0.1804375},
+		{0.2126729, 0.7151522, 0.0721750},
+		{0.0193339, 0.1191920, 0.9503041},
+	}
+	SRGBToXYZD50 = Matrix{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	}
+	XYZD50ToSRGB = mustInvert(SRGBToXYZD50)
+)
+
+// Mul returns the matrix product m * n.
+func (m Matrix) Mul(n Matrix) (p Matrix) {
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				p[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return
+}
+
+// Apply transforms the column vector (a, b, c).
+func (m Matrix) Apply(a, b, c float64) (float64, float64, float64) {
+	return m[0][0]*a + m[0][1]*b + m[0][2]*c,
+		m[1][0]*a + m[1][1]*b + m[1][2]*c,
+		m[2][0]*a + m[2][1]*b + m[2][2]*c
+}
+
+// Invert returns the inverse of the matrix and whether it exists.
+func (m Matrix) Invert() (Matrix, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if det == 0 {
+		return m, false
+	}
+
+	var inv Matrix
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inv, true
+}
+
+func mustInvert(m Matrix) Matrix {
+	inv, ok := m.Invert()
+	if !ok {
+		panic("colorspace: singular matrix")
+	}
+	return inv
+}
+
+// SRGBToLinear decodes an sRGB encoded value to linear light.
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// LinearToSRGB encodes a linear light value with the sRGB transfer function.
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
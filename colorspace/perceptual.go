@@ -0,0 +1,88 @@
+package colorspace
+
+import "math"
+
+// Linear sRGB to LMS cone responses and cube-rooted LMS to Oklab, from
+// Björn Ottosson's definition of Oklab.
+var (
+	linearToLMS = Matrix{
+		{0.4122214708, 0.5363325363, 0.0514459929},
+		{0.2119034982, 0.6806995451, 0.1073969566},
+		{0.0883024619, 0.2817188376, 0.6299787005},
+	}
+	lmsToOklab = Matrix{
+		{0.2104542553, 0.7936177850, -0.0040720468},
+		{1.9779984951, -2.4285922050, 0.4505937099},
+		{0.0259040371, 0.7827717662, -0.8086757660},
+	}
+	oklabToLMS  = mustInvert(lmsToOklab)
+	lmsToLinear = mustInvert(linearToLMS)
+)
+
+// SRGBToOklab converts an sRGB encoded color to Oklab, whose lightness,
+// chroma and hue match the perceived ones closely.
+func SRGBToOklab(r, g, b float64) (float64, float64, float64) {
+	l, m, s := linearToLMS.Apply(SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b))
+	return lmsToOklab.Apply(math.Cbrt(l), math.Cbrt(m), math.Cbrt(s))
+}
+
+// OklabToSRGB converts an Oklab color to sRGB encoding.
+func OklabToSRGB(L, a, b float64) (float64, float64, float64) {
+	l, m, s := oklabToLMS.Apply(L, a, b)
+	r, g, bl := lmsToLinear.Apply(l*l*l, m*m*m, s*s*s)
+	return LinearToSRGB(r), LinearToSRGB(g), LinearToSRGB(bl)
+}
+
+// RGBToHSL converts an RGB color to hue, in turns from 0 to 1,
+// saturation and lightness.
+func RGBToHSL(r, g, b float64) (h, s, l float64) {
+	hi, lo := max(r, g, b), min(r, g, b)
+	l = (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+
+	if den := 1 - math.Abs(2*l-1); den > 0 {
+		s = d / den
+	}
+	switch hi {
+	case r:
+		h = (g - b) / d
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h /= 6
+	if h < 0 {
+		h++
+	}
+	return h, s, l
+}
+
+// HSLToRGB converts a color from hue, in turns, saturation and
+// lightness to RGB.
+func HSLToRGB(h, s, l float64) (float64, float64, float64) {
+	c := (1 - math.Abs(2*l-1)) * s
+	h = (h - math.Floor(h)) * 6
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	m := l - c/2
+
+	var r, g, b float64
+	switch {
+	case h < 1:
+		r, g = c, x
+	case h < 2:
+		r, g = x, c
+	case h < 3:
+		g, b = c, x
+	case h < 4:
+		g, b = x, c
+	case h < 5:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	return r + m, g + m, b + m
+}
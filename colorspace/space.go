@@ -0,0 +1,266 @@
+package colorspace
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+// Primaries are the CIE xy chromaticities of the red, green and blue
+// primaries and of the white point of an RGB color space.
+type Primaries struct {
+	R, G, B, W [2]float64
+}
+
+// Standard and camera gamuts.
+var (
+	D65 = [2]float64{0.3127, 0.3290}
+
+	Rec709      = Primaries{R: [2]float64{0.640, 0.330}, G: [2]float64{0.300, 0.600}, B: [2]float64{0.150, 0.060}, W: D65}
+	Rec2020     = Primaries{R: [2]float64{0.708, 0.292}, G: [2]float64{0.170, 0.797}, B: [2]float64{0.131, 0.046}, W: D65}
+	SGamut3Cine = Primaries{R: [2]float64{0.766, 0.275}, G: [2]float64{0.225, 0.800}, B: [2]float64{0.089, -0.087}, W: D65}
+	VGamut      = Primaries{R: [2]float64{0.730, 0.280}, G: [2]float64{0.165, 0.840}, B: [2]float64{0.100, -0.030}, W: D65}
+	AWG3        = Primaries{R: [2]float64{0.684, 0.313}, G: [2]float64{0.221, 0.848}, B: [2]float64{0.0861, -0.102}, W: D65}
+	CinemaGamut = Primaries{R: [2]float64{0.740, 0.270}, G: [2]float64{0.170, 1.140}, B: [2]float64{0.080, -0.100}, W: D65}
+)
+
+// ToXYZ returns the matrix converting linear RGB in the color space to
+// CIE XYZ, scaled so that the white point has Y = 1.
+func (p Primaries) ToXYZ() Matrix {
+	xyz := func(c [2]float64) [3]float64 {
+		return [3]float64{c[0] / c[1], 1, (1 - c[0] - c[1]) / c[1]}
+	}
+
+	var m Matrix
+	for j, c := range [][2]float64{p.R, p.G, p.B} {
+		v := xyz(c)
+		for i := range 3 {
+			m[i][j] = v[i]
+		}
+	}
+
+	// Scale the primaries so that they add up to the white point.
+	w := xyz(p.W)
+	inv := mustInvert(m)
+	sr, sg, sb := inv.Apply(w[0], w[1], w[2])
+	for i := range 3 {
+		m[i][0] *= sr
+		m[i][1] *= sg
+		m[i][2] *= sb
+	}
+	return m
+}
+
+// Space is an RGB color space: a gamut and the transfer function
+// encoding its linear values.
+type Space struct {
+	Name      string
+	Primaries Primaries
+	// Decode converts an encoded value to linear light.
+	Decode func(float64) float64
+	// Encode converts a linear light value to its encoding.
+	Encode func(float64) float64
+}
+
+// Built-in color spaces.
+var (
+	SRGB = Space{Name: "srgb", Primaries: Rec709, Decode: SRGBToLinear, Encode: LinearToSRGB}
+
+	Linear = Space{Name: "linear", Primaries: Rec709, Decode: identity, Encode: identity}
+
+	Rec709Display  = Space{Name: "rec709", Primaries: Rec709, Decode: bt1886Decode, Encode: bt1886Encode}
+	Rec2020Display = Space{Name: "rec2020", Primaries: Rec2020, Decode: bt1886Decode, Encode: bt1886Encode}
+
+	PQ  = Space{Name: "pq", Primaries: Rec2020, Decode: pqDecode, Encode: pqEncode}
+	HLG = Space{Name: "hlg", Primaries: Rec2020, Decode: hlgDecode, Encode: hlgEncode}
+
+	SLog3 = Space{Name: "slog3", Primaries: SGamut3Cine, Decode: slog3Decode, Encode: slog3Encode}
+	VLog  = Space{Name: "vlog", Primaries: VGamut, Decode: vlogDecode, Encode: vlogEncode}
+	LogC  = Space{Name: "logc", Primaries: AWG3, Decode: logcDecode, Encode: logcEncode}
+	CLog  = Space{Name: "clog", Primaries: CinemaGamut, Decode: clogDecode, Encode: clogEncode}
+)
+
+var spaces = []Space{SRGB, Linear, Rec709Display, Rec2020Display, PQ, HLG, SLog3, VLog, LogC, CLog}
+
+// Lookup returns the built-in color space with the given name.
+func Lookup(name string) (Space, bool) {
+	i := slices.IndexFunc(spaces, func(s Space) bool {
+		return s.Name == strings.ToLower(name)
+	})
+	if i < 0 {
+		return Space{}, false
+	}
+	return spaces[i], true
+}
+
+// Names returns the names of the built-in color spaces.
+func Names() []string {
+	names := make([]string, len(spaces))
+	for i, s := range spaces {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Conversion returns a function converting normalised colors encoded
+// in the space from to the space to.
+func Conversion(from, to Space) func(r, g, b float64) (float64, float64, float64) {
+	m := mustInvert(to.Primaries.ToXYZ()).Mul(from.Primaries.ToXYZ())
+
+	return func(r, g, b float64) (float64, float64, float64) {
+		r, g, b = m.Apply(from.Decode(r), from.Decode(g), from.Decode(b))
+		return to.Encode(r), to.Encode(g), to.Encode(b)
+	}
+}
+
+func identity(v float64) float64 {
+	return v
+}
+
+// ITU-R BT.1886 display gamma, mirrored for values below zero so that
+// colors outside the gamut survive a round trip.
+func bt1886Decode(e float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(e), 2.4), e)
+}
+
+func bt1886Encode(v float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), 1/2.4), v)
+}
+
+// ReferenceWhite is the luminance in nits of diffuse white in HDR
+// signals, decoded to linear 1.0 (ITU-R BT.2408).
+const ReferenceWhite = 203
+
+// SMPTE ST 2084 perceptual quantizer.
+const (
+	pqM1 = 2610.0 / 16384
+	pqM2 = 2523.0 / 4096 * 128
+	pqC1 = 3424.0 / 4096
+	pqC2 = 2413.0 / 4096 * 32
+	pqC3 = 2392.0 / 4096 * 32
+)
+
+func pqDecode(e float64) float64 {
+	p := math.Pow(max(0, e), 1/pqM2)
+	y := math.Pow(max(0, p-pqC1)/(pqC2-pqC3*p), 1/pqM1)
+	return y * 10000 / ReferenceWhite
+}
+
+func pqEncode(v float64) float64 {
+	y := math.Pow(max(0, v*ReferenceWhite/10000), pqM1)
+	return math.Pow((pqC1+pqC2*y)/(1+pqC3*y), pqM2)
+}
+
+// ITU-R BT.2100 hybrid log-gamma, with the scene light of the 75%
+// reference white signal decoded to 1.0.
+const (
+	hlgA = 0.17883277
+	hlgB = 0.28466892
+	hlgC = 0.55991073
+)
+
+var hlgWhite = hlgInverseOETF(0.75)
+
+func hlgInverseOETF(e float64) float64 {
+	if e <= 0.5 {
+		return e * e / 3
+	}
+	return (math.Exp((e-hlgC)/hlgA) + hlgB) / 12
+}
+
+func hlgDecode(e float64) float64 {
+	return hlgInverseOETF(e) / hlgWhite
+}
+
+func hlgEncode(v float64) float64 {
+	v = max(0, v*hlgWhite)
+	if v <= 1.0/12 {
+		return math.Sqrt(3 * v)
+	}
+	return hlgA*math.Log(12*v-hlgB) + hlgC
+}
+
+// Sony S-Log3, as code values normalised over 10 bits.
+func slog3Encode(x float64) float64 {
+	if x >= 0.01125 {
+		return (420 + math.Log10((x+0.01)/(0.18+0.01))*261.5) / 1023
+	}
+	return (x*(171.2102946929-95)/0.01125 + 95) / 1023
+}
+
+func slog3Decode(y float64) float64 {
+	if y >= 171.2102946929/1023 {
+		return math.Pow(10, (y*1023-420)/261.5)*(0.18+0.01) - 0.01
+	}
+	return (y*1023 - 95) * 0.01125 / (171.2102946929 - 95)
+}
+
+// Panasonic V-Log.
+const (
+	vlogB = 0.00873
+	vlogC = 0.241514
+	vlogD = 0.598206
+)
+
+func vlogEncode(x float64) float64 {
+	if x < 0.01 {
+		return 5.6*x + 0.125
+	}
+	return vlogC*math.Log10(x+vlogB) + vlogD
+}
+
+func vlogDecode(y float64) float64 {
+	if y < 0.181 {
+		return (y - 0.125) / 5.6
+	}
+	return math.Pow(10, (y-vlogD)/vlogC) - vlogB
+}
+
+// ARRI LogC3 at EI 800.
+const (
+	logcCut = 0.010591
+	logcA   = 5.555556
+	logcB   = 0.052272
+	logcC   = 0.247190
+	logcD   = 0.385537
+	logcE   = 5.367655
+	logcF   = 0.092809
+)
+
+func logcEncode(x float64) float64 {
+	if x > logcCut {
+		return logcC*math.Log10(logcA*x+logcB) + logcD
+	}
+	return logcE*x + logcF
+}
+
+func logcDecode(y float64) float64 {
+	if y > logcE*logcCut+logcF {
+		return (math.Pow(10, (y-logcD)/logcC) - logcB) / logcA
+	}
+	return (y - logcF) / logcE
+}
+
+// Canon Log, as legal range code values. Linear values are scene
+// reflectance, with 90% white at 1.
+func clogEncode(x float64) float64 {
+	x /= 0.9
+	var ire float64
+	if x >= 0 {
+		ire = 0.529136*math.Log10(10.1596*x+1) + 0.0730597
+	} else {
+		ire = -0.529136*math.Log10(-10.1596*x+1) + 0.0730597
+	}
+	return (64 + 876*ire) / 1023
+}
+
+func clogDecode(y float64) float64 {
+	ire := (y*1023 - 64) / 876
+	var x float64
+	if ire >= 0.0730597 {
+		x = (math.Pow(10, (ire-0.0730597)/0.529136) - 1) / 10.1596
+	} else {
+		x = -(math.Pow(10, (0.0730597-ire)/0.529136) - 1) / 10.1596
+	}
+	return x * 0.9
+}
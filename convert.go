@@ -0,0 +1,88 @@
+package prism
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/lut"
+)
+
+// ConvertOptions are the parameters of Convert. Zero values select the
+// defaults.
+type ConvertOptions struct {
+	// Size is the points per axis of CUBE outputs, DefaultSize if zero.
+	Size int
+	// Level is the level of HALD outputs, 8 if zero.
+	Level int
+	// Bits is the bits per channel of HALD outputs, 8 or 16.
+	Bits int
+	// Compression is the compression level of HALD outputs.
+	Compression png.CompressionLevel
+	// Title is the title of the output, in the TITLE line of CUBEs and
+	// the Title text of HALDs.
+	Title string
+	// Text is the textual metadata of HALD outputs, by PNG keyword.
+	Text map[string]string
+}
+
+// ToCube samples the LUT l into a CUBE of the given size over the unit
+// domain. CUBEs of that size and domain are returned as they are.
+func ToCube(l lut.LUT, size int) cube.Cube {
+	if c, ok := l.(cube.Cube); ok && c.LUT3Dsize == size && c.DomainMin == (cube.Sample{}) && c.DomainMax == (cube.Sample{R: 1, G: 1, B: 1}) {
+		return c
+	}
+	return cube.FromLUT(l, size)
+}
+
+// ToHALD renders the LUT l as a HALD image of the given level, with 8 or
+// 16 bits per channel. 16-bit HALDs are rendered from a 16-bit identity.
+func ToHALD(l lut.LUT, level, bits int) image.Image {
+	a := l.Compile(lut.Options{Intensity: 1})
+	if bits == 16 {
+		id, _ := hald.IdentityDepth(level, 16)
+		return a.Apply16(id)
+	}
+	return a.Apply(hald.Identity(level))
+}
+
+// Convert writes the LUT l to w in the format typ: .cube for a CUBE or
+// .png for a HALD.
+func Convert(w io.Writer, l lut.LUT, typ string, opt ConvertOptions) error {
+	switch typ {
+	case ".cube":
+		size := opt.Size
+		if size == 0 {
+			size = DefaultSize
+		}
+		c := ToCube(l, size)
+		if opt.Title != "" {
+			c.Title = opt.Title
+		}
+		_, err := c.WriteTo(w)
+		return err
+	case ".png":
+		level := opt.Level
+		if level == 0 {
+			level = 8
+		}
+		h, err := hald.New(ToHALD(l, level, opt.Bits))
+		if err != nil {
+			return err
+		}
+		h.Text = maps.Clone(opt.Text)
+		if opt.Title != "" {
+			if h.Text == nil {
+				h.Text = make(map[string]string)
+			}
+			h.Text[hald.TextTitle] = opt.Title
+		}
+		return (&hald.Encoder{CompressionLevel: opt.Compression}).Encode(w, h)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, typ)
+	}
+}
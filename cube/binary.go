@@ -0,0 +1,194 @@
+package cube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// binaryMagic identifies the compact binary form of a CUBE LUT. It is
+// followed by the format version: version 2 adds the video range flags
+// after the size.
+const (
+	binaryMagic   = "PCUB"
+	binaryVersion = 2
+)
+
+// Flags of the binary form.
+const (
+	flagInVideoRange = 1 << iota
+	flagOutVideoRange
+	flagInputRange
+	// flagFloat64 marks samples stored as float64 values, as in the
+	// files of a Cache.
+	flagFloat64
+)
+
+var ErrInvalidBinary = errors.New("invalid binary CUBE data")
+
+// MarshalBinary encodes the LUT in a compact binary form, storing the
+// samples as little-endian float32 values.
+func (c Cube) MarshalBinary() ([]byte, error) {
+	return c.marshal(false), nil
+}
+
+// marshal encodes the LUT in binary form, with samples stored as float64
+// values if double is set and as float32 values otherwise.
+func (c Cube) marshal(double bool) []byte {
+	n := len(c.Samples)
+	width := 12
+	if double {
+		width = 24
+	}
+	buf := make([]byte, 0, len(binaryMagic)+len(c.Title)+len(c.Meta)+64+n*width)
+
+	var flags byte
+	if c.InVideoRange {
+		flags |= flagInVideoRange
+	}
+	if c.OutVideoRange {
+		flags |= flagOutVideoRange
+	}
+	if c.InputRange {
+		flags |= flagInputRange
+	}
+	if double {
+		flags |= flagFloat64
+	}
+
+	buf = append(buf, binaryMagic...)
+	buf = append(buf, binaryVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(c.Title)))
+	buf = append(buf, c.Title...)
+	buf = binary.AppendUvarint(buf, uint64(len(c.Meta)))
+	buf = append(buf, c.Meta...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(c.LUT3Dsize))
+	buf = append(buf, flags)
+
+	for _, s := range []Sample{c.DomainMin, c.DomainMax} {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.R))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.G))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.B))
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(n))
+	for _, s := range c.Samples {
+		if double {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.R))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.G))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.B))
+			continue
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(s.R)))
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(s.G)))
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(s.B)))
+	}
+	return buf
+}
+
+// IsBinary reports whether data starts as the binary form written by
+// MarshalBinary does.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// UnmarshalBinary decodes a LUT encoded with MarshalBinary.
+func (c *Cube) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(binaryMagic)) || len(data) == len(binaryMagic) {
+		return ErrInvalidBinary
+	}
+	version := data[len(binaryMagic)]
+	if version < 1 || version > binaryVersion {
+		return ErrInvalidBinary
+	}
+	data = data[len(binaryMagic)+1:]
+
+	str := func() (string, bool) {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || n > uint64(len(data)-k) {
+			return "", false
+		}
+		s := string(data[k : k+int(n)])
+		data = data[k+int(n):]
+		return s, true
+	}
+
+	title, ok := str()
+	if !ok {
+		return ErrInvalidBinary
+	}
+	minLen := 4 + 6*8 + 4
+	if version >= 2 {
+		minLen++
+	}
+	meta, ok := str()
+	if !ok || len(data) < minLen {
+		return ErrInvalidBinary
+	}
+
+	size := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+
+	var flags byte
+	if version >= 2 {
+		flags = data[0]
+		data = data[1:]
+	}
+
+	var domain [6]float64
+	for i := range domain {
+		domain[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	data = data[6*8:]
+
+	// Check the sample count against the data before allocating.
+	n := uint64(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	width := uint64(12)
+	if flags&flagFloat64 != 0 {
+		width = 24
+	}
+	if n*width != uint64(len(data)) {
+		return ErrInvalidBinary
+	}
+
+	samples := make([]Sample, n)
+	for i := range samples {
+		v := data[uint64(i)*width:]
+		if width == 24 {
+			samples[i] = Sample{
+				R: math.Float64frombits(binary.LittleEndian.Uint64(v)),
+				G: math.Float64frombits(binary.LittleEndian.Uint64(v[8:])),
+				B: math.Float64frombits(binary.LittleEndian.Uint64(v[16:])),
+			}
+			continue
+		}
+		samples[i] = Sample{
+			R: float64(math.Float32frombits(binary.LittleEndian.Uint32(v))),
+			G: float64(math.Float32frombits(binary.LittleEndian.Uint32(v[4:]))),
+			B: float64(math.Float32frombits(binary.LittleEndian.Uint32(v[8:]))),
+		}
+	}
+
+	d := Cube{
+		Title:     title,
+		Meta:      meta,
+		LUT3Dsize: size,
+		DomainMin: Sample{domain[0], domain[1], domain[2]},
+		DomainMax: Sample{domain[3], domain[4], domain[5]},
+		Samples:   samples,
+
+		InVideoRange:  flags&flagInVideoRange != 0,
+		OutVideoRange: flags&flagOutVideoRange != 0,
+		InputRange:    flags&flagInputRange != 0,
+	}
+	if err := d.checkSize(); err != nil {
+		return err
+	}
+	if err := d.checkDomain(); err != nil {
+		return err
+	}
+	*c = d
+	return nil
+}
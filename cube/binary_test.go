@@ -0,0 +1,154 @@
+package cube
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// binaryCube returns a LUT of size 3 setting every field the binary form
+// stores. Its samples are multiples of 1/8, exact in float32.
+func binaryCube() Cube {
+	c := Cube{
+		Title:         "Binary ሴ",
+		Meta:          "# created by a test\n# second line",
+		LUT3Dsize:     3,
+		DomainMin:     Sample{-0.5, 0, 0.25},
+		DomainMax:     Sample{1, 2, 1.5},
+		InVideoRange:  true,
+		OutVideoRange: true,
+	}
+	for i := range 27 {
+		c.Samples = append(c.Samples, Sample{float64(i) / 8, float64(26-i) / 8, -float64(i%3) / 8})
+	}
+	return c
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	inputRange := binaryCube()
+	inputRange.DomainMin, inputRange.DomainMax = Sample{-0.25, -0.25, -0.25}, Sample{1.25, 1.25, 1.25}
+	inputRange.InputRange, inputRange.InVideoRange, inputRange.OutVideoRange = true, false, false
+	tests := []struct {
+		name string
+		cube Cube
+	}{
+		{"all fields", binaryCube()},
+		{"input range", inputRange},
+		{"identity", FromTransform(func(r, g, b float64) (float64, float64, float64) { return r, g, b }, 5)},
+	}
+
+	for _, tt := range tests {
+		data, err := tt.cube.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsBinary(data) {
+			t.Errorf("%s: not recognised as binary", tt.name)
+		}
+		var got Cube
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.cube) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.cube)
+		}
+	}
+}
+
+func TestBinaryPrecision(t *testing.T) {
+	c := binaryCube()
+	c.Samples[13] = Sample{0.1, 1.0 / 3, 2e-9}
+
+	var single, double Cube
+	data, _ := c.MarshalBinary()
+	if err := single.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := double.UnmarshalBinary(c.marshal(true)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := single.Samples[13], (Sample{float64(float32(0.1)), float64(float32(1.0 / 3)), float64(float32(2e-9))}); got != want {
+		t.Errorf("float32: got %v, want %v", got, want)
+	}
+	if got := double.Samples[13]; got != c.Samples[13] {
+		t.Errorf("float64: got %v, want %v", got, c.Samples[13])
+	}
+}
+
+func TestUnmarshalBinaryVersion1(t *testing.T) {
+	// Version 1 has no flags byte after the size.
+	c := binaryCube()
+	c.InVideoRange, c.OutVideoRange = false, false
+	data, _ := c.MarshalBinary()
+	flags := len(binaryMagic) + 1 + 1 + len(c.Title) + 1 + len(c.Meta) + 4
+	v1 := append(bytes.Clone(data[:flags]), data[flags+1:]...)
+	v1[len(binaryMagic)] = 1
+
+	var got Cube
+	if err := got.UnmarshalBinary(v1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+}
+
+func TestUnmarshalBinaryTruncated(t *testing.T) {
+	data, _ := binaryCube().MarshalBinary()
+	for n := range len(data) {
+		var c Cube
+		if err := c.UnmarshalBinary(data[:n]); !errors.Is(err, ErrInvalidBinary) {
+			t.Fatalf("%d of %d bytes: got %v, want %v", n, len(data), err, ErrInvalidBinary)
+		}
+	}
+	var c Cube
+	if err := c.UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrInvalidBinary) {
+		t.Errorf("trailing byte: got %v, want %v", err, ErrInvalidBinary)
+	}
+}
+
+func TestUnmarshalBinaryCorrupt(t *testing.T) {
+	c := binaryCube()
+	data, _ := c.MarshalBinary()
+	size := len(binaryMagic) + 1 + 1 + len(c.Title) + 1 + len(c.Meta)
+	domain := size + 4 + 1
+	count := domain + 6*8
+
+	// set returns data with the bytes at offset i replaced by v, and
+	// set32 with the uint32 at offset i replaced by v.
+	set := func(i int, v ...byte) []byte {
+		d := bytes.Clone(data)
+		copy(d[i:], v)
+		return d
+	}
+	set32 := func(i int, v uint32) []byte {
+		return set(i, binary.LittleEndian.AppendUint32(nil, v)...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"magic", set(0, 'P', 'C', 'U', 'C'), ErrInvalidBinary},
+		{"version 0", set(len(binaryMagic), 0), ErrInvalidBinary},
+		{"future version", set(len(binaryMagic), binaryVersion+1), ErrInvalidBinary},
+		{"title length", set(len(binaryMagic)+1, 0xff, 0xff, 0xff, 0xff, 0x0f), ErrInvalidBinary},
+		{"sample count", set32(count, 28), ErrInvalidBinary},
+		{"huge sample count", set32(count, 0xffffffff), ErrInvalidBinary},
+		{"size", set32(size, 4), ErrSampleCount},
+		{"size 1", set32(size, 1), ErrInvalidSize},
+		{"empty domain", set(domain, data[domain+3*8:domain+6*8]...), ErrInvalidDomain},
+	}
+
+	for _, tt := range tests {
+		var got Cube
+		if err := got.UnmarshalBinary(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if !reflect.DeepEqual(got, Cube{}) {
+			t.Errorf("%s: set the LUT on error", tt.name)
+		}
+	}
+}
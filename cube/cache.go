@@ -0,0 +1,83 @@
+package cube
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Cache keeps the binary form of the CUBEs it loads, with samples at
+// full precision, in a directory, named after the SHA-256 hash of their
+// text, so that loading the same LUT again skips parsing it. Servers and
+// batch jobs reloading large LUTs load them several times faster.
+//
+// The cache is best effort: LUTs load from their text when it cannot be
+// read or written. LUTs loaded with warnings are not cached, so that
+// their warnings are reported every time.
+type Cache struct {
+	// Dir is the directory of the cache, created on the first write.
+	Dir string
+	// MinSize is the size of the smallest text cached: smaller LUTs
+	// parse about as fast as they are hashed.
+	MinSize int
+}
+
+// cacheExt is the extension of the cached files.
+const cacheExt = ".pcub"
+
+// Load reads a CUBE LUT from r, in text or binary form, as Load does,
+// from the cache if the text was cached.
+func (c Cache) Load(r io.Reader) (Cube, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Cube{}, err
+	}
+
+	var lut Cube
+	if IsBinary(data) {
+		err = lut.UnmarshalBinary(data)
+		return lut, err
+	}
+	if len(data) < c.MinSize {
+		return Load(bytes.NewReader(data))
+	}
+
+	sum := sha256.Sum256(data)
+	path := filepath.Join(c.Dir, hex.EncodeToString(sum[:])+cacheExt)
+	if cached, err := os.ReadFile(path); err == nil && lut.UnmarshalBinary(cached) == nil {
+		return lut, nil
+	}
+
+	if lut, err = Load(bytes.NewReader(data)); err != nil {
+		return Cube{}, err
+	}
+	if len(lut.warnings) == 0 {
+		c.store(path, lut)
+	}
+	return lut, nil
+}
+
+// store writes the binary form of lut to path, through a temporary file
+// so that concurrent loads never read it half written.
+func (c Cache) store(path string, lut Cube) {
+	if os.MkdirAll(c.Dir, 0o755) != nil {
+		return
+	}
+	f, err := os.CreateTemp(c.Dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(lut.marshal(true))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
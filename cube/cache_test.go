@@ -0,0 +1,173 @@
+package cube
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cacheText is a CUBE of size 2 with a sample float32 cannot hold.
+const cacheText = `TITLE "Cached"
+LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 0.1
+`
+
+// cachePath returns the path of the file caching text in c.
+func cachePath(c Cache, text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+cacheExt)
+}
+
+// cacheFiles returns the names of the files in the cache directory.
+func cacheFiles(t *testing.T, c Cache) []string {
+	t.Helper()
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestCacheLoad(t *testing.T) {
+	c := Cache{Dir: filepath.Join(t.TempDir(), "cache")}
+	want, err := Load(strings.NewReader(cacheText))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 2 {
+		got, err := c.Load(strings.NewReader(cacheText))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("load %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if got, want := cacheFiles(t, c), []string{filepath.Base(cachePath(c, cacheText))}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got cache files %q, want %q", got, want)
+	}
+
+	// Loads read the cached file rather than the text.
+	marked := want.Clone()
+	marked.Title = "From the cache"
+	if err := os.WriteFile(cachePath(c, cacheText), marked.marshal(true), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Load(strings.NewReader(cacheText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != marked.Title {
+		t.Errorf("got title %q, want %q from the cache", got.Title, marked.Title)
+	}
+}
+
+func TestCacheSourceChanged(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	if _, err := c.Load(strings.NewReader(cacheText)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A changed text hashes to another file, so the stale one is never
+	// read for it.
+	changed := strings.Replace(cacheText, "1 1 0.1", "1 1 0.2", 1)
+	got, err := c.Load(strings.NewReader(changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Samples[7]; s != (Sample{1, 1, 0.2}) {
+		t.Errorf("got sample %v, want {1 1 0.2}", s)
+	}
+	if n := len(cacheFiles(t, c)); n != 2 {
+		t.Errorf("got %d cache files, want 2", n)
+	}
+	if _, err := os.Stat(cachePath(c, changed)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCacheCorrupt(t *testing.T) {
+	c := Cache{Dir: t.TempDir()}
+	path := cachePath(c, cacheText)
+	data, _ := binaryCube().MarshalBinary()
+	for _, corrupt := range [][]byte{[]byte("not a PCUB"), data[:len(data)/2]} {
+		if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Load(strings.NewReader(cacheText))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Cached" {
+			t.Errorf("got title %q, want the LUT of the text", got.Title)
+		}
+
+		// The corrupt file is replaced.
+		var cached Cube
+		if data, err := os.ReadFile(path); err != nil || cached.UnmarshalBinary(data) != nil {
+			t.Errorf("cache file not rewritten: %v", err)
+		}
+	}
+}
+
+func TestCacheNotStored(t *testing.T) {
+	untitled := strings.Replace(cacheText, `"Cached"`, "Cached", 1)
+	data, _ := binaryCube().MarshalBinary()
+	tests := []struct {
+		name  string
+		cache Cache
+		text  string
+	}{
+		{"below MinSize", Cache{MinSize: len(cacheText) + 1}, cacheText},
+		{"warnings", Cache{}, untitled},
+		{"binary", Cache{}, string(data)},
+	}
+
+	for _, tt := range tests {
+		tt.cache.Dir = t.TempDir()
+		for range 2 {
+			got, err := tt.cache.Load(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if tt.text == untitled && len(got.Warnings()) != 1 {
+				t.Errorf("%s: got warnings %v, want 1", tt.name, got.Warnings())
+			}
+		}
+		if files := cacheFiles(t, tt.cache); files != nil {
+			t.Errorf("%s: got cache files %q", tt.name, files)
+		}
+	}
+}
+
+func TestCacheUnwritable(t *testing.T) {
+	// The cache directory is a file: loads still work.
+	dir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Cache{Dir: dir}.Load(bytes.NewReader([]byte(cacheText)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Cached" {
+		t.Errorf("got title %q, want Cached", got.Title)
+	}
+}
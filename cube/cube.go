@@ -2,14 +2,20 @@ package cube
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"io"
+	"io/fs"
+	"math"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/NicoNex/prism/lut"
 )
 
 type Sample struct {
@@ -30,6 +36,9 @@ func (s *Sample) Blend(s2 Sample, w1, w2 float64) *Sample {
 	return s
 }
 
+// Clamp maps each channel of the sample linearly from the range from min
+// to max onto [0, 1]. Despite its name it does not limit the channels,
+// which ClampTo does.
 func (s *Sample) Clamp(min, max Sample) *Sample {
 	s.R = (s.R - min.R) / (max.R - min.R)
 	s.G = (s.G - min.G) / (max.G - min.G)
@@ -37,6 +46,14 @@ func (s *Sample) Clamp(min, max Sample) *Sample {
 	return s
 }
 
+// ClampTo limits each channel of the sample to the range from lo to hi.
+func (s *Sample) ClampTo(lo, hi Sample) *Sample {
+	s.R = max(lo.R, min(hi.R, s.R))
+	s.G = max(lo.G, min(hi.G, s.G))
+	s.B = max(lo.B, min(hi.B, s.B))
+	return s
+}
+
 func (s *Sample) Scale(v float64) *Sample {
 	s.R *= v
 	s.G *= v
@@ -70,6 +87,35 @@ type Cube struct {
 	DomainMin Sample
 	DomainMax Sample
 	Samples   []Sample
+
+	// InVideoRange and OutVideoRange mark LUTs taking their input and
+	// returning their output in video (legal) range, as declared by the
+	// LUT_IN_VIDEO_RANGE and LUT_OUT_VIDEO_RANGE keywords.
+	InVideoRange  bool
+	OutVideoRange bool
+	// InputRange marks a domain declared with LUT_3D_INPUT_RANGE, the
+	// Resolve form of DOMAIN_MIN and DOMAIN_MAX giving one range for
+	// all the channels. The LUT is written back in the same form.
+	InputRange bool
+	// Workers is the number of goroutines Map, Sum, the blends and the
+	// other operations over the samples spread them over, GOMAXPROCS if
+	// zero. It is not written to files.
+	Workers int
+
+	warnings []lut.Warning
+}
+
+// Warnings returns the non-fatal issues found while loading the LUT.
+func (c Cube) Warnings() []lut.Warning {
+	return c.warnings
+}
+
+// warn records a non-fatal issue found on the given line.
+func (c *Cube) warn(line int, format string, args ...any) {
+	c.warnings = append(c.warnings, lut.Warning{
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
 }
 
 func (c Cube) String() string {
@@ -79,44 +125,18 @@ func (c Cube) String() string {
 	return buf.String()
 }
 
-func (c Cube) WriteTo(w io.Writer) (n int64, err error) {
-	var cur int
-
-	if c.Title != "" {
-		if cur, err = fmt.Fprintf(w, "TITLE \"%s\"\n", c.Title); err != nil {
-			return
-		}
-		n += int64(cur)
-	}
-	if c.Meta != "" {
-		if cur, err = fmt.Fprintf(w, "%s\n", c.Meta); err != nil {
-			return
-		}
-		n += int64(cur)
-	}
-
-	if cur, err = fmt.Fprintf(w, "LUT_3D_SIZE %d\n\n", c.LUT3Dsize); err != nil {
-		return
-	}
-	n += int64(cur)
-
-	if cur, err = fmt.Fprintf(w, "DOMAIN_MIN %v\n", c.DomainMin); err != nil {
-		return
-	}
-	n += int64(cur)
-	if cur, err = fmt.Fprintf(w, "DOMAIN_MAX %v\n\n", c.DomainMax); err != nil {
-		return
-	}
-	n += int64(cur)
-
-	for _, s := range c.Samples {
-		if cur, err = fmt.Fprintln(w, s); err != nil {
-			return
-		}
-		n += int64(cur)
-	}
+// WriteTo writes the LUT to w as a CUBE file, with the formatting of the
+// zero Encoder.
+func (c Cube) WriteTo(w io.Writer) (int64, error) {
+	return (&Encoder{}).encode(w, c)
+}
 
-	return
+// Clone returns a deep copy of the LUT, whose samples can be changed,
+// as Scale and Clamp do in place, without changing those of c.
+func (c Cube) Clone() Cube {
+	c.Samples = slices.Clone(c.Samples)
+	c.warnings = slices.Clone(c.warnings)
+	return c
 }
 
 func (c *Cube) Scale(v float64) *Cube {
@@ -124,23 +144,46 @@ func (c *Cube) Scale(v float64) *Cube {
 		return c
 	}
 
-	for i := range c.Samples {
-		c.Samples[i].Scale(v)
-	}
-	return c
+	return c.Map(func(s Sample) Sample {
+		return *s.Scale(v)
+	})
 }
 
+// Clamp maps the samples from the domain of the LUT onto [0, 1], in
+// place, as Sample.Clamp does. ClampToDomain limits them to the domain.
 func (c *Cube) Clamp() *Cube {
-	for i := range c.Samples {
-		c.Samples[i].Clamp(c.DomainMin, c.DomainMax)
-	}
-	return c
+	lo, hi := c.domain()
+	return c.Map(func(s Sample) Sample {
+		return *s.Clamp(lo, hi)
+	})
+}
+
+// ClampToDomain limits the samples to the domain of the LUT, in place.
+func (c *Cube) ClampToDomain() *Cube {
+	lo, hi := c.domain()
+	return c.Map(func(s Sample) Sample {
+		return *s.ClampTo(lo, hi)
+	})
 }
 
 var (
 	ErrEmptyLut            = errors.New("empty LUT")
 	ErrDifferentSampleSize = errors.New("different sample sizes in LUTs")
 	ErrUnrecognisedLine    = errors.New("unrecognised line")
+	ErrInvalidWeights      = errors.New("invalid blend weights")
+	ErrInvalidSize         = errors.New("invalid LUT_3D_SIZE")
+	ErrSampleCount         = errors.New("sample count does not match LUT_3D_SIZE")
+	ErrInvalidDomain       = errors.New("DOMAIN_MAX must be greater than DOMAIN_MIN")
+)
+
+const (
+	// scanBuffer is the initial line buffer of Load.
+	scanBuffer = 64 << 10
+	// maxPrealloc is the largest LUT_3D_SIZE Load reserves room for
+	// ahead of reading the samples, taking under 1 MiB. Larger LUTs
+	// grow as their samples are read, so that a bogus size in a short
+	// file does not reserve more than that.
+	maxPrealloc = 33
 )
 
 func min(a, b float64) float64 {
@@ -166,15 +209,63 @@ func (c *Cube) Sum(c2 Cube) (*Cube, error) {
 		return c, ErrDifferentSampleSize
 	}
 
-	for i := range c.Samples {
-		c.Samples[i].Sum(c2.Samples[i])
-	}
+	parallel(len(c.Samples), c.Workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			c.Samples[i].Sum(c2.Samples[i])
+		}
+	})
 	return c, nil
 }
 
-// Blend does a weighted blend of two LUTs using the two intensities
-// i1 and i2 provided in input.
-func (c *Cube) Blend(c2 Cube, i1, i2 float64) (*Cube, error) {
+// Blend returns the weighted blend of the LUT with other, of any format,
+// using the two intensities i1 and i2 provided in input, which must add
+// up to a positive total. LUTs other than CUBEs of the same size and
+// domain are sampled on the lattice of c first. The LUT is left
+// unchanged.
+func (c Cube) Blend(other lut.LUT, i1, i2 float64) (lut.LUT, error) {
+	c2, ok := other.(Cube)
+	if !ok || c2.LUT3Dsize != c.LUT3Dsize || c2.DomainMin != c.DomainMin || c2.DomainMax != c.DomainMax {
+		c2 = c.Resample(other)
+	}
+	ret := c.Clone()
+	ret.warnings = nil
+	if _, err := ret.blend(c2, i1, i2); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Resample samples l on the lattice of c into a CUBE of the same size,
+// domain and Workers, with the outputs of l scaled to the domain. The
+// points are looked up on c.Workers goroutines, so l.Interpolate must be
+// safe for concurrent use, as those of CUBEs and HALDs are.
+func (c Cube) Resample(l lut.LUT) Cube {
+	n := c.LUT3Dsize
+	lo, hi := c.domain()
+	r := Cube{
+		LUT3Dsize: n,
+		DomainMin: lo,
+		DomainMax: hi,
+		Samples:   make([]Sample, n*n*n),
+		Workers:   c.Workers,
+	}
+	last := float64(n - 1)
+
+	parallel(len(r.Samples), c.Workers, func(from, to int) {
+		for i := from; i < to; i++ {
+			R, G, B := l.Interpolate(float64(i%n)/last, float64(i/n%n)/last, float64(i/(n*n))/last)
+			r.Samples[i] = Sample{
+				R: lo.R + R*(hi.R-lo.R),
+				G: lo.G + G*(hi.G-lo.G),
+				B: lo.B + B*(hi.B-lo.B),
+			}
+		}
+	})
+	return r
+}
+
+// blend does a weighted blend of c2 into c, in place.
+func (c *Cube) blend(c2 Cube, i1, i2 float64) (*Cube, error) {
 	if len(c.Samples) == 0 || len(c2.Samples) == 0 {
 		return c, ErrEmptyLut
 	}
@@ -184,17 +275,76 @@ func (c *Cube) Blend(c2 Cube, i1, i2 float64) (*Cube, error) {
 	}
 
 	total := i1 + i2
+	if total <= 0 {
+		return c, ErrInvalidWeights
+	}
 	w1 := i1 / total
 	w2 := i2 / total
 
-	for i := range c.Samples {
-		c.Samples[i].Blend(c2.Samples[i], w1, w2)
-	}
+	parallel(len(c.Samples), c.Workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			c.Samples[i].Blend(c2.Samples[i], w1, w2)
+		}
+	})
 	return c, nil
 }
 
+// BlendAll does a weighted blend of any number of LUTs, each weighted by
+// the intensity at the same index of weights. The result keeps the
+// header of the first LUT, and the LUTs are left unchanged.
+func BlendAll(luts []Cube, weights []float64) (*Cube, error) {
+	return BlendAllIn(luts, weights, lut.MixRGB)
+}
+
+// BlendAllIn blends the LUTs like BlendAll, averaging their samples in
+// the color space of mix. The samples are spread over the Workers of the
+// first LUT.
+func BlendAllIn(luts []Cube, weights []float64, mix lut.Mix) (*Cube, error) {
+	if len(luts) == 0 {
+		return nil, ErrEmptyLut
+	}
+	if len(weights) != len(luts) {
+		return nil, ErrInvalidWeights
+	}
+
+	var total float64
+	for i, c := range luts {
+		if len(c.Samples) == 0 {
+			return nil, ErrEmptyLut
+		}
+		if len(c.Samples) != len(luts[0].Samples) {
+			return nil, ErrDifferentSampleSize
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return nil, ErrInvalidWeights
+	}
+
+	ret := luts[0]
+	ret.Samples = make([]Sample, len(luts[0].Samples))
+	ret.warnings = nil
+	// Each chunk of samples is summed over every LUT in turn, keeping
+	// the order of the additions, and so the results, of a serial loop.
+	parallel(len(ret.Samples), ret.Workers, func(lo, hi int) {
+		for i, c := range luts {
+			w := weights[i] / total
+			for j := lo; j < hi; j++ {
+				s := c.Samples[j]
+				s.R, s.G, s.B = mix.To(s.R, s.G, s.B)
+				ret.Samples[j].Sum(*s.Scale(w))
+			}
+		}
+		for j := lo; j < hi; j++ {
+			s := &ret.Samples[j]
+			s.R, s.G, s.B = mix.From(s.R, s.G, s.B)
+		}
+	})
+	return &ret, nil
+}
+
 func (c *Cube) MustBlend(c2 Cube, i1, i2 float64) *Cube {
-	ret, err := c.Blend(c2, i1, i2)
+	ret, err := c.blend(c2, i1, i2)
 	if err != nil {
 		panic(err)
 	}
@@ -216,31 +366,48 @@ func (c Cube) minmax() (minVal, maxVal float64) {
 
 	minVal = c.Samples[0].R
 	maxVal = c.Samples[0].R
-	for _, s := range c.Samples {
-		minVal = min(minVal, min(min(s.R, s.G), s.B))
-		maxVal = max(maxVal, max(max(s.R, s.G), s.B))
-	}
+	var mu sync.Mutex
+	parallel(len(c.Samples), c.Workers, func(lo, hi int) {
+		lmin, lmax := c.Samples[lo].R, c.Samples[lo].R
+		for _, s := range c.Samples[lo:hi] {
+			lmin = min(lmin, min(min(s.R, s.G), s.B))
+			lmax = max(lmax, max(max(s.R, s.G), s.B))
+		}
+		mu.Lock()
+		minVal, maxVal = min(minVal, lmin), max(maxVal, lmax)
+		mu.Unlock()
+	})
 	return
 }
 
+// Rescale maps the samples linearly from the range of their values to
+// the domain of the LUT, in place.
 func (c *Cube) Rescale() *Cube {
 	minVal, maxVal := c.minmax()
+	lo, hi := c.domain()
 
-	for i := range c.Samples {
-		c.Samples[i].Rescale(minVal, maxVal, c.DomainMin, c.DomainMax)
-	}
+	parallel(len(c.Samples), c.Workers, func(from, to int) {
+		for i := from; i < to; i++ {
+			c.Samples[i].Rescale(minVal, maxVal, lo, hi)
+		}
+	})
 
 	return c
 }
 
-// interpolate performs trilinear interpolation in the 3D LUT
-func (c Cube) interpolate(r, g, b float64) Sample {
+// At looks up the color (r, g, b), given in the domain of the LUT, by
+// trilinear interpolation in the 3D LUT and returns its output as the
+// samples hold it. Unlike Interpolate it neither normalises the color
+// nor applies the video range flags: it is the lookup of the table as
+// written in the file, for transforming single colors or palettes.
+func (c Cube) At(r, g, b float64) (float64, float64, float64) {
 	size := float64(c.LUT3Dsize - 1)
+	lo, hi := c.domain()
 
 	// Normalize input to cube coordinates [0, size]
-	rIdx := (r - c.DomainMin.R) / (c.DomainMax.R - c.DomainMin.R) * size
-	gIdx := (g - c.DomainMin.G) / (c.DomainMax.G - c.DomainMin.G) * size
-	bIdx := (b - c.DomainMin.B) / (c.DomainMax.B - c.DomainMin.B) * size
+	rIdx := (r - lo.R) / (hi.R - lo.R) * size
+	gIdx := (g - lo.G) / (hi.G - lo.G) * size
+	bIdx := (b - lo.B) / (hi.B - lo.B) * size
 
 	// Clamp to valid range
 	rIdx = max(0, min(size, rIdx))
@@ -283,13 +450,14 @@ func (c Cube) interpolate(r, g, b float64) Sample {
 	c1 := interpolateSample(c01, c11, gFrac)
 
 	// Finally interpolate along b
-	return interpolateSample(c0, c1, bFrac)
+	s := interpolateSample(c0, c1, bFrac)
+	return s.R, s.G, s.B
 }
 
 // getSample retrieves a sample from the 3D LUT at the given indices
 func (c Cube) getSample(r, g, b int) Sample {
 	idx := r + g*c.LUT3Dsize + b*c.LUT3Dsize*c.LUT3Dsize
-	if idx >= len(c.Samples) {
+	if idx < 0 || idx >= len(c.Samples) {
 		return Sample{R: 0, G: 0, B: 0}
 	}
 	return c.Samples[idx]
@@ -304,96 +472,121 @@ func interpolateSample(s1, s2 Sample, t float64) Sample {
 	}
 }
 
-func (c Cube) Apply(img image.Image) *image.RGBA {
-	return c.ApplyScaled(img, 1.0)
+// lattice exposes a Cube as a lut.Lattice with outputs normalised
+// from the LUT domain to [0, 1].
+type lattice struct {
+	Cube
+}
+
+func (l lattice) Size() int {
+	return l.LUT3Dsize
+}
+
+func (l lattice) Point(r, g, b int) (float64, float64, float64) {
+	s := l.getSample(r, g, b)
+	lo, hi := l.domain()
+	return (s.R - lo.R) / (hi.R - lo.R),
+		(s.G - lo.G) / (hi.G - lo.G),
+		(s.B - lo.B) / (hi.B - lo.B)
+}
+
+// Domain returns the input range of the LUT.
+func (l lattice) Domain() (lo, hi [3]float64) {
+	from, to := l.domain()
+	return [3]float64{from.R, from.G, from.B}, [3]float64{to.R, to.G, to.B}
+}
+
+// VideoRange reports whether the LUT takes its input and returns its
+// output in video range.
+func (l lattice) VideoRange() (in, out bool) {
+	return l.InVideoRange, l.OutVideoRange
 }
 
+// Size returns the number of grid points per axis.
+func (c Cube) Size() int {
+	return c.LUT3Dsize
+}
+
+// Interpolate looks up the normalised color (r, g, b) in the LUT,
+// returning its output normalised from the domain to [0, 1].
+func (c Cube) Interpolate(r, g, b float64) (float64, float64, float64) {
+	return lut.Interpolate(lattice{c}, r, g, b)
+}
+
+// Compile prepares the LUT to be applied to images with the given options.
+func (c Cube) Compile(opt lut.Options) *lut.Applier {
+	return lut.Compile(lattice{c}, opt)
+}
+
+// Apply applies the LUT to img with the options set by opts, at full
+// intensity with an 8-bit *image.RGBA result by default:
+//
+//	c.Apply(img, lut.WithIntensity(0.7), lut.WithOutputDepth(16))
+func (c Cube) Apply(img image.Image, opts ...lut.ApplyOption) image.Image {
+	return lut.Apply(lattice{c}, img, opts...)
+}
+
+// ApplyScaled applies the LUT to img with the given intensity.
+//
+// Deprecated: Use Apply with lut.WithIntensity.
 func (c Cube) ApplyScaled(img image.Image, intensity float64) *image.RGBA {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-
-	// Clamp intensity to [0, 1]
-	intensity = max(0, min(1, intensity))
-
-	// Pre-compute domain ranges to avoid recalculation
-	domainRangeR := c.DomainMax.R - c.DomainMin.R
-	domainRangeG := c.DomainMax.G - c.DomainMin.G
-	domainRangeB := c.DomainMax.B - c.DomainMin.B
-
-	var wg sync.WaitGroup
-
-	// Process each row in parallel
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		wg.Go(func() {
-			c.processRowScaled(
-				img,
-				out,
-				bounds,
-				y,
-				domainRangeR,
-				domainRangeG,
-				domainRangeB,
-				intensity,
-			)
-		})
-	}
-
-	wg.Wait()
-	return out
-}
-
-// processRowScaled processes a single row of the image with intensity blending
-func (c Cube) processRowScaled(img image.Image, out *image.RGBA, bounds image.Rectangle, y int, domainRangeR, domainRangeG, domainRangeB, intensity float64) {
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-		r, g, b, a := img.At(x, y).RGBA()
-
-		// Convert from uint32 (0-65535) to float64 (0-1)
-		rNorm := float64(r) / 65535.0
-		gNorm := float64(g) / 65535.0
-		bNorm := float64(b) / 65535.0
-
-		// Map to LUT domain
-		rLut := c.DomainMin.R + rNorm*domainRangeR
-		gLut := c.DomainMin.G + gNorm*domainRangeG
-		bLut := c.DomainMin.B + bNorm*domainRangeB
-
-		// Apply LUT using trilinear interpolation
-		result := c.interpolate(rLut, gLut, bLut)
-
-		// Blend between original (identity) and LUT result
-		// Identity in LUT domain space is just the input color
-		blendedR := rLut*(1-intensity) + result.R*intensity
-		blendedG := gLut*(1-intensity) + result.G*intensity
-		blendedB := bLut*(1-intensity) + result.B*intensity
-
-		// Map back from LUT domain to [0, 1]
-		rOut := (blendedR - c.DomainMin.R) / domainRangeR
-		gOut := (blendedG - c.DomainMin.G) / domainRangeG
-		bOut := (blendedB - c.DomainMin.B) / domainRangeB
-
-		// Clamp to [0, 1]
-		rOut = max(0, min(1, rOut))
-		gOut = max(0, min(1, gOut))
-		bOut = max(0, min(1, bOut))
-
-		// Convert back to uint8
-		out.SetRGBA(x, y, color.RGBA{
-			R: uint8(rOut * 255),
-			G: uint8(gOut * 255),
-			B: uint8(bOut * 255),
-			A: uint8(a / 257), // Convert from uint32 to uint8
-		})
-	}
+	return c.Compile(lut.Options{Intensity: intensity}).Apply(img)
+}
+
+// ApplyContext applies the LUT to img with the given options, returning
+// early with the context error once ctx is done.
+func (c Cube) ApplyContext(ctx context.Context, img image.Image, opt lut.Options) (*image.RGBA, error) {
+	return c.Compile(opt).ApplyContext(ctx, img)
+}
+
+// ApplyInto applies the LUT to img with the given options, writing the
+// result to dst instead of allocating a new image. See
+// lut.Applier.ApplyInto; frames sharing the options are best graded
+// with a single Compile.
+func (c Cube) ApplyInto(dst *image.RGBA, img image.Image, opt lut.Options) error {
+	return c.Compile(opt).ApplyInto(dst, img)
 }
 
+// Apply16 applies the LUT to img with full intensity, keeping 16 bits
+// per channel from the input samples to the output.
+//
+// Deprecated: Use Apply with lut.WithOutputDepth(16).
+func (c Cube) Apply16(img image.Image) *image.RGBA64 {
+	return c.Compile(lut.Options{Intensity: 1}).Apply16(img)
+}
+
+// Load reads a CUBE LUT in text form from r, within DefaultLimits.
 func Load(r io.Reader) (Cube, error) {
+	return LoadLimits(r, DefaultLimits)
+}
+
+// LoadLimits reads a CUBE LUT in text form from r, failing with an error
+// wrapping lut.ErrLimit on input beyond lim, as from untrusted sources.
+func LoadLimits(r io.Reader, lim Limits) (Cube, error) {
 	var (
 		c       = Cube{DomainMax: Sample{1, 1, 1}}
 		scanner = bufio.NewScanner(r)
+		lineNum int
+		seen    = make(map[string]bool)
 	)
+	maxLine := lim.MaxLine
+	if maxLine <= 0 {
+		maxLine = math.MaxInt
+	}
+	buf := scanBuffer
+	if maxLine < buf {
+		buf = maxLine
+	}
+	scanner.Buffer(make([]byte, buf), maxLine)
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		lineNum++
+		line := scanner.Text()
+		if lineNum == 1 {
+			// Windows tools often start UTF-8 files with a BOM.
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		line = strings.TrimSpace(line)
 
 		// Skip empty lines
 		if line == "" {
@@ -405,42 +598,81 @@ func Load(r io.Reader) (Cube, error) {
 		if len(fields) == 0 {
 			continue
 		}
+		if i := strings.IndexByte(line, '#'); i > 0 && strings.ToUpper(fields[0]) != "TITLE" {
+			// Trailing comment, as some exporters write after samples.
+			line = strings.TrimSpace(line[:i])
+			fields = strings.Fields(line)
+		}
+		// Keywords match in any case. Sample lines, which start with a
+		// digit, a sign or a dot, are left as they are.
+		field := fields[0]
+		if ch := field[0]; ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' {
+			field = strings.ToUpper(field)
+		}
+
+		switch field {
+		case "TITLE", "LUT_3D_SIZE", "DOMAIN_MIN", "DOMAIN_MAX", "LUT_3D_INPUT_RANGE", "LUT_IN_VIDEO_RANGE", "LUT_OUT_VIDEO_RANGE":
+			if seen[field] {
+				c.warn(lineNum, "duplicate %s, overriding the previous one", field)
+			}
+			if len(c.Samples) > 0 {
+				c.warn(lineNum, "%s after the samples", field)
+			}
+			seen[field] = true
+		}
 
-		switch field := fields[0]; {
+		switch {
 		case field == "TITLE":
 			// Extract quoted title
-			if start := strings.Index(line, "\""); start != -1 {
-				if end := strings.LastIndex(line, "\""); end > start {
-					c.Title = line[start+1 : end]
-				}
+			start := strings.Index(line, "\"")
+			end := strings.LastIndex(line, "\"")
+			if start != -1 && end > start {
+				c.Title = line[start+1 : end]
+			} else {
+				c.Title = strings.Trim(strings.TrimSpace(line[len(fields[0]):]), "\"")
+				c.warn(lineNum, "TITLE not in double quotes")
 			}
 
 		case field == "LUT_3D_SIZE":
-			if _, err := fmt.Sscanf(line, "LUT_3D_SIZE %d", &c.LUT3Dsize); err != nil {
+			if err := parseInt(fields[1:], &c.LUT3Dsize); err != nil {
 				return Cube{}, err
 			}
+			if lim.MaxSize > 0 && c.LUT3Dsize > lim.MaxSize {
+				return Cube{}, fmt.Errorf("%w: LUT_3D_SIZE %d, at most %d", lut.ErrLimit, c.LUT3Dsize, lim.MaxSize)
+			}
+			if n := c.LUT3Dsize; len(c.Samples) == 0 && n > 0 {
+				if n > maxPrealloc {
+					n = maxPrealloc
+				}
+				c.Samples = make([]Sample, 0, n*n*n)
+			}
 
 		case field == "DOMAIN_MIN":
-			if _, err := fmt.Sscanf(
-				line,
-				"DOMAIN_MIN %f %f %f",
-				&c.DomainMin.R,
-				&c.DomainMin.G,
-				&c.DomainMin.B,
-			); err != nil {
+			if err := parseFloats(fields[1:], &c.DomainMin.R, &c.DomainMin.G, &c.DomainMin.B); err != nil {
 				return Cube{}, err
 			}
+			c.InputRange = false
 
 		case field == "DOMAIN_MAX":
-			if _, err := fmt.Sscanf(
-				line,
-				"DOMAIN_MAX %f %f %f",
-				&c.DomainMax.R,
-				&c.DomainMax.G,
-				&c.DomainMax.B,
-			); err != nil {
+			if err := parseFloats(fields[1:], &c.DomainMax.R, &c.DomainMax.G, &c.DomainMax.B); err != nil {
 				return Cube{}, err
 			}
+			c.InputRange = false
+
+		case field == "LUT_3D_INPUT_RANGE":
+			var lo, hi float64
+			if err := parseFloats(fields[1:], &lo, &hi); err != nil {
+				return Cube{}, err
+			}
+			c.DomainMin = Sample{lo, lo, lo}
+			c.DomainMax = Sample{hi, hi, hi}
+			c.InputRange = true
+
+		case field == "LUT_IN_VIDEO_RANGE":
+			c.InVideoRange = true
+
+		case field == "LUT_OUT_VIDEO_RANGE":
+			c.OutVideoRange = true
 
 		// Metadata lines (starting with #)
 		case strings.HasPrefix(line, "#"):
@@ -449,11 +681,17 @@ func Load(r io.Reader) (Cube, error) {
 			}
 			c.Meta += line
 
+		case isKeyword(field) && !isNumber(fields[0]):
+			c.warn(lineNum, "unknown keyword %s skipped", field)
+
 		case len(fields) == 3:
 			var s Sample
-			if _, err := fmt.Sscanf(line, "%f %f %f", &s.R, &s.G, &s.B); err != nil {
+			if err := parseFloats(fields, &s.R, &s.G, &s.B); err != nil {
 				return Cube{}, err
 			}
+			if lim.MaxSamples > 0 && len(c.Samples) == lim.MaxSamples {
+				return Cube{}, fmt.Errorf("%w: more than %d samples", lut.ErrLimit, lim.MaxSamples)
+			}
 			c.Samples = append(c.Samples, s)
 
 		default:
@@ -461,13 +699,122 @@ func Load(r io.Reader) (Cube, error) {
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return c, err
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return Cube{}, fmt.Errorf("%w: line %d longer than %d bytes", lut.ErrLimit, lineNum+1, maxLine)
+	} else if err != nil {
+		return Cube{}, err
+	}
+	if err := c.checkSize(); err != nil {
+		return Cube{}, err
+	}
+	if err := c.checkDomain(); err != nil {
+		return Cube{}, err
+	}
+
+	if n := c.outOfDomain(); n > 0 {
+		c.warn(0, "%d samples outside the domain", n)
 	}
 
 	return c, nil
 }
 
+// checkSize returns an error unless the samples fill a lattice of at
+// least 2 points per axis, as truncated files do not.
+func (c Cube) checkSize() error {
+	n := c.LUT3Dsize
+	if n < 2 {
+		return fmt.Errorf("%w: %d, must be at least 2", ErrInvalidSize, n)
+	}
+	if want := n * n * n; len(c.Samples) != want {
+		return fmt.Errorf("%w: %d samples, size %d needs %d", ErrSampleCount, len(c.Samples), n, want)
+	}
+	return nil
+}
+
+// checkDomain returns an error unless DOMAIN_MAX is greater than
+// DOMAIN_MIN on every channel.
+func (c Cube) checkDomain() error {
+	lo, hi := c.DomainMin, c.DomainMax
+	if !(hi.R > lo.R && hi.G > lo.G && hi.B > lo.B) {
+		return fmt.Errorf("%w: DOMAIN_MIN %v, DOMAIN_MAX %v", ErrInvalidDomain, lo, hi)
+	}
+	return nil
+}
+
+// domain returns the domain of the LUT, falling back to [0, 1] on the
+// channels where it is empty or inverted, as in a Cube built without
+// one, so that lookups never divide by zero.
+func (c Cube) domain() (lo, hi Sample) {
+	lo, hi = c.DomainMin, c.DomainMax
+	for _, ch := range []struct{ lo, hi *float64 }{{&lo.R, &hi.R}, {&lo.G, &hi.G}, {&lo.B, &hi.B}} {
+		if !(*ch.hi > *ch.lo) {
+			*ch.lo, *ch.hi = 0, 1
+		}
+	}
+	return lo, hi
+}
+
+// parseFloats parses the leading fields into vals, ignoring the fields
+// left over.
+func parseFloats(fields []string, vals ...*float64) error {
+	if len(fields) < len(vals) {
+		return fmt.Errorf("%w: %d values, expected %d", ErrUnrecognisedLine, len(fields), len(vals))
+	}
+	for i, v := range vals {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return err
+		}
+		*v = f
+	}
+	return nil
+}
+
+// parseInt parses the first field into v.
+func parseInt(fields []string, v *int) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: missing value", ErrUnrecognisedLine)
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return err
+	}
+	*v = n
+	return nil
+}
+
+// isNumber reports whether s parses as a number, as the NaN and Inf of
+// samples do while looking like keywords.
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// isKeyword reports whether s looks like a CUBE keyword, that is an
+// upper case identifier such as LUT_1D_SIZE.
+func isKeyword(s string) bool {
+	for i, r := range s {
+		switch {
+		case r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// outOfDomain returns the number of samples lying outside the domain.
+func (c Cube) outOfDomain() (n int) {
+	for _, s := range c.Samples {
+		if s.R < c.DomainMin.R || s.G < c.DomainMin.G || s.B < c.DomainMin.B ||
+			s.R > c.DomainMax.R || s.G > c.DomainMax.G || s.B > c.DomainMax.B {
+			n++
+		}
+	}
+	return
+}
+
 func LoadFile(path string) (Cube, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -477,3 +824,45 @@ func LoadFile(path string) (Cube, error) {
 
 	return Load(f)
 }
+
+// LoadFS reads a CUBE LUT from the file at path in fsys, such as LUTs
+// bundled with an embed.FS.
+func LoadFS(fsys fs.FS, path string) (Cube, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return Cube{}, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// FromLUT samples the LUT l, such as a hald.HALD, into a CUBE LUT with
+// size points per axis over the [0, 1] domain, looking the points up as
+// l.Interpolate does. Outputs are stored unclamped. It takes any lut.LUT
+// because package hald imports cube, for hald.FromCube, and cube cannot
+// import hald back: HALD.ToCube calls it for HALDs.
+func FromLUT(l lut.LUT, size int) Cube {
+	return FromTransform(l.Interpolate, size)
+}
+
+// FromTransform bakes the transform t into a CUBE LUT with size points
+// per axis over the [0, 1] domain. Outputs are stored unclamped.
+func FromTransform(t lut.Transform, size int) Cube {
+	c := Cube{
+		LUT3Dsize: size,
+		DomainMax: Sample{1, 1, 1},
+		Samples:   make([]Sample, size*size*size),
+	}
+	last := float64(size - 1)
+
+	for b := range size {
+		for g := range size {
+			for r := range size {
+				s := &c.Samples[r+g*size+b*size*size]
+				s.R, s.G, s.B = t(float64(r)/last, float64(g)/last, float64(b)/last)
+			}
+		}
+	}
+	return c
+}
@@ -0,0 +1,771 @@
+package cube
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/NicoNex/prism/lut"
+)
+
+func TestLoadWarnings(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       []lut.Warning
+	}{
+		{
+			"conforming",
+			`TITLE "Clean"
+# comment
+LUT_3D_SIZE 2
+DOMAIN_MIN 0 0 0
+DOMAIN_MAX 1 1 1
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`,
+			nil,
+		},
+		{
+			"unknown keyword",
+			`LUT_3D_SIZE 2
+LUT_1D_SIZE 4
+LUT_3D_SHAPER x
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`,
+			[]lut.Warning{{Line: 2, Message: "unknown keyword LUT_1D_SIZE skipped"}, {Line: 3, Message: "unknown keyword LUT_3D_SHAPER skipped"}},
+		},
+		{
+			"duplicate keyword",
+			`LUT_3D_SIZE 3
+LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`,
+			[]lut.Warning{{Line: 2, Message: "duplicate LUT_3D_SIZE, overriding the previous one"}},
+		},
+		{
+			"keyword after the samples",
+			`LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+TITLE "Late"
+`,
+			[]lut.Warning{{Line: 10, Message: "TITLE after the samples"}},
+		},
+		{
+			"unquoted title",
+			`TITLE Bare title
+LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`,
+			[]lut.Warning{{Line: 1, Message: "TITLE not in double quotes"}},
+		},
+		{
+			"samples outside the domain",
+			`LUT_3D_SIZE 2
+0 0 0
+1.2 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 -0.1
+`,
+			[]lut.Warning{{Line: 0, Message: "2 samples outside the domain"}},
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := Load(strings.NewReader(tt.text))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := c.Warnings(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got warnings %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	lenient, err := Load(strings.NewReader(`TITLE Bare
+LUT_3D_SIZE 2
+LUT_1D_SIZE 4
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lenient.Title != "Bare" {
+		t.Errorf("got title %q, want Bare", lenient.Title)
+	}
+
+	var strictErr *lut.StrictError
+	if err := lut.Strict(lenient); !errors.As(err, &strictErr) || len(strictErr.Warnings) != 2 {
+		t.Fatalf("got %v, want a *lut.StrictError with 2 warnings", err)
+	}
+	if got, want := strictErr.Error(), "LUT deviates from its specification: line 1: TITLE not in double quotes (and 1 more)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	conforming, err := Load(strings.NewReader(`LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lut.Strict(conforming); err != nil {
+		t.Errorf("conforming LUT: got %v", err)
+	}
+}
+
+func TestLoadSampleCount(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       error
+	}{
+		{
+			"truncated",
+			`LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+`,
+			ErrSampleCount,
+		},
+		{
+			"extra samples",
+			`LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+1 1 1
+`,
+			ErrSampleCount,
+		},
+		{
+			"no samples",
+			`LUT_3D_SIZE 33
+`,
+			ErrSampleCount,
+		},
+		{
+			"size 1",
+			`LUT_3D_SIZE 1
+0.5 0.5 0.5
+`,
+			ErrInvalidSize,
+		},
+		{
+			"negative size",
+			`LUT_3D_SIZE -2
+`,
+			ErrInvalidSize,
+		},
+		{
+			"missing size",
+			`0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`,
+			ErrInvalidSize,
+		},
+		{
+			"empty",
+			``,
+			ErrInvalidSize,
+		},
+	}
+
+	for _, tt := range tests {
+		c, err := Load(strings.NewReader(tt.text))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if !reflect.DeepEqual(c, Cube{}) {
+			t.Errorf("%s: got a LUT of %d samples on error", tt.name, len(c.Samples))
+		}
+	}
+
+	_, err := Load(strings.NewReader(`LUT_3D_SIZE 2
+0 0 0
+1 1 1
+`))
+	if want := "sample count does not match LUT_3D_SIZE: 2 samples, size 2 needs 8"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestLoadDefaultDomain(t *testing.T) {
+	c, err := Load(strings.NewReader(`LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DomainMin != (Sample{0, 0, 0}) || c.DomainMax != (Sample{1, 1, 1}) {
+		t.Errorf("got domain %v to %v, want [0, 1]", c.DomainMin, c.DomainMax)
+	}
+	if r, g, b := c.At(0.25, 0.5, 0.75); r != 0.25 || g != 0.5 || b != 0.75 {
+		t.Errorf("got (%v, %v, %v), want (0.25, 0.5, 0.75)", r, g, b)
+	}
+}
+
+func TestLoadInvalidDomain(t *testing.T) {
+	tests := []struct {
+		name, domain string
+	}{
+		{"empty", "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 0 0 0"},
+		{"empty channel", "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 1 0 1"},
+		{"inverted", "DOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0"},
+		{"inverted channel", "DOMAIN_MIN 0 0 0.5\nDOMAIN_MAX 1 1 0.25"},
+		{"empty input range", "LUT_3D_INPUT_RANGE 0.5 0.5"},
+		{"maximum alone below 0", "DOMAIN_MAX -1 1 1"},
+	}
+
+	for _, tt := range tests {
+		text := "LUT_3D_SIZE 2\n" + tt.domain + `
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`
+		if _, err := Load(strings.NewReader(text)); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidDomain)
+		}
+	}
+}
+
+func TestEmptyDomainLookup(t *testing.T) {
+	// Cubes built in code may have no domain: lookups treat it as [0, 1]
+	// rather than dividing by zero.
+	c := Cube{
+		LUT3Dsize: 2,
+		Samples: []Sample{
+			{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0},
+			{0, 0, 1}, {1, 0, 1}, {0, 1, 1}, {1, 1, 1},
+		},
+	}
+	if r, g, b := c.At(0.25, 0.5, 0.75); r != 0.25 || g != 0.5 || b != 0.75 {
+		t.Errorf("At: got (%v, %v, %v), want (0.25, 0.5, 0.75)", r, g, b)
+	}
+	if r, g, b := c.Interpolate(0.25, 0.5, 0.75); r != 0.25 || g != 0.5 || b != 0.75 {
+		t.Errorf("Interpolate: got (%v, %v, %v), want (0.25, 0.5, 0.75)", r, g, b)
+	}
+	for _, opt := range []lut.Options{{Intensity: 1}, {Intensity: 1, Extended: true}, {Intensity: 0.5, Depth: lut.DepthFloat}} {
+		if r, g, b := c.Compile(opt).Interpolate(0.25, 0.5, 0.75); r != 0.25 || g != 0.5 || b != 0.75 {
+			t.Errorf("%+v: got (%v, %v, %v), want (0.25, 0.5, 0.75)", opt, r, g, b)
+		}
+	}
+}
+
+func TestLoadInputRange(t *testing.T) {
+	text := `LUT_3D_SIZE 2
+LUT_3D_INPUT_RANGE -0.25 1.25
+-0.25 -0.25 -0.25
+1.25 -0.25 -0.25
+-0.25 1.25 -0.25
+1.25 1.25 -0.25
+-0.25 -0.25 1.25
+1.25 -0.25 1.25
+-0.25 1.25 1.25
+1.25 1.25 1.25
+`
+	c, err := Load(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.InputRange || c.DomainMin != (Sample{-0.25, -0.25, -0.25}) || c.DomainMax != (Sample{1.25, 1.25, 1.25}) {
+		t.Fatalf("got domain %v to %v, input range %t", c.DomainMin, c.DomainMax, c.InputRange)
+	}
+	if n := len(c.Warnings()); n != 0 {
+		t.Errorf("got warnings %v", c.Warnings())
+	}
+
+	// Inputs scale from the range to the lattice, and outputs back to
+	// [0, 1] for Interpolate.
+	if r, g, b := c.At(-0.25, 0.5, 1.25); r != -0.25 || g != 0.5 || b != 1.25 {
+		t.Errorf("At: got (%v, %v, %v), want (-0.25, 0.5, 1.25)", r, g, b)
+	}
+	if r, g, b := c.Interpolate(0, 0.5, 1); r != 0 || g != 0.5 || b != 1 {
+		t.Errorf("Interpolate: got (%v, %v, %v), want (0, 0.5, 1)", r, g, b)
+	}
+
+	// The range is written back in the same form.
+	var out strings.Builder
+	if _, err := c.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\nLUT_3D_INPUT_RANGE -0.250000 1.250000\n") || strings.Contains(out.String(), "DOMAIN_") {
+		t.Errorf("got %q, want a LUT_3D_INPUT_RANGE line", out.String())
+	}
+	back, err := Load(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, c) {
+		t.Errorf("got %+v, want %+v", back, c)
+	}
+
+	// DOMAIN_MIN and DOMAIN_MAX after it override the range.
+	c, err = Load(strings.NewReader(strings.Replace(text, "-0.25 1.25\n", "-0.25 1.25\nDOMAIN_MAX 1.25 1.25 2\n", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.InputRange || c.DomainMax != (Sample{1.25, 1.25, 2}) {
+		t.Errorf("got domain max %v, input range %t", c.DomainMax, c.InputRange)
+	}
+}
+
+func TestLoadVideoRange(t *testing.T) {
+	const black, white = 16.0 / 255, 235.0 / 255
+	identity := `
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`
+	tests := []struct {
+		name, header string
+		in, out      bool
+		want         [3]float64 // Interpolate(0, 0.5, 1)
+	}{
+		{"full range", "", false, false, [3]float64{0, 0.5, 1}},
+		{"video input", "LUT_IN_VIDEO_RANGE\n", true, false, [3]float64{black, (black + white) / 2, white}},
+		{"video output", "LUT_OUT_VIDEO_RANGE\n", false, true, [3]float64{-black / (white - black), (0.5 - black) / (white - black), (1 - black) / (white - black)}},
+		{"video input and output", "LUT_IN_VIDEO_RANGE\nLUT_OUT_VIDEO_RANGE\n", true, true, [3]float64{0, 0.5, 1}},
+	}
+
+	for _, tt := range tests {
+		c, err := Load(strings.NewReader(tt.header + "LUT_3D_SIZE 2" + identity))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if c.InVideoRange != tt.in || c.OutVideoRange != tt.out {
+			t.Errorf("%s: got video range %t, %t, want %t, %t", tt.name, c.InVideoRange, c.OutVideoRange, tt.in, tt.out)
+		}
+		r, g, b := c.Interpolate(0, 0.5, 1)
+		for i, v := range [3]float64{r, g, b} {
+			if math.Abs(v-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: got (%v, %v, %v), want %v", tt.name, r, g, b, tt.want)
+				break
+			}
+		}
+
+		var out strings.Builder
+		if _, err := c.WriteTo(&out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(out.String(), tt.header+"LUT_3D_SIZE 2\n") {
+			t.Errorf("%s: got %q, want the video range keywords first", tt.name, out.String())
+		}
+	}
+}
+
+func TestLoadBOM(t *testing.T) {
+	c, err := Load(strings.NewReader("\ufeffTITLE \"BOM\"\nLUT_3D_SIZE 2\n0 0 0\n1 0 0\n0 1 0\n1 1 0\n0 0 1\n1 0 1\n0 1 1\n1 1 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "BOM" || len(c.Warnings()) != 0 {
+		t.Errorf("got title %q, warnings %v", c.Title, c.Warnings())
+	}
+
+	// A BOM starting the samples.
+	c, err = Load(strings.NewReader("\ufeff0 0 0\n1 0 0\n0 1 0\n1 1 0\n0 0 1\n1 0 1\n0 1 1\n1 1 1\nLUT_3D_SIZE 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Samples[0] != (Sample{}) {
+		t.Errorf("got first sample %v, want {0 0 0}", c.Samples[0])
+	}
+
+	// Only the first line may start with one.
+	if _, err := Load(strings.NewReader("LUT_3D_SIZE 2\n\ufeff0 0 0\n")); !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("BOM on line 2: got %v, want %v", err, strconv.ErrSyntax)
+	}
+}
+
+func TestLoadCRLF(t *testing.T) {
+	text := "TITLE \"Windows\"\r\n# comment\r\nLUT_3D_SIZE 2\r\nDOMAIN_MIN 0 0 0\r\nDOMAIN_MAX 1 1 1\r\n\r\n" +
+		"0 0 0\r\n1 0 0\r\n0 1 0\r\n1 1 0\r\n0 0 1\r\n1 0 1\r\n0 1 1\r\n1 1 0.5\r\n"
+	c, err := Load(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Windows" || c.Meta != "# comment" || c.LUT3Dsize != 2 || len(c.Warnings()) != 0 {
+		t.Errorf("got title %q, meta %q, size %d, warnings %v", c.Title, c.Meta, c.LUT3Dsize, c.Warnings())
+	}
+	if s := c.Samples[7]; s != (Sample{1, 1, 0.5}) {
+		t.Errorf("got last sample %v, want {1 1 0.5}", s)
+	}
+}
+
+func TestLoadTabs(t *testing.T) {
+	text := "TITLE\t\"Tabs\"\nLUT_3D_SIZE\t2\nDOMAIN_MIN\t0\t0\t0\nDOMAIN_MAX 1\t1  1\n" +
+		"0\t0\t0\n1\t0\t0\n\t0 1\t0\n1\t1\t0\t\n0 \t0\t1\n1\t0\t1\n0\t1\t1\n1\t1\t1\n"
+	c, err := Load(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Tabs" || c.LUT3Dsize != 2 || c.DomainMax != (Sample{1, 1, 1}) || len(c.Warnings()) != 0 {
+		t.Errorf("got title %q, size %d, domain max %v, warnings %v", c.Title, c.LUT3Dsize, c.DomainMax, c.Warnings())
+	}
+	if s := c.Samples[2]; s != (Sample{0, 1, 0}) {
+		t.Errorf("got sample %v, want {0 1 0}", s)
+	}
+}
+
+func TestLoadKeywordCase(t *testing.T) {
+	c, err := Load(strings.NewReader(`title "Lower"
+lut_3d_size 2
+Domain_Min -0.5 -0.5 -0.5
+domain_max 1 1 1
+lut_in_video_range
+Lut_Out_Video_Range
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Lower" || c.LUT3Dsize != 2 || c.DomainMin != (Sample{-0.5, -0.5, -0.5}) || !c.InVideoRange || !c.OutVideoRange {
+		t.Errorf("got %+v", c)
+	}
+	if len(c.Warnings()) != 0 {
+		t.Errorf("got warnings %v", c.Warnings())
+	}
+
+	c, err = Load(strings.NewReader(`lut_3d_input_range 0 2
+LUT_3D_Size 2
+0 0 0
+2 0 0
+0 2 0
+2 2 0
+0 0 2
+2 0 2
+0 2 2
+2 2 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.InputRange || c.DomainMax != (Sample{2, 2, 2}) {
+		t.Errorf("got domain max %v, input range %t", c.DomainMax, c.InputRange)
+	}
+
+	// Duplicates are told regardless of case, and unknown keywords are
+	// reported in upper case.
+	c, err = Load(strings.NewReader(`LUT_3D_SIZE 3
+lut_3d_size 2
+lut_1d_size 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lut.Warning{
+		{Line: 2, Message: "duplicate LUT_3D_SIZE, overriding the previous one"},
+		{Line: 3, Message: "unknown keyword LUT_1D_SIZE skipped"},
+	}
+	if !reflect.DeepEqual(c.Warnings(), want) {
+		t.Errorf("got warnings %v, want %v", c.Warnings(), want)
+	}
+}
+
+func TestLoadTrailingComments(t *testing.T) {
+	c, err := Load(strings.NewReader(`TITLE "Look #2" # exported by a tool
+# header comment
+LUT_3D_SIZE 2 # points per axis
+DOMAIN_MIN 0 0 0 #black
+0 0 0 # black
+1 0 0	# red
+0 1 0#green
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+0.5 0.5 0.5 # midpoint
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Look #2" {
+		t.Errorf("got title %q, want %q", c.Title, "Look #2")
+	}
+	if c.Meta != "# header comment" {
+		t.Errorf("got meta %q, want the comment lines only", c.Meta)
+	}
+	if len(c.Warnings()) != 0 {
+		t.Errorf("got warnings %v", c.Warnings())
+	}
+	want := []Sample{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {1, 1, 0}, {0, 0, 1}, {1, 0, 1}, {0, 1, 1}, {0.5, 0.5, 0.5}}
+	if !reflect.DeepEqual(c.Samples, want) {
+		t.Errorf("got samples %v, want %v", c.Samples, want)
+	}
+
+	// A comment does not make up for missing values.
+	if _, err := Load(strings.NewReader("LUT_3D_SIZE 2\n0 0 # 0\n")); !errors.Is(err, ErrUnrecognisedLine) {
+		t.Errorf("sample of two values: got %v, want %v", err, ErrUnrecognisedLine)
+	}
+}
+
+func TestLoadScientificNotation(t *testing.T) {
+	c, err := Load(strings.NewReader(`LUT_3D_SIZE 2
+DOMAIN_MAX 1e0 1.0E+00 10e-1
+0.0e0 -0.0 1.0e-03
+1E0 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+5e-1 .25 +.75
+1 1 1.
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DomainMax != (Sample{1, 1, 1}) {
+		t.Errorf("got domain max %v, want {1 1 1}", c.DomainMax)
+	}
+	for i, want := range map[int]Sample{0: {0, 0, 0.001}, 1: {1, 0, 0}, 6: {0.5, 0.25, 0.75}, 7: {1, 1, 1}} {
+		if s := c.Samples[i]; s != want {
+			t.Errorf("sample %d: got %v, want %v", i, s, want)
+		}
+	}
+
+	if _, err := Load(strings.NewReader("LUT_3D_SIZE 2\n1e 0 0\n")); !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("sample of 1e: got %v, want %v", err, strconv.ErrSyntax)
+	}
+}
+
+func TestLoadLarge(t *testing.T) {
+	const size = 65
+	src := FromTransform(func(r, g, b float64) (float64, float64, float64) {
+		return math.Sqrt(r), g * g, 1 - b/3
+	}, size)
+	var text strings.Builder
+	if _, err := src.WriteTo(&text); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(strings.NewReader(text.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Samples) != size*size*size {
+		t.Errorf("got %d samples, want %d", len(c.Samples), size*size*size)
+	}
+	for i, s := range c.Samples {
+		want := src.Samples[i]
+		if math.Abs(s.R-want.R) > 5e-7 || math.Abs(s.G-want.G) > 5e-7 || math.Abs(s.B-want.B) > 5e-7 {
+			t.Fatalf("sample %d: got %v, want %v", i, s, want)
+		}
+	}
+}
+
+func TestLoadBogusSize(t *testing.T) {
+	// Sizes beyond the samples the file holds fail once it is read.
+	for _, text := range []string{
+		"LUT_3D_SIZE 200\n0 0 0\n1 1 1\n",
+		"LUT_3D_SIZE 128\n0 0 0\n",
+		"0 0 0\n1 1 1\nLUT_3D_SIZE 256\n",
+	} {
+		if _, err := Load(strings.NewReader(text)); !errors.Is(err, ErrSampleCount) {
+			t.Errorf("%q: got %v, want %v", text, err, ErrSampleCount)
+		}
+	}
+}
+
+func TestLoadHeaderAllocs(t *testing.T) {
+	// A header with a bogus size takes under 1 MiB besides the line
+	// buffer, rather than the 50 MB of the samples it announces.
+	const text = "LUT_3D_SIZE 128\n"
+	const limit = scanBuffer + 1<<20
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	const runs = 10
+	for range runs {
+		if _, err := Load(strings.NewReader(text)); !errors.Is(err, ErrSampleCount) {
+			t.Fatalf("got %v, want %v", err, ErrSampleCount)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if got := (after.TotalAlloc - before.TotalAlloc) / runs; got > limit {
+		t.Errorf("got %d bytes allocated, want at most %d", got, limit)
+	}
+}
+
+func TestLoadLongLine(t *testing.T) {
+	// Lines longer than the initial buffer of the scanner, such as long
+	// comments, grow it.
+	comment := "# " + strings.Repeat("long comment ", 10000)
+	c, err := Load(strings.NewReader(`TITLE "Long"
+` + comment + `
+LUT_3D_SIZE 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comment) <= scanBuffer || c.Meta != strings.TrimSpace(comment) {
+		t.Errorf("got meta of %d bytes, want %d", len(c.Meta), len(comment))
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       error
+	}{
+		{"sample", "LUT_3D_SIZE 2\n0 0 x\n", strconv.ErrSyntax},
+		{"two values", "LUT_3D_SIZE 2\n0 0\n", ErrUnrecognisedLine},
+		{"four values", "LUT_3D_SIZE 2\n0 0 0 0\n", ErrUnrecognisedLine},
+		{"size", "LUT_3D_SIZE two\n", strconv.ErrSyntax},
+		{"fractional size", "LUT_3D_SIZE 2.5\n", strconv.ErrSyntax},
+		{"missing size", "LUT_3D_SIZE\n", ErrUnrecognisedLine},
+		{"domain", "DOMAIN_MIN 0 0\n", ErrUnrecognisedLine},
+		{"input range", "LUT_3D_INPUT_RANGE 0 one\n", strconv.ErrSyntax},
+	}
+
+	for _, tt := range tests {
+		if _, err := Load(strings.NewReader(tt.text)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestBlendWeights(t *testing.T) {
+	c := FromTransform(func(r, g, b float64) (float64, float64, float64) { return r, g, b }, 3)
+	other := FromTransform(func(r, g, b float64) (float64, float64, float64) { return 1 - r, g, b / 2 }, 3)
+
+	tests := []struct {
+		i1, i2 float64
+		err    error
+	}{
+		{0, 0, ErrInvalidWeights},
+		{1, -1, ErrInvalidWeights},
+		{-1, -2, ErrInvalidWeights},
+		{0, 2, nil},
+		{0.3, 0.7, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := c.Blend(other, tt.i1, tt.i2)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v, %v: got %v, want %v", tt.i1, tt.i2, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		w := tt.i2 / (tt.i1 + tt.i2)
+		for i, s := range got.(Cube).Samples {
+			a, b := c.Samples[i], other.Samples[i]
+			want := Sample{a.R + (b.R-a.R)*w, a.G + (b.G-a.G)*w, a.B + (b.B-a.B)*w}
+			if math.Abs(s.R-want.R) > 1e-12 || math.Abs(s.G-want.G) > 1e-12 || math.Abs(s.B-want.B) > 1e-12 {
+				t.Fatalf("%v, %v: sample %d = %v, want %v", tt.i1, tt.i2, i, s, want)
+			}
+		}
+	}
+}
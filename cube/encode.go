@@ -0,0 +1,162 @@
+package cube
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Header is a group of header lines of a CUBE file.
+type Header int
+
+const (
+	// HeaderTitle is the TITLE line.
+	HeaderTitle Header = iota
+	// HeaderMeta is the Meta text, comments and unknown keywords kept
+	// from the source file.
+	HeaderMeta
+	// HeaderVideoRange is the LUT_IN_VIDEO_RANGE and LUT_OUT_VIDEO_RANGE
+	// lines.
+	HeaderVideoRange
+	// HeaderSize is the LUT_3D_SIZE line.
+	HeaderSize
+	// HeaderDomain is the DOMAIN_MIN and DOMAIN_MAX lines, or the
+	// LUT_3D_INPUT_RANGE line of LUTs with an InputRange.
+	HeaderDomain
+)
+
+// defaultHeaders is the order WriteTo writes the headers in.
+var defaultHeaders = []Header{HeaderTitle, HeaderMeta, HeaderVideoRange, HeaderSize, HeaderDomain}
+
+// encodeFlush is the size of the buffered output written at once.
+const encodeFlush = 32 << 10
+
+// Encoder writes CUBE files with control over their formatting, for
+// applications picky about it. The zero value writes as WriteTo does.
+type Encoder struct {
+	// Precision is the number of digits after the decimal point of the
+	// values, 6 if zero.
+	Precision int
+	// LineEnding ends each line, "\n" if empty. Some Windows tools
+	// expect "\r\n".
+	LineEnding string
+	// OmitDefaultDomain leaves out the DOMAIN_MIN and DOMAIN_MAX lines
+	// when the domain is the default one, from 0 to 1.
+	OmitDefaultDomain bool
+	// Headers is the order of the header lines. Headers left out of it
+	// follow in the default order: title, meta, video range, size and
+	// domain.
+	Headers []Header
+}
+
+// Encode writes the LUT c to w.
+func (e *Encoder) Encode(w io.Writer, c Cube) error {
+	_, err := e.encode(w, c)
+	return err
+}
+
+// encode writes the LUT c to w and returns the number of bytes written.
+func (e *Encoder) encode(w io.Writer, c Cube) (n int64, err error) {
+	prec := e.Precision
+	if prec == 0 {
+		prec = 6
+	}
+	eol := e.LineEnding
+	if eol == "" {
+		eol = "\n"
+	}
+
+	buf := make([]byte, 0, encodeFlush+256)
+	flush := func() error {
+		cur, err := w.Write(buf)
+		n += int64(cur)
+		buf = buf[:0]
+		return err
+	}
+	sample := func(s Sample) {
+		buf = strconv.AppendFloat(buf, s.R, 'f', prec, 64)
+		buf = append(buf, ' ')
+		buf = strconv.AppendFloat(buf, s.G, 'f', prec, 64)
+		buf = append(buf, ' ')
+		buf = strconv.AppendFloat(buf, s.B, 'f', prec, 64)
+		buf = append(buf, eol...)
+	}
+
+	for _, h := range e.order() {
+		switch h {
+		case HeaderTitle:
+			if c.Title != "" {
+				buf = append(buf, "TITLE \""...)
+				buf = append(buf, c.Title...)
+				buf = append(buf, '"')
+				buf = append(buf, eol...)
+			}
+		case HeaderMeta:
+			if c.Meta != "" {
+				buf = append(buf, strings.ReplaceAll(c.Meta, "\n", eol)...)
+				buf = append(buf, eol...)
+			}
+		case HeaderVideoRange:
+			if c.InVideoRange {
+				buf = append(buf, "LUT_IN_VIDEO_RANGE"...)
+				buf = append(buf, eol...)
+			}
+			if c.OutVideoRange {
+				buf = append(buf, "LUT_OUT_VIDEO_RANGE"...)
+				buf = append(buf, eol...)
+			}
+		case HeaderSize:
+			buf = append(buf, "LUT_3D_SIZE "...)
+			buf = strconv.AppendInt(buf, int64(c.LUT3Dsize), 10)
+			buf = append(buf, eol...)
+			buf = append(buf, eol...)
+		case HeaderDomain:
+			if e.OmitDefaultDomain && c.DomainMin == (Sample{}) && c.DomainMax == (Sample{1, 1, 1}) {
+				continue
+			}
+			if lo, hi := c.DomainMin, c.DomainMax; c.InputRange && lo.R == lo.G && lo.G == lo.B && hi.R == hi.G && hi.G == hi.B {
+				buf = append(buf, "LUT_3D_INPUT_RANGE "...)
+				buf = strconv.AppendFloat(buf, lo.R, 'f', prec, 64)
+				buf = append(buf, ' ')
+				buf = strconv.AppendFloat(buf, hi.R, 'f', prec, 64)
+				buf = append(buf, eol...)
+				buf = append(buf, eol...)
+				continue
+			}
+			buf = append(buf, "DOMAIN_MIN "...)
+			sample(c.DomainMin)
+			buf = append(buf, "DOMAIN_MAX "...)
+			sample(c.DomainMax)
+			buf = append(buf, eol...)
+		}
+	}
+
+	for _, s := range c.Samples {
+		sample(s)
+		if len(buf) >= encodeFlush {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+	}
+	err = flush()
+	return
+}
+
+// order returns the order of the headers, those missing from e.Headers
+// following in the default order.
+func (e *Encoder) order() []Header {
+	if len(e.Headers) == 0 {
+		return defaultHeaders
+	}
+	order := make([]Header, 0, len(defaultHeaders))
+	seen := make(map[Header]bool, len(defaultHeaders))
+	for _, h := range slices.Concat(e.Headers, defaultHeaders) {
+		if !seen[h] {
+			seen[h] = true
+			order = append(order, h)
+		}
+	}
+	return order
+}
@@ -0,0 +1,63 @@
+package cube
+
+import (
+	"iter"
+	"runtime"
+	"sync"
+)
+
+// mapChunk is the least number of samples handed to a goroutine by Map
+// and the other operations over the samples.
+const mapChunk = 4096
+
+// All returns an iterator over the samples of the LUT and their lattice
+// coordinates, red changing fastest as in the file.
+func (c Cube) All() iter.Seq2[[3]int, Sample] {
+	return func(yield func([3]int, Sample) bool) {
+		n := c.LUT3Dsize
+		if n <= 0 {
+			return
+		}
+		for i, s := range c.Samples {
+			if !yield([3]int{i % n, i / n % n, i / (n * n)}, s) {
+				return
+			}
+		}
+	}
+}
+
+// Map replaces each sample of the LUT with the result of fn, in place,
+// spreading the samples over c.Workers goroutines. fn must be safe for
+// concurrent use.
+func (c *Cube) Map(fn func(Sample) Sample) *Cube {
+	parallel(len(c.Samples), c.Workers, func(lo, hi int) {
+		for i, s := range c.Samples[lo:hi] {
+			c.Samples[lo+i] = fn(s)
+		}
+	})
+	return c
+}
+
+// parallel splits the indices from 0 to n in contiguous chunks of at
+// least mapChunk, one per goroutine up to workers, and calls fn on each
+// chunk from lo to hi. Workers below 1 mean GOMAXPROCS, as the default
+// lut.Options.Workers does for the rows of Apply.
+func parallel(n, workers int, fn func(lo, hi int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	chunk := (n + workers - 1) / workers
+	if chunk < mapChunk {
+		chunk = mapChunk
+	}
+
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += chunk {
+		hi := lo + chunk
+		if hi > n {
+			hi = n
+		}
+		wg.Go(func() { fn(lo, hi) })
+	}
+	wg.Wait()
+}
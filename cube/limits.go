@@ -0,0 +1,21 @@
+package cube
+
+// Limits are the maxima LoadLimits enforces on its input, so that LUTs
+// from untrusted sources cannot make it allocate unbounded memory. Zero
+// fields set no limit.
+type Limits struct {
+	// MaxSize is the largest LUT_3D_SIZE.
+	MaxSize int
+	// MaxLine is the length of the longest line, in bytes.
+	MaxLine int
+	// MaxSamples is the largest number of samples.
+	MaxSamples int
+}
+
+// DefaultLimits are the limits of Load: 256 points per axis and lines of
+// up to 1 MiB, as long comments can be.
+var DefaultLimits = Limits{
+	MaxSize:    256,
+	MaxLine:    1 << 20,
+	MaxSamples: 256 * 256 * 256,
+}
@@ -0,0 +1,105 @@
+package cube
+
+// Mode selects how BlendMode combines the samples of two LUTs, as the
+// layer blend modes of image editors do.
+type Mode int
+
+const (
+	// ModeNormal replaces the samples with those of the top LUT.
+	ModeNormal Mode = iota
+	// ModeMultiply multiplies the samples, darkening them.
+	ModeMultiply
+	// ModeScreen multiplies the complements of the samples, lightening
+	// them.
+	ModeScreen
+	// ModeOverlay multiplies the dark samples and screens the light ones,
+	// increasing contrast.
+	ModeOverlay
+	// ModeDarken keeps the darker of the two samples on each channel.
+	ModeDarken
+	// ModeLighten keeps the lighter of the two samples on each channel.
+	ModeLighten
+)
+
+func (s *Sample) Multiply(s2 Sample) *Sample {
+	s.R *= s2.R
+	s.G *= s2.G
+	s.B *= s2.B
+	return s
+}
+
+func (s *Sample) Screen(s2 Sample) *Sample {
+	s.R = 1 - (1-s.R)*(1-s2.R)
+	s.G = 1 - (1-s.G)*(1-s2.G)
+	s.B = 1 - (1-s.B)*(1-s2.B)
+	return s
+}
+
+func (s *Sample) Overlay(s2 Sample) *Sample {
+	s.R = overlay(s.R, s2.R)
+	s.G = overlay(s.G, s2.G)
+	s.B = overlay(s.B, s2.B)
+	return s
+}
+
+func (s *Sample) Darken(s2 Sample) *Sample {
+	s.R = min(s.R, s2.R)
+	s.G = min(s.G, s2.G)
+	s.B = min(s.B, s2.B)
+	return s
+}
+
+func (s *Sample) Lighten(s2 Sample) *Sample {
+	s.R = max(s.R, s2.R)
+	s.G = max(s.G, s2.G)
+	s.B = max(s.B, s2.B)
+	return s
+}
+
+// overlay blends the top value b over the base value a, multiplying
+// or screening them depending on the base.
+func overlay(a, b float64) float64 {
+	if a < 0.5 {
+		return 2 * a * b
+	}
+	return 1 - 2*(1-a)*(1-b)
+}
+
+// BlendMode blends c2 over c with the given mode, then mixes the result
+// with c by opacity, as a layer is blended over the one below.
+func (c *Cube) BlendMode(c2 Cube, mode Mode, opacity float64) (*Cube, error) {
+	if len(c.Samples) == 0 || len(c2.Samples) == 0 {
+		return c, ErrEmptyLut
+	}
+
+	if len(c.Samples) != len(c2.Samples) {
+		return c, ErrDifferentSampleSize
+	}
+
+	parallel(len(c.Samples), c.Workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			c.blendMode(i, c2.Samples[i], mode, opacity)
+		}
+	})
+	return c, nil
+}
+
+// blendMode blends top over the sample i with mode at opacity.
+func (c *Cube) blendMode(i int, top Sample, mode Mode, opacity float64) {
+	s := c.Samples[i]
+	switch mode {
+	case ModeMultiply:
+		s.Multiply(top)
+	case ModeScreen:
+		s.Screen(top)
+	case ModeOverlay:
+		s.Overlay(top)
+	case ModeDarken:
+		s.Darken(top)
+	case ModeLighten:
+		s.Lighten(top)
+	default:
+		s = top
+	}
+	c.Samples[i].Blend(s, 1-opacity, opacity)
+}
@@ -0,0 +1,465 @@
+package dng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+
+	"github.com/NicoNex/prism/colorspace"
+	"github.com/NicoNex/prism/tiff"
+)
+
+// DNG specific tags.
+const (
+	tagCFARepeatPatternDim    = 33421
+	tagCFAPattern             = 33422
+	tagDNGVersion             = 50706
+	tagLinearizationTable     = 50712
+	tagBlackLevelRepeatDim    = 50713
+	tagBlackLevel             = 50714
+	tagWhiteLevel             = 50717
+	tagDefaultCropOrigin      = 50719
+	tagDefaultCropSize        = 50720
+	tagColorMatrix1           = 50721
+	tagColorMatrix2           = 50722
+	tagCalibrationIlluminant2 = 50779
+	tagAsShotNeutral          = 50728
+	tagBaselineExposure       = 50730
+	tagActiveArea             = 50829
+)
+
+const (
+	photometricCFA       = 32803
+	photometricLinearRaw = 34892
+
+	compressionNone     = 1
+	compressionLossless = 7
+
+	illuminantD65 = 21
+)
+
+var (
+	ErrNotDNG         = errors.New("not a DNG file")
+	ErrNoRawImage     = errors.New("no raw image found in DNG")
+	ErrUnsupportedDNG = errors.New("unsupported DNG encoding")
+)
+
+// raw is the undecoded sensor data of a DNG along with the metadata
+// required to render it.
+type raw struct {
+	ifd0, ifd     tiff.IFD
+	width, height int
+	spp           int
+	data          []uint16
+}
+
+// Decode renders the main raw image of a DNG file to a 16-bit sRGB image.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := readRaw(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return rw.render(), nil
+}
+
+// DecodeFile renders the main raw image of the DNG file at path.
+func DecodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Decode(f)
+}
+
+// readRaw locates the main raw IFD and reads its samples.
+func readRaw(r io.ReaderAt) (raw, error) {
+	t, err := tiff.NewReader(r)
+	if err != nil {
+		return raw{}, ErrNotDNG
+	}
+
+	ifd0, err := t.IFD(t.First)
+	if err != nil {
+		return raw{}, err
+	}
+	if !ifd0.Has(tagDNGVersion) {
+		return raw{}, ErrNotDNG
+	}
+
+	ifd, err := findRawIFD(t, ifd0)
+	if err != nil {
+		return raw{}, err
+	}
+
+	rw := raw{
+		ifd0:   ifd0,
+		ifd:    ifd,
+		width:  int(ifd.Uint(tiff.TagImageWidth, 0)),
+		height: int(ifd.Uint(tiff.TagImageLength, 0)),
+		spp:    int(ifd.Uint(tiff.TagSamplesPerPixel, 1)),
+	}
+	if rw.width == 0 || rw.height == 0 {
+		return raw{}, ErrUnsupportedDNG
+	}
+
+	if err := rw.read(r); err != nil {
+		return raw{}, err
+	}
+	return rw, nil
+}
+
+// findRawIFD returns the full resolution raw IFD, searching IFD0 and
+// its sub-IFDs.
+func findRawIFD(t *tiff.Reader, ifd0 tiff.IFD) (tiff.IFD, error) {
+	candidates := []tiff.IFD{ifd0}
+	for _, off := range ifd0.Uints(tiff.TagSubIFDs) {
+		sub, err := t.IFD(off)
+		if err != nil {
+			return tiff.IFD{}, err
+		}
+		candidates = append(candidates, sub)
+	}
+
+	for _, ifd := range candidates {
+		photometric := ifd.Uint(tiff.TagPhotometricInterpretation, 0)
+		isRaw := photometric == photometricCFA || photometric == photometricLinearRaw
+		if isRaw && ifd.Uint(tiff.TagNewSubfileType, 0) == 0 {
+			return ifd, nil
+		}
+	}
+	return tiff.IFD{}, ErrNoRawImage
+}
+
+// read decodes the strips or tiles of the raw IFD into rw.data.
+func (rw *raw) read(r io.ReaderAt) error {
+	ifd := rw.ifd
+	compression := ifd.Uint(tiff.TagCompression, compressionNone)
+	bps := int(ifd.Uint(tiff.TagBitsPerSample, 16))
+
+	tw, th := rw.width, int(ifd.Uint(tiff.TagRowsPerStrip, uint32(rw.height)))
+	offsets := ifd.Uints(tiff.TagStripOffsets)
+	counts := ifd.Uints(tiff.TagStripByteCounts)
+	if ifd.Has(tiff.TagTileOffsets) {
+		tw = int(ifd.Uint(tiff.TagTileWidth, 0))
+		th = int(ifd.Uint(tiff.TagTileLength, 0))
+		offsets = ifd.Uints(tiff.TagTileOffsets)
+		counts = ifd.Uints(tiff.TagTileByteCounts)
+	}
+	if tw == 0 || th == 0 || len(offsets) == 0 || len(offsets) != len(counts) {
+		return ErrUnsupportedDNG
+	}
+
+	rw.data = make([]uint16, rw.width*rw.height*rw.spp)
+	across := (rw.width + tw - 1) / tw
+
+	for i, off := range offsets {
+		buf := make([]byte, counts[i])
+		if _, err := r.ReadAt(buf, int64(off)); err != nil {
+			return err
+		}
+
+		var samples []uint16
+		switch compression {
+		case compressionNone:
+			samples = unpack(buf, bps, rw.ifd.ByteOrder)
+		case compressionLossless:
+			lj, err := decodeLJPEG(buf)
+			if err != nil {
+				return err
+			}
+			samples = lj.samples
+		default:
+			return ErrUnsupportedDNG
+		}
+
+		rw.place(samples, (i%across)*tw, (i/across)*th, tw, th)
+	}
+	return nil
+}
+
+// place copies the samples of a tw*th tile to its position in the image,
+// discarding the padding outside the image bounds.
+func (rw *raw) place(samples []uint16, x0, y0, tw, th int) {
+	rowLen := tw * rw.spp
+	for ty := range th {
+		y := y0 + ty
+		if y >= rw.height || (ty+1)*rowLen > len(samples) {
+			return
+		}
+
+		n := min(tw, rw.width-x0) * rw.spp
+		dst := rw.data[(y*rw.width+x0)*rw.spp:]
+		copy(dst[:n], samples[ty*rowLen:ty*rowLen+n])
+	}
+}
+
+// unpack splits uncompressed data into samples of the given bit depth.
+func unpack(buf []byte, bps int, bo binary.ByteOrder) []uint16 {
+	switch bps {
+	case 8:
+		samples := make([]uint16, len(buf))
+		for i, b := range buf {
+			samples[i] = uint16(b)
+		}
+		return samples
+
+	case 16:
+		samples := make([]uint16, len(buf)/2)
+		for i := range samples {
+			samples[i] = bo.Uint16(buf[i*2:])
+		}
+		return samples
+
+	default:
+		// Packed samples are stored most significant bit first.
+		samples := make([]uint16, len(buf)*8/bps)
+		var acc uint64
+		var nbits int
+		i := 0
+		for _, b := range buf {
+			acc = acc<<8 | uint64(b)
+			nbits += 8
+			for nbits >= bps && i < len(samples) {
+				nbits -= bps
+				samples[i] = uint16(acc >> nbits & (1<<bps - 1))
+				i++
+			}
+		}
+		return samples
+	}
+}
+
+// render converts the raw samples to a 16-bit sRGB image.
+func (rw raw) render() *image.RGBA64 {
+	lin := rw.linearize()
+
+	var rgb []float64
+	if rw.ifd.Uint(tiff.TagPhotometricInterpretation, 0) == photometricCFA {
+		rgb = rw.demosaic(lin)
+	} else {
+		rgb = rw.expand(lin)
+	}
+
+	rw.colorCorrect(rgb)
+
+	crop := rw.cropRect()
+	out := image.NewRGBA64(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	for y := crop.Min.Y; y < crop.Max.Y; y++ {
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			i := (y*rw.width + x) * 3
+			out.SetRGBA64(x-crop.Min.X, y-crop.Min.Y, color.RGBA64{
+				R: encodeSRGB(rgb[i]),
+				G: encodeSRGB(rgb[i+1]),
+				B: encodeSRGB(rgb[i+2]),
+				A: 0xffff,
+			})
+		}
+	}
+	return out
+}
+
+// linearize applies the linearization table, black and white levels,
+// returning samples normalised to [0, 1].
+func (rw raw) linearize() []float64 {
+	ifd := rw.ifd
+	table := ifd.Uints(tagLinearizationTable)
+
+	black := ifd.Floats(tagBlackLevel)
+	if len(black) == 0 {
+		black = []float64{0}
+	}
+	blackDim := ifd.Uints(tagBlackLevelRepeatDim)
+	if len(blackDim) != 2 || int(blackDim[0]*blackDim[1])*rw.spp > len(black) {
+		blackDim = []uint32{1, 1}
+	}
+
+	white := ifd.Floats(tagWhiteLevel)
+	if len(white) == 0 {
+		bps := ifd.Uint(tiff.TagBitsPerSample, 16)
+		white = []float64{float64(uint32(1)<<bps - 1)}
+	}
+
+	out := make([]float64, len(rw.data))
+	for i, v := range rw.data {
+		s := i % rw.spp
+		x := i / rw.spp % rw.width
+		y := i / rw.spp / rw.width
+
+		val := float64(v)
+		if len(table) > 0 {
+			val = float64(table[min(int(v), len(table)-1)])
+		}
+
+		bi := (y%int(blackDim[0])*int(blackDim[1])+x%int(blackDim[1]))*rw.spp + s
+		b := black[min(bi, len(black)-1)]
+		w := white[min(s, len(white)-1)]
+
+		out[i] = max(0, min(1, (val-b)/(w-b)))
+	}
+	return out
+}
+
+// demosaic interpolates the missing colors of a CFA image by averaging
+// the neighbouring photosites of each color.
+func (rw raw) demosaic(lin []float64) []float64 {
+	dim := rw.ifd.Uints(tagCFARepeatPatternDim)
+	pattern := rw.ifd.Uints(tagCFAPattern)
+	if len(dim) != 2 || len(pattern) < int(dim[0]*dim[1]) {
+		dim = []uint32{2, 2}
+		pattern = []uint32{0, 1, 1, 2}
+	}
+	prows, pcols := int(dim[0]), int(dim[1])
+
+	colorAt := func(x, y int) int {
+		return int(pattern[(y%prows)*pcols+x%pcols])
+	}
+
+	w, h := rw.width, rw.height
+	rgb := make([]float64, w*h*3)
+	for y := range h {
+		for x := range w {
+			var sum [3]float64
+			var cnt [3]int
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					c := colorAt(nx, ny)
+					if c > 2 {
+						continue
+					}
+					sum[c] += lin[ny*w+nx]
+					cnt[c]++
+				}
+			}
+
+			i := (y*w + x) * 3
+			own := colorAt(x, y)
+			for c := range 3 {
+				switch {
+				case c == own:
+					rgb[i+c] = lin[y*w+x]
+				case cnt[c] > 0:
+					rgb[i+c] = sum[c] / float64(cnt[c])
+				}
+			}
+		}
+	}
+	return rgb
+}
+
+// expand converts linear raw samples to RGB triplets.
+func (rw raw) expand(lin []float64) []float64 {
+	if rw.spp == 3 {
+		return lin
+	}
+
+	rgb := make([]float64, rw.width*rw.height*3)
+	for i := range rw.width * rw.height {
+		for c := range 3 {
+			rgb[i*3+c] = lin[i*rw.spp+min(c, rw.spp-1)]
+		}
+	}
+	return rgb
+}
+
+// colorCorrect white balances the camera RGB values and converts them
+// to linear sRGB in place.
+func (rw raw) colorCorrect(rgb []float64) {
+	ifd0 := rw.ifd0
+
+	// Prefer the D65 calibration when both matrices are present.
+	cm := ifd0.Floats(tagColorMatrix1)
+	if cm2 := ifd0.Floats(tagColorMatrix2); len(cm2) == 9 && ifd0.Uint(tagCalibrationIlluminant2, 0) == illuminantD65 {
+		cm = cm2
+	}
+
+	wb := [3]float64{1, 1, 1}
+	if neutral := ifd0.Floats(tagAsShotNeutral); len(neutral) == 3 {
+		minMul := math.Inf(1)
+		for c := range 3 {
+			wb[c] = 1 / neutral[c]
+			minMul = min(minMul, wb[c])
+		}
+		for c := range 3 {
+			wb[c] /= minMul
+		}
+	}
+
+	rgbCam := colorspace.Identity
+	if len(cm) == 9 {
+		var camXYZ colorspace.Matrix
+		for i := range 3 {
+			for j := range 3 {
+				camXYZ[i][j] = cm[i*3+j]
+			}
+		}
+		camRGB := camXYZ.Mul(colorspace.SRGBToXYZ)
+
+		// Normalise so that camera white maps to sRGB white.
+		for i := range 3 {
+			sum := camRGB[i][0] + camRGB[i][1] + camRGB[i][2]
+			for j := range 3 {
+				camRGB[i][j] /= sum
+			}
+		}
+		if inv, ok := camRGB.Invert(); ok {
+			rgbCam = inv
+		}
+	}
+
+	exposure := 1.0
+	if ev := ifd0.Floats(tagBaselineExposure); len(ev) == 1 {
+		exposure = math.Exp2(ev[0])
+	}
+
+	for i := 0; i < len(rgb); i += 3 {
+		var cam [3]float64
+		for c := range 3 {
+			cam[c] = min(1, rgb[i+c]*wb[c])
+		}
+		r, g, b := rgbCam.Apply(cam[0], cam[1], cam[2])
+		rgb[i] = max(0, min(1, r*exposure))
+		rgb[i+1] = max(0, min(1, g*exposure))
+		rgb[i+2] = max(0, min(1, b*exposure))
+	}
+}
+
+// cropRect returns the rectangle of the raw image holding actual image
+// data, honouring ActiveArea and the default crop.
+func (rw raw) cropRect() image.Rectangle {
+	r := image.Rect(0, 0, rw.width, rw.height)
+	if area := rw.ifd.Uints(tagActiveArea); len(area) == 4 {
+		r = image.Rect(int(area[1]), int(area[0]), int(area[3]), int(area[2])).Intersect(r)
+	}
+
+	origin := rw.ifd.Floats(tagDefaultCropOrigin)
+	size := rw.ifd.Floats(tagDefaultCropSize)
+	if len(origin) == 2 && len(size) == 2 {
+		x0 := r.Min.X + int(origin[0])
+		y0 := r.Min.Y + int(origin[1])
+		r = image.Rect(x0, y0, x0+int(size[0]), y0+int(size[1])).Intersect(r)
+	}
+	return r
+}
+
+// encodeSRGB applies the sRGB transfer function to a linear value.
+func encodeSRGB(v float64) uint16 {
+	v = colorspace.LinearToSRGB(v)
+	return uint16(math.Round(max(0, min(1, v)) * 0xffff))
+}
@@ -0,0 +1,303 @@
+package dng
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidLJPEG = errors.New("invalid lossless JPEG data")
+
+// huffman is a canonical Huffman table as defined by ITU T.81 Annex C.
+type huffman struct {
+	maxcode [18]int32
+	valptr  [17]int32
+	mincode [17]int32
+	vals    []byte
+}
+
+func newHuffman(counts [16]byte, vals []byte) *huffman {
+	h := &huffman{vals: vals}
+
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		if n == 0 {
+			h.maxcode[l] = -1
+		} else {
+			h.valptr[l] = k
+			h.mincode[l] = code
+			code += n
+			k += n
+			h.maxcode[l] = code - 1
+		}
+		code <<= 1
+	}
+	// Sentinel guaranteeing termination on corrupt data.
+	h.maxcode[17] = 0x7fffffff
+	return h
+}
+
+// bitReader reads entropy coded bits skipping byte stuffing.
+type bitReader struct {
+	data   []byte
+	pos    int
+	acc    uint32
+	nbits  uint
+	marker bool
+}
+
+func (br *bitReader) fill() {
+	for br.nbits <= 24 {
+		var b byte
+		if !br.marker && br.pos < len(br.data) {
+			b = br.data[br.pos]
+			if b == 0xff {
+				if br.pos+1 < len(br.data) && br.data[br.pos+1] == 0x00 {
+					br.pos += 2
+				} else {
+					// A marker ends the entropy coded segment.
+					br.marker = true
+					b = 0
+				}
+			} else {
+				br.pos++
+			}
+		}
+		br.acc |= uint32(b) << (24 - br.nbits)
+		br.nbits += 8
+	}
+}
+
+func (br *bitReader) bits(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	br.fill()
+	v := br.acc >> (32 - n)
+	br.acc <<= n
+	br.nbits -= n
+	return v
+}
+
+// reset discards buffered bits and skips a restart marker.
+func (br *bitReader) reset() {
+	br.acc, br.nbits, br.marker = 0, 0, false
+	for br.pos+1 < len(br.data) {
+		if br.data[br.pos] == 0xff && br.data[br.pos+1] >= 0xd0 && br.data[br.pos+1] <= 0xd7 {
+			br.pos += 2
+			return
+		}
+		br.pos++
+	}
+}
+
+func (br *bitReader) decode(h *huffman) (byte, error) {
+	code := int32(br.bits(1))
+	l := 1
+	for code > h.maxcode[l] {
+		code = code<<1 | int32(br.bits(1))
+		l++
+		if l > 16 {
+			return 0, ErrInvalidLJPEG
+		}
+	}
+
+	i := h.valptr[l] + code - h.mincode[l]
+	if int(i) >= len(h.vals) {
+		return 0, ErrInvalidLJPEG
+	}
+	return h.vals[i], nil
+}
+
+// diff decodes a single difference value.
+func (br *bitReader) diff(h *huffman) (int32, error) {
+	s, err := br.decode(h)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case s == 0:
+		return 0, nil
+	case s == 16:
+		return 32768, nil
+	case s > 16:
+		return 0, ErrInvalidLJPEG
+	}
+
+	v := int32(br.bits(uint(s)))
+	if v < 1<<(s-1) {
+		v -= 1<<s - 1
+	}
+	return v, nil
+}
+
+// ljpeg holds the result of decoding a lossless JPEG stream: width
+// columns of comps interleaved samples for each of the height rows.
+type ljpeg struct {
+	width, height, comps int
+	samples              []uint16
+}
+
+// decodeLJPEG decodes a lossless (process 14, SOF3) JPEG stream.
+func decodeLJPEG(data []byte) (ljpeg, error) {
+	var (
+		res       ljpeg
+		precision int
+		tables    [4]*huffman
+		restart   int
+	)
+
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return res, ErrInvalidLJPEG
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return res, ErrInvalidLJPEG
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			pos++
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if pos+2+n > len(data) || n < 2 {
+			return res, ErrInvalidLJPEG
+		}
+		seg := data[pos+4 : pos+2+n]
+		pos += 2 + n
+
+		switch marker {
+		case 0xc3: // SOF3
+			if len(seg) < 6 {
+				return res, ErrInvalidLJPEG
+			}
+			precision = int(seg[0])
+			res.height = int(binary.BigEndian.Uint16(seg[1:]))
+			res.width = int(binary.BigEndian.Uint16(seg[3:]))
+			res.comps = int(seg[5])
+
+		case 0xc4: // DHT
+			for len(seg) >= 17 {
+				id := seg[0] & 0x0f
+				var counts [16]byte
+				copy(counts[:], seg[1:17])
+				total := 0
+				for _, c := range counts {
+					total += int(c)
+				}
+				if id > 3 || len(seg) < 17+total {
+					return res, ErrInvalidLJPEG
+				}
+				tables[id] = newHuffman(counts, seg[17:17+total])
+				seg = seg[17+total:]
+			}
+
+		case 0xdd: // DRI
+			if len(seg) < 2 {
+				return res, ErrInvalidLJPEG
+			}
+			restart = int(binary.BigEndian.Uint16(seg))
+
+		case 0xda: // SOS
+			if res.comps == 0 || len(seg) < 1+2*res.comps+3 {
+				return res, ErrInvalidLJPEG
+			}
+			huffs := make([]*huffman, res.comps)
+			for c := range res.comps {
+				huffs[c] = tables[seg[2+c*2]>>4&0x03]
+				if huffs[c] == nil {
+					return res, ErrInvalidLJPEG
+				}
+			}
+			predictor := int(seg[1+2*res.comps])
+			pt := uint(seg[3+2*res.comps] & 0x0f)
+
+			err := res.scan(data[pos:], huffs, predictor, precision, pt, restart)
+			return res, err
+		}
+	}
+	return res, ErrInvalidLJPEG
+}
+
+// scan decodes the entropy coded samples following the SOS header.
+func (res *ljpeg) scan(data []byte, huffs []*huffman, predictor, precision int, pt uint, restart int) error {
+	w, h, nc := res.width, res.height, res.comps
+	res.samples = make([]uint16, w*h*nc)
+	br := &bitReader{data: data}
+	initial := int32(1) << (precision - int(pt) - 1)
+
+	mcus := 0
+	firstRow := true
+	for y := range h {
+		row := res.samples[y*w*nc : (y+1)*w*nc]
+		var prev []uint16
+		if y > 0 {
+			prev = res.samples[(y-1)*w*nc : y*w*nc]
+		}
+
+		for x := range w {
+			if restart > 0 && mcus == restart {
+				br.reset()
+				mcus = 0
+				firstRow = true
+			}
+
+			for c := range nc {
+				d, err := br.diff(huffs[c])
+				if err != nil {
+					return err
+				}
+
+				i := x*nc + c
+				var p int32
+				switch {
+				case firstRow && x == 0:
+					p = initial
+				case firstRow:
+					p = int32(row[i-nc])
+				case x == 0:
+					p = int32(prev[i])
+				default:
+					p = predict(predictor, int32(row[i-nc]), int32(prev[i]), int32(prev[i-nc]))
+				}
+				row[i] = uint16(p + d)
+			}
+			mcus++
+		}
+		// Restart intervals span whole rows in lossless mode.
+		firstRow = false
+	}
+
+	if pt > 0 {
+		for i, s := range res.samples {
+			res.samples[i] = s << pt
+		}
+	}
+	return nil
+}
+
+// predict computes the sample prediction given the left (a), above (b)
+// and above-left (c) neighbours.
+func predict(sel int, a, b, c int32) int32 {
+	switch sel {
+	case 1:
+		return a
+	case 2:
+		return b
+	case 3:
+		return c
+	case 4:
+		return a + b - c
+	case 5:
+		return a + (b-c)>>1
+	case 6:
+		return b + (a-c)>>1
+	case 7:
+		return (a + b) / 2
+	default:
+		return a
+	}
+}
@@ -0,0 +1,193 @@
+package exif
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const tagOrientation = 0x0112
+
+var (
+	ErrNoExif      = errors.New("no EXIF data")
+	ErrInvalidExif = errors.New("invalid EXIF data")
+	ErrTooLarge    = errors.New("EXIF data too large for a JPEG segment")
+)
+
+// Orientation reads the EXIF Orientation tag from a JPEG stream.
+// It returns 1 (normal) when the tag is not present.
+func Orientation(r io.Reader) (int, error) {
+	data, err := Extract(r)
+	if err != nil {
+		if errors.Is(err, ErrNoExif) {
+			return 1, nil
+		}
+		return 1, err
+	}
+
+	o, err := orientation(data)
+	if err != nil {
+		return 1, err
+	}
+	return o, nil
+}
+
+// Extract returns the TIFF structured payload of the EXIF APP1 segment
+// of a JPEG stream, without the "Exif\x00\x00" header.
+func Extract(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xff || soi[1] != 0xd8 {
+		return nil, ErrNoExif
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, err
+		}
+
+		// Start of scan or end of image, no more metadata past this point.
+		if marker == 0xda || marker == 0xd9 {
+			return nil, ErrNoExif
+		}
+		// Standalone markers carry no length.
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			continue
+		}
+
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if n < 0 {
+			return nil, ErrInvalidExif
+		}
+
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+
+		if marker == 0xe1 && len(seg) >= 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return seg[6:], nil
+		}
+	}
+}
+
+// nextMarker skips to the next JPEG marker and returns its code.
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, ErrInvalidExif
+	}
+	// Markers may be preceded by any number of fill bytes.
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// byteOrder returns the byte order declared in a TIFF header.
+func byteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalidExif
+	}
+
+	switch string(data[:2]) {
+	case "II":
+		return binary.LittleEndian, nil
+	case "MM":
+		return binary.BigEndian, nil
+	default:
+		return nil, ErrInvalidExif
+	}
+}
+
+// orientation looks up the Orientation tag in IFD0 of a TIFF payload.
+func orientation(data []byte) (int, error) {
+	bo, entry, err := findOrientation(data)
+	if err != nil || entry < 0 {
+		return 1, err
+	}
+
+	o := int(bo.Uint16(data[entry+8:]))
+	if o < 1 || o > 8 {
+		return 1, nil
+	}
+	return o, nil
+}
+
+// findOrientation returns the offset of the Orientation entry in IFD0
+// of a TIFF payload, or -1 if the tag is not present.
+func findOrientation(data []byte) (binary.ByteOrder, int, error) {
+	bo, err := byteOrder(data)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	off := int(bo.Uint32(data[4:8]))
+	if off+2 > len(data) {
+		return nil, -1, ErrInvalidExif
+	}
+
+	count := int(bo.Uint16(data[off:]))
+	for i := range count {
+		entry := off + 2 + i*12
+		if entry+12 > len(data) {
+			return nil, -1, ErrInvalidExif
+		}
+
+		if bo.Uint16(data[entry:]) == tagOrientation {
+			return bo, entry, nil
+		}
+	}
+	return bo, -1, nil
+}
+
+// SetOrientation overwrites the Orientation tag of the EXIF payload
+// returned by Extract. It does nothing if the tag is not present.
+func SetOrientation(data []byte, o int) error {
+	bo, entry, err := findOrientation(data)
+	if err != nil || entry < 0 {
+		return err
+	}
+
+	bo.PutUint16(data[entry+8:], uint16(o))
+	return nil
+}
+
+// Insert writes the JPEG stream jpg to w adding an EXIF APP1 segment
+// holding the payload right after the SOI marker. Any EXIF segment
+// already in jpg is kept, so jpg is expected to carry none.
+func Insert(w io.Writer, jpg, payload []byte) error {
+	if len(jpg) < 2 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return ErrInvalidExif
+	}
+
+	n := len(payload) + len("Exif\x00\x00") + 2
+	if n > 0xffff {
+		return ErrTooLarge
+	}
+
+	seg := []byte{0xff, 0xd8, 0xff, 0xe1, byte(n >> 8), byte(n)}
+	seg = append(seg, "Exif\x00\x00"...)
+
+	for _, chunk := range [][]byte{seg, payload, jpg[2:]} {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
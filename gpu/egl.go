@@ -0,0 +1,289 @@
+//go:build gpu && linux && cgo
+
+package gpu
+
+/*
+#cgo LDFLAGS: -lEGL -lGLESv2
+
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <EGL/egl.h>
+#include <EGL/eglext.h>
+#include <GLES3/gl3.h>
+
+#ifndef EGL_PLATFORM_SURFACELESS_MESA
+#define EGL_PLATFORM_SURFACELESS_MESA 0x31DD
+#endif
+
+static EGLDisplay display = EGL_NO_DISPLAY;
+static EGLContext context = EGL_NO_CONTEXT;
+static GLuint program, lutTex, srcTex, fbo, rbo;
+static GLint locScale, locOffset, locIntensity;
+// Largest side of the tiles the images are graded in.
+static GLint maxTile;
+
+static const char *vertexSrc =
+	"#version 300 es\n"
+	"void main() {\n"
+	"	vec2 p = vec2(float((gl_VertexID & 1) << 2) - 1.0, float((gl_VertexID & 2) << 1) - 1.0);\n"
+	"	gl_Position = vec4(p, 0.0, 1.0);\n"
+	"}\n";
+
+static const char *fragmentSrc =
+	"#version 300 es\n"
+	"precision highp float;\n"
+	"precision highp sampler3D;\n"
+	"uniform sampler2D src;\n"
+	"uniform sampler3D lut;\n"
+	"uniform float scale, offset, intensity;\n"
+	"out vec4 color;\n"
+	"void main() {\n"
+	"	vec4 c = texelFetch(src, ivec2(gl_FragCoord.xy), 0);\n"
+	"	vec3 g = texture(lut, c.rgb * scale + offset).rgb;\n"
+	"	color = vec4(mix(c.rgb, g, intensity), 1.0);\n"
+	"}\n";
+
+static char errbuf[1024];
+
+static GLuint compile(GLenum type, const char *src) {
+	GLuint s = glCreateShader(type);
+	glShaderSource(s, 1, &src, NULL);
+	glCompileShader(s);
+	GLint ok;
+	glGetShaderiv(s, GL_COMPILE_STATUS, &ok);
+	if (!ok) {
+		glGetShaderInfoLog(s, sizeof errbuf, NULL, errbuf);
+		glDeleteShader(s);
+		return 0;
+	}
+	return s;
+}
+
+static const char *gpuInit(void) {
+	PFNEGLGETPLATFORMDISPLAYEXTPROC getPlatformDisplay =
+		(PFNEGLGETPLATFORMDISPLAYEXTPROC)eglGetProcAddress("eglGetPlatformDisplayEXT");
+	if (getPlatformDisplay != NULL) {
+		display = getPlatformDisplay(EGL_PLATFORM_SURFACELESS_MESA, EGL_DEFAULT_DISPLAY, NULL);
+	}
+	if (display == EGL_NO_DISPLAY) {
+		display = eglGetDisplay(EGL_DEFAULT_DISPLAY);
+	}
+	if (display == EGL_NO_DISPLAY || !eglInitialize(display, NULL, NULL)) {
+		return "cannot open an EGL display";
+	}
+	if (!eglBindAPI(EGL_OPENGL_ES_API)) {
+		return "OpenGL ES not supported";
+	}
+
+	// Contexts without a surface need no configuration where
+	// EGL_KHR_no_config_context is supported, as on surfaceless displays
+	// that have none.
+	const EGLint configAttr[] = {EGL_RENDERABLE_TYPE, EGL_OPENGL_ES3_BIT, EGL_NONE};
+	EGLConfig config = (EGLConfig)0;
+	EGLint n = 0;
+	if (!eglChooseConfig(display, configAttr, &config, 1, &n) || n == 0) {
+		const char *ext = eglQueryString(display, EGL_EXTENSIONS);
+		if (ext == NULL || strstr(ext, "EGL_KHR_no_config_context") == NULL) {
+			return "no OpenGL ES 3 configuration";
+		}
+		config = (EGLConfig)0;
+	}
+	const EGLint contextAttr[] = {EGL_CONTEXT_MAJOR_VERSION, 3, EGL_NONE};
+	context = eglCreateContext(display, config, EGL_NO_CONTEXT, contextAttr);
+	if (context == EGL_NO_CONTEXT) {
+		return "cannot create an OpenGL ES 3 context";
+	}
+	if (!eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, context)) {
+		return "surfaceless contexts not supported";
+	}
+
+	GLuint vs = compile(GL_VERTEX_SHADER, vertexSrc);
+	GLuint fs = compile(GL_FRAGMENT_SHADER, fragmentSrc);
+	if (vs == 0 || fs == 0) {
+		return errbuf;
+	}
+	program = glCreateProgram();
+	glAttachShader(program, vs);
+	glAttachShader(program, fs);
+	glLinkProgram(program);
+	glDeleteShader(vs);
+	glDeleteShader(fs);
+	GLint ok;
+	glGetProgramiv(program, GL_LINK_STATUS, &ok);
+	if (!ok) {
+		glGetProgramInfoLog(program, sizeof errbuf, NULL, errbuf);
+		return errbuf;
+	}
+	glUseProgram(program);
+	glUniform1i(glGetUniformLocation(program, "src"), 0);
+	glUniform1i(glGetUniformLocation(program, "lut"), 1);
+	locScale = glGetUniformLocation(program, "scale");
+	locOffset = glGetUniformLocation(program, "offset");
+	locIntensity = glGetUniformLocation(program, "intensity");
+
+	glGetIntegerv(GL_MAX_TEXTURE_SIZE, &maxTile);
+	if (maxTile > 4096) {
+		maxTile = 4096;
+	}
+
+	glGenTextures(1, &lutTex);
+	glActiveTexture(GL_TEXTURE1);
+	glBindTexture(GL_TEXTURE_3D, lutTex);
+	glTexParameteri(GL_TEXTURE_3D, GL_TEXTURE_MIN_FILTER, GL_LINEAR);
+	glTexParameteri(GL_TEXTURE_3D, GL_TEXTURE_MAG_FILTER, GL_LINEAR);
+	glTexParameteri(GL_TEXTURE_3D, GL_TEXTURE_WRAP_S, GL_CLAMP_TO_EDGE);
+	glTexParameteri(GL_TEXTURE_3D, GL_TEXTURE_WRAP_T, GL_CLAMP_TO_EDGE);
+	glTexParameteri(GL_TEXTURE_3D, GL_TEXTURE_WRAP_R, GL_CLAMP_TO_EDGE);
+
+	glGenTextures(1, &srcTex);
+	glActiveTexture(GL_TEXTURE0);
+	glBindTexture(GL_TEXTURE_2D, srcTex);
+	glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_MIN_FILTER, GL_NEAREST);
+	glTexParameteri(GL_TEXTURE_2D, GL_TEXTURE_MAG_FILTER, GL_NEAREST);
+	glTexStorage2D(GL_TEXTURE_2D, 1, GL_RGBA8, maxTile, maxTile);
+
+	glGenRenderbuffers(1, &rbo);
+	glBindRenderbuffer(GL_RENDERBUFFER, rbo);
+	glRenderbufferStorage(GL_RENDERBUFFER, GL_RGBA8, maxTile, maxTile);
+	glGenFramebuffers(1, &fbo);
+	glBindFramebuffer(GL_FRAMEBUFFER, fbo);
+	glFramebufferRenderbuffer(GL_FRAMEBUFFER, GL_COLOR_ATTACHMENT0, GL_RENDERBUFFER, rbo);
+	if (glCheckFramebufferStatus(GL_FRAMEBUFFER) != GL_FRAMEBUFFER_COMPLETE) {
+		return "incomplete framebuffer";
+	}
+
+	eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, EGL_NO_CONTEXT);
+	return NULL;
+}
+
+// Float textures are filtered in full precision where the extension
+// allows it, and in half precision otherwise.
+static GLenum lutFormat(void) {
+	const char *ext = (const char *)glGetString(GL_EXTENSIONS);
+	if (ext != NULL && strstr(ext, "GL_OES_texture_float_linear") != NULL) {
+		return GL_RGB32F;
+	}
+	return GL_RGB16F;
+}
+
+static const char *gpuApply(const float *lut, int n, uint8_t *src, uint8_t *dst, int stride, int w, int h, float intensity) {
+	if (!eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, context)) {
+		return "cannot make the context current";
+	}
+
+	glActiveTexture(GL_TEXTURE1);
+	glPixelStorei(GL_UNPACK_ALIGNMENT, 4);
+	glPixelStorei(GL_UNPACK_ROW_LENGTH, 0);
+	glTexImage3D(GL_TEXTURE_3D, 0, lutFormat(), n, n, n, 0, GL_RGB, GL_FLOAT, lut);
+	// The centers of the first and last texels map to 0 and 1.
+	glUniform1f(locScale, (float)(n - 1) / n);
+	glUniform1f(locOffset, 0.5f / n);
+	glUniform1f(locIntensity, intensity);
+	glActiveTexture(GL_TEXTURE0);
+
+	glPixelStorei(GL_UNPACK_ALIGNMENT, 1);
+	glPixelStorei(GL_PACK_ALIGNMENT, 1);
+	glPixelStorei(GL_UNPACK_ROW_LENGTH, stride / 4);
+	glPixelStorei(GL_PACK_ROW_LENGTH, stride / 4);
+
+	for (int y = 0; y < h; y += maxTile) {
+		for (int x = 0; x < w; x += maxTile) {
+			int tw = w - x < maxTile ? w - x : maxTile;
+			int th = h - y < maxTile ? h - y : maxTile;
+			size_t off = (size_t)y * stride + (size_t)x * 4;
+			glTexSubImage2D(GL_TEXTURE_2D, 0, 0, 0, tw, th, GL_RGBA, GL_UNSIGNED_BYTE, src + off);
+			glViewport(0, 0, tw, th);
+			glDrawArrays(GL_TRIANGLES, 0, 3);
+			glReadPixels(0, 0, tw, th, GL_RGBA, GL_UNSIGNED_BYTE, dst + off);
+		}
+	}
+
+	GLenum err = glGetError();
+	eglMakeCurrent(display, EGL_NO_SURFACE, EGL_NO_SURFACE, EGL_NO_CONTEXT);
+	if (err != GL_NO_ERROR) {
+		snprintf(errbuf, sizeof errbuf, "OpenGL error 0x%x", err);
+		return errbuf;
+	}
+	return NULL;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/NicoNex/prism/lut"
+)
+
+var (
+	// mu serializes the use of the context, current on one thread at a
+	// time.
+	mu sync.Mutex
+	// initErr is the error of creating the context, if any.
+	initErr = sync.OnceValue(func() error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if msg := C.gpuInit(); msg != nil {
+			return fmt.Errorf("%w: %s", ErrUnavailable, C.GoString(msg))
+		}
+		return nil
+	})
+)
+
+// Available reports whether a GPU context can be created.
+func Available() bool {
+	return initErr() == nil
+}
+
+func apply(l lut.Lattice, img image.Image, intensity float64) (*image.RGBA, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := initErr(); err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Stride != 4*b.Dx() {
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+	dst := image.NewRGBA(b)
+	if b.Empty() {
+		return dst, nil
+	}
+
+	flat := flatten(l)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	msg := C.gpuApply((*C.float)(unsafe.Pointer(&flat[0])), C.int(l.Size()),
+		(*C.uint8_t)(unsafe.Pointer(&src.Pix[0])), (*C.uint8_t)(unsafe.Pointer(&dst.Pix[0])),
+		C.int(dst.Stride), C.int(b.Dx()), C.int(b.Dy()), C.float(intensity))
+	if msg != nil {
+		return nil, errors.New(C.GoString(msg))
+	}
+	return dst, nil
+}
+
+// flatten returns the points of l, red changing fastest, as RGB triplets.
+func flatten(l lut.Lattice) []float32 {
+	n := l.Size()
+	flat := make([]float32, 0, 3*n*n*n)
+	for b := range n {
+		for g := range n {
+			for r := range n {
+				pr, pg, pb := l.Point(r, g, b)
+				flat = append(flat, float32(pr), float32(pg), float32(pb))
+			}
+		}
+	}
+	return flat
+}
@@ -0,0 +1,63 @@
+// Package gpu applies LUTs to images on the GPU: the lattice is uploaded
+// as a 3D texture and sampled with the hardware trilinear filtering.
+//
+// The backend uses OpenGL ES 3 through EGL, without a window, and is
+// built with cgo under the gpu build tag:
+//
+//	go build -tags gpu ./cmd/prism
+//
+// Without the tag, or when no EGL display can be opened, Apply fails with
+// ErrUnavailable and callers grade on the CPU instead. Filtering in
+// hardware works with a few bits of precision on the weights, so results
+// may differ from those of the CPU by a level of 8 bits.
+package gpu
+
+import (
+	"errors"
+	"image"
+
+	"github.com/NicoNex/prism/lut"
+)
+
+var (
+	// ErrUnavailable is returned when prism is built without the gpu
+	// tag or no GPU context can be created.
+	ErrUnavailable = errors.New("GPU backend unavailable")
+	// ErrUnsupported is returned for options the GPU backend does not
+	// implement, which the CPU applies.
+	ErrUnsupported = errors.New("options not supported on the GPU")
+)
+
+// Supported reports whether Apply grades with the lattice and options
+// of a: trilinear interpolation at any intensity mixed in RGB, rounding
+// to nearest, with no transforms, dithering, masks or video range.
+func Supported(a *lut.Applier) bool {
+	opt := a.Options()
+	if v, ok := a.Lattice().(lut.VideoRange); ok {
+		if in, out := v.VideoRange(); in || out {
+			return false
+		}
+	}
+	return opt.Interpolation == lut.Trilinear &&
+		opt.Rounding == lut.RoundNearest &&
+		opt.Dither == lut.DitherNone &&
+		(opt.Mix == lut.MixRGB || opt.Intensity >= 1) &&
+		opt.Input == nil && opt.Output == nil &&
+		!opt.Linear && !opt.Extended && !opt.VideoRange &&
+		opt.Mask == nil && opt.LumaRange == nil && opt.Under == nil &&
+		opt.Component == lut.ComponentAll
+}
+
+// Apply applies the LUT compiled in a to the opaque image img, into an
+// 8-bit image as a.Apply does, failing with ErrUnsupported for options
+// or images the backend does not handle and with ErrUnavailable when
+// there is no GPU.
+func Apply(a *lut.Applier, img image.Image) (*image.RGBA, error) {
+	if !Supported(a) {
+		return nil, ErrUnsupported
+	}
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+		return nil, ErrUnsupported
+	}
+	return apply(a.Lattice(), img, a.Options().Intensity)
+}
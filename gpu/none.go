@@ -0,0 +1,18 @@
+//go:build !(gpu && linux && cgo)
+
+package gpu
+
+import (
+	"image"
+
+	"github.com/NicoNex/prism/lut"
+)
+
+// Available reports whether a GPU context can be created.
+func Available() bool {
+	return false
+}
+
+func apply(lut.Lattice, image.Image, float64) (*image.RGBA, error) {
+	return nil, ErrUnavailable
+}
@@ -0,0 +1,36 @@
+package hald
+
+import (
+	"errors"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/lut"
+)
+
+var ErrInvalidLevel = errors.New("HALD level must be between 2 and 16")
+
+// FromCube renders the CUBE c as a HALD of the given level, from 2 to 16,
+// with 16 bits per channel, titled after c. Encode it with Bits set to 8
+// for an 8-bit image.
+func FromCube(c cube.Cube, level int) (HALD, error) {
+	if level < 2 || level > 16 {
+		return HALD{}, ErrInvalidLevel
+	}
+
+	h, err := New(c.Apply(identity16(level), lut.WithOutputDepth(16)))
+	if err != nil {
+		return HALD{}, err
+	}
+	if c.Title != "" {
+		h.Text = map[string]string{TextTitle: c.Title}
+	}
+	return h, nil
+}
+
+// ToCube samples the HALD into a CUBE with size points per axis over the
+// [0, 1] domain, as cube.FromLUT does, titled after the HALD.
+func (h HALD) ToCube(size int) cube.Cube {
+	c := cube.FromLUT(h, size)
+	c.Title = h.Text[TextTitle]
+	return c
+}
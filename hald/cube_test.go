@@ -0,0 +1,46 @@
+package hald
+
+import (
+	"math"
+	"testing"
+)
+
+func TestToCubeIdentity(t *testing.T) {
+	tests := []struct {
+		level, bits, size int
+		tol               float64
+	}{
+		{level: 2, bits: 8, size: 2, tol: 0.5 / 255},
+		{level: 4, bits: 8, size: 17, tol: 0.5 / 255},
+		{level: 8, bits: 8, size: 33, tol: 0.5 / 255},
+		{level: 4, bits: 16, size: 17, tol: 0.5 / 65535},
+		{level: 12, bits: 16, size: 65, tol: 0.5 / 65535},
+	}
+
+	for _, tt := range tests {
+		id, err := IdentityDepth(tt.level, tt.bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id.Text = map[string]string{TextTitle: "identity"}
+
+		c := id.ToCube(tt.size)
+		if c.LUT3Dsize != tt.size || len(c.Samples) != tt.size*tt.size*tt.size {
+			t.Fatalf("level %d: got %d samples of size %d, want size %d", tt.level, len(c.Samples), c.LUT3Dsize, tt.size)
+		}
+		if c.Title != "identity" {
+			t.Errorf("level %d: got title %q, want %q", tt.level, c.Title, "identity")
+		}
+
+		last := float64(tt.size - 1)
+		for p, s := range c.All() {
+			want := [3]float64{float64(p[0]) / last, float64(p[1]) / last, float64(p[2]) / last}
+			got := [3]float64{s.R, s.G, s.B}
+			for i := range got {
+				if math.Abs(got[i]-want[i]) > tt.tol {
+					t.Fatalf("level %d, %d bits, size %d: sample %v = %v, want %v", tt.level, tt.bits, tt.size, p, got, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package hald
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"github.com/NicoNex/prism/tiff"
+)
+
+// Container is the image format a HALD is stored in.
+type Container int
+
+const (
+	// PNG stores HALDs as PNG images, the format every HALD tool reads.
+	PNG Container = iota
+	// TIFF stores HALDs as uncompressed TIFF images, for pipelines
+	// built around TIFF.
+	TIFF
+)
+
+// Encoder writes HALD images. The zero value writes PNGs keeping the
+// depth of the image with the default compression.
+type Encoder struct {
+	// CompressionLevel trades encoding speed for size: BestCompression
+	// suits HALDs exported to be shared, BestSpeed previews. TIFFs are
+	// written uncompressed.
+	CompressionLevel png.CompressionLevel
+	// Bits per channel, 8 or 16, or 0 for the depth of the image.
+	Bits int
+	// Container is the image format, PNG by default. Only PNGs hold the
+	// Text of HALDs.
+	Container Container
+}
+
+// Encode writes the HALD image h to w
+func (e *Encoder) Encode(w io.Writer, h HALD) error {
+	img := h.Image
+	switch e.Bits {
+	case 0:
+	case 8:
+		if _, ok := img.(*image.RGBA); !ok {
+			rgba := image.NewRGBA(img.Bounds())
+			draw.Draw(rgba, rgba.Rect, img, rgba.Rect.Min, draw.Src)
+			img = rgba
+		}
+	case 16:
+		if _, ok := img.(*image.RGBA64); !ok {
+			rgba := image.NewRGBA64(img.Bounds())
+			draw.Draw(rgba, rgba.Rect, img, rgba.Rect.Min, draw.Src)
+			img = rgba
+		}
+	default:
+		return ErrInvalidBits
+	}
+
+	switch e.Container {
+	case PNG:
+		if len(h.Text) > 0 {
+			chunks, err := textChunks(h.Text)
+			if err != nil {
+				return err
+			}
+			w = &textWriter{w: w, chunks: chunks}
+		}
+		return (&png.Encoder{CompressionLevel: e.CompressionLevel}).Encode(w, img)
+	case TIFF:
+		return tiff.Encode(w, img)
+	default:
+		return ErrInvalidContainer
+	}
+}
+
+// countWriter counts the bytes written through it, for WriteTo.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,27 +1,61 @@
 package hald
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
-	"image/png"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"io/fs"
 	"math"
 	"os"
+	"runtime"
+	"slices"
 	"sync"
+
+	"github.com/NicoNex/prism/lut"
 )
 
 type HALD struct {
+	// Image holds the samples. HALDs made by New and Load read it once
+	// into a flat buffer that Interpolate looks up: change the samples
+	// with SetSample, as changes to the image are not seen.
 	image.Image
+	// Text is the textual metadata of the HALD by keyword, such as
+	// TextTitle, read from and written to the text chunks of PNGs.
+	Text map[string]string
+	// Workers is the number of goroutines blends spread the rows of the
+	// HALD over, GOMAXPROCS if zero.
+	Workers int
+
 	level int
+	// samples holds the RGB values of the samples in lattice order, red
+	// changing fastest, or is nil to read them from Image.
+	samples  []uint16
+	warnings []lut.Warning
 }
 
 var (
 	ErrInvalidDimensions = errors.New("invalid HALD image dimensions")
 	ErrNilImage          = errors.New("image is nil")
 	ErrDifferentLevels   = errors.New("different HALD levels")
+	ErrInvalidWeights    = errors.New("invalid blend weights")
+	ErrNoHALDs           = errors.New("no HALDs to blend")
+	ErrInvalidBits       = errors.New("bits per channel must be 8 or 16")
+	ErrInvalidContainer  = errors.New("unknown HALD container")
 )
 
+// New returns the HALD LUT held by img, which must be a square of
+// level³ pixels on each side
+func New(img image.Image) (HALD, error) {
+	return newHALD(img)
+}
+
 // newHALD creates a HALD from an image after validating dimensions
 func newHALD(img image.Image) (HALD, error) {
 	if img == nil {
@@ -40,22 +74,134 @@ func newHALD(img image.Image) (HALD, error) {
 		return HALD{}, ErrInvalidDimensions
 	}
 
-	return HALD{Image: img, level: level}, nil
+	return HALD{Image: img, level: level, samples: flatten(img)}, nil
+}
+
+// flatten returns the RGB values of the pixels of img, row by row, which
+// is the lattice order of the samples of a HALD.
+func flatten(img image.Image) []uint16 {
+	b := img.Bounds()
+	flat := make([]uint16, 0, 3*b.Dx()*b.Dy())
+	if img, ok := img.(image.RGBA64Image); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := img.RGBA64At(x, y)
+				flat = append(flat, c.R, c.G, c.B)
+			}
+		}
+		return flat
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			flat = append(flat, uint16(r), uint16(g), uint16(b))
+		}
+	}
+	return flat
 }
 
 // sample retrieves the color at the given 3D cube coordinates
-// r, g, b should be in range [0, level-1]
+// r, g, b should be in range [0, level²-1]
 func (h HALD) sample(r, g, b int) color.Color {
+	x, y := h.pixel(r, g, b)
+	return h.Image.At(x, y)
+}
+
+// point returns the RGB values in range [0, 1] of the sample at the given
+// 3D cube coordinates, from the flat buffer if there is one.
+func (h HALD) point(r, g, b int) (float64, float64, float64) {
+	if h.samples == nil {
+		return colorToFloat64(h.sample(r, g, b))
+	}
+	cube := h.level * h.level
+	return h.at((b*cube+g)*cube + r)
+}
+
+// at returns the RGB values in range [0, 1] of the i-th sample in lattice
+// order, which is the i-th pixel of the image row by row.
+func (h HALD) at(i int) (float64, float64, float64) {
+	if h.samples == nil {
+		size := h.level * h.level * h.level
+		min := h.Image.Bounds().Min
+		return colorToFloat64(h.Image.At(min.X+i%size, min.Y+i/size))
+	}
+	s := h.samples[3*i : 3*i+3 : 3*i+3]
+	return float64(s[0]) / 65535.0, float64(s[1]) / 65535.0, float64(s[2]) / 65535.0
+}
+
+// rows splits the rows from 0 to n in contiguous chunks, one per
+// goroutine up to workers, or GOMAXPROCS if workers is below 1, and
+// calls fn on each chunk from y0 to y1.
+func rows(n, workers int, fn func(y0, y1 int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	chunk := max(1, (n+workers-1)/workers)
+
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < n; y0 += chunk {
+		y1 := min(y0+chunk, n)
+		wg.Go(func() { fn(y0, y1) })
+	}
+	wg.Wait()
+}
+
+// pixel returns the coordinates in the image of the sample at the given
+// 3D cube coordinates, red changing fastest.
+func (h HALD) pixel(r, g, b int) (x, y int) {
 	N := h.level
 	cube := N * N
 	size := N * N * N
 
 	idx := b*cube*cube + g*cube + r
-	x := idx % size
-	y := idx / size
-
 	min := h.Image.Bounds().Min
-	return h.Image.At(min.X+x, min.Y+y)
+	return min.X + idx%size, min.Y + idx/size
+}
+
+// SampleAt returns the sample at the given 3D cube coordinates, each in
+// the range [0, Size()-1].
+func (h HALD) SampleAt(r, g, b int) color.Color {
+	return h.sample(r, g, b)
+}
+
+// SetSample sets the sample at the given 3D cube coordinates, each in
+// the range [0, Size()-1], to c. The first call copies images that
+// cannot be changed in place to an RGBA image, or to an RGBA64 one for
+// 16-bit images so that they keep their precision. HALDs copied from h
+// before that share its samples.
+func (h *HALD) SetSample(r, g, b int, c color.Color) {
+	x, y := h.pixel(r, g, b)
+	img := h.mutable()
+	img.Set(x, y, c)
+	if cube := h.level * h.level; h.samples != nil && r >= 0 && g >= 0 && b >= 0 && r < cube && g < cube && b < cube {
+		// Store the color as the image does, rounded to its precision.
+		i := 3 * ((b*cube+g)*cube + r)
+		R, G, B, _ := img.At(x, y).RGBA()
+		h.samples[i], h.samples[i+1], h.samples[i+2] = uint16(R), uint16(G), uint16(B)
+	}
+}
+
+// mutable returns the image of the HALD as an RGBA or RGBA64 image,
+// replacing it with a copy the first time if it is not one.
+func (h *HALD) mutable() draw.Image {
+	switch img := h.Image.(type) {
+	case *image.RGBA:
+		return img
+	case *image.RGBA64:
+		return img
+	}
+
+	var img draw.Image
+	switch h.Image.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		img = image.NewRGBA64(h.Image.Bounds())
+	default:
+		img = image.NewRGBA(h.Image.Bounds())
+	}
+	draw.Draw(img, img.Bounds(), h.Image, img.Bounds().Min, draw.Src)
+	h.Image = img
+	h.samples = slices.Clone(h.samples)
+	return img
 }
 
 // colorToFloat64 converts an image color to float64 RGB values in range [0, 1]
@@ -65,21 +211,14 @@ func colorToFloat64(c color.Color) (r, g, b float64) {
 	return float64(rVal) / 65535.0, float64(gVal) / 65535.0, float64(bVal) / 65535.0
 }
 
-// interpolateSamples linearly interpolates between two colors
-func interpolateSamples(c1, c2 color.Color, t float64) (r, g, b float64) {
-	r1, g1, b1 := colorToFloat64(c1)
-	r2, g2, b2 := colorToFloat64(c2)
-
-	return r1 + t*(r2-r1), g1 + t*(g2-g1), b1 + t*(b2-b1)
-}
-
 // Interpolate performs trilinear interpolation in the 3D HALD LUT
 func (h HALD) Interpolate(r, g, b float64) (float64, float64, float64) {
 	cubeF := float64(h.level*h.level - 1) // N² - 1
 
-	rIdx := r * cubeF
-	gIdx := g * cubeF
-	bIdx := b * cubeF
+	// Colors out of [0, 1] look up the nearest edge of the lattice.
+	rIdx := clampIndex(r*cubeF, cubeF)
+	gIdx := clampIndex(g*cubeF, cubeF)
+	bIdx := clampIndex(b*cubeF, cubeF)
 
 	r0 := int(math.Floor(rIdx))
 	r1 := min(r0+1, h.level*h.level-1)
@@ -92,19 +231,10 @@ func (h HALD) Interpolate(r, g, b float64) (float64, float64, float64) {
 	gFrac := gIdx - float64(g0)
 	bFrac := bIdx - float64(b0)
 
-	c000 := h.sample(r0, g0, b0)
-	c001 := h.sample(r0, g0, b1)
-	c010 := h.sample(r0, g1, b0)
-	c011 := h.sample(r0, g1, b1)
-	c100 := h.sample(r1, g0, b0)
-	c101 := h.sample(r1, g0, b1)
-	c110 := h.sample(r1, g1, b0)
-	c111 := h.sample(r1, g1, b1)
-
-	c00r, c00g, c00b := interpolateSamples(c000, c100, rFrac)
-	c01r, c01g, c01b := interpolateSamples(c001, c101, rFrac)
-	c10r, c10g, c10b := interpolateSamples(c010, c110, rFrac)
-	c11r, c11g, c11b := interpolateSamples(c011, c111, rFrac)
+	c00r, c00g, c00b := h.interpolateSamples(r0, r1, g0, b0, rFrac)
+	c01r, c01g, c01b := h.interpolateSamples(r0, r1, g0, b1, rFrac)
+	c10r, c10g, c10b := h.interpolateSamples(r0, r1, g1, b0, rFrac)
+	c11r, c11g, c11b := h.interpolateSamples(r0, r1, g1, b1, rFrac)
 
 	c0r, c0g, c0b := lerp(c00r, c00g, c00b, c10r, c10g, c10b, gFrac)
 	c1r, c1g, c1b := lerp(c01r, c01g, c01b, c11r, c11g, c11b, gFrac)
@@ -112,6 +242,23 @@ func (h HALD) Interpolate(r, g, b float64) (float64, float64, float64) {
 	return lerp(c0r, c0g, c0b, c1r, c1g, c1b, bFrac)
 }
 
+// interpolateSamples linearly interpolates along red between the samples
+// at r0 and r1
+func (h HALD) interpolateSamples(r0, r1, g, b int, t float64) (float64, float64, float64) {
+	ra, ga, ba := h.point(r0, g, b)
+	rb, gb, bb := h.point(r1, g, b)
+	return lerp(ra, ga, ba, rb, gb, bb, t)
+}
+
+// clampIndex limits the lattice index v to the range [0, last], mapping
+// NaN to 0.
+func clampIndex(v, last float64) float64 {
+	if !(v > 0) {
+		return 0
+	}
+	return min(v, last)
+}
+
 // lerp linearly interpolates between two RGB values
 func lerp(r1, g1, b1, r2, g2, b2, t float64) (float64, float64, float64) {
 	return r1 + t*(r2-r1), g1 + t*(g2-g1), b1 + t*(b2-b1)
@@ -124,132 +271,261 @@ func min[T int | float64](a, b T) T {
 	return b
 }
 
-func max[T int | float64](a, b T) T {
-	if a > b {
-		return a
-	}
-	return b
+// lattice exposes a HALD as a lut.Lattice.
+type lattice struct {
+	HALD
+}
+
+func (l lattice) Size() int {
+	return l.level * l.level
 }
 
-// Apply applies the HALD LUT to an image with full intensity (1.0)
-func (h HALD) Apply(img image.Image) *image.RGBA {
-	return h.ApplyScaled(img, 1.0)
+func (l lattice) Point(r, g, b int) (float64, float64, float64) {
+	return l.point(r, g, b)
+}
+
+// Compile prepares the HALD LUT to be applied to images with the given options.
+func (h HALD) Compile(opt lut.Options) *lut.Applier {
+	return lut.Compile(lattice{h}, opt)
+}
+
+// Apply applies the HALD LUT to an image with the options set by opts,
+// at full intensity with an 8-bit *image.RGBA result by default
+func (h HALD) Apply(img image.Image, opts ...lut.ApplyOption) image.Image {
+	return lut.Apply(lattice{h}, img, opts...)
 }
 
 // ApplyScaled applies the HALD LUT to an image with adjustable intensity
+//
+// Deprecated: Use Apply with lut.WithIntensity.
 func (h HALD) ApplyScaled(img image.Image, intensity float64) *image.RGBA {
-	bounds := img.Bounds()
-	out := image.NewRGBA(bounds)
-
-	// Clamp intensity to [0, 1]
-	intensity = max(0, min(1, intensity))
+	return h.Compile(lut.Options{Intensity: intensity}).Apply(img)
+}
 
-	var wg sync.WaitGroup
+// ApplyContext applies the HALD LUT to an image with the given options,
+// returning early with the context error once ctx is done
+func (h HALD) ApplyContext(ctx context.Context, img image.Image, opt lut.Options) (*image.RGBA, error) {
+	return h.Compile(opt).ApplyContext(ctx, img)
+}
 
-	// Process each row in parallel
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		wg.Add(1)
-		go func(y int) {
-			defer wg.Done()
-			h.processRowScaled(img, out, bounds, y, intensity)
-		}(y)
-	}
+// ApplyInto applies the HALD LUT to an image with the given options,
+// writing the result to dst instead of allocating a new image. See
+// lut.Applier.ApplyInto; frames sharing the options are best graded
+// with a single Compile
+func (h HALD) ApplyInto(dst *image.RGBA, img image.Image, opt lut.Options) error {
+	return h.Compile(opt).ApplyInto(dst, img)
+}
 
-	wg.Wait()
-	return out
+// Apply16 applies the HALD LUT to an image with full intensity, keeping
+// 16 bits per channel from the input samples to the output
+//
+// Deprecated: Use Apply with lut.WithOutputDepth(16).
+func (h HALD) Apply16(img image.Image) *image.RGBA64 {
+	return h.Compile(lut.Options{Intensity: 1}).Apply16(img)
 }
 
-// processRowScaled processes a single row of the image with intensity blending
-func (h HALD) processRowScaled(img image.Image, out *image.RGBA, bounds image.Rectangle, y int, intensity float64) {
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-		r, g, b, a := img.At(x, y).RGBA()
+// Blend does a weighted blend of the HALD with other, of any format,
+// using the two intensities i1 and i2 provided in input, which must add
+// up to a positive total. LUTs other than HALDs of the same level are
+// sampled on the lattice of h first. The result has 16 bits per
+// channel, and its rows are blended on h.Workers goroutines.
+func (h HALD) Blend(other lut.LUT, i1, i2 float64) (lut.LUT, error) {
+	total := i1 + i2
+	if total <= 0 {
+		return nil, ErrInvalidWeights
+	}
+	h2, ok := other.(HALD)
+	if !ok || h2.level != h.level {
+		h2 = render(other, h.level)
+	}
 
-		// Convert from uint32 (0-65535) to float64 (0-1)
-		rNorm := float64(r) / 65535.0
-		gNorm := float64(g) / 65535.0
-		bNorm := float64(b) / 65535.0
+	size := h.level * h.level * h.level
+	blended := image.NewRGBA64(image.Rect(0, 0, size, size))
+	samples := make([]uint16, 3*size*size)
 
-		// Apply HALD using trilinear interpolation
-		resultR, resultG, resultB := h.Interpolate(rNorm, gNorm, bNorm)
+	w1 := i1 / total
+	w2 := i2 / total
 
-		// Blend between original (identity) and HALD result
-		blendedR := rNorm*(1-intensity) + resultR*intensity
-		blendedG := gNorm*(1-intensity) + resultG*intensity
-		blendedB := bNorm*(1-intensity) + resultB*intensity
+	rows(size, h.Workers, func(y0, y1 int) {
+		for i := y0 * size; i < y1*size; i++ {
+			r1, g1, b1 := h.at(i)
+			r2, g2, b2 := h2.at(i)
 
-		// Clamp to [0, 1]
-		blendedR = max(0, min(1, blendedR))
-		blendedG = max(0, min(1, blendedG))
-		blendedB = max(0, min(1, blendedB))
+			// Blend the colors
+			c := color.RGBA64{
+				R: to16(r1*w1 + r2*w2),
+				G: to16(g1*w1 + g2*w2),
+				B: to16(b1*w1 + b2*w2),
+				A: 0xffff,
+			}
+			blended.SetRGBA64(i%size, i/size, c)
+			samples[3*i], samples[3*i+1], samples[3*i+2] = c.R, c.G, c.B
+		}
+	})
 
-		// Convert back to uint8
-		out.SetRGBA(x, y, color.RGBA{
-			R: uint8(blendedR * 255),
-			G: uint8(blendedG * 255),
-			B: uint8(blendedB * 255),
-			A: uint8(a / 257), // Convert from uint32 to uint8
-		})
-	}
+	return HALD{Image: blended, Workers: h.Workers, level: h.level, samples: samples}, nil
 }
 
-// Blend does a weighted blend of two HALDs using the two intensities
-// i1 and i2 provided in input.
-func (h *HALD) Blend(h2 HALD, i1, i2 float64) (*HALD, error) {
-	// Validate levels match
-	if h.level != h2.level {
-		return h, ErrDifferentLevels
-	}
+// to16 converts v, clamped to the range [0, 1], to a 16-bit channel.
+func to16(v float64) uint16 {
+	return uint16(math.Round(max(0, min(1, v)) * 0xffff))
+}
 
-	bounds := h.Image.Bounds()
-	blended := image.NewRGBA(bounds)
+// render samples l on the lattice of a HALD of the given level, with 16
+// bits per channel.
+func render(l lut.LUT, level int) HALD {
+	cube := level * level
+	size := cube * level
+	img := image.NewRGBA64(image.Rect(0, 0, size, size))
+	samples := make([]uint16, 3*size*size)
+	den := float64(cube - 1)
 
-	total := i1 + i2
-	w1 := i1 / total
-	w2 := i2 / total
+	for b := range cube {
+		for g := range cube {
+			for r := range cube {
+				idx := b*cube*cube + g*cube + r
+				R, G, B := l.Interpolate(float64(r)/den, float64(g)/den, float64(b)/den)
+				c := color.RGBA64{R: to16(R), G: to16(G), B: to16(B), A: 0xffff}
+				img.SetRGBA64(idx%size, idx/size, c)
+				samples[3*idx], samples[3*idx+1], samples[3*idx+2] = c.R, c.G, c.B
+			}
+		}
+	}
+	return HALD{Image: img, level: level, samples: samples}
+}
 
-	// Blend each pixel
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c1 := h.Image.At(x, y)
-			c2 := h2.Image.At(x, y)
+// BlendAll does a weighted blend of any number of HALDs of the same
+// level, each weighted by the intensity at the same index of weights
+// over the sum of the weights. Like Blend, the result has 16 bits per
+// channel.
+func BlendAll(halds []HALD, weights []float64) (*HALD, error) {
+	return BlendAllIn(halds, weights, lut.MixRGB)
+}
 
-			r1, g1, b1 := colorToFloat64(c1)
-			r2, g2, b2 := colorToFloat64(c2)
+// BlendAllIn blends the HALDs like BlendAll, averaging their samples in
+// the color space of mix. The rows are spread over the Workers of the
+// first HALD.
+func BlendAllIn(halds []HALD, weights []float64, mix lut.Mix) (*HALD, error) {
+	if len(halds) == 0 {
+		return nil, ErrNoHALDs
+	}
+	if len(weights) != len(halds) {
+		return nil, ErrInvalidWeights
+	}
 
-			// Blend the colors
-			r := r1*w1 + r2*w2
-			g := g1*w1 + g2*w2
-			b := b1*w1 + b2*w2
-
-			blended.SetRGBA(x, y, color.RGBA{
-				R: uint8(r * 255),
-				G: uint8(g * 255),
-				B: uint8(b * 255),
-				A: 255,
-			})
+	var total float64
+	for i, h := range halds {
+		if h.level != halds[0].level {
+			return nil, ErrDifferentLevels
 		}
+		total += weights[i]
 	}
-
-	result, err := newHALD(blended)
-	if err != nil {
-		return h, err
+	if total <= 0 {
+		return nil, ErrInvalidWeights
 	}
 
-	return &result, nil
+	level := halds[0].level
+	size := level * level * level
+	blended := image.NewRGBA64(image.Rect(0, 0, size, size))
+	samples := make([]uint16, 3*size*size)
+
+	// Blend each pixel, at the same offset from the origin of each HALD
+	rows(size, halds[0].Workers, func(y0, y1 int) {
+		for i := y0 * size; i < y1*size; i++ {
+			var r, g, b float64
+			for j, h := range halds {
+				hr, hg, hb := mix.To(h.at(i))
+				w := weights[j] / total
+				r, g, b = r+hr*w, g+hg*w, b+hb*w
+			}
+			r, g, b = mix.From(r, g, b)
+
+			c := color.RGBA64{R: to16(r), G: to16(g), B: to16(b), A: 0xffff}
+			blended.SetRGBA64(i%size, i/size, c)
+			samples[3*i], samples[3*i+1], samples[3*i+2] = c.R, c.G, c.B
+		}
+	})
+
+	return &HALD{Image: blended, Workers: halds[0].Workers, level: level, samples: samples}, nil
 }
 
-// WriteTo writes the HALD image as PNG to the given writer
+// WriteTo writes the HALD image as PNG to the given writer, with the
+// parameters of the zero Encoder
 func (h HALD) WriteTo(w io.Writer) (int64, error) {
-	return 0, png.Encode(w, h.Image)
+	cw := &countWriter{w: w}
+	err := (&Encoder{}).Encode(cw, h)
+	return cw.n, err
+}
+
+// EncodeOptions are the parameters HALDs are written with.
+//
+// Deprecated: Use Encoder.
+type EncodeOptions = Encoder
+
+// Encode writes the HALD image to w with the given options, nil for the
+// defaults
+func (h HALD) Encode(w io.Writer, o *EncodeOptions) error {
+	if o == nil {
+		o = &Encoder{}
+	}
+	return o.Encode(w, h)
 }
 
 // Identity creates a neutral/identity HALD of the given level.
 // An identity HALD returns each input color unchanged.
 func Identity(level int) HALD {
+	return identity8(level)
+}
+
+// IdentityDepth creates an identity HALD of the given level with 8 or 16
+// bits per channel: an *image.RGBA or an *image.NRGBA64. HALDs of level 12
+// and above have more samples per axis than 8 bits tell apart, so LUTs
+// applied to a 16-bit identity keep the precision of their lattice.
+func IdentityDepth(level, bits int) (HALD, error) {
+	switch bits {
+	case 8:
+		return identity8(level), nil
+	case 16:
+		return identity16(level), nil
+	}
+	return HALD{}, ErrInvalidBits
+}
+
+// identity16 creates an identity HALD of the given level with 16 bits
+// per channel.
+func identity16(level int) HALD {
+	cube := level * level
+	size := cube * level
+	img := image.NewNRGBA64(image.Rect(0, 0, size, size))
+	samples := make([]uint16, 3*size*size)
+	den := float64(cube - 1)
+
+	for b := range cube {
+		for g := range cube {
+			for r := range cube {
+				idx := b*cube*cube + g*cube + r
+				c := color.NRGBA64{
+					R: to16(float64(r) / den),
+					G: to16(float64(g) / den),
+					B: to16(float64(b) / den),
+					A: 0xffff,
+				}
+				img.SetNRGBA64(idx%size, idx/size, c)
+				samples[3*idx], samples[3*idx+1], samples[3*idx+2] = c.R, c.G, c.B
+			}
+		}
+	}
+	return HALD{Image: img, level: level, samples: samples}
+}
+
+// identity8 creates an identity HALD of the given level with 8 bits per
+// channel.
+func identity8(level int) HALD {
 	N := level
 	cube := N * N     // samples per axis
 	size := N * N * N // image width & height
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	samples := make([]uint16, 3*size*size)
 	den := float64(cube - 1)
 
 	for b := 0; b < cube; b++ {
@@ -259,27 +535,81 @@ func Identity(level int) HALD {
 				x := idx % size
 				y := idx / size
 
-				R := uint8((float64(r) / den) * 255.0)
-				G := uint8((float64(g) / den) * 255.0)
-				B := uint8((float64(b) / den) * 255.0)
+				R := uint8(math.Round(float64(r) / den * 255.0))
+				G := uint8(math.Round(float64(g) / den * 255.0))
+				B := uint8(math.Round(float64(b) / den * 255.0))
 				img.SetRGBA(x, y, color.RGBA{R: R, G: G, B: B, A: 255})
+				samples[3*idx], samples[3*idx+1], samples[3*idx+2] = uint16(R)*0x101, uint16(G)*0x101, uint16(B)*0x101
 			}
 		}
 	}
-	return HALD{Image: img, level: N}
+	return HALD{Image: img, level: N, samples: samples}
 }
 
-// Load reads a HALD LUT from a PNG image reader
+// Limits are the maxima LoadLimits enforces on its input, so that HALDs
+// from untrusted sources cannot make it allocate unbounded memory. Zero
+// fields set no limit.
+type Limits struct {
+	// MaxDimension is the largest width and height of the image, in
+	// pixels.
+	MaxDimension int
+}
+
+// DefaultLimits are the limits of Load: images of up to 4096 pixels a
+// side, as HALDs of level 16 are.
+var DefaultLimits = Limits{MaxDimension: 4096}
+
+// Load reads a HALD LUT from a PNG, JPEG or TIFF image reader, with the
+// metadata of the text chunks of PNGs, within DefaultLimits
 func Load(r io.Reader) (HALD, error) {
-	img, err := png.Decode(r)
+	return LoadLimits(r, DefaultLimits)
+}
+
+// LoadLimits reads a HALD LUT from a PNG, JPEG or TIFF image reader,
+// failing with an error wrapping lut.ErrLimit on images beyond lim
+// before decoding them
+func LoadLimits(r io.Reader, lim Limits) (HALD, error) {
+	if lim.MaxDimension > 0 {
+		// Read the dimensions from the header, then decode from its copy.
+		var head bytes.Buffer
+		cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+		if err != nil {
+			return HALD{}, err
+		}
+		if cfg.Width > lim.MaxDimension || cfg.Height > lim.MaxDimension {
+			return HALD{}, fmt.Errorf("%w: %dx%d image, at most %d pixels a side", lut.ErrLimit, cfg.Width, cfg.Height, lim.MaxDimension)
+		}
+		r = io.MultiReader(&head, r)
+	}
+
+	var text textReader
+	img, format, err := image.Decode(io.TeeReader(r, &text))
 	if err != nil {
 		return HALD{}, err
 	}
 
-	return newHALD(img)
+	h, err := newHALD(img)
+	if err != nil {
+		return HALD{}, err
+	}
+	h.Text = text.text
+	if text.invalid {
+		h.warn("malformed PNG text chunk ignored")
+	}
+	if format == "jpeg" {
+		h.warn("JPEG image, lossy compression reduces LUT precision")
+	}
+
+	if _, ok := img.(*image.Paletted); ok {
+		h.warn("paletted image, LUT precision is reduced")
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		h.warn("image has transparent pixels, alpha is ignored")
+	}
+	return h, nil
 }
 
-// LoadFile reads a HALD LUT from a PNG file
+// LoadFile reads a HALD LUT from a PNG, JPEG or TIFF file
 func LoadFile(path string) (HALD, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -290,6 +620,34 @@ func LoadFile(path string) (HALD, error) {
 	return Load(f)
 }
 
+// LoadFS reads a HALD LUT from the image file at path in fsys, such as
+// LUTs bundled with an embed.FS.
+func LoadFS(fsys fs.FS, path string) (HALD, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return HALD{}, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Warnings returns the non-fatal issues found while loading the LUT.
+func (h HALD) Warnings() []lut.Warning {
+	return h.warnings
+}
+
+// warn records a non-fatal issue found while loading.
+func (h *HALD) warn(msg string) {
+	h.warnings = append(h.warnings, lut.Warning{Message: msg})
+}
+
+// Size returns the number of grid points per axis, the square of the
+// level.
+func (h HALD) Size() int {
+	return h.level * h.level
+}
+
 // Level returns the HALD level of this LUT
 func (h HALD) Level() int {
 	return h.level
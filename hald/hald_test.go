@@ -0,0 +1,60 @@
+package hald
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/NicoNex/prism/lut"
+)
+
+func TestApplyLevel1(t *testing.T) {
+	// A 1x1 PNG is a HALD of level 1, whose lattice is a single point.
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.Set(0, 0, color.NRGBA{51, 102, 153, 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	h, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Level() != 1 {
+		t.Fatalf("got level %d, want 1", h.Level())
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	want := color.RGBA{51, 102, 153, 0xff}
+	for _, depth := range []lut.Depth{lut.DepthFloat, lut.Depth8} {
+		got := h.Apply(img, lut.WithOptions(lut.Options{Intensity: 1, Depth: depth})).(*image.RGBA)
+		for y := range 16 {
+			for x := range 16 {
+				if c := got.RGBAAt(x, y); c != want {
+					t.Fatalf("depth %d: pixel (%d, %d) = %v, want %v", depth, x, y, c, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBlendWeights(t *testing.T) {
+	h := Identity(2)
+	for _, w := range [][2]float64{{0, 0}, {1, -1}, {-1, -2}} {
+		if _, err := h.Blend(h, w[0], w[1]); !errors.Is(err, ErrInvalidWeights) {
+			t.Errorf("%v: got %v, want %v", w, err, ErrInvalidWeights)
+		}
+	}
+	if _, err := h.Blend(h, 0, 1); err != nil {
+		t.Errorf("0, 1: got %v, want no error", err)
+	}
+}
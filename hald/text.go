@@ -0,0 +1,239 @@
+package hald
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keywords of the text metadata prism reads and writes. Title,
+// Description and Software are keywords of the PNG specification.
+const (
+	TextTitle       = "Title"
+	TextDescription = "Description"
+	TextSoftware    = "Software"
+	// TextSources names the LUTs a HALD was made from, comma separated.
+	TextSources = "Sources"
+	// TextWeights holds the weights the sources were blended with, comma
+	// separated in the order of TextSources.
+	TextWeights = "Weights"
+)
+
+const (
+	pngSignature = "\x89PNG\r\n\x1a\n"
+	// ihdrEnd is the offset of the first chunk after IHDR, which holds
+	// 13 bytes of data.
+	ihdrEnd = len(pngSignature) + 8 + 13 + 4
+	// maxTextChunk is the largest text chunk read, so that a corrupt
+	// length does not allocate the memory it claims.
+	maxTextChunk = 1 << 20
+)
+
+var ErrInvalidKeyword = errors.New("PNG text keywords must be 1 to 79 printable ASCII characters")
+
+// textReader collects the text chunks of the PNG stream written to it,
+// skipping the other chunks. Load tees the stream it decodes into it.
+type textReader struct {
+	text map[string]string
+	// Bytes collected of the signature, chunk header or text chunk.
+	buf  []byte
+	want int
+	// Bytes left of a skipped chunk.
+	skip int
+	// Type of the text chunk being collected, or "" for a signature or
+	// chunk header.
+	typ  string
+	sig  bool
+	done bool
+	// invalid records a malformed text chunk.
+	invalid bool
+}
+
+func (t *textReader) Write(p []byte) (int, error) {
+	n := len(p)
+	if !t.sig && t.want == 0 {
+		t.want = len(pngSignature)
+	}
+	for len(p) > 0 && !t.done {
+		if t.skip > 0 {
+			k := min(t.skip, len(p))
+			t.skip -= k
+			p = p[k:]
+			continue
+		}
+		k := min(t.want-len(t.buf), len(p))
+		t.buf = append(t.buf, p[:k]...)
+		p = p[k:]
+		if len(t.buf) < t.want {
+			break
+		}
+		t.next()
+	}
+	return n, nil
+}
+
+// next handles the complete signature, header or chunk in t.buf.
+func (t *textReader) next() {
+	defer func() { t.buf = t.buf[:0] }()
+
+	switch {
+	case !t.sig:
+		t.sig = true
+		t.done = string(t.buf) != pngSignature
+		t.want = 8
+
+	case t.typ == "":
+		n := int(binary.BigEndian.Uint32(t.buf))
+		typ := string(t.buf[4:8])
+		switch {
+		case typ == "IEND":
+			t.done = true
+		case (typ == "tEXt" || typ == "zTXt" || typ == "iTXt") && n <= maxTextChunk:
+			t.typ = typ
+			t.want = n + 4
+		default:
+			t.skip = n + 4
+		}
+
+	default:
+		data := t.buf[:len(t.buf)-4]
+		if crc32.ChecksumIEEE(append([]byte(t.typ), data...)) != binary.BigEndian.Uint32(t.buf[len(data):]) {
+			t.invalid = true
+		} else if key, val, ok := parseText(t.typ, data); ok {
+			if t.text == nil {
+				t.text = make(map[string]string)
+			}
+			t.text[key] = val
+		} else {
+			t.invalid = true
+		}
+		t.typ = ""
+		t.want = 8
+	}
+}
+
+// parseText decodes the keyword and text of a tEXt, zTXt or iTXt chunk.
+func parseText(typ string, data []byte) (key, val string, ok bool) {
+	k, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(k) == 0 {
+		return "", "", false
+	}
+	key = latin1(k)
+
+	switch typ {
+	case "tEXt":
+		return key, latin1(rest), true
+
+	case "zTXt":
+		if len(rest) == 0 || rest[0] != 0 {
+			return "", "", false
+		}
+		text, err := inflate(rest[1:])
+		return key, latin1(text), err == nil
+
+	default:
+		// Compression flag and method, language tag and translated
+		// keyword precede the UTF-8 text.
+		if len(rest) < 2 {
+			return "", "", false
+		}
+		compressed := rest[0] == 1
+		_, rest, ok1 := bytes.Cut(rest[2:], []byte{0})
+		_, text, ok2 := bytes.Cut(rest, []byte{0})
+		if !ok1 || !ok2 {
+			return "", "", false
+		}
+		if compressed {
+			var err error
+			if text, err = inflate(text); err != nil {
+				return "", "", false
+			}
+		}
+		return key, string(text), utf8.Valid(text)
+	}
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxTextChunk))
+}
+
+// latin1 converts the Latin-1 text b to UTF-8.
+func latin1(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String()
+}
+
+// appendText appends to buf a chunk holding the text val under key: a
+// tEXt chunk for ASCII text, an iTXt chunk otherwise.
+func appendText(buf []byte, key, val string) ([]byte, error) {
+	if len(key) == 0 || len(key) > 79 || strings.ContainsFunc(key, func(r rune) bool { return r < ' ' || r > '~' }) {
+		return nil, ErrInvalidKeyword
+	}
+
+	typ := "tEXt"
+	data := append([]byte(key), 0)
+	if strings.ContainsFunc(val, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		// Uncompressed, without language tag or translated keyword.
+		typ = "iTXt"
+		data = append(data, 0, 0, 0, 0)
+	}
+	data = append(data, val...)
+
+	start := len(buf)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, typ...)
+	buf = append(buf, data...)
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start+4:])), nil
+}
+
+// textChunks returns the chunks holding text, sorted by keyword.
+func textChunks(text map[string]string) ([]byte, error) {
+	var buf []byte
+	for _, key := range slices.Sorted(maps.Keys(text)) {
+		var err error
+		if buf, err = appendText(buf, key, text[key]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// textWriter writes a PNG stream to w, inserting chunks right after the
+// IHDR chunk.
+type textWriter struct {
+	w      io.Writer
+	chunks []byte
+	pos    int
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	if t.pos >= ihdrEnd {
+		return t.w.Write(p)
+	}
+	k := min(ihdrEnd-t.pos, len(p))
+	n, err := t.w.Write(p[:k])
+	t.pos += n
+	if err != nil || t.pos < ihdrEnd {
+		return n, err
+	}
+	if _, err := t.w.Write(t.chunks); err != nil {
+		return n, err
+	}
+	m, err := t.w.Write(p[k:])
+	return n + m, err
+}
@@ -0,0 +1,226 @@
+package icc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// jpegMarker identifies the APP2 segments carrying an ICC profile.
+	jpegMarker = "ICC_PROFILE\x00"
+	// jpegChunk is the largest profile chunk fitting a JPEG segment.
+	jpegChunk = 0xffff - 2 - len(jpegMarker) - 2
+
+	pngSignature = "\x89PNG\r\n\x1a\n"
+)
+
+// ExtractJPEG returns the ICC profile embedded in the APP2 segments of a
+// JPEG stream.
+func ExtractJPEG(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xff || soi[1] != 0xd8 {
+		return nil, ErrNoProfile
+	}
+
+	// Profiles larger than a segment are split in numbered chunks.
+	chunks := map[byte][]byte{}
+	var count byte
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, err
+		}
+
+		// Start of scan or end of image, no more metadata past this point.
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		// Standalone markers carry no length.
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			continue
+		}
+
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		n := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if n < 0 {
+			return nil, ErrInvalidProfile
+		}
+
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return nil, err
+		}
+
+		hdr := len(jpegMarker) + 2
+		if marker == 0xe2 && len(seg) >= hdr && string(seg[:len(jpegMarker)]) == jpegMarker {
+			chunks[seg[hdr-2]] = seg[hdr:]
+			count = seg[hdr-1]
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil, ErrNoProfile
+	}
+
+	var data []byte
+	for i := range count {
+		chunk, ok := chunks[i+1]
+		if !ok {
+			return nil, ErrInvalidProfile
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// nextMarker skips to the next JPEG marker and returns its code.
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, ErrInvalidProfile
+	}
+	// Markers may be preceded by any number of fill bytes.
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// InsertJPEG writes the JPEG stream jpg to w adding APP2 segments holding
+// the ICC profile right after the SOI marker and any APP0/APP1 segments.
+func InsertJPEG(w io.Writer, jpg, profile []byte) error {
+	if len(jpg) < 2 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return ErrInvalidProfile
+	}
+
+	// Keep JFIF and EXIF segments first, as readers expect them there.
+	pos := 2
+	for pos+4 <= len(jpg) && jpg[pos] == 0xff && (jpg[pos+1] == 0xe0 || jpg[pos+1] == 0xe1) {
+		pos += 2 + int(binary.BigEndian.Uint16(jpg[pos+2:]))
+	}
+	pos = min(pos, len(jpg))
+
+	count := (len(profile) + jpegChunk - 1) / jpegChunk
+	if count > 255 {
+		return ErrInvalidProfile
+	}
+
+	if _, err := w.Write(jpg[:pos]); err != nil {
+		return err
+	}
+	for i := range count {
+		chunk := profile[i*jpegChunk : min((i+1)*jpegChunk, len(profile))]
+		n := len(chunk) + len(jpegMarker) + 4
+
+		seg := []byte{0xff, 0xe2, byte(n >> 8), byte(n)}
+		seg = append(seg, jpegMarker...)
+		seg = append(seg, byte(i+1), byte(count))
+		seg = append(seg, chunk...)
+		if _, err := w.Write(seg); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(jpg[pos:])
+	return err
+}
+
+// ExtractPNG returns the ICC profile stored in the iCCP chunk of a PNG
+// stream.
+func ExtractPNG(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(br, sig); err != nil {
+		return nil, err
+	}
+	if string(sig) != pngSignature {
+		return nil, ErrNoProfile
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[:4])
+		typ := string(hdr[4:])
+
+		// The profile must precede the image data.
+		if typ == "IDAT" || typ == "IEND" {
+			return nil, ErrNoProfile
+		}
+		if typ != "iCCP" {
+			if _, err := br.Discard(int(n) + 4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+
+		// Profile name, null separator and compression method.
+		i := bytes.IndexByte(data, 0)
+		if i < 0 || i+2 > len(data) || data[i+1] != 0 {
+			return nil, ErrInvalidProfile
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[i+2:]))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+}
+
+// InsertPNG writes the PNG stream img to w adding an iCCP chunk holding
+// the ICC profile right after the IHDR chunk.
+func InsertPNG(w io.Writer, img, profile []byte) error {
+	// Signature and IHDR chunk, with its 13 bytes of data.
+	ihdr := len(pngSignature) + 8 + 13 + 4
+	if len(img) < ihdr || string(img[:len(pngSignature)]) != pngSignature {
+		return ErrInvalidProfile
+	}
+
+	var data bytes.Buffer
+	data.WriteString("ICC profile\x00\x00")
+	zw := zlib.NewWriter(&data)
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(data.Len()))
+	chunk = append(chunk, "iCCP"...)
+	chunk = append(chunk, data.Bytes()...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	for _, b := range [][]byte{img[:ihdr], chunk, img[ihdr:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
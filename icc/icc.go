@@ -0,0 +1,336 @@
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/NicoNex/prism/colorspace"
+)
+
+var (
+	ErrNoProfile      = errors.New("no ICC profile")
+	ErrInvalidProfile = errors.New("invalid ICC profile")
+	ErrUnsupported    = errors.New("unsupported ICC profile: only RGB matrix/TRC profiles are supported")
+)
+
+// curveSize is the number of points tone curves are tabulated on.
+const curveSize = 4096
+
+// Profile is an RGB matrix/TRC ICC profile.
+type Profile struct {
+	// Data holds the raw profile, as found in the image.
+	Data []byte
+	// Description is the profile description, if any.
+	Description string
+
+	toSRGB   colorspace.Matrix
+	fromSRGB colorspace.Matrix
+	trc      [3]curve
+}
+
+// Parse parses a raw ICC profile. Only RGB profiles described by
+// primaries and tone reproduction curves are supported, which covers
+// the common working spaces such as Adobe RGB, Display P3 and ProPhoto.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, ErrInvalidProfile
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, ErrUnsupported
+	}
+
+	tags, err := tagTable(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Profile{Data: data}
+	if desc, ok := tags["desc"]; ok {
+		p.Description = description(desc)
+	}
+
+	// The colorant tags are the columns of the matrix converting linear
+	// RGB to XYZ in the D50 profile connection space.
+	var toXYZ colorspace.Matrix
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag, ok := tags[sig]
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		xyz, err := readXYZ(tag)
+		if err != nil {
+			return nil, err
+		}
+		for i := range 3 {
+			toXYZ[i][c] = xyz[i]
+		}
+	}
+
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tag, ok := tags[sig]
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		if p.trc[c], err = readCurve(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	p.toSRGB = colorspace.XYZD50ToSRGB.Mul(toXYZ)
+	inv, ok := p.toSRGB.Invert()
+	if !ok {
+		return nil, ErrInvalidProfile
+	}
+	p.fromSRGB = inv
+	return p, nil
+}
+
+// ToSRGB converts a normalised color from the profile space to sRGB.
+// Colors outside of the sRGB gamut are clipped.
+func (p *Profile) ToSRGB(r, g, b float64) (float64, float64, float64) {
+	r, g, b = p.toSRGB.Apply(p.trc[0].eval(r), p.trc[1].eval(g), p.trc[2].eval(b))
+	return colorspace.LinearToSRGB(clamp(r)),
+		colorspace.LinearToSRGB(clamp(g)),
+		colorspace.LinearToSRGB(clamp(b))
+}
+
+// FromSRGB converts a normalised sRGB color to the profile space.
+func (p *Profile) FromSRGB(r, g, b float64) (float64, float64, float64) {
+	r, g, b = p.fromSRGB.Apply(
+		colorspace.SRGBToLinear(clamp(r)),
+		colorspace.SRGBToLinear(clamp(g)),
+		colorspace.SRGBToLinear(clamp(b)),
+	)
+	return p.trc[0].invert(r), p.trc[1].invert(g), p.trc[2].invert(b)
+}
+
+// IsSRGB reports whether the profile is equivalent to sRGB, in which
+// case no conversion is needed.
+func (p *Profile) IsSRGB() bool {
+	const tolerance = 0.002
+
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(p.toSRGB[i][j]-colorspace.Identity[i][j]) > tolerance {
+				return false
+			}
+		}
+	}
+	for _, c := range p.trc {
+		for v := 0.1; v < 1; v += 0.1 {
+			if math.Abs(c.eval(v)-colorspace.SRGBToLinear(v)) > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tagTable returns the tag data of the profile indexed by signature.
+func tagTable(data []byte) (map[string][]byte, error) {
+	n := uint64(binary.BigEndian.Uint32(data[128:]))
+	if 132+n*12 > uint64(len(data)) {
+		return nil, ErrInvalidProfile
+	}
+
+	tags := make(map[string][]byte, n)
+	for i := range n {
+		entry := data[132+i*12:]
+		off := uint64(binary.BigEndian.Uint32(entry[4:]))
+		size := uint64(binary.BigEndian.Uint32(entry[8:]))
+		if off+size > uint64(len(data)) {
+			return nil, ErrInvalidProfile
+		}
+		tags[string(entry[:4])] = data[off : off+size]
+	}
+	return tags, nil
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// readXYZ decodes an XYZType tag.
+func readXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, ErrInvalidProfile
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// description decodes a textDescriptionType (v2) or
+// multiLocalizedUnicodeType (v4) tag.
+func description(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[:4]) {
+	case "desc":
+		n := uint64(binary.BigEndian.Uint32(tag[8:]))
+		if 12+n > uint64(len(tag)) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		// Use the first record.
+		n := uint64(binary.BigEndian.Uint32(tag[20:]))
+		off := uint64(binary.BigEndian.Uint32(tag[24:]))
+		if off+n > uint64(len(tag)) {
+			return ""
+		}
+		u := make([]uint16, n/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(tag[off+uint64(i)*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	}
+	return ""
+}
+
+// curve is a tone reproduction curve decoding normalised values to
+// linear light, tabulated on evenly spaced points over [0, 1].
+type curve []float64
+
+// tabulate samples f on curveSize points.
+func tabulate(f func(float64) float64) curve {
+	c := make(curve, curveSize)
+	for i := range c {
+		c[i] = f(float64(i) / (curveSize - 1))
+	}
+	return c
+}
+
+// eval returns the linear value of the encoded value v.
+func (c curve) eval(v float64) float64 {
+	v = clamp(v) * float64(len(c)-1)
+	i := min(int(v), len(c)-2)
+	return c[i] + (v-float64(i))*(c[i+1]-c[i])
+}
+
+// invert returns the encoded value of the linear value v. The curve is
+// assumed to be non-decreasing.
+func (c curve) invert(v float64) float64 {
+	last := len(c) - 1
+	if v <= c[0] {
+		return 0
+	}
+	if v >= c[last] {
+		return 1
+	}
+
+	i, _ := slices.BinarySearch(c, v)
+	lo, hi := c[i-1], c[i]
+	t := 0.0
+	if hi > lo {
+		t = (v - lo) / (hi - lo)
+	}
+	return (float64(i-1) + t) / float64(last)
+}
+
+// readCurve decodes a curveType or parametricCurveType tag.
+func readCurve(tag []byte) (curve, error) {
+	if len(tag) < 12 {
+		return nil, ErrInvalidProfile
+	}
+
+	switch string(tag[:4]) {
+	case "curv":
+		n := uint64(binary.BigEndian.Uint32(tag[8:]))
+		if 12+n*2 > uint64(len(tag)) {
+			return nil, ErrInvalidProfile
+		}
+
+		switch n {
+		case 0:
+			return tabulate(func(x float64) float64 { return x }), nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return tabulate(func(x float64) float64 { return math.Pow(x, gamma) }), nil
+		}
+
+		points := make(curve, n)
+		for i := range points {
+			points[i] = float64(binary.BigEndian.Uint16(tag[12+i*2:])) / 65535
+		}
+		return tabulate(points.eval), nil
+
+	case "para":
+		return readParametric(tag)
+
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// readParametric decodes a parametricCurveType tag.
+func readParametric(tag []byte) (curve, error) {
+	params := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+
+	typ := binary.BigEndian.Uint16(tag[8:])
+	n, ok := params[typ]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if len(tag) < 12+n*4 {
+		return nil, ErrInvalidProfile
+	}
+
+	// Parameters g, a, b, c, d, e, f in the order of the specification.
+	var p [7]float64
+	for i := range n {
+		p[i] = s15Fixed16(tag[12+i*4:])
+	}
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(x float64) float64 {
+		return math.Pow(max(0, a*x+b), g)
+	}
+
+	var fn func(float64) float64
+	switch typ {
+	case 0:
+		fn = func(x float64) float64 { return math.Pow(x, g) }
+	case 1:
+		fn = func(x float64) float64 {
+			if x >= -b/a {
+				return pow(x)
+			}
+			return 0
+		}
+	case 2:
+		fn = func(x float64) float64 {
+			if x >= -b/a {
+				return pow(x) + c
+			}
+			return c
+		}
+	case 3:
+		fn = func(x float64) float64 {
+			if x >= d {
+				return pow(x)
+			}
+			return c * x
+		}
+	case 4:
+		fn = func(x float64) float64 {
+			if x >= d {
+				return pow(x) + e
+			}
+			return c*x + f
+		}
+	}
+	return tabulate(fn), nil
+}
+
+func clamp(v float64) float64 {
+	return max(0, min(1, v))
+}
@@ -0,0 +1,112 @@
+package icc
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/NicoNex/prism/colorspace"
+)
+
+// srgbCurvePoints is the number of points of the tone curve of the sRGB
+// profile, enough for 16-bit outputs to round trip.
+const srgbCurvePoints = 1024
+
+// srgbProfile builds the sRGB profile once.
+var srgbProfile = sync.OnceValue(func() []byte {
+	var (
+		d50   = [3]float64{0.9642, 1, 0.8249}
+		desc  = "sRGB IEC61966-2.1"
+		curve = make([]uint16, srgbCurvePoints)
+	)
+	for i := range curve {
+		v := colorspace.SRGBToLinear(float64(i) / (srgbCurvePoints - 1))
+		curve[i] = uint16(math.Round(v * 0xffff))
+	}
+
+	be := binary.BigEndian
+	xyz := func(v [3]float64) []byte {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for _, c := range v {
+			tag = be.AppendUint32(tag, uint32(int32(math.Round(c*0x10000))))
+		}
+		return tag
+	}
+	colorant := func(c int) []byte {
+		m := colorspace.SRGBToXYZD50
+		return xyz([3]float64{m[0][c], m[1][c], m[2][c]})
+	}
+
+	// textDescriptionType, with empty Unicode and ScriptCode records.
+	descTag := []byte("desc\x00\x00\x00\x00")
+	descTag = be.AppendUint32(descTag, uint32(len(desc)+1))
+	descTag = append(descTag, desc...)
+	descTag = append(descTag, make([]byte, 1+4+4+2+1+67)...)
+
+	curvTag := []byte("curv\x00\x00\x00\x00")
+	curvTag = be.AppendUint32(curvTag, srgbCurvePoints)
+	for _, v := range curve {
+		curvTag = be.AppendUint16(curvTag, v)
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", descTag},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(d50)},
+		{"rXYZ", colorant(0)},
+		{"gXYZ", colorant(1)},
+		{"bXYZ", colorant(2)},
+		{"rTRC", curvTag},
+		{"gTRC", curvTag},
+		{"bTRC", curvTag},
+	}
+
+	// Header, then the tag table and the tag data, each tag aligned to 4
+	// bytes. The three tone curves share their data.
+	data := make([]byte, 128)
+	data = be.AppendUint32(data, uint32(len(tags)))
+	table := len(data)
+	data = append(data, make([]byte, 12*len(tags))...)
+
+	var curvOff int
+	for i, tag := range tags {
+		off := len(data)
+		if tag.sig == "gTRC" || tag.sig == "bTRC" {
+			off = curvOff
+		} else {
+			if tag.sig == "rTRC" {
+				curvOff = off
+			}
+			data = append(data, tag.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		entry := data[table+12*i:]
+		copy(entry, tag.sig)
+		be.PutUint32(entry[4:], uint32(off))
+		be.PutUint32(entry[8:], uint32(len(tag.data)))
+	}
+
+	be.PutUint32(data, uint32(len(data)))
+	be.PutUint32(data[8:], 0x02100000)
+	copy(data[12:], "mntrRGB XYZ ")
+	// Creation date, 2026-01-01.
+	be.PutUint16(data[24:], 2026)
+	be.PutUint16(data[26:], 1)
+	be.PutUint16(data[28:], 1)
+	copy(data[36:], "acsp")
+	// Illuminant of the profile connection space.
+	copy(data[68:], xyz(d50)[8:])
+	return data
+})
+
+// SRGB returns an ICC v2 profile describing sRGB, small enough to be
+// embedded in every output: tagging graded images with it keeps color
+// managed applications from assuming a different space.
+func SRGB() []byte {
+	return append([]byte(nil), srgbProfile()...)
+}
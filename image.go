@@ -0,0 +1,141 @@
+package prism
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NicoNex/prism/dng"
+	"github.com/NicoNex/prism/jpegenc"
+	"github.com/NicoNex/prism/lut"
+	"github.com/NicoNex/prism/tiff"
+)
+
+// ErrUnsupportedImage is returned by Encode for image formats it does
+// not write.
+var ErrUnsupportedImage = errors.New("unsupported output format")
+
+// EncodeOptions are the parameters of the image encoders. The zero value
+// holds their defaults.
+type EncodeOptions struct {
+	JPEG           jpegenc.EncodeOptions
+	PNGCompression png.CompressionLevel
+}
+
+// DecodeImage decodes the image read from r and returns it with the name
+// of its format. Camera raw files are told by the extension of name and
+// rendered.
+func DecodeImage(r io.Reader, name string) (image.Image, string, error) {
+	if strings.ToLower(filepath.Ext(name)) == ".dng" {
+		img, err := dng.Decode(r)
+		return img, "dng", err
+	}
+	return image.Decode(r)
+}
+
+// Encode encodes img to w in the format named format: png, jpeg, tiff or
+// gif. A nil opt uses the default parameters.
+func Encode(w io.Writer, img image.Image, format string, opt *EncodeOptions) error {
+	if opt == nil {
+		opt = &EncodeOptions{}
+	}
+	switch format {
+	case "png":
+		return (&png.Encoder{CompressionLevel: opt.PNGCompression}).Encode(w, img)
+	case "jpeg":
+		return jpegenc.Encode(w, img, &opt.JPEG)
+	case "tiff":
+		return tiff.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("%w %s", ErrUnsupportedImage, format)
+	}
+}
+
+// ImageFormat returns the image format to encode path with, told by its
+// extension, falling back to def when the extension is not recognized.
+func ImageFormat(path, def string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".tif", ".tiff":
+		return "tiff"
+	case ".gif":
+		return "gif"
+	default:
+		return def
+	}
+}
+
+// Apply grades img with the LUT l compiled with opt, with 8 or 16 bits
+// per channel.
+func Apply(img image.Image, l lut.LUT, opt lut.Options, bits int) image.Image {
+	return ApplyCompiled(l.Compile(opt), img, bits)
+}
+
+// ApplyCompiled grades img with the compiled LUT a, with 8 or 16 bits
+// per channel, so that a LUT compiled once grades many images. Images
+// storing colors with straight alpha, as PNGs with an alpha channel
+// decode, keep it.
+func ApplyCompiled(a *lut.Applier, img image.Image, bits int) image.Image {
+	switch {
+	case bits == 16 && HasStraightAlpha(img):
+		return a.ApplyNRGBA64(img)
+	case bits == 16:
+		return a.Apply16(img)
+	case HasStraightAlpha(img):
+		return a.ApplyNRGBA(img)
+	default:
+		return a.Apply(img)
+	}
+}
+
+// HasStraightAlpha reports whether img stores colors without
+// premultiplied alpha, as PNGs with an alpha channel decode.
+func HasStraightAlpha(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA, *image.NRGBA64:
+		return true
+	default:
+		return false
+	}
+}
+
+// ApplyFile grades the image at in with the LUT l compiled with opt and
+// writes it to out, in the format told by its extension or else in that
+// of the input. Raw files default to PNG.
+func ApplyFile(l lut.LUT, in, out string, opt lut.Options, enc *EncodeOptions) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, format, err := DecodeImage(f, in)
+	if err != nil {
+		return err
+	}
+	if format == "dng" {
+		format = "png"
+	}
+	format = ImageFormat(out, format)
+
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	err = Encode(w, Apply(img, l, opt, 8), format, enc)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package jpegenc
+
+import "bufio"
+
+// huffCode is the Huffman code of a symbol.
+type huffCode struct {
+	code uint16
+	size uint8
+}
+
+// coder codes the symbols of a scan. Without a bit writer it only counts
+// them, to build the Huffman tables of the scan.
+type coder struct {
+	bw *bitWriter
+	// Symbol frequencies and codes by class, DC or AC, and table. The
+	// frequencies have room for the reserved symbol of buildTable.
+	freq  [2][2][257]int64
+	codes [2][2][256]huffCode
+}
+
+// emit codes the symbol sym of the given class and table followed by the
+// n low bits of extra.
+func (c *coder) emit(class, table int, sym byte, extra uint32, n uint) {
+	if c.bw == nil {
+		c.freq[class][table][sym]++
+		return
+	}
+	h := c.codes[class][table][sym]
+	c.bw.write(uint32(h.code), uint(h.size))
+	if n > 0 {
+		c.bw.write(extra, n)
+	}
+}
+
+// used reports whether any symbol of the table was counted.
+func (c *coder) used(class, table int) bool {
+	for _, f := range c.freq[class][table] {
+		if f > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTable returns the optimal Huffman table of the symbol frequencies
+// as the number of codes of each length, from 1 to 16, and the symbols
+// by increasing code length, following section K.2 of the JPEG
+// specification. A reserved symbol keeps any code from being all ones.
+func buildTable(freq [257]int64) (bits [17]byte, vals []byte) {
+	freq[256] = 1
+	var (
+		codesize [257]int
+		others   [257]int
+	)
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// Merge the two least frequent trees, the one of the larger
+		// symbol first on ties.
+		c1, c2 := -1, -1
+		for i, f := range freq {
+			if f == 0 {
+				continue
+			}
+			switch {
+			case c1 < 0 || f <= freq[c1]:
+				c1, c2 = i, c1
+			case c2 < 0 || f <= freq[c2]:
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var count [258]int
+	longest := 0
+	for _, size := range codesize {
+		if size > 0 {
+			count[size]++
+			longest = max(longest, size)
+		}
+	}
+	// Shorten the codes longer than 16 bits.
+	for i := longest; i > 16; i-- {
+		for count[i] > 0 {
+			j := i - 2
+			for count[j] == 0 {
+				j--
+			}
+			count[i] -= 2
+			count[i-1]++
+			count[j+1] += 2
+			count[j]--
+		}
+	}
+	// Drop the reserved symbol, the last of the longest codes.
+	i := 16
+	for count[i] == 0 {
+		i--
+	}
+	count[i]--
+
+	for i := 1; i <= 16; i++ {
+		bits[i] = byte(count[i])
+	}
+	for size := 1; size <= longest; size++ {
+		for sym := range 256 {
+			if codesize[sym] == size {
+				vals = append(vals, byte(sym))
+			}
+		}
+	}
+	return bits, vals
+}
+
+// huffCodes assigns the canonical codes of a table as described in
+// section C of the JPEG specification.
+func huffCodes(bits [17]byte, vals []byte) (codes [256]huffCode) {
+	code, k := uint16(0), 0
+	for size := 1; size <= 16; size++ {
+		for range bits[size] {
+			codes[vals[k]] = huffCode{code: code, size: uint8(size)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return
+}
+
+// bitWriter writes the entropy coded data of a scan, stuffing a zero
+// byte after each 0xff byte.
+type bitWriter struct {
+	w   *bufio.Writer
+	acc uint32
+	n   uint
+}
+
+// write writes the n low bits of v.
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc = b.acc<<n | v&(1<<n-1)
+	b.n += n
+	for b.n >= 8 {
+		c := byte(b.acc >> (b.n - 8))
+		b.w.WriteByte(c)
+		if c == 0xff {
+			b.w.WriteByte(0)
+		}
+		b.n -= 8
+	}
+}
+
+// flush pads the last byte with ones.
+func (b *bitWriter) flush() {
+	if b.n > 0 {
+		b.write(1<<(8-b.n)-1, 8-b.n)
+	}
+}
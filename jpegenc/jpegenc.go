@@ -0,0 +1,517 @@
+// Package jpegenc encodes JPEG images, baseline or progressive, with a
+// choice of quality and chroma subsampling and Huffman tables optimized
+// for each image.
+package jpegenc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Subsampling is the resolution the chroma is stored at, relative to
+// the luma.
+type Subsampling int
+
+const (
+	// Subsample420 halves the chroma resolution horizontally and
+	// vertically, as most cameras and encoders do.
+	Subsample420 Subsampling = iota
+	// Subsample422 halves the chroma resolution horizontally.
+	Subsample422
+	// Subsample444 keeps the chroma at full resolution, for graphics,
+	// text and saturated edges.
+	Subsample444
+)
+
+func (s Subsampling) String() string {
+	switch s {
+	case Subsample420:
+		return "4:2:0"
+	case Subsample422:
+		return "4:2:2"
+	case Subsample444:
+		return "4:4:4"
+	default:
+		return fmt.Sprintf("Subsampling(%d)", int(s))
+	}
+}
+
+// DefaultQuality is the quality images are encoded at when the options
+// leave it at 0.
+const DefaultQuality = 95
+
+// EncodeOptions are the encoding parameters. The zero value encodes
+// baseline JPEGs at DefaultQuality with 4:2:0 subsampling.
+type EncodeOptions struct {
+	// Quality ranges from 1 to 100, higher is better.
+	Quality int
+	// Progressive writes the image as a sequence of scans, the first
+	// showing a coarse version of the whole image, for web pages
+	// loading over slow connections.
+	Progressive bool
+	// Subsampling of the chroma of color images.
+	Subsampling Subsampling
+}
+
+var (
+	ErrTooLarge           = errors.New("jpegenc: image is larger than 65535 pixels per side")
+	ErrInvalidQuality     = errors.New("jpegenc: quality must be between 1 and 100")
+	ErrInvalidSubsampling = errors.New("jpegenc: invalid subsampling")
+)
+
+// Markers written by the encoder.
+const (
+	markerSOF0 = 0xc0
+	markerSOF2 = 0xc2
+	markerDHT  = 0xc4
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerDQT  = 0xdb
+)
+
+// component is an image component with its quantized coefficients.
+type component struct {
+	id   byte
+	h, v int // Sampling factors.
+	// Quantization and Huffman table indices.
+	tq, table int
+	// Blocks per row and column, covering whole MCUs.
+	bw, bh int
+	// Blocks covering the component alone, as non-interleaved scans
+	// code them.
+	cw, ch int
+	// Quantized coefficients of each block, in zigzag order.
+	blocks [][64]int16
+}
+
+type encoder struct {
+	w            *bufio.Writer
+	comps        []component
+	quant        [][64]byte // In zigzag order.
+	hmax, vmax   int
+	mcusX, mcusY int
+}
+
+// Encode writes img to w as a JPEG with the given options, which may be
+// nil for the defaults. Gray images are written with a single
+// component, all the others in YCbCr.
+func Encode(w io.Writer, img image.Image, o *EncodeOptions) error {
+	if o == nil {
+		o = &EncodeOptions{}
+	}
+	quality := o.Quality
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+	if quality < 1 || quality > 100 {
+		return ErrInvalidQuality
+	}
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return ErrTooLarge
+	}
+
+	e := encoder{w: bufio.NewWriter(w)}
+	e.quant = append(e.quant, scaleQuant(&lumaQuant, quality))
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		e.comps = []component{{id: 1, h: 1, v: 1}}
+	default:
+		var h, v int
+		switch o.Subsampling {
+		case Subsample420:
+			h, v = 2, 2
+		case Subsample422:
+			h, v = 2, 1
+		case Subsample444:
+			h, v = 1, 1
+		default:
+			return ErrInvalidSubsampling
+		}
+		e.quant = append(e.quant, scaleQuant(&chromaQuant, quality))
+		e.comps = []component{
+			{id: 1, h: h, v: v},
+			{id: 2, h: 1, v: 1, tq: 1, table: 1},
+			{id: 3, h: 1, v: 1, tq: 1, table: 1},
+		}
+	}
+	e.layout(b.Dx(), b.Dy())
+	e.transform(img)
+
+	e.w.Write([]byte{0xff, markerSOI})
+	e.writeDQT()
+	e.writeSOF(b.Dx(), b.Dy(), o.Progressive)
+	for _, s := range e.scans(o.Progressive) {
+		e.writeScan(s)
+	}
+	e.w.Write([]byte{0xff, markerEOI})
+	return e.w.Flush()
+}
+
+// layout sizes the MCUs and the blocks of the components of a width x
+// height image.
+func (e *encoder) layout(width, height int) {
+	for _, c := range e.comps {
+		e.hmax, e.vmax = max(e.hmax, c.h), max(e.vmax, c.v)
+	}
+	e.mcusX = (width + 8*e.hmax - 1) / (8 * e.hmax)
+	e.mcusY = (height + 8*e.vmax - 1) / (8 * e.vmax)
+	for i := range e.comps {
+		c := &e.comps[i]
+		c.bw, c.bh = e.mcusX*c.h, e.mcusY*c.v
+		c.cw = ((width*c.h+e.hmax-1)/e.hmax + 7) / 8
+		c.ch = ((height*c.v+e.vmax-1)/e.vmax + 7) / 8
+		c.blocks = make([][64]int16, c.bw*c.bh)
+	}
+}
+
+// transform converts img to the components and stores their quantized
+// DCT coefficients, one row of MCUs at a time. Pixels past the edges
+// repeat the last row and column.
+func (e *encoder) transform(img image.Image) {
+	b := img.Bounds()
+	read, spp := rowReader(img)
+	row := make([]uint8, b.Dx()*spp)
+
+	stripW, stripH := e.mcusX*8*e.hmax, 8*e.vmax
+	planes := make([][]float32, len(e.comps))
+	for i := range planes {
+		planes[i] = make([]float32, stripW*stripH)
+	}
+
+	var blk [64]float32
+	for my := range e.mcusY {
+		for sy := range stripH {
+			read(b.Min.Y+min(my*stripH+sy, b.Dy()-1), row)
+			for x := range stripW {
+				p := row[min(x, b.Dx()-1)*spp:]
+				i := sy*stripW + x
+				if spp == 1 {
+					planes[0][i] = float32(p[0]) - 128
+					continue
+				}
+				r, g, bl := float32(p[0]), float32(p[1]), float32(p[2])
+				planes[0][i] = 0.299*r + 0.587*g + 0.114*bl - 128
+				planes[1][i] = -0.168736*r - 0.331264*g + 0.5*bl
+				planes[2][i] = 0.5*r - 0.418688*g - 0.081312*bl
+			}
+		}
+
+		for ci := range e.comps {
+			c := &e.comps[ci]
+			fx, fy := e.hmax/c.h, e.vmax/c.v
+			scale := 1 / float32(fx*fy)
+			for v := range c.v {
+				for bx := range c.bw {
+					for j := range 8 {
+						for i := range 8 {
+							var sum float32
+							for dy := range fy {
+								base := ((v*8+j)*fy+dy)*stripW + (bx*8+i)*fx
+								for dx := range fx {
+									sum += planes[ci][base+dx]
+								}
+							}
+							blk[j*8+i] = sum * scale
+						}
+					}
+					fdct(&blk)
+					quantize(&c.blocks[(my*c.v+v)*c.bw+bx], &blk, &e.quant[c.tq])
+				}
+			}
+		}
+	}
+}
+
+// rowReader returns a function reading a row of img as 8-bit samples,
+// with the number of samples per pixel: 1 for gray images and 3, RGB,
+// for the others.
+func rowReader(img image.Image) (func(y int, dst []uint8), int) {
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.Gray:
+		return func(y int, dst []uint8) {
+			copy(dst, m.Pix[m.PixOffset(b.Min.X, y):])
+		}, 1
+	case *image.Gray16:
+		return func(y int, dst []uint8) {
+			src := m.Pix[m.PixOffset(b.Min.X, y):]
+			for i := range dst {
+				dst[i] = src[i*2]
+			}
+		}, 1
+	case *image.RGBA:
+		return func(y int, dst []uint8) {
+			src := m.Pix[m.PixOffset(b.Min.X, y):]
+			for x := range b.Dx() {
+				copy(dst[x*3:x*3+3], src[x*4:])
+			}
+		}, 3
+	case *image.RGBA64:
+		return func(y int, dst []uint8) {
+			src := m.Pix[m.PixOffset(b.Min.X, y):]
+			for x := range b.Dx() {
+				dst[x*3], dst[x*3+1], dst[x*3+2] = src[x*8], src[x*8+2], src[x*8+4]
+			}
+		}, 3
+	default:
+		return func(y int, dst []uint8) {
+			for x := range b.Dx() {
+				r, g, bl, _ := img.At(b.Min.X+x, y).RGBA()
+				dst[x*3], dst[x*3+1], dst[x*3+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			}
+		}, 3
+	}
+}
+
+// dctCos holds the DCT basis: dctCos[u][x] is C(u)/2 cos((2x+1)uπ/16).
+var dctCos = func() (t [8][8]float32) {
+	for u := range 8 {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := range 8 {
+			t[u][x] = float32(c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16))
+		}
+	}
+	return
+}()
+
+// fdct computes in place the 2D DCT of an 8x8 block stored by rows.
+func fdct(blk *[64]float32) {
+	var tmp [64]float32
+	for y := range 8 {
+		row := blk[y*8 : y*8+8]
+		for u := range 8 {
+			var sum float32
+			for x, v := range row {
+				sum += dctCos[u][x] * v
+			}
+			tmp[y*8+u] = sum
+		}
+	}
+	for u := range 8 {
+		for v := range 8 {
+			var sum float32
+			for y := range 8 {
+				sum += dctCos[v][y] * tmp[y*8+u]
+			}
+			blk[v*8+u] = sum
+		}
+	}
+}
+
+// quantize divides the coefficients of blk by the quantization table q
+// and stores them in zigzag order in dst. AC coefficients are clamped
+// to the 10 bits baseline JPEGs allow.
+func quantize(dst *[64]int16, blk *[64]float32, q *[64]byte) {
+	for k, n := range zigzag {
+		v := int(math.Round(float64(blk[n] / float32(q[k]))))
+		if k > 0 {
+			v = max(-1023, min(v, 1023))
+		}
+		dst[k] = int16(v)
+	}
+}
+
+// scan is a scan of the coefficients ss to se, in zigzag order, of the
+// given components.
+type scan struct {
+	comps  []int
+	ss, se int
+}
+
+// scans returns the scans the image is written in: a single one for
+// baseline JPEGs and, for progressive ones, the DC coefficients of every
+// component followed by the low and the high frequencies of the luma
+// and the chroma.
+func (e *encoder) scans(progressive bool) []scan {
+	all := make([]int, len(e.comps))
+	for i := range all {
+		all[i] = i
+	}
+	if !progressive {
+		return []scan{{comps: all, ss: 0, se: 63}}
+	}
+
+	scans := []scan{{comps: all, ss: 0, se: 0}, {comps: []int{0}, ss: 1, se: 5}}
+	for i := range e.comps[1:] {
+		scans = append(scans, scan{comps: []int{i + 1}, ss: 1, se: 63})
+	}
+	return append(scans, scan{comps: []int{0}, ss: 6, se: 63})
+}
+
+// marker writes a marker segment.
+func (e *encoder) marker(m byte, data []byte) {
+	n := len(data) + 2
+	e.w.Write([]byte{0xff, m, byte(n >> 8), byte(n)})
+	e.w.Write(data)
+}
+
+func (e *encoder) writeDQT() {
+	var data []byte
+	for i, q := range e.quant {
+		data = append(data, byte(i))
+		data = append(data, q[:]...)
+	}
+	e.marker(markerDQT, data)
+}
+
+func (e *encoder) writeSOF(width, height int, progressive bool) {
+	m := byte(markerSOF0)
+	if progressive {
+		m = markerSOF2
+	}
+	data := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(e.comps))}
+	for _, c := range e.comps {
+		data = append(data, c.id, byte(c.h<<4|c.v), byte(c.tq))
+	}
+	e.marker(m, data)
+}
+
+// writeScan codes the scan twice: first counting its symbols to build
+// optimal Huffman tables, then writing it with them.
+func (e *encoder) writeScan(s scan) {
+	c := &coder{}
+	e.codeScan(c, s)
+
+	var dht []byte
+	for class := range 2 {
+		for table := range 2 {
+			if !c.used(class, table) {
+				continue
+			}
+			bits, vals := buildTable(c.freq[class][table])
+			c.codes[class][table] = huffCodes(bits, vals)
+			dht = append(dht, byte(class<<4|table))
+			dht = append(dht, bits[1:]...)
+			dht = append(dht, vals...)
+		}
+	}
+	e.marker(markerDHT, dht)
+
+	sos := []byte{byte(len(s.comps))}
+	for _, ci := range s.comps {
+		t := byte(e.comps[ci].table)
+		sos = append(sos, e.comps[ci].id, t<<4|t)
+	}
+	e.marker(markerSOS, append(sos, byte(s.ss), byte(s.se), 0))
+
+	c.bw = &bitWriter{w: e.w}
+	e.codeScan(c, s)
+	c.bw.flush()
+}
+
+// blocks calls fn with each block of the scan in coding order: MCU by
+// MCU for interleaved scans and row by row for scans of one component.
+func (e *encoder) blocks(s scan, fn func(ci int, blk *[64]int16)) {
+	if len(s.comps) == 1 {
+		ci := s.comps[0]
+		c := &e.comps[ci]
+		for by := range c.ch {
+			for bx := range c.cw {
+				fn(ci, &c.blocks[by*c.bw+bx])
+			}
+		}
+		return
+	}
+
+	for my := range e.mcusY {
+		for mx := range e.mcusX {
+			for _, ci := range s.comps {
+				c := &e.comps[ci]
+				for v := range c.v {
+					for h := range c.h {
+						fn(ci, &c.blocks[(my*c.v+v)*c.bw+mx*c.h+h])
+					}
+				}
+			}
+		}
+	}
+}
+
+// codeScan codes the coefficients of the scan with c. DC coefficients
+// are coded as differences from the previous block of their component.
+// In progressive AC scans runs of blocks ending with zeros are coded
+// together.
+func (e *encoder) codeScan(c *coder, s scan) {
+	var (
+		pred   = make([]int, len(e.comps))
+		eobrun int
+		table  = e.comps[s.comps[0]].table
+	)
+	flushEOB := func() {
+		if eobrun > 0 {
+			n := bitLen(eobrun) - 1
+			c.emit(1, table, byte(n<<4), uint32(eobrun), n)
+			eobrun = 0
+		}
+	}
+
+	e.blocks(s, func(ci int, blk *[64]int16) {
+		t := e.comps[ci].table
+		if s.ss == 0 {
+			diff := int(blk[0]) - pred[ci]
+			pred[ci] = int(blk[0])
+			v, n := magnitude(diff)
+			c.emit(0, t, byte(n), v, n)
+			if s.se == 0 {
+				return
+			}
+		}
+
+		run := 0
+		for k := max(s.ss, 1); k <= s.se; k++ {
+			if blk[k] == 0 {
+				run++
+				continue
+			}
+			if s.ss > 0 {
+				flushEOB()
+			}
+			for ; run > 15; run -= 16 {
+				c.emit(1, t, 0xf0, 0, 0)
+			}
+			v, n := magnitude(int(blk[k]))
+			c.emit(1, t, byte(run<<4|int(n)), v, n)
+			run = 0
+		}
+		if run == 0 {
+			return
+		}
+		if s.ss == 0 {
+			c.emit(1, t, 0x00, 0, 0)
+			return
+		}
+		if eobrun++; eobrun == 0x7fff {
+			flushEOB()
+		}
+	})
+	flushEOB()
+}
+
+// magnitude returns the bits coding v after its symbol and their
+// number, the size category of v.
+func magnitude(v int) (uint32, uint) {
+	a := v
+	if v < 0 {
+		a, v = -v, v-1
+	}
+	n := bitLen(a)
+	return uint32(v) & (1<<n - 1), n
+}
+
+// bitLen returns the number of bits needed to represent v.
+func bitLen(v int) uint {
+	var n uint
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,183 @@
+package jpegenc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// gradient returns a w x h image of smooth gradients, which JPEG keeps
+// close to the original at high qualities.
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(255 * x / max(1, w-1)),
+				G: uint8(255 * y / max(1, h-1)),
+				B: uint8(128 + 64*math.Sin(float64(x+y)/8)),
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
+
+// psnr returns the peak signal-to-noise ratio of b against a, in dB.
+func psnr(a, b image.Image) float64 {
+	var sum float64
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, _ := a.At(x, y).RGBA()
+			r2, g2, b2, _ := b.At(x, y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(3*r.Dx()*r.Dy())
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	ratios := map[Subsampling]image.YCbCrSubsampleRatio{
+		Subsample420: image.YCbCrSubsampleRatio420,
+		Subsample422: image.YCbCrSubsampleRatio422,
+		Subsample444: image.YCbCrSubsampleRatio444,
+	}
+	tests := []struct {
+		quality int
+		minPSNR float64
+	}{
+		{quality: 1, minPSNR: 0},
+		{quality: 50, minPSNR: 28},
+		{quality: 75, minPSNR: 30},
+		{quality: 95, minPSNR: 34},
+		{quality: 100, minPSNR: 35},
+	}
+	sizes := []image.Point{{1, 1}, {7, 5}, {17, 9}, {33, 31}, {64, 48}, {129, 67}}
+
+	for _, tt := range tests {
+		for sub, ratio := range ratios {
+			for _, progressive := range []bool{false, true} {
+				for _, size := range sizes {
+					name := fmt.Sprintf("q%d/%s/progressive=%t/%dx%d", tt.quality, sub, progressive, size.X, size.Y)
+					t.Run(name, func(t *testing.T) {
+						img := gradient(size.X, size.Y)
+						var buf bytes.Buffer
+						opt := EncodeOptions{Quality: tt.quality, Progressive: progressive, Subsampling: sub}
+						if err := Encode(&buf, img, &opt); err != nil {
+							t.Fatal(err)
+						}
+
+						dec, err := jpeg.Decode(&buf)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if dec.Bounds() != img.Bounds() {
+							t.Fatalf("got bounds %v, want %v", dec.Bounds(), img.Bounds())
+						}
+						ycc, ok := dec.(*image.YCbCr)
+						if !ok {
+							t.Fatalf("got %T, want *image.YCbCr", dec)
+						}
+						if ycc.SubsampleRatio != ratio {
+							t.Errorf("got subsampling %v, want %v", ycc.SubsampleRatio, ratio)
+						}
+						// Images a few pixels wide are mostly edge blocks,
+						// whose error tells little of the quality.
+						if size.X >= 33 {
+							if p := psnr(img, dec); p < tt.minPSNR {
+								t.Errorf("got PSNR %.1f dB, want at least %.1f", p, tt.minPSNR)
+							}
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeGray(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {9, 3}, {31, 17}} {
+		img := image.NewGray(image.Rect(0, 0, size.X, size.Y))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i * 7)
+		}
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, &EncodeOptions{Quality: 100}); err != nil {
+			t.Fatal(err)
+		}
+
+		dec, err := jpeg.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := dec.(*image.Gray); !ok {
+			t.Fatalf("%v: got %T, want *image.Gray", size, dec)
+		}
+		if dec.Bounds() != img.Bounds() {
+			t.Fatalf("got bounds %v, want %v", dec.Bounds(), img.Bounds())
+		}
+	}
+}
+
+func TestEncodeOffsetBounds(t *testing.T) {
+	img := gradient(40, 30).SubImage(image.Rect(3, 5, 36, 26))
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, &EncodeOptions{Quality: 100, Subsampling: Subsample444}); err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dec.Bounds().Size(), img.Bounds().Size(); got != want {
+		t.Fatalf("got size %v, want %v", got, want)
+	}
+	shifted := image.NewRGBA(image.Rect(0, 0, 33, 21))
+	for y := range 21 {
+		for x := range 33 {
+			shifted.Set(x, y, img.At(x+3, y+5))
+		}
+	}
+	if p := psnr(shifted, dec); p < 40 {
+		t.Errorf("got PSNR %.1f dB, want at least 40", p)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		opt  EncodeOptions
+		want error
+	}{
+		{"quality below 1", gradient(8, 8), EncodeOptions{Quality: -1}, ErrInvalidQuality},
+		{"quality above 100", gradient(8, 8), EncodeOptions{Quality: 101}, ErrInvalidQuality},
+		{"subsampling", gradient(8, 8), EncodeOptions{Subsampling: 3}, ErrInvalidSubsampling},
+		{"empty", image.NewRGBA(image.Rect(0, 0, 0, 8)), EncodeOptions{}, ErrTooLarge},
+		{"too wide", image.NewRGBA(image.Rect(0, 0, 0x10000, 1)), EncodeOptions{}, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.img, &tt.opt); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package lut
+
+import "github.com/NicoNex/prism/colorspace"
+
+// Component selects the part of the color the LUT changes, the rest
+// being kept from the original. Lightness and color are separated in
+// Oklab.
+type Component int
+
+const (
+	// ComponentAll keeps the whole LUT result. This is the default.
+	ComponentAll Component = iota
+	// ComponentLuma keeps the lightness of the LUT result and the color
+	// of the original, taking the contrast of a LUT without its cast.
+	ComponentLuma
+	// ComponentChroma keeps the color of the LUT result and the
+	// lightness of the original, taking the cast of a LUT without its
+	// contrast.
+	ComponentChroma
+)
+
+// Merge returns the LUT result (r2, g2, b2) of the original color
+// (r1, g1, b1) restricted to the component.
+func (c Component) Merge(r1, g1, b1, r2, g2, b2 float64) (float64, float64, float64) {
+	if c == ComponentAll {
+		return r2, g2, b2
+	}
+	l1, a1, bb1 := colorspace.SRGBToOklab(r1, g1, b1)
+	l2, a2, bb2 := colorspace.SRGBToOklab(r2, g2, b2)
+	if c == ComponentLuma {
+		return colorspace.OklabToSRGB(l2, a1, bb1)
+	}
+	return colorspace.OklabToSRGB(l1, a2, bb2)
+}
@@ -0,0 +1,100 @@
+package lut
+
+import (
+	"image"
+	"math"
+)
+
+// fixedShift is the number of fractional bits of the fixed-point values
+// interpolated for 8-bit images.
+const fixedShift = 24
+
+// compileFixed flattens the lattice to fixed point and precomputes the
+// grid index and fraction for each possible 8-bit input value.
+func (a *Applier) compileFixed() {
+	const limit = math.MaxInt32 >> fixedShift
+
+	fix := func(v float64) int32 {
+		return int32(math.Round(max(-limit, min(limit, v)) * (1 << fixedShift)))
+	}
+	a.fixed = make([]int32, a.size*a.size*a.size*3)
+	a.points(func(i int, r, g, b float64) {
+		a.fixed[i], a.fixed[i+1], a.fixed[i+2] = fix(r), fix(g), fix(b)
+	})
+	a.grid()
+}
+
+// eightBit reports whether img stores 8-bit RGB samples, which the
+// fixed-point path reads without loss.
+func eightBit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA, *image.NRGBA, *image.Gray, *image.Paletted:
+		return true
+	default:
+		return false
+	}
+}
+
+// processRowFixed processes a single row of an 8-bit image in fixed
+// point, with no blending since the intensity is 1.
+func (a *Applier) processRowFixed(src source, out buffer, y int) {
+	for x := out.rect.Min.X; x < out.rect.Max.X; x++ {
+		a.pixelFixed(src, out, x, y)
+	}
+}
+
+// pixelFixed processes the pixel at (x, y) of an 8-bit image in fixed
+// point. Translucent pixels take the float path: once their alpha is
+// divided out they no longer hold 8-bit samples.
+func (a *Applier) pixelFixed(src source, out buffer, x, y int) {
+	r, g, b, alpha := src(x, y)
+	if alpha != 0xffff {
+		outR, outG, outB := a.color(r, g, b)
+		out.set(x, y, outR, outG, outB, alpha, a.threshold(x, y))
+		return
+	}
+
+	resR, resG, resB := a.interpolateFixed(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	t := int64(a.threshold(x, y) * (1 << fixedShift))
+	i := (y-out.rect.Min.Y)*out.stride + (x-out.rect.Min.X)*4
+	p := out.pix[i : i+4 : i+4]
+	p[0], p[1], p[2] = quantizeFixed(resR, t), quantizeFixed(resG, t), quantizeFixed(resB, t)
+	p[3] = 0xff
+}
+
+// interpolateFixed performs trilinear interpolation of an 8-bit color
+// in the fixed-point lattice.
+func (a *Applier) interpolateFixed(r, g, b uint8) (int32, int32, int32) {
+	n := int32(a.size)
+	r0, g0, b0 := a.idx[r], a.idx[g], a.idx[b]
+	rf, gf, bf := a.fixedFrac[r], a.fixedFrac[g], a.fixedFrac[b]
+
+	// Offsets of the 8 corners in the flattened lattice
+	base := (r0 + g0*n + b0*n*n) * 3
+	dr := int32(3)
+	dg := n * 3
+	db := n * n * 3
+
+	var res [3]int32
+	for ch := range int32(3) {
+		i := base + ch
+		c00 := lerpFixed(a.fixed[i], a.fixed[i+dr], rf)
+		c10 := lerpFixed(a.fixed[i+dg], a.fixed[i+dg+dr], rf)
+		c01 := lerpFixed(a.fixed[i+db], a.fixed[i+db+dr], rf)
+		c11 := lerpFixed(a.fixed[i+db+dg], a.fixed[i+db+dg+dr], rf)
+		res[ch] = lerpFixed(lerpFixed(c00, c10, gf), lerpFixed(c01, c11, gf), bf)
+	}
+	return res[0], res[1], res[2]
+}
+
+// lerpFixed linearly interpolates between two fixed-point values
+func lerpFixed(a, b, t int32) int32 {
+	return a + int32((int64(b)-int64(a))*int64(t)>>fixedShift)
+}
+
+// quantizeFixed converts the fixed-point v, clamped to [0, 1], to 8
+// bits, truncating it after adding the fixed-point threshold t.
+func quantizeFixed(v int32, t int64) uint8 {
+	v = max(0, min(1<<fixedShift, v))
+	return uint8(min(255, (int64(v)*255+t)>>fixedShift))
+}
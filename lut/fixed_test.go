@@ -0,0 +1,25 @@
+package lut
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestFixedMatchesFloat(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 17))
+	img := randomImage(rng, 256, 256)
+	a := Compile(randomLattice(rng, 17), Options{Intensity: 1})
+	if a.fixed == nil {
+		t.Fatal("not compiled to fixed point")
+	}
+
+	// Fixed point rounds a few samples differently from float64, never
+	// by more than one level.
+	largest, n := diff(a.Apply(img).Pix, floatApply(a, img, true))
+	if largest > 1 {
+		t.Errorf("got samples %d levels apart, want at most 1", largest)
+	}
+	if total := len(img.Pix) * 3 / 4; n > total/100 {
+		t.Errorf("got %d of %d samples different, want at most 1%%", n, total)
+	}
+}
@@ -0,0 +1,100 @@
+package lut
+
+import (
+	"image"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// LUT is a loaded LUT of any format, ready to be compiled and applied to
+// images, blended with LUTs of other formats and written back. cube.Cube
+// and hald.HALD implement it, as must the LUTs of the formats added with
+// RegisterFormat.
+type LUT interface {
+	// Size returns the number of grid points per axis of the LUT.
+	Size() int
+	// Interpolate looks up the normalised full range color (r, g, b),
+	// returning the normalised output, as a compiled Applier at full
+	// intensity does.
+	Interpolate(r, g, b float64) (float64, float64, float64)
+	// Compile prepares the LUT to be applied with the given options.
+	Compile(Options) *Applier
+	// Apply applies the LUT to img with the options set by opts, at
+	// full intensity with an 8-bit result by default. Implementations
+	// built on a Lattice can use the Apply function.
+	Apply(img image.Image, opts ...ApplyOption) image.Image
+	// Blend returns the blend of the LUT with other, of any format,
+	// weighted by the intensities i1 and i2, in the format of the LUT.
+	// The LUT and other are left unchanged.
+	Blend(other LUT, i1, i2 float64) (LUT, error)
+	// WriteTo writes the LUT to w in its format.
+	WriteTo(w io.Writer) (int64, error)
+	// Warnings returns the problems found while loading the LUT, which
+	// did not prevent it from loading.
+	Warnings() []Warning
+}
+
+// Format is a LUT format added with RegisterFormat.
+type Format struct {
+	// Ext is the extension of the files of the format, lower case and
+	// with the leading dot, as in ".3dl".
+	Ext string
+	// Sniff reports whether the first bytes of a file are in the
+	// format. It may be nil for formats told by their extension only.
+	Sniff func(head []byte) bool
+	// Load decodes a LUT of the format.
+	Load func(r io.Reader) (LUT, error)
+}
+
+var formats struct {
+	sync.RWMutex
+	list []Format
+}
+
+// RegisterFormat adds a LUT format, so that programs embedding prism can
+// load their own formats wherever CUBEs and HALDs are loaded. Files are
+// told by their contents first, with sniff, which receives up to the
+// first 4 KiB of a file and may be nil, then by their extension ext.
+// Registering an extension again replaces the previous format. It is
+// usually called from an init function.
+func RegisterFormat(ext string, sniff func(head []byte) bool, load func(r io.Reader) (LUT, error)) {
+	ext = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+
+	formats.Lock()
+	defer formats.Unlock()
+	formats.list = slices.DeleteFunc(formats.list, func(f Format) bool { return f.Ext == ext })
+	formats.list = append(formats.list, Format{Ext: ext, Sniff: sniff, Load: load})
+}
+
+// Formats returns the registered formats, in the order they were
+// registered.
+func Formats() []Format {
+	formats.RLock()
+	defer formats.RUnlock()
+	return slices.Clone(formats.list)
+}
+
+// SniffFormat returns the first registered format whose Sniff function
+// recognizes head.
+func SniffFormat(head []byte) (Format, bool) {
+	for _, f := range Formats() {
+		if f.Sniff != nil && f.Sniff(head) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// LookupFormat returns the registered format of the extension ext, with
+// its leading dot, in any case.
+func LookupFormat(ext string) (Format, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range Formats() {
+		if f.Ext == ext {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
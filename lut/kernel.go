@@ -0,0 +1,118 @@
+package lut
+
+import "image"
+
+// kernel returns the function grading row y of img to out with the
+// unrolled fixed-point kernel, reading and writing the pixel buffers
+// directly, or nil when the kernel does not apply: it is selected for
+// *image.RGBA and *image.NRGBA images on the fixed-point path, when not
+// dithering. Rows are then graded by kernelFixed, leaving translucent
+// pixels to the general path when their alpha must be multiplied or
+// divided out.
+func (a *Applier) kernel(img image.Image, src source, out buffer) func(y int) {
+	if a.fixed == nil || a.opt.Dither != DitherNone {
+		return nil
+	}
+
+	var (
+		pix    []uint8
+		stride int
+		rect   image.Rectangle
+		premul bool
+	)
+	switch img := img.(type) {
+	case *image.RGBA:
+		pix, stride, rect, premul = img.Pix, img.Stride, img.Rect, true
+	case *image.NRGBA:
+		pix, stride, rect = img.Pix, img.Stride, img.Rect
+	default:
+		return nil
+	}
+
+	translucent := !premul && !out.premul
+	t := int64(a.rounding() * (1 << fixedShift))
+	w := out.rect.Dx() * 4
+
+	return func(y int) {
+		i := (y-rect.Min.Y)*stride + (out.rect.Min.X-rect.Min.X)*4
+		o := (y - out.rect.Min.Y) * out.stride
+		in := pix[i : i+w : i+w]
+		if a.kernelFixed(in, out.pix[o:o+w:o+w], t, translucent) {
+			return
+		}
+		for x := 0; x < w; x += 4 {
+			if in[x+3] != 0xff {
+				a.pixelFixed(src, out, out.rect.Min.X+x/4, y)
+			}
+		}
+	}
+}
+
+// kernelFixed grades a row of 8-bit samples, four per pixel, to out in
+// fixed point with the trilinear interpolation unrolled, adding the
+// fixed-point threshold t before truncating the results. Translucent
+// pixels are graded as well if translucent is set, otherwise they are
+// skipped and false is returned.
+func (a *Applier) kernelFixed(in, out []uint8, t int64, translucent bool) bool {
+	var (
+		lat  = a.fixed
+		n    = int32(a.size)
+		dg   = n * 3
+		db   = n * n * 3
+		idx  = &a.idx
+		frac = &a.fixedFrac
+		done = true
+	)
+
+	for i := 0; i+4 <= len(in) && i+4 <= len(out); i += 4 {
+		p := in[i : i+4 : i+4]
+		q := out[i : i+4 : i+4]
+		if p[3] != 0xff && !translucent {
+			done = false
+			continue
+		}
+		r, g, b := p[0], p[1], p[2]
+		rf, gf, bf := int64(frac[r]), int64(frac[g]), int64(frac[b])
+
+		// The 4 pairs of corners along r, with their channels next to
+		// each other
+		c := (idx[r] + idx[g]*n + idx[b]*n*n) * 3
+		c0 := lat[c : c+6 : c+6]
+		c1 := lat[c+dg : c+dg+6 : c+dg+6]
+		c2 := lat[c+db : c+db+6 : c+db+6]
+		c3 := lat[c+db+dg : c+db+dg+6 : c+db+dg+6]
+
+		{
+			c00 := int64(c0[0]) + (int64(c0[3])-int64(c0[0]))*rf>>fixedShift
+			c10 := int64(c1[0]) + (int64(c1[3])-int64(c1[0]))*rf>>fixedShift
+			c01 := int64(c2[0]) + (int64(c2[3])-int64(c2[0]))*rf>>fixedShift
+			c11 := int64(c3[0]) + (int64(c3[3])-int64(c3[0]))*rf>>fixedShift
+			c0x := c00 + (c10-c00)*gf>>fixedShift
+			c1x := c01 + (c11-c01)*gf>>fixedShift
+			v := max(0, min(1<<fixedShift, c0x+(c1x-c0x)*bf>>fixedShift))
+			q[0] = uint8(min(255, (v*255+t)>>fixedShift))
+		}
+		{
+			c00 := int64(c0[1]) + (int64(c0[4])-int64(c0[1]))*rf>>fixedShift
+			c10 := int64(c1[1]) + (int64(c1[4])-int64(c1[1]))*rf>>fixedShift
+			c01 := int64(c2[1]) + (int64(c2[4])-int64(c2[1]))*rf>>fixedShift
+			c11 := int64(c3[1]) + (int64(c3[4])-int64(c3[1]))*rf>>fixedShift
+			c0x := c00 + (c10-c00)*gf>>fixedShift
+			c1x := c01 + (c11-c01)*gf>>fixedShift
+			v := max(0, min(1<<fixedShift, c0x+(c1x-c0x)*bf>>fixedShift))
+			q[1] = uint8(min(255, (v*255+t)>>fixedShift))
+		}
+		{
+			c00 := int64(c0[2]) + (int64(c0[5])-int64(c0[2]))*rf>>fixedShift
+			c10 := int64(c1[2]) + (int64(c1[5])-int64(c1[2]))*rf>>fixedShift
+			c01 := int64(c2[2]) + (int64(c2[5])-int64(c2[2]))*rf>>fixedShift
+			c11 := int64(c3[2]) + (int64(c3[5])-int64(c3[2]))*rf>>fixedShift
+			c0x := c00 + (c10-c00)*gf>>fixedShift
+			c1x := c01 + (c11-c01)*gf>>fixedShift
+			v := max(0, min(1<<fixedShift, c0x+(c1x-c0x)*bf>>fixedShift))
+			q[2] = uint8(min(255, (v*255+t)>>fixedShift))
+		}
+		q[3] = p[3]
+	}
+	return done
+}
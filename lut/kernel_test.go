@@ -0,0 +1,63 @@
+package lut
+
+import (
+	"image"
+	"image/draw"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestKernelsMatchFloat(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 54))
+	opaque := randomImage(rng, 64, 64)
+
+	// Half of the pixels of translucent are opaque, and some of the rest
+	// fully transparent.
+	translucent := image.NewNRGBA(opaque.Rect)
+	copy(translucent.Pix, opaque.Pix)
+	for i := 3; i < len(translucent.Pix); i += 8 {
+		translucent.Pix[i] = uint8(rng.IntN(4) * 85)
+	}
+	premul := image.NewRGBA(translucent.Rect)
+	draw.Draw(premul, premul.Rect, translucent, image.Point{}, draw.Src)
+	gray := image.NewGray(opaque.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(rng.Uint32())
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		depth  Depth
+		premul bool
+	}{
+		{"RGBA", opaque, DepthFloat, true},
+		{"translucent RGBA", premul, DepthFloat, true},
+		{"NRGBA", translucent, DepthFloat, true},
+		{"NRGBA to NRGBA", translucent, DepthFloat, false},
+		{"Gray", gray, DepthFloat, true},
+		{"Depth8 RGBA", opaque, Depth8, true},
+		{"Depth8 NRGBA", translucent, Depth8, true},
+		{"Depth8 Gray", gray, Depth8, true},
+	}
+
+	for _, size := range []int{1, 2, 3, 17} {
+		l := randomLattice(rng, size)
+		for _, tt := range tests {
+			a := Compile(l, Options{Intensity: 1, Depth: tt.depth})
+			if size > 1 && a.fixed == nil && !a.depth8 {
+				t.Fatalf("size %d, %s: compiled to the float path", size, tt.name)
+			}
+
+			var got []uint8
+			if tt.premul {
+				got = a.Apply(tt.img).Pix
+			} else {
+				got = a.ApplyNRGBA(tt.img).Pix
+			}
+			if largest, _ := diff(got, floatApply(a, tt.img, tt.premul)); largest > 1 {
+				t.Errorf("size %d, %s: got samples %d levels from the float path, want at most 1", size, tt.name, largest)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package lut
+
+// LumaRange restricts a LUT to the pixels whose luma falls in a range,
+// for split-grade looks graded separately in the shadows, midtones and
+// highlights.
+type LumaRange struct {
+	// Min and Max bound the luma, normalised to [0, 1], graded at full
+	// intensity.
+	Min, Max float64
+	// Feather is the width of the smooth falloff of the intensity to 0
+	// below Min and above Max.
+	Feather float64
+}
+
+// Presets for the common tonal ranges.
+var (
+	Shadows    = LumaRange{Min: 0, Max: 0.2, Feather: 0.3}
+	Midtones   = LumaRange{Min: 0.35, Max: 0.65, Feather: 0.25}
+	Highlights = LumaRange{Min: 0.8, Max: 1, Feather: 0.3}
+)
+
+// Weight returns the intensity weight, from 0 to 1, of a pixel of the
+// given luma.
+func (lr LumaRange) Weight(luma float64) float64 {
+	var d float64
+	switch {
+	case luma < lr.Min:
+		d = lr.Min - luma
+	case luma > lr.Max:
+		d = luma - lr.Max
+	default:
+		return 1
+	}
+	if d >= lr.Feather {
+		return 0
+	}
+	return 1 - smoothstep(d/lr.Feather)
+}
+
+// smoothstep eases t from 0 to 1 with zero slope at both ends.
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+// luma returns the Rec.709 luma of the normalised color.
+func luma(r, g, b float64) float64 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
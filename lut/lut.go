@@ -0,0 +1,811 @@
+package lut
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/NicoNex/prism/colorspace"
+)
+
+// Lattice is a 3D LUT sampled on a regular grid covering the normalised
+// [0, 1] input range on each axis.
+type Lattice interface {
+	// Size returns the number of grid points per axis.
+	Size() int
+	// Point returns the normalised output color at the given grid indices.
+	Point(r, g, b int) (float64, float64, float64)
+}
+
+// Depth selects the working color depth used while processing pixels.
+type Depth int
+
+const (
+	// DepthFloat reads the full 16-bit input samples and interpolates
+	// in float64 on a flattened float32 copy of the lattice. This is the
+	// high quality path and the default. At full intensity, rounding to
+	// nearest and with no transforms, images with 8-bit samples such as
+	// *image.RGBA are interpolated in 8.24 fixed point instead, faster
+	// and with results that may differ by one 8-bit level.
+	DepthFloat Depth = iota
+	// Depth8 reads 8-bit input samples and interpolates in float32 on
+	// a flattened copy of the lattice, trading precision for speed.
+	// It falls back to DepthFloat when color transforms are set,
+	// Linear or Extended are enabled, with DitherDiffusion, a Mask, a
+	// LumaRange, an Under LUT, a Component other than ComponentAll or
+	// Tetrahedral interpolation, when mixing below full intensity
+	// with a Mix other than MixRGB, or for lattices of a single point.
+	Depth8
+	// DepthTable reads 8-bit input samples like Depth8 and looks them
+	// up in a table holding the result of every 8-bit color, computed
+	// on the float path at Compile unless Options.Table provides it.
+	// Building the table takes about as long as grading a 16 megapixel
+	// image and 48 MiB of memory, so it pays off over many images. It
+	// falls back to DepthFloat when dithering or with a Mask.
+	DepthTable
+)
+
+// Interpolation selects how colors between the points of the lattice
+// are looked up.
+type Interpolation int
+
+const (
+	// Trilinear interpolates between the 8 corners of the lattice cell
+	// holding the color. It is the default.
+	Trilinear Interpolation = iota
+	// Tetrahedral splits the cell in 6 tetrahedra along its gray
+	// diagonal and interpolates between the 4 corners of the one
+	// holding the color, keeping grays neutral where trilinear
+	// interpolation tints them. It always runs on the float path.
+	Tetrahedral
+)
+
+// ErrBounds is returned by ApplyInto when the destination image does not
+// cover the bounds of the source.
+var ErrBounds = errors.New("destination does not cover the image")
+
+// ErrLimit is returned by loaders for input exceeding their resource
+// limits, before allocating the memory it would take.
+var ErrLimit = errors.New("LUT exceeds the loader limits")
+
+// Transform maps a normalised color to another, typically to convert
+// it between color spaces.
+type Transform func(r, g, b float64) (float64, float64, float64)
+
+// Options configures how a LUT is applied to an image.
+type Options struct {
+	// Intensity blends between the original image (0) and the full
+	// LUT result (1).
+	Intensity float64
+	// Depth is the working color depth.
+	Depth Depth
+	// Rounding is the rounding mode used when quantizing the results.
+	Rounding Rounding
+	// Dither is the dithering applied when quantizing the results to
+	// 8 bits. It has no effect on Apply16.
+	Dither Dither
+	// Interpolation is how colors between the lattice points are
+	// looked up.
+	Interpolation Interpolation
+	// Workers is the number of goroutines processing the rows of an
+	// image. Zero uses GOMAXPROCS.
+	Workers int
+	// OnProgress, if set, is called each time a chunk of rows of the
+	// image is done with the number of rows done so far and the total.
+	// Calls are serialized but come from the worker goroutines.
+	OnProgress func(done, total int)
+	// Table holds the precomputed results used with DepthTable. It
+	// must come from an Applier compiled from the same lattice with the
+	// same options, save for Workers, OnProgress and Memo.
+	Table *Table
+	// Input converts the pixels to the working space of the LUT before
+	// the lookup, if set.
+	Input Transform
+	// Output converts the blended result out of the working space of
+	// the LUT, if set.
+	Output Transform
+	// Linear decodes sRGB pixels to linear light before the lookup and
+	// encodes the result back afterwards, for LUTs designed for linear
+	// input. The decoding follows Input and the encoding precedes Output.
+	Linear bool
+	// Extended takes the values looked up, after Input, in the units of
+	// the lattice Domain instead of normalised to it, and returns the
+	// results in the same units. Values beyond [0, 1] are then only
+	// clamped at the domain bounds, as needed by HDR and scene-linear
+	// LUTs.
+	Extended bool
+	// VideoRange treats the pixels as video (legal) range, with black
+	// at 16 and white at 235 over 8 bits, expanding them to full range
+	// before Input and compressing the result back after Output.
+	VideoRange bool
+	// Memo caches the results of the colors looked up on the float
+	// path, so that screenshots, graphics and skies with large areas of
+	// identical pixels interpolate each color once. It slows down
+	// photographs slightly and has no effect on the 8-bit fast paths.
+	Memo bool
+	// Mix is the color space the LUT result is blended with the
+	// original color in at intensities below 1.
+	Mix Mix
+	// Component restricts the LUT to the lightness or the color of the
+	// pixels.
+	Component Component
+	// Mask, if set, weights the intensity of each pixel by the
+	// luminance of the mask at the same coordinates, from 0 where it is
+	// black or transparent to 1 where it is white. Pixels outside the
+	// mask are left ungraded.
+	Mask image.Image
+	// LumaRange, if set, weights the intensity of each pixel by its
+	// luma, grading only the pixels in the range.
+	LumaRange *LumaRange
+	// Under, if set, grades the image below the LUT: its result replaces
+	// the original color the LUT result is mixed with at intensities
+	// below 1, so that pixels left ungraded by the Mask or the LumaRange
+	// take it instead. Only its lattice, Extended and Intensity are
+	// used, on the colors after Input.
+	Under *Applier
+}
+
+// Domain is implemented by lattices whose grid covers an input range
+// other than [0, 1]. Their points are normalised to the same range.
+type Domain interface {
+	Domain() (lo, hi [3]float64)
+}
+
+// VideoRange is implemented by lattices that take their input or return
+// their output in video range, such as CUBE LUTs marked with
+// LUT_IN_VIDEO_RANGE or LUT_OUT_VIDEO_RANGE. The lookup converts full
+// range colors to and from the range they expect.
+type VideoRange interface {
+	VideoRange() (in, out bool)
+}
+
+// Applier is a LUT compiled with a set of options, ready to be applied
+// to any number of images.
+type Applier struct {
+	lat  Lattice
+	size int
+	opt  Options
+
+	// Transforms run before the lookup and after blending.
+	in, out Transform
+
+	// Lattice domain, used with Options.Extended, with its extent and
+	// the inverse of the extent to normalise colors to it.
+	extended bool
+	lo, hi   [3]float64
+	span     [3]float64
+	invSpan  [3]float64
+
+	// Video range of the lattice input and output.
+	videoIn, videoOut bool
+
+	// Flattened lattice, red changing fastest, with the video range of
+	// the lattice output folded in. It replaces the Lattice on every
+	// path but the fixed-point one.
+	flat []float32
+
+	// Depth8 state: per-byte grid positions.
+	depth8 bool
+	idx    [256]int32
+	frac   [256]float32
+
+	// DepthFloat state for 8-bit images at full intensity: flattened
+	// lattice and grid fractions in fixed point.
+	fixed     []int32
+	fixedFrac [256]int32
+
+	// DepthTable state.
+	table *Table
+
+	// Memos of the float path, with Options.Memo.
+	memos sync.Pool
+
+	// Intensity weights of Options.Mask.
+	mask weights
+}
+
+// Compile prepares the lattice l to be applied with the given options.
+func Compile(l Lattice, opt Options) *Applier {
+	// Clamp intensity to [0, 1]
+	opt.Intensity = max(0, min(1, opt.Intensity))
+
+	a := &Applier{
+		lat:  l,
+		size: l.Size(),
+		opt:  opt,
+		in:   opt.Input,
+		out:  opt.Output,
+	}
+	if opt.Linear {
+		a.in = Chain(a.in, srgbToLinear)
+		a.out = Chain(linearToSRGB, a.out)
+	}
+	if opt.VideoRange {
+		a.in = Chain(videoToFull, a.in)
+		a.out = Chain(a.out, fullToVideo)
+	}
+
+	if v, ok := l.(VideoRange); ok {
+		a.videoIn, a.videoOut = v.VideoRange()
+	}
+
+	if d, ok := l.(Domain); ok && opt.Extended {
+		a.extended = true
+		a.lo, a.hi = d.Domain()
+		for i := range 3 {
+			a.span[i] = a.hi[i] - a.lo[i]
+			a.invSpan[i] = 1 / a.span[i]
+		}
+	}
+
+	if opt.Mask != nil {
+		a.mask = newWeights(opt.Mask)
+	}
+
+	a.flatten()
+	// The 8-bit grids index the cells by their lower corner, which
+	// lattices of a single point do not have.
+	plain := a.size > 1 && a.in == nil && a.out == nil && !opt.Extended && opt.Dither != DitherDiffusion && opt.Mask == nil && opt.LumaRange == nil && opt.Under == nil && opt.Component == ComponentAll && opt.Interpolation == Trilinear
+	switch {
+	case opt.Depth == Depth8 && plain && (opt.Mix == MixRGB || opt.Intensity == 1):
+		a.depth8 = true
+		a.grid()
+	case opt.Depth == DepthFloat && plain && opt.Intensity == 1 && opt.Rounding == RoundNearest:
+		a.compileFixed()
+	}
+	if opt.Depth == DepthTable && opt.Dither == DitherNone && opt.Mask == nil {
+		a.table = opt.Table
+		if a.table == nil {
+			a.table = a.buildTable()
+		}
+	}
+	return a
+}
+
+// Lattice returns the lattice the applier was compiled from.
+func (a *Applier) Lattice() Lattice {
+	return a.lat
+}
+
+// Interpolate looks up the normalised full range color (r, g, b) in the
+// lattice l by trilinear interpolation, as a compiled Applier at full
+// intensity does, without compiling it first. It suits lookups of a few
+// colors rather than whole images.
+func Interpolate(l Lattice, r, g, b float64) (float64, float64, float64) {
+	var videoIn, videoOut bool
+	if v, ok := l.(VideoRange); ok {
+		videoIn, videoOut = v.VideoRange()
+	}
+	if videoIn {
+		r, g, b = fullToVideo(r, g, b)
+	}
+
+	n := l.Size()
+	last := float64(n - 1)
+	idx := [3]float64{
+		max(0, min(last, r*last)),
+		max(0, min(last, g*last)),
+		max(0, min(last, b*last)),
+	}
+	var lo, hi [3]int
+	var frac [3]float64
+	for i, v := range idx {
+		lo[i] = int(v)
+		hi[i] = min(lo[i]+1, n-1)
+		frac[i] = v - float64(lo[i])
+	}
+	point := func(r, g, b int) [3]float64 {
+		pr, pg, pb := l.Point(r, g, b)
+		return [3]float64{pr, pg, pb}
+	}
+	c000, c100 := point(lo[0], lo[1], lo[2]), point(hi[0], lo[1], lo[2])
+	c010, c110 := point(lo[0], hi[1], lo[2]), point(hi[0], hi[1], lo[2])
+	c001, c101 := point(lo[0], lo[1], hi[2]), point(hi[0], lo[1], hi[2])
+	c011, c111 := point(lo[0], hi[1], hi[2]), point(hi[0], hi[1], hi[2])
+
+	var res [3]float64
+	for ch := range 3 {
+		// First interpolate along r, then along g and finally along b
+		c00 := lerp64(c000[ch], c100[ch], frac[0])
+		c10 := lerp64(c010[ch], c110[ch], frac[0])
+		c01 := lerp64(c001[ch], c101[ch], frac[0])
+		c11 := lerp64(c011[ch], c111[ch], frac[0])
+		res[ch] = lerp64(lerp64(c00, c10, frac[1]), lerp64(c01, c11, frac[1]), frac[2])
+	}
+	if videoOut {
+		return videoToFull(res[0], res[1], res[2])
+	}
+	return res[0], res[1], res[2]
+}
+
+// Chain returns a transform running the non-nil transforms in order.
+func Chain(ts ...Transform) Transform {
+	ts = slices.DeleteFunc(ts, func(t Transform) bool { return t == nil })
+
+	switch len(ts) {
+	case 0:
+		return nil
+	case 1:
+		return ts[0]
+	}
+	return func(r, g, b float64) (float64, float64, float64) {
+		for _, t := range ts {
+			r, g, b = t(r, g, b)
+		}
+		return r, g, b
+	}
+}
+
+func srgbToLinear(r, g, b float64) (float64, float64, float64) {
+	return colorspace.SRGBToLinear(r), colorspace.SRGBToLinear(g), colorspace.SRGBToLinear(b)
+}
+
+func linearToSRGB(r, g, b float64) (float64, float64, float64) {
+	return colorspace.LinearToSRGB(max(0, r)), colorspace.LinearToSRGB(max(0, g)), colorspace.LinearToSRGB(max(0, b))
+}
+
+// Video range black and white levels, normalised over 8 bits.
+const (
+	videoBlack = 16.0 / 255
+	videoWhite = 235.0 / 255
+)
+
+func videoToFull(r, g, b float64) (float64, float64, float64) {
+	scale := func(v float64) float64 { return (v - videoBlack) / (videoWhite - videoBlack) }
+	return scale(r), scale(g), scale(b)
+}
+
+func fullToVideo(r, g, b float64) (float64, float64, float64) {
+	scale := func(v float64) float64 { return videoBlack + v*(videoWhite-videoBlack) }
+	return scale(r), scale(g), scale(b)
+}
+
+// flatten copies the lattice to a flat float32 slice, so that the
+// interpolation reads the 8 corners from memory laid out next to each
+// other rather than through the Lattice.
+func (a *Applier) flatten() {
+	a.flat = make([]float32, a.size*a.size*a.size*3)
+	a.points(func(i int, r, g, b float64) {
+		a.flat[i], a.flat[i+1], a.flat[i+2] = float32(r), float32(g), float32(b)
+	})
+}
+
+// points calls fn with the offset in a flattened copy of the lattice
+// and the color of each point, folding in the video range conversion
+// of the lattice output.
+func (a *Applier) points(fn func(i int, r, g, b float64)) {
+	n := a.size
+	for b := range n {
+		for g := range n {
+			for r := range n {
+				pr, pg, pb := a.lat.Point(r, g, b)
+				if a.videoOut {
+					pr, pg, pb = videoToFull(pr, pg, pb)
+				}
+				fn((r+g*n+b*n*n)*3, pr, pg, pb)
+			}
+		}
+	}
+}
+
+// grid precomputes the grid index and fraction, in float32 and in fixed
+// point, of each possible 8-bit input value, folding in the video range
+// conversion of the lattice input.
+func (a *Applier) grid() {
+	last := float64(a.size - 1)
+	for v := range 256 {
+		in := float64(v) / 255
+		if a.videoIn {
+			in, _, _ = fullToVideo(in, 0, 0)
+		}
+		pos := in * last
+		i := min(int(pos), a.size-2)
+		a.idx[v] = int32(i)
+		a.frac[v] = float32(pos - float64(i))
+		a.fixedFrac[v] = int32(math.Round((pos - float64(i)) * (1 << fixedShift)))
+	}
+}
+
+// Options returns the options the applier was compiled with.
+func (a *Applier) Options() Options {
+	return a.opt
+}
+
+// Apply applies the compiled LUT to img. Colors are looked up without
+// premultiplied alpha and premultiplied again in the output.
+func (a *Applier) Apply(img image.Image) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	a.apply8(context.Background(), img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, premul: true})
+	return out
+}
+
+// ApplyContext applies the compiled LUT to img like Apply, returning
+// early with the context error once ctx is done.
+func (a *Applier) ApplyContext(ctx context.Context, img image.Image) (*image.RGBA, error) {
+	out := image.NewRGBA(img.Bounds())
+	if err := a.apply8(ctx, img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, premul: true}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ApplyInto applies the compiled LUT to img like Apply, writing the
+// result to the pixels of dst at the same coordinates instead of
+// allocating a new image, so that the frames of a video can share one
+// buffer. dst must cover the bounds of img, and may be img itself to
+// grade it in place. The pixels of dst outside img are left unchanged.
+func (a *Applier) ApplyInto(dst *image.RGBA, img image.Image) error {
+	return a.ApplyIntoContext(context.Background(), dst, img)
+}
+
+// ApplyIntoContext applies the compiled LUT to img like ApplyInto,
+// returning early with the context error once ctx is done.
+func (a *Applier) ApplyIntoContext(ctx context.Context, dst *image.RGBA, img image.Image) error {
+	b := img.Bounds()
+	if !b.In(dst.Rect) {
+		return ErrBounds
+	}
+	out := dst.SubImage(b).(*image.RGBA)
+	return a.apply8(ctx, img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, premul: true})
+}
+
+// ApplyNRGBA applies the compiled LUT to img like Apply, leaving the
+// output not premultiplied. The alpha channel is passed through as it
+// is and straight alpha inputs, such as *image.NRGBA, keep the color
+// of fully transparent pixels.
+func (a *Applier) ApplyNRGBA(img image.Image) *image.NRGBA {
+	out := image.NewNRGBA(img.Bounds())
+	a.apply8(context.Background(), img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect})
+	return out
+}
+
+// apply8 applies the compiled LUT to img writing the 8-bit result to out.
+func (a *Applier) apply8(ctx context.Context, img image.Image, out buffer) error {
+	if a.opt.Dither == DitherDiffusion {
+		return a.applyDiffusion(ctx, img, out)
+	}
+
+	src := newSource(img)
+	kernel := a.kernel(img, src, out)
+	return a.rows(ctx, out.rect, func(y int) {
+		switch {
+		case a.table != nil:
+			a.processRowTable(src, out, y)
+		case kernel != nil:
+			kernel(y)
+		case a.fixed != nil && eightBit(img):
+			a.processRowFixed(src, out, y)
+		case a.depth8:
+			a.processRow8(src, out, y)
+		default:
+			a.processRow(src, out, y)
+		}
+	})
+}
+
+// Apply16 applies the compiled LUT to img keeping 16 bits per channel
+// in the output. It always works at DepthFloat.
+func (a *Applier) Apply16(img image.Image) *image.RGBA64 {
+	out := image.NewRGBA64(img.Bounds())
+	a.apply16(context.Background(), img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, premul: true, deep: true})
+	return out
+}
+
+// ApplyNRGBA64 applies the compiled LUT to img like Apply16, leaving
+// the output not premultiplied as ApplyNRGBA does.
+func (a *Applier) ApplyNRGBA64(img image.Image) *image.NRGBA64 {
+	out := image.NewNRGBA64(img.Bounds())
+	a.apply16(context.Background(), img, buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, deep: true})
+	return out
+}
+
+// apply16 applies the compiled LUT to img writing the 16-bit result to
+// out.
+func (a *Applier) apply16(ctx context.Context, img image.Image, out buffer) error {
+	src := newSource(img)
+	t := a.rounding()
+	return a.rows(ctx, out.rect, func(y int) {
+		m := a.getMemo()
+		defer a.putMemo(m)
+
+		for x := out.rect.Min.X; x < out.rect.Max.X; x++ {
+			r, g, b, alpha := src(x, y)
+			outR, outG, outB := a.pixel(m, x, y, r, g, b)
+			out.set(x, y, outR, outG, outB, alpha, t)
+		}
+	})
+}
+
+// maxChunk is the maximum number of rows handed to a worker at once.
+const maxChunk = 32
+
+// rows runs process on each row of bounds on Options.Workers
+// goroutines, handing the rows out in chunks so that workers do not
+// contend on every row. Chunks not yet started when ctx is done are
+// skipped and the context error returned.
+func (a *Applier) rows(ctx context.Context, bounds image.Rectangle, process func(y int)) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		next atomic.Int64
+		done int
+	)
+
+	workers := a.opt.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// Several chunks per worker balance the load, and capping their
+	// size keeps cancellation and progress reports timely.
+	chunk := max(1, min(maxChunk, bounds.Dy()/(workers*8)))
+
+	for range min(workers, bounds.Dy()) {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				y0 := bounds.Min.Y + int(next.Add(int64(chunk))) - chunk
+				if y0 >= bounds.Max.Y {
+					return
+				}
+				y1 := min(y0+chunk, bounds.Max.Y)
+				for y := y0; y < y1; y++ {
+					process(y)
+				}
+
+				if a.opt.OnProgress != nil {
+					mu.Lock()
+					done += y1 - y0
+					a.opt.OnProgress(done, bounds.Dy())
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// processRow processes a single row of the image in float64.
+func (a *Applier) processRow(src source, out buffer, y int) {
+	m := a.getMemo()
+	defer a.putMemo(m)
+
+	for x := out.rect.Min.X; x < out.rect.Max.X; x++ {
+		r, g, b, alpha := src(x, y)
+		outR, outG, outB := a.pixel(m, x, y, r, g, b)
+		out.set(x, y, outR, outG, outB, alpha, a.threshold(x, y))
+	}
+}
+
+// pixel returns the result of the 16-bit input color of the pixel at
+// (x, y), with the intensity weighted by the mask if set and through the
+// memo otherwise.
+func (a *Applier) pixel(m *memo, x, y int, r, g, b uint32) (float64, float64, float64) {
+	if a.mask != nil {
+		return a.colorAt(r, g, b, a.opt.Intensity*float64(a.mask(x, y))/0xffff)
+	}
+	return m.color(a, r, g, b)
+}
+
+// color returns the normalised result, clamped to [0, 1], of the
+// 16-bit input color.
+func (a *Applier) color(r, g, b uint32) (float64, float64, float64) {
+	return a.colorAt(r, g, b, a.opt.Intensity)
+}
+
+// colorAt returns the result of the 16-bit input color like color, at
+// the given intensity.
+func (a *Applier) colorAt(r, g, b uint32, intensity float64) (float64, float64, float64) {
+	// Convert from uint32 (0-65535) to float64 (0-1)
+	rNorm := float64(r) / 65535.0
+	gNorm := float64(g) / 65535.0
+	bNorm := float64(b) / 65535.0
+
+	if a.opt.LumaRange != nil {
+		intensity *= a.opt.LumaRange.Weight(luma(rNorm, gNorm, bNorm))
+	}
+
+	if a.in != nil {
+		rNorm, gNorm, bNorm = a.in(rNorm, gNorm, bNorm)
+	}
+
+	// Blend between the original, or the Under result, and LUT result
+	var outR, outG, outB float64
+	if u := a.opt.Under; u != nil && intensity < 1 {
+		baseR, baseG, baseB := u.mixed(rNorm, gNorm, bNorm, u.opt.Intensity)
+		resR, resG, resB := a.Interpolate(rNorm, gNorm, bNorm)
+		resR, resG, resB = a.opt.Component.Merge(rNorm, gNorm, bNorm, resR, resG, resB)
+		outR, outG, outB = a.opt.Mix.Lerp(baseR, baseG, baseB, resR, resG, resB, intensity)
+	} else {
+		outR, outG, outB = a.mixed(rNorm, gNorm, bNorm, intensity)
+	}
+
+	if a.out != nil {
+		outR, outG, outB = a.out(outR, outG, outB)
+	}
+
+	// Clamp to [0, 1]
+	return max(0, min(1, outR)), max(0, min(1, outG)), max(0, min(1, outB))
+}
+
+// mixed returns the LUT result of the color (r, g, b), after Input,
+// restricted to the Component and mixed with the color by intensity.
+func (a *Applier) mixed(r, g, b, intensity float64) (float64, float64, float64) {
+	resR, resG, resB := a.Interpolate(r, g, b)
+	resR, resG, resB = a.opt.Component.Merge(r, g, b, resR, resG, resB)
+	if intensity < 1 {
+		return a.opt.Mix.Lerp(r, g, b, resR, resG, resB, intensity)
+	}
+	return resR, resG, resB
+}
+
+// processRow8 processes a single row of the image on the 8-bit fast path.
+func (a *Applier) processRow8(src source, out buffer, y int) {
+	intensity := float32(a.opt.Intensity)
+
+	for x := out.rect.Min.X; x < out.rect.Max.X; x++ {
+		r, g, b, alpha := src(x, y)
+		r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(b>>8)
+
+		resR, resG, resB := a.interpolate8(r8, g8, b8)
+
+		// Blend between original (identity) and LUT result
+		outR := float32(r8)/255*(1-intensity) + resR*intensity
+		outG := float32(g8)/255*(1-intensity) + resG*intensity
+		outB := float32(b8)/255*(1-intensity) + resB*intensity
+
+		out.set(x, y,
+			float64(max(0, min(1, outR))),
+			float64(max(0, min(1, outG))),
+			float64(max(0, min(1, outB))),
+			alpha, a.threshold(x, y),
+		)
+	}
+}
+
+// Interpolate performs trilinear interpolation of the normalised color
+// (r, g, b) in the lattice, or of a color in the units of the lattice
+// domain when compiled with Options.Extended.
+func (a *Applier) Interpolate(r, g, b float64) (float64, float64, float64) {
+	if !a.extended {
+		return a.lookup(r, g, b)
+	}
+
+	r, g, b = a.lookup(
+		(r-a.lo[0])*a.invSpan[0],
+		(g-a.lo[1])*a.invSpan[1],
+		(b-a.lo[2])*a.invSpan[2],
+	)
+	return a.lo[0] + r*a.span[0],
+		a.lo[1] + g*a.span[1],
+		a.lo[2] + b*a.span[2]
+}
+
+// lookup interpolates the normalised full range color (r, g, b),
+// converting it to the video range of the lattice input if needed. The
+// video range of the output is folded in the flattened lattice.
+func (a *Applier) lookup(r, g, b float64) (float64, float64, float64) {
+	if a.videoIn {
+		r, g, b = fullToVideo(r, g, b)
+	}
+	return a.interpolate(r, g, b)
+}
+
+// interpolate performs trilinear interpolation of the normalised color
+// (r, g, b) in the flattened lattice.
+func (a *Applier) interpolate(r, g, b float64) (float64, float64, float64) {
+	n := a.size
+	last := float64(n - 1)
+
+	// Normalize input to lattice coordinates [0, size-1]
+	rIdx := max(0, min(last, r*last))
+	gIdx := max(0, min(last, g*last))
+	bIdx := max(0, min(last, b*last))
+
+	// Find the surrounding lattice vertices, as offsets in the
+	// flattened lattice. The upper corners repeat the lower ones at
+	// the last grid point.
+	r0, g0, b0 := int(rIdx), int(gIdx), int(bIdx)
+	dr, dg, db := 3, n*3, n*n*3
+	if r0 == n-1 {
+		dr = 0
+	}
+	if g0 == n-1 {
+		dg = 0
+	}
+	if b0 == n-1 {
+		db = 0
+	}
+
+	// Calculate interpolation weights
+	rFrac := rIdx - float64(r0)
+	gFrac := gIdx - float64(g0)
+	bFrac := bIdx - float64(b0)
+
+	base := (r0 + g0*n + b0*n*n) * 3
+	if a.opt.Interpolation == Tetrahedral {
+		return a.tetrahedral(base, [3]int{dr, dg, db}, [3]float64{rFrac, gFrac, bFrac})
+	}
+
+	var res [3]float64
+	for ch := range 3 {
+		c := a.flat[base+ch:]
+
+		// First interpolate along r, then along g and finally along b
+		c00 := lerp64(float64(c[0]), float64(c[dr]), rFrac)
+		c10 := lerp64(float64(c[dg]), float64(c[dg+dr]), rFrac)
+		c01 := lerp64(float64(c[db]), float64(c[db+dr]), rFrac)
+		c11 := lerp64(float64(c[db+dg]), float64(c[db+dg+dr]), rFrac)
+		res[ch] = lerp64(lerp64(c00, c10, gFrac), lerp64(c01, c11, gFrac), bFrac)
+	}
+	return res[0], res[1], res[2]
+}
+
+// tetrahedral performs tetrahedral interpolation at the fractions f of
+// the lattice cell whose lower corner is at base in the flattened
+// lattice, d holding the offsets of the next corner along each axis.
+func (a *Applier) tetrahedral(base int, d [3]int, f [3]float64) (float64, float64, float64) {
+	// The tetrahedron holding the color joins the lower and the upper
+	// corners walking along the axes in decreasing order of fraction.
+	i, j, k := 0, 1, 2
+	if f[i] < f[j] {
+		i, j = j, i
+	}
+	if f[j] < f[k] {
+		j, k = k, j
+	}
+	if f[i] < f[j] {
+		i, j = j, i
+	}
+	o1 := d[i]
+	o2 := o1 + d[j]
+	o3 := o2 + d[k]
+	w0, w1, w2, w3 := 1-f[i], f[i]-f[j], f[j]-f[k], f[k]
+
+	var res [3]float64
+	for ch := range 3 {
+		c := a.flat[base+ch:]
+		res[ch] = w0*float64(c[0]) + w1*float64(c[o1]) + w2*float64(c[o2]) + w3*float64(c[o3])
+	}
+	return res[0], res[1], res[2]
+}
+
+// interpolate8 performs trilinear interpolation of an 8-bit color in
+// the flattened float32 lattice.
+func (a *Applier) interpolate8(r, g, b uint8) (float32, float32, float32) {
+	n := int32(a.size)
+	r0, g0, b0 := a.idx[r], a.idx[g], a.idx[b]
+	rf, gf, bf := a.frac[r], a.frac[g], a.frac[b]
+
+	// Offsets of the 8 corners in the flattened lattice
+	base := (r0 + g0*n + b0*n*n) * 3
+	dr := int32(3)
+	dg := n * 3
+	db := n * n * 3
+
+	var res [3]float32
+	for ch := range int32(3) {
+		i := base + ch
+		c00 := lerp32(a.flat[i], a.flat[i+dr], rf)
+		c10 := lerp32(a.flat[i+dg], a.flat[i+dg+dr], rf)
+		c01 := lerp32(a.flat[i+db], a.flat[i+db+dr], rf)
+		c11 := lerp32(a.flat[i+db+dg], a.flat[i+db+dg+dr], rf)
+		res[ch] = lerp32(lerp32(c00, c10, gf), lerp32(c01, c11, gf), bf)
+	}
+	return res[0], res[1], res[2]
+}
+
+// lerp64 linearly interpolates between two float64 values
+func lerp64(a, b, t float64) float64 {
+	return a + t*(b-a)
+}
+
+// lerp32 linearly interpolates between two float32 values
+func lerp32(a, b, t float32) float32 {
+	return a + t*(b-a)
+}
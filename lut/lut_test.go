@@ -0,0 +1,175 @@
+package lut
+
+import (
+	"image"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// testLattice is a lattice holding its points in a slice, red changing
+// fastest.
+type testLattice struct {
+	size int
+	pts  [][3]float64
+}
+
+func (l testLattice) Size() int {
+	return l.size
+}
+
+func (l testLattice) Point(r, g, b int) (float64, float64, float64) {
+	p := l.pts[r+g*l.size+b*l.size*l.size]
+	return p[0], p[1], p[2]
+}
+
+// randomLattice returns a lattice of the given size with random points
+// in [-0.1, 1.1), so that some of the results are clamped.
+func randomLattice(rng *rand.Rand, size int) testLattice {
+	l := testLattice{size: size, pts: make([][3]float64, size*size*size)}
+	for i := range l.pts {
+		for c := range 3 {
+			l.pts[i][c] = rng.Float64()*1.2 - 0.1
+		}
+	}
+	return l
+}
+
+// latticeOf returns the lattice of the given size sampling fn.
+func latticeOf(size int, fn Transform) testLattice {
+	l := testLattice{size: size, pts: make([][3]float64, 0, size*size*size)}
+	last := float64(size - 1)
+	for b := range size {
+		for g := range size {
+			for r := range size {
+				pr, pg, pb := fn(float64(r)/last, float64(g)/last, float64(b)/last)
+				l.pts = append(l.pts, [3]float64{pr, pg, pb})
+			}
+		}
+	}
+	return l
+}
+
+// randomImage returns an opaque w×h image of random colors.
+func randomImage(rng *rand.Rand, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Uint32())
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}
+
+func TestCompileSinglePoint(t *testing.T) {
+	l := testLattice{size: 1, pts: [][3]float64{{0.2, 0.4, 0.6}}}
+	img := randomImage(rand.New(rand.NewPCG(1, 1)), 16, 16)
+	want := [4]uint8{51, 102, 153, 0xff}
+
+	for _, depth := range []Depth{DepthFloat, Depth8} {
+		got := Compile(l, Options{Intensity: 1, Depth: depth}).Apply(img)
+		for i := 0; i < len(got.Pix); i += 4 {
+			if p := [4]uint8(got.Pix[i : i+4]); p != want {
+				t.Fatalf("depth %d, pixel %d: got %v, want %v", depth, i/4, p, want)
+			}
+		}
+	}
+}
+
+func TestTetrahedral(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 108))
+	var m [3][4]float64
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = rng.Float64() - 0.5
+		}
+	}
+	affine := latticeOf(9, func(r, g, b float64) (float64, float64, float64) {
+		return m[0][0]*r + m[0][1]*g + m[0][2]*b + m[0][3],
+			m[1][0]*r + m[1][1]*g + m[1][2]*b + m[1][3],
+			m[2][0]*r + m[2][1]*g + m[2][2]*b + m[2][3]
+	})
+
+	// Grays are neutral at the points of the diagonal only.
+	neutral := randomLattice(rng, 9)
+	for i := range 9 {
+		v := rng.Float64()
+		neutral.pts[i*(1+9+81)] = [3]float64{v, v, v}
+	}
+
+	near := func(x, y [3]float64) bool {
+		for c := range 3 {
+			if math.Abs(x[c]-y[c]) > 1e-6 {
+				return false
+			}
+		}
+		return true
+	}
+	// Both interpolations return the points of the lattice.
+	for _, size := range []int{1, 2, 9} {
+		l := randomLattice(rng, size)
+		tri := Compile(l, Options{Intensity: 1})
+		tet := Compile(l, Options{Intensity: 1, Interpolation: Tetrahedral})
+		last := float64(size - 1)
+		for p := range l.pts {
+			in := [3]float64{float64(p % size), float64(p / size % size), float64(p / size / size)}
+			if size > 1 {
+				in = [3]float64{in[0] / last, in[1] / last, in[2] / last}
+			}
+			var got, want [3]float64
+			got[0], got[1], got[2] = tet.Interpolate(in[0], in[1], in[2])
+			want[0], want[1], want[2] = tri.Interpolate(in[0], in[1], in[2])
+			if !near(got, want) {
+				t.Fatalf("size %d, point %v: got %v, want %v as trilinear", size, in, got, want)
+			}
+		}
+	}
+
+	// Both are exact on affine lattices, and tetrahedral keeps grays
+	// neutral between the points of the diagonal.
+	tri := Compile(affine, Options{Intensity: 1})
+	tet := Compile(affine, Options{Intensity: 1, Interpolation: Tetrahedral})
+	gray := Compile(neutral, Options{Intensity: 1, Interpolation: Tetrahedral})
+	for range 1000 {
+		r, g, b := rng.Float64(), rng.Float64(), rng.Float64()
+		var got, want [3]float64
+		got[0], got[1], got[2] = tet.Interpolate(r, g, b)
+		want[0], want[1], want[2] = tri.Interpolate(r, g, b)
+		if !near(got, want) {
+			t.Fatalf("affine lattice, color (%v, %v, %v): got %v, want %v as trilinear", r, g, b, got, want)
+		}
+
+		got[0], got[1], got[2] = gray.Interpolate(r, r, r)
+		if !near(got, [3]float64{got[0], got[0], got[0]}) {
+			t.Fatalf("gray %v: got %v, want a gray", r, got)
+		}
+	}
+}
+
+// floatApply applies a to img on the float path, whatever the fast path
+// it was compiled for, as the reference the fast paths are checked
+// against. The result is premultiplied like Apply if premul is set and
+// straight like ApplyNRGBA otherwise.
+func floatApply(a *Applier, img image.Image, premul bool) []uint8 {
+	out := image.NewRGBA(img.Bounds())
+	buf := buffer{pix: out.Pix, stride: out.Stride, rect: out.Rect, premul: premul}
+	src := newSource(img)
+	for y := out.Rect.Min.Y; y < out.Rect.Max.Y; y++ {
+		a.processRow(src, buf, y)
+	}
+	return out.Pix
+}
+
+// diff returns the largest difference between the samples a and b, and
+// the number of samples that differ.
+func diff(a, b []uint8) (largest, n int) {
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d != 0 {
+			largest = max(largest, d, -d)
+			n++
+		}
+	}
+	return largest, n
+}
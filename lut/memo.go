@@ -0,0 +1,52 @@
+package lut
+
+// memoBits is the log2 of the number of entries of a memo.
+const memoBits = 12
+
+// memo caches the results of the colors last looked up on the float
+// path, in a hash table indexed by the 16-bit input color. An entry is
+// overwritten by the next color hashing to it, so that runs of identical
+// pixels and small palettes hit the cache while photographs only pay
+// for the hash.
+type memo struct {
+	keys [1 << memoBits]uint64
+	vals [1 << memoBits][3]float64
+}
+
+// getMemo returns a memo to use for a row, or nil without Options.Memo or
+// with a Mask. Memos are kept in a pool between rows and images, as the
+// results of the applier never change.
+func (a *Applier) getMemo() *memo {
+	if !a.opt.Memo || a.mask != nil {
+		return nil
+	}
+	if m, ok := a.memos.Get().(*memo); ok {
+		return m
+	}
+	return new(memo)
+}
+
+// putMemo returns m to the pool once the row is done.
+func (a *Applier) putMemo(m *memo) {
+	if m != nil {
+		a.memos.Put(m)
+	}
+}
+
+// color returns the result of a.color for the 16-bit input color,
+// looking it up in the memo first. A nil memo always computes it.
+func (m *memo) color(a *Applier, r, g, b uint32) (float64, float64, float64) {
+	if m == nil {
+		return a.color(r, g, b)
+	}
+
+	// The top bit tells used entries from the zero color.
+	key := 1<<63 | uint64(r)<<32 | uint64(g)<<16 | uint64(b)
+	i := key * 0x9e3779b97f4a7c15 >> (64 - memoBits)
+	v := &m.vals[i]
+	if m.keys[i] != key {
+		m.keys[i] = key
+		v[0], v[1], v[2] = a.color(r, g, b)
+	}
+	return v[0], v[1], v[2]
+}
@@ -0,0 +1,61 @@
+package lut
+
+import (
+	"bytes"
+	"image"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestMemo(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 60))
+	l := randomLattice(rng, 17)
+
+	// Runs of a few colors, which hit the memo, and random colors, which
+	// overwrite its entries.
+	flat := image.NewRGBA64(image.Rect(0, 0, 128, 128))
+	noisy := image.NewRGBA64(flat.Rect)
+	palette := make([][8]uint8, 5)
+	for i := range palette {
+		for c := range 6 {
+			palette[i][c] = uint8(rng.Uint32())
+		}
+		palette[i][6], palette[i][7] = 0xff, 0xff
+	}
+	for i := 0; i < len(flat.Pix); i += 8 {
+		copy(flat.Pix[i:], palette[i/8/50%len(palette)][:])
+		copy(noisy.Pix[i:], palette[rng.IntN(len(palette))][:])
+		if rng.IntN(2) == 0 {
+			for c := range 6 {
+				noisy.Pix[i+c] = uint8(rng.Uint32())
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		opt  Options
+	}{
+		{"default", Options{Intensity: 1}},
+		{"intensity", Options{Intensity: 0.6, Mix: MixOklab}},
+		{"tetrahedral", Options{Intensity: 1, Interpolation: Tetrahedral, Rounding: RoundTruncate}},
+		{"ordered dither", Options{Intensity: 1, Dither: DitherOrdered, Linear: true}},
+	}
+
+	for _, tt := range tests {
+		a := Compile(l, tt.opt)
+		memo := tt.opt
+		memo.Memo = true
+		m := Compile(l, memo)
+
+		// The memo is reused across images.
+		for _, img := range []image.Image{flat, noisy, flat} {
+			if got, want := m.Apply16(img).Pix, a.Apply16(img).Pix; !bytes.Equal(got, want) {
+				t.Errorf("%s: Apply16 with a memo differs", tt.name)
+			}
+			if got, want := m.Apply(img).Pix, a.Apply(img).Pix; !bytes.Equal(got, want) {
+				t.Errorf("%s: Apply with a memo differs", tt.name)
+			}
+		}
+	}
+}
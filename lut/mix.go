@@ -0,0 +1,63 @@
+package lut
+
+import (
+	"math"
+
+	"github.com/NicoNex/prism/colorspace"
+)
+
+// Mix selects the color space colors are mixed in, when the LUT result
+// is blended with the original color at intensities below 1 and when
+// LUTs are blended together.
+type Mix int
+
+const (
+	// MixRGB mixes the RGB values as they are. This is the default.
+	MixRGB Mix = iota
+	// MixHSL mixes hue, saturation and lightness, keeping the
+	// saturation of colors of similar hue that RGB mixes dull.
+	MixHSL
+	// MixOklab mixes colors in the Oklab perceptual space, keeping their
+	// perceived hue and lightness.
+	MixOklab
+)
+
+// To converts the RGB color to the coordinates it is mixed in. Hue and
+// saturation are taken as polar coordinates on the color wheel, so that
+// averages wrap around the hue circle and grays take any hue. HSL is
+// meant for colors within [0, 1].
+func (m Mix) To(r, g, b float64) (float64, float64, float64) {
+	switch m {
+	case MixHSL:
+		h, s, l := colorspace.RGBToHSL(r, g, b)
+		sin, cos := math.Sincos(2 * math.Pi * h)
+		return s * cos, s * sin, l
+	case MixOklab:
+		return colorspace.SRGBToOklab(r, g, b)
+	default:
+		return r, g, b
+	}
+}
+
+// From converts mixed coordinates back to RGB.
+func (m Mix) From(x, y, z float64) (float64, float64, float64) {
+	switch m {
+	case MixHSL:
+		h := math.Atan2(y, x) / (2 * math.Pi)
+		return colorspace.HSLToRGB(h, math.Hypot(x, y), z)
+	case MixOklab:
+		return colorspace.OklabToSRGB(x, y, z)
+	default:
+		return x, y, z
+	}
+}
+
+// Lerp mixes the colors (r1, g1, b1) and (r2, g2, b2), by t.
+func (m Mix) Lerp(r1, g1, b1, r2, g2, b2, t float64) (float64, float64, float64) {
+	if m == MixRGB {
+		return r1*(1-t) + r2*t, g1*(1-t) + g2*t, b1*(1-t) + b2*t
+	}
+	x1, y1, z1 := m.To(r1, g1, b1)
+	x2, y2, z2 := m.To(r2, g2, b2)
+	return m.From(x1+t*(x2-x1), y1+t*(y2-y1), z1+t*(z2-z1))
+}
@@ -0,0 +1,66 @@
+package lut
+
+import "image"
+
+// ApplyOption sets an option of the Apply methods of LUTs, as in
+//
+//	c.Apply(img, lut.WithIntensity(0.7), lut.WithOutputDepth(16))
+type ApplyOption func(*ApplyConfig)
+
+// ApplyConfig is the configuration ApplyOptions set.
+type ApplyConfig struct {
+	Options
+	// OutputDepth is the bits per channel of the result, 8 or 16.
+	OutputDepth int
+}
+
+// NewApplyConfig returns the configuration opts set, applied in order
+// over the defaults: full intensity and an 8-bit result.
+func NewApplyConfig(opts ...ApplyOption) ApplyConfig {
+	cfg := ApplyConfig{Options: Options{Intensity: 1}, OutputDepth: 8}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithOptions sets all the Options at once, for those without an
+// ApplyOption of their own. Options given after it override its fields.
+func WithOptions(opt Options) ApplyOption {
+	return func(c *ApplyConfig) { c.Options = opt }
+}
+
+// WithIntensity sets the intensity the LUT is applied at, from 0 for
+// the original image to 1 for the full LUT result.
+func WithIntensity(v float64) ApplyOption {
+	return func(c *ApplyConfig) { c.Intensity = v }
+}
+
+// WithInterpolation sets how colors between the lattice points are
+// looked up.
+func WithInterpolation(i Interpolation) ApplyOption {
+	return func(c *ApplyConfig) { c.Interpolation = i }
+}
+
+// WithWorkers sets the number of goroutines grading the rows of the
+// image. Zero uses GOMAXPROCS.
+func WithWorkers(n int) ApplyOption {
+	return func(c *ApplyConfig) { c.Workers = n }
+}
+
+// WithOutputDepth sets the bits per channel of the result: 8 for an
+// *image.RGBA, the default, or 16 for an *image.RGBA64.
+func WithOutputDepth(bits int) ApplyOption {
+	return func(c *ApplyConfig) { c.OutputDepth = bits }
+}
+
+// Apply compiles the lattice l with the options set by opts and applies
+// it to img. It backs the Apply methods of LUTs.
+func Apply(l Lattice, img image.Image, opts ...ApplyOption) image.Image {
+	cfg := NewApplyConfig(opts...)
+	a := Compile(l, cfg.Options)
+	if cfg.OutputDepth == 16 {
+		return a.Apply16(img)
+	}
+	return a.Apply(img)
+}
@@ -0,0 +1,30 @@
+package lut
+
+import (
+	"image"
+
+	"github.com/NicoNex/prism/transform"
+)
+
+// Preview is an image downscaled once to be graded quickly with any
+// number of LUTs, sharing the decoding and downscaling of the image
+// between them.
+type Preview struct {
+	img *image.RGBA
+}
+
+// NewPreview returns the preview of img downscaled to fit in a size x
+// size square. Images already fitting are kept as they are.
+func NewPreview(img image.Image, size int) *Preview {
+	return &Preview{img: transform.Fit(transform.ToRGBA(img), size, size)}
+}
+
+// Image returns the ungraded preview.
+func (p *Preview) Image() *image.RGBA {
+	return p.img
+}
+
+// Apply returns the preview graded with a.
+func (p *Preview) Apply(a *Applier) *image.RGBA {
+	return a.Apply(p.img)
+}
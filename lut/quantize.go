@@ -0,0 +1,177 @@
+package lut
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// Rounding selects how the results are quantized when not dithered.
+type Rounding int
+
+const (
+	// RoundNearest rounds the results to the nearest value. This is
+	// the default.
+	RoundNearest Rounding = iota
+	// RoundTruncate truncates the results, darkening them by up to one
+	// quantization step.
+	RoundTruncate
+)
+
+// Dither selects how the results are quantized to 8 bits.
+type Dither int
+
+const (
+	// DitherNone quantizes the results following the Rounding mode.
+	// This is the default.
+	DitherNone Dither = iota
+	// DitherOrdered offsets the quantization threshold of each pixel
+	// following an 8x8 Bayer pattern.
+	DitherOrdered
+	// DitherDiffusion spreads the quantization error of each pixel to
+	// its neighbours (Floyd-Steinberg). The results are computed in
+	// parallel but quantized one row after another, and it always works
+	// at DepthFloat.
+	DitherDiffusion
+)
+
+// bayer8 is the 8x8 ordered dithering threshold map.
+var bayer8 = [8][8]uint8{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// rounding returns the offset, in quantization steps, added to the
+// results before they are truncated.
+func (a *Applier) rounding() float64 {
+	if a.opt.Rounding == RoundTruncate {
+		return 0
+	}
+	return 0.5
+}
+
+// threshold returns the offset, in [0, 1) of a quantization step, added
+// to the results at (x, y) before they are truncated to 8 bits.
+func (a *Applier) threshold(x, y int) float64 {
+	if a.opt.Dither != DitherOrdered {
+		return a.rounding()
+	}
+	return (float64(bayer8[y&7][x&7]) + 0.5) / 64
+}
+
+// quantize converts the normalised v to 8 bits, truncating it after
+// adding the threshold t.
+func quantize(v, t float64) uint8 {
+	return uint8(min(255, v*255+t))
+}
+
+// quantize16 converts the normalised v to 16 bits, truncating it after
+// adding the threshold t.
+func quantize16(v, t float64) uint16 {
+	return uint16(min(65535, v*65535+t))
+}
+
+// applyDiffusion applies the compiled LUT to img with Floyd-Steinberg
+// error diffusion, writing the 8-bit result to out.
+func (a *Applier) applyDiffusion(ctx context.Context, img image.Image, out buffer) error {
+	bounds := out.rect
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Results in 8-bit units, accumulating the diffused error.
+	res := make([]float64, w*h*3)
+	alphas := make([]uint32, w*h)
+	src := newSource(img)
+	err := a.rows(ctx, bounds, func(y int) {
+		m := a.getMemo()
+		defer a.putMemo(m)
+
+		row := res[(y-bounds.Min.Y)*w*3:]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, alpha := src(x, y)
+			outR, outG, outB := a.pixel(m, x, y, r, g, b)
+			if out.premul {
+				k := float64(alpha) / 0xffff
+				outR, outG, outB = outR*k, outG*k, outB*k
+			}
+
+			i := (x - bounds.Min.X) * 3
+			row[i], row[i+1], row[i+2] = outR*255, outG*255, outB*255
+			alphas[(y-bounds.Min.Y)*w+x-bounds.Min.X] = alpha
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for y := range h {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := range w {
+			off := y*out.stride + x*4
+			for c := range 3 {
+				i := (y*w+x)*3 + c
+				q := math.Round(max(0, min(255, res[i])))
+				out.pix[off+c] = uint8(q)
+
+				e := res[i] - q
+				if x+1 < w {
+					res[i+3] += e * 7 / 16
+				}
+				if y+1 < h {
+					if x > 0 {
+						res[i+w*3-3] += e * 3 / 16
+					}
+					res[i+w*3] += e * 5 / 16
+					if x+1 < w {
+						res[i+w*3+3] += e / 16
+					}
+				}
+			}
+			out.pix[off+3] = uint8(alphas[y*w+x] / 257)
+		}
+	}
+	return nil
+}
+
+// buffer is the pixel storage of an *image.RGBA, *image.NRGBA,
+// *image.RGBA64 or *image.NRGBA64 output.
+type buffer struct {
+	pix    []uint8
+	stride int
+	rect   image.Rectangle
+	// premul stores the colors premultiplied by alpha.
+	premul bool
+	// deep stores 16 bits per channel instead of 8.
+	deep bool
+}
+
+// set quantizes the straight normalised color (r, g, b) with the
+// threshold t and stores it with the 16-bit alpha at (x, y).
+func (o buffer) set(x, y int, r, g, b float64, alpha uint32, t float64) {
+	if o.premul && alpha != 0xffff {
+		a := float64(alpha) / 0xffff
+		r, g, b = r*a, g*a, b*a
+	}
+
+	if !o.deep {
+		i := (y-o.rect.Min.Y)*o.stride + (x-o.rect.Min.X)*4
+		p := o.pix[i : i+4 : i+4]
+		p[0], p[1], p[2] = quantize(r, t), quantize(g, t), quantize(b, t)
+		p[3] = uint8(alpha / 257)
+		return
+	}
+
+	i := (y-o.rect.Min.Y)*o.stride + (x-o.rect.Min.X)*8
+	p := o.pix[i : i+8 : i+8]
+	for c, v := range [4]uint16{quantize16(r, t), quantize16(g, t), quantize16(b, t), uint16(alpha)} {
+		p[c*2] = uint8(v >> 8)
+		p[c*2+1] = uint8(v)
+	}
+}
@@ -0,0 +1,54 @@
+package lut
+
+import (
+	"image"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestDither(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 43))
+	img := randomImage(rng, 64, 64)
+	l := randomLattice(rng, 9)
+
+	// A flat image graded halfway between two levels, to 75.5.
+	flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range flat.Pix {
+		flat.Pix[i] = 100
+		if i%4 == 3 {
+			flat.Pix[i] = 0xff
+		}
+	}
+	half := latticeOf(2, func(r, g, b float64) (float64, float64, float64) {
+		return r/2 + 0.1, g/2 + 0.1, b/2 + 0.1
+	})
+
+	for _, dither := range []Dither{DitherOrdered, DitherDiffusion} {
+		// Each sample is one of the two levels around the float result.
+		a := Compile(l, Options{Intensity: 1, Dither: dither})
+		got := a.Apply(img)
+		for i := 0; i < len(img.Pix); i += 4 {
+			p := img.Pix[i : i+4]
+			var want [3]float64
+			want[0], want[1], want[2] = a.color(uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101)
+			for c := range 3 {
+				if v := float64(got.Pix[i+c]); math.Abs(v-want[c]*255) >= 1 {
+					t.Fatalf("dither %d, sample %d: got %v, want %.2f within a level", dither, i+c, v, want[c]*255)
+				}
+			}
+		}
+
+		// The levels average to the float result.
+		got = Compile(half, Options{Intensity: 1, Dither: dither}).Apply(flat)
+		var sum float64
+		for i, v := range got.Pix {
+			if i%4 != 3 {
+				sum += float64(v)
+			}
+		}
+		if mean := sum / float64(len(got.Pix)*3/4); math.Abs(mean-75.5) > 0.05 {
+			t.Errorf("dither %d: got mean %.3f, want 75.5", dither, mean)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package lut
+
+import (
+	"image"
+	"image/color"
+)
+
+// source reads the straight 16-bit color of an image at (x, y).
+type source func(x, y int) (r, g, b, a uint32)
+
+// newSource returns the source reading img. The pixel buffers of the
+// common image types are read directly, saving the boxing of every
+// pixel in a color.Color done by At.
+func newSource(img image.Image) source {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			return unpremultiply(uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101, uint32(p[3])*0x101)
+		}
+
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+4 : i+4]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101, uint32(p[3]) * 0x101
+		}
+
+	case *image.RGBA64:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+8 : i+8]
+			return unpremultiply(
+				uint32(p[0])<<8|uint32(p[1]),
+				uint32(p[2])<<8|uint32(p[3]),
+				uint32(p[4])<<8|uint32(p[5]),
+				uint32(p[6])<<8|uint32(p[7]),
+			)
+		}
+
+	case *image.NRGBA64:
+		return func(x, y int) (r, g, b, a uint32) {
+			i := img.PixOffset(x, y)
+			p := img.Pix[i : i+8 : i+8]
+			return uint32(p[0])<<8 | uint32(p[1]),
+				uint32(p[2])<<8 | uint32(p[3]),
+				uint32(p[4])<<8 | uint32(p[5]),
+				uint32(p[6])<<8 | uint32(p[7])
+		}
+
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			r, g, b, _ = color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}.RGBA()
+			return r, g, b, 0xffff
+		}
+
+	case *image.Gray:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := uint32(img.Pix[img.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+
+	default:
+		return func(x, y int) (r, g, b, a uint32) {
+			return straight(img, x, y)
+		}
+	}
+}
+
+// weights reads the 16-bit luminance of a mask at (x, y).
+type weights func(x, y int) uint32
+
+// newWeights returns the weights of the mask img: the luminance of its
+// premultiplied color, so that transparent pixels weigh 0 like black
+// ones and so do pixels outside the mask.
+func newWeights(img image.Image) weights {
+	b := img.Bounds()
+	switch img := img.(type) {
+	case *image.Gray:
+		return func(x, y int) uint32 {
+			if !image.Pt(x, y).In(b) {
+				return 0
+			}
+			return uint32(img.Pix[img.PixOffset(x, y)]) * 0x101
+		}
+
+	case *image.Gray16:
+		return func(x, y int) uint32 {
+			if !image.Pt(x, y).In(b) {
+				return 0
+			}
+			i := img.PixOffset(x, y)
+			return uint32(img.Pix[i])<<8 | uint32(img.Pix[i+1])
+		}
+
+	case interface{ Gray16At(x, y int) color.Gray16 }:
+		return func(x, y int) uint32 {
+			if !image.Pt(x, y).In(b) {
+				return 0
+			}
+			return uint32(img.Gray16At(x, y).Y)
+		}
+
+	default:
+		return func(x, y int) uint32 {
+			if !image.Pt(x, y).In(b) {
+				return 0
+			}
+			return uint32(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+		}
+	}
+}
+
+// straight returns the 16-bit color of img at (x, y) without
+// premultiplied alpha. Colors stored straight, like those of
+// *image.NRGBA, are returned as they are, keeping the color of fully
+// transparent pixels.
+func straight(img image.Image, x, y int) (r, g, b, a uint32) {
+	switch c := img.At(x, y).(type) {
+	case color.NRGBA:
+		return uint32(c.R) * 0x101, uint32(c.G) * 0x101, uint32(c.B) * 0x101, uint32(c.A) * 0x101
+	case color.NRGBA64:
+		return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+	default:
+		return unpremultiply(c.RGBA())
+	}
+}
+
+// unpremultiply divides the 16-bit premultiplied color by its alpha.
+func unpremultiply(r, g, b, a uint32) (uint32, uint32, uint32, uint32) {
+	if a != 0 && a != 0xffff {
+		r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+	}
+	return r, g, b, a
+}
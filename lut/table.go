@@ -0,0 +1,59 @@
+package lut
+
+import (
+	"context"
+	"image"
+)
+
+// Table holds the 8-bit results of a compiled LUT for each of the 256³
+// 8-bit input colors, to apply it by direct lookup with DepthTable.
+type Table struct {
+	rgb []uint8
+}
+
+// buildTable computes the result of every 8-bit input color on the
+// float path, reporting the progress as rows of 256 colors.
+func (a *Applier) buildTable() *Table {
+	t := &Table{rgb: make([]uint8, 256*256*256*3)}
+	th := a.rounding()
+
+	a.rows(context.Background(), image.Rect(0, 0, 256, 256*256), func(y int) {
+		g, b := uint32(y&0xff), uint32(y>>8)
+		row := t.rgb[y*256*3 : (y+1)*256*3]
+		for r := range uint32(256) {
+			outR, outG, outB := a.color(r*0x101, g*0x101, b*0x101)
+			p := row[r*3 : r*3+3 : r*3+3]
+			p[0], p[1], p[2] = quantize(outR, th), quantize(outG, th), quantize(outB, th)
+		}
+	})
+	return t
+}
+
+// lookup returns the result of the 8-bit color (r, g, b).
+func (t *Table) lookup(r, g, b uint32) []uint8 {
+	i := (r | g<<8 | b<<16) * 3
+	return t.rgb[i : i+3 : i+3]
+}
+
+// Table returns the precomputed results of the applier, or nil when it
+// was not compiled with DepthTable.
+func (a *Applier) Table() *Table {
+	return a.table
+}
+
+// processRowTable processes a single row of the image looking up the
+// precomputed results.
+func (a *Applier) processRowTable(src source, out buffer, y int) {
+	for x := out.rect.Min.X; x < out.rect.Max.X; x++ {
+		r, g, b, alpha := src(x, y)
+		v := a.table.lookup(r>>8, g>>8, b>>8)
+
+		if out.premul && alpha != 0xffff {
+			out.set(x, y, float64(v[0])/255, float64(v[1])/255, float64(v[2])/255, alpha, a.rounding())
+			continue
+		}
+		i := (y-out.rect.Min.Y)*out.stride + (x-out.rect.Min.X)*4
+		p := out.pix[i : i+4 : i+4]
+		p[0], p[1], p[2], p[3] = v[0], v[1], v[2], uint8(alpha/257)
+	}
+}
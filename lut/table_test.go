@@ -0,0 +1,52 @@
+package lut
+
+import (
+	"bytes"
+	"image"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestTableMatchesFloat(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 50))
+	opaque := randomImage(rng, 64, 64)
+	translucent := image.NewNRGBA(opaque.Rect)
+	copy(translucent.Pix, opaque.Pix)
+	for i := 3; i < len(translucent.Pix); i += 8 {
+		translucent.Pix[i] = uint8(rng.Uint32())
+	}
+	gray := image.NewGray(opaque.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(rng.Uint32())
+	}
+
+	opt := Options{Intensity: 0.8, Depth: DepthTable, Interpolation: Tetrahedral}
+	a := Compile(randomLattice(rng, 17), opt)
+	if a.Table() == nil {
+		t.Fatal("no table built")
+	}
+	opt.Table = a.Table()
+	shared := Compile(a.Lattice(), opt)
+
+	// Opaque 8-bit images get the float results, translucent ones
+	// within a level once their alpha is multiplied in.
+	tests := []struct {
+		name  string
+		img   image.Image
+		level int
+	}{
+		{"RGBA", opaque, 0},
+		{"NRGBA", translucent, 1},
+		{"Gray", gray, 0},
+	}
+
+	for _, tt := range tests {
+		got := a.Apply(tt.img).Pix
+		if largest, _ := diff(got, floatApply(a, tt.img, true)); largest > tt.level {
+			t.Errorf("%s: got samples %d levels from the float path, want at most %d", tt.name, largest, tt.level)
+		}
+		if !bytes.Equal(shared.Apply(tt.img).Pix, got) {
+			t.Errorf("%s: shared table differs", tt.name)
+		}
+	}
+}
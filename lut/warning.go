@@ -0,0 +1,44 @@
+package lut
+
+import "fmt"
+
+// Warning is a non-fatal issue found while loading a LUT.
+type Warning struct {
+	// Line is the 1-based line the issue was found on, or 0 when the
+	// issue does not refer to a specific line.
+	Line    int
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Line == 0 {
+		return w.Message
+	}
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+// StrictError is the error of Strict for a LUT deviating from the
+// specification of its format. It holds the issues a lenient load
+// recovered from.
+type StrictError struct {
+	Warnings []Warning
+}
+
+func (e *StrictError) Error() string {
+	msg := "LUT deviates from its specification: " + e.Warnings[0].String()
+	if n := len(e.Warnings) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// Strict returns a *StrictError if loading l found any issue, and nil
+// otherwise. Loaders are lenient, skipping unknown keywords and
+// recovering from minor issues: Strict rejects the LUTs they recovered,
+// as when validating LUTs before delivery.
+func Strict(l LUT) error {
+	if w := l.Warnings(); len(w) > 0 {
+		return &StrictError{Warnings: w}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package pngstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// pattern returns a w x h image of the given model with every channel
+// varying across it, and alpha too for the models having it.
+func pattern(model color.Model, w, h int) image.Image {
+	r := image.Rect(0, 0, w, h)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch model {
+	case color.GrayModel:
+		img = image.NewGray(r)
+	case color.Gray16Model:
+		img = image.NewGray16(r)
+	case color.RGBA64Model:
+		img = image.NewRGBA64(r)
+	case color.NRGBAModel:
+		img = image.NewNRGBA(r)
+	case color.NRGBA64Model:
+		img = image.NewNRGBA64(r)
+	default:
+		img = image.NewRGBA(r)
+	}
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA64{
+				R: uint16(x * 0xffff / max(1, w-1)),
+				G: uint16(y * 0xffff / max(1, h-1)),
+				B: uint16((x*7919 + y*104729) % 0x10000),
+				A: 0xffff,
+			}
+			if model == color.NRGBAModel || model == color.NRGBA64Model {
+				c.A = uint16((x + y) * 0xffff / max(1, w+h-2))
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// equal fails the test at the first pixel of got differing from want,
+// compared in the color model of got.
+func equal(t *testing.T, want, got image.Image) {
+	t.Helper()
+	b := got.Bounds()
+	if !b.In(want.Bounds()) {
+		t.Fatalf("got bounds %v, out of %v", b, want.Bounds())
+	}
+	model := got.ColorModel()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := got.At(x, y), model.Convert(want.At(x, y)); g != w {
+				t.Fatalf("pixel (%d, %d): got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	tests := []struct {
+		model       color.Model
+		deep, alpha bool
+	}{
+		{color.RGBAModel, false, false},
+		{color.RGBA64Model, true, false},
+		{color.NRGBAModel, false, true},
+		{color.NRGBA64Model, true, true},
+	}
+	sizes := []image.Point{{1, 1}, {5, 3}, {37, 29}, {300, 7}}
+
+	for _, tt := range tests {
+		for _, size := range sizes {
+			for _, strip := range []int{1, 2, 7, size.Y} {
+				name := fmt.Sprintf("deep=%t/alpha=%t/%dx%d/strip=%d", tt.deep, tt.alpha, size.X, size.Y, strip)
+				t.Run(name, func(t *testing.T) {
+					img := pattern(tt.model, size.X, size.Y)
+					var buf bytes.Buffer
+					e, err := NewEncoder(&buf, size.X, size.Y, tt.deep, tt.alpha)
+					if err != nil {
+						t.Fatal(err)
+					}
+					sub := img.(interface {
+						SubImage(image.Rectangle) image.Image
+					})
+					for y := 0; y < size.Y; y += strip {
+						if err := e.WriteRows(sub.SubImage(image.Rect(0, y, size.X, min(y+strip, size.Y)))); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if err := e.Close(); err != nil {
+						t.Fatal(err)
+					}
+
+					dec, err := png.Decode(bytes.NewReader(buf.Bytes()))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if dec.ColorModel() != tt.model {
+						t.Errorf("got model %v, want %v", dec.ColorModel(), tt.model)
+					}
+					equal(t, img, dec)
+				})
+			}
+		}
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	models := []color.Model{
+		color.GrayModel,
+		color.Gray16Model,
+		color.RGBAModel,
+		color.RGBA64Model,
+		color.NRGBAModel,
+		color.NRGBA64Model,
+	}
+
+	for _, model := range models {
+		for _, strip := range []int{1, 3, 16, 41} {
+			img := pattern(model, 23, 41)
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				t.Fatal(err)
+			}
+			want, err := png.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := Open(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.ColorModel() != want.ColorModel() {
+				t.Errorf("%T: got model %v, want %v", want, m.ColorModel(), want.ColorModel())
+			}
+			if m.Bounds() != want.Bounds() {
+				t.Fatalf("%T: got bounds %v, want %v", want, m.Bounds(), want.Bounds())
+			}
+			for y := 0; y < 41; y += strip {
+				rows, err := m.ReadRows(y, min(y+strip, 41))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rows.ColorModel() != want.ColorModel() {
+					t.Fatalf("%T: got rows of model %v", want, rows.ColorModel())
+				}
+				equal(t, want, rows)
+			}
+		}
+	}
+}
+
+func TestReadRowsOutOfOrder(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, pattern(color.RGBAModel, 4, 4)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadRows(1, 2); err == nil {
+		t.Error("read row 1 before row 0")
+	}
+	if _, err := m.ReadRows(0, 5); err == nil {
+		t.Error("read rows past the image")
+	}
+	if _, err := m.ReadRows(0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadRows(0, 2); err == nil {
+		t.Error("read rows 0 to 2 twice")
+	}
+}
+
+// setIHDR returns the PNG data with the byte at offset i of the IHDR
+// chunk replaced by v, and its CRC updated.
+func setIHDR(data []byte, i int, v byte) []byte {
+	data = bytes.Clone(data)
+	ihdr := data[len(signature)+4 : len(signature)+8+13]
+	ihdr[4+i] = v
+	binary.BigEndian.PutUint32(data[len(signature)+8+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestOpenUnsupported(t *testing.T) {
+	var rgb, paletted bytes.Buffer
+	if err := png.Encode(&rgb, pattern(color.RGBAModel, 8, 8)); err != nil {
+		t.Fatal(err)
+	}
+	pal := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	if err := png.Encode(&paletted, pal); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"interlaced", setIHDR(rgb.Bytes(), 12, 1), ErrUnsupported},
+		{"paletted", paletted.Bytes(), ErrUnsupported},
+		{"4 bits", setIHDR(setIHDR(rgb.Bytes(), 9, ctGray), 8, 4), ErrUnsupported},
+		{"color type", setIHDR(rgb.Bytes(), 9, 5), ErrInvalid},
+		{"signature", append([]byte("\x89PNH"), rgb.Bytes()[4:]...), ErrInvalid},
+	}
+	for _, tt := range tests {
+		if _, err := Open(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadRowsTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, pattern(color.RGBAModel, 64, 64)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	if err == nil {
+		_, err = m.ReadRows(0, 64)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestEncoderRows(t *testing.T) {
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, 4, 4, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteRows(pattern(color.RGBAModel, 5, 2)); err == nil {
+		t.Error("wrote rows wider than the image")
+	}
+	if err := e.WriteRows(pattern(color.RGBAModel, 4, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err == nil {
+		t.Error("closed the image with 2 of 4 rows written")
+	}
+	if _, err := NewEncoder(&buf, 0, 4, false, false); err == nil {
+		t.Error("created an image 0 pixels wide")
+	}
+}
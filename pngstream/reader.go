@@ -0,0 +1,377 @@
+// Package pngstream reads and writes PNG images a strip of rows at a
+// time, so that images too large for memory can be processed as they
+// are decoded. It covers the common 8 and 16-bit non-interlaced formats;
+// image/png handles the rest.
+package pngstream
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+)
+
+const signature = "\x89PNG\r\n\x1a\n"
+
+// Color types of the IHDR chunk.
+const (
+	ctGray      = 0
+	ctRGB       = 2
+	ctPaletted  = 3
+	ctGrayAlpha = 4
+	ctRGBA      = 6
+)
+
+// Row filter types.
+const (
+	ftNone = iota
+	ftSub
+	ftUp
+	ftAverage
+	ftPaeth
+	nFilter
+)
+
+var (
+	ErrInvalid     = errors.New("invalid PNG data")
+	ErrUnsupported = errors.New("unsupported PNG image")
+)
+
+// Image reads the rows of a PNG image in order, from top to bottom.
+type Image struct {
+	width, height int
+	depth         int
+	colorType     byte
+
+	r   *idatReader
+	zr  io.ReadCloser
+	cur []byte // Current and previous rows, with the filter byte.
+	pre []byte
+	y   int
+}
+
+// Open reads the chunks of the PNG image read from r up to its pixel
+// data. The image must be 8 or 16 bits per sample, non-interlaced and
+// not paletted, without a transparent color.
+func Open(r io.Reader) (*Image, error) {
+	br := bufio.NewReader(r)
+
+	sig := make([]byte, len(signature))
+	if _, err := io.ReadFull(br, sig); err != nil {
+		return nil, err
+	}
+	if string(sig) != signature {
+		return nil, ErrInvalid
+	}
+
+	m := &Image{r: &idatReader{r: br}}
+	for {
+		typ, data, err := m.r.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case "IHDR":
+			if err := m.parseIHDR(data); err != nil {
+				return nil, err
+			}
+		case "tRNS":
+			return nil, fmt.Errorf("%w: transparent color", ErrUnsupported)
+		case "IDAT":
+			if m.width == 0 {
+				return nil, ErrInvalid
+			}
+			m.r.data = data
+			rowSize := 1 + (m.width*m.depth*m.channels()+7)/8
+			m.cur, m.pre = make([]byte, rowSize), make([]byte, rowSize)
+			return m, nil
+		case "IEND":
+			return nil, ErrInvalid
+		}
+	}
+}
+
+// parseIHDR reads the image header.
+func (m *Image) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return ErrInvalid
+	}
+	m.width = int(binary.BigEndian.Uint32(data))
+	m.height = int(binary.BigEndian.Uint32(data[4:]))
+	m.depth = int(data[8])
+	m.colorType = data[9]
+
+	switch {
+	case m.width <= 0 || m.height <= 0:
+		return ErrInvalid
+	case data[10] != 0 || data[11] != 0:
+		return ErrInvalid
+	case data[12] != 0:
+		return fmt.Errorf("%w: interlaced", ErrUnsupported)
+	case m.colorType == ctPaletted:
+		return fmt.Errorf("%w: paletted", ErrUnsupported)
+	case m.colorType != ctGray && m.colorType != ctRGB && m.colorType != ctGrayAlpha && m.colorType != ctRGBA:
+		return ErrInvalid
+	case m.depth != 8 && m.depth != 16:
+		return fmt.Errorf("%w: %d bits per sample", ErrUnsupported, m.depth)
+	}
+	return nil
+}
+
+// channels returns the number of samples of each pixel.
+func (m *Image) channels() int {
+	switch m.colorType {
+	case ctGray:
+		return 1
+	case ctGrayAlpha:
+		return 2
+	case ctRGB:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Bounds returns the bounds of the image.
+func (m *Image) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.width, m.height)
+}
+
+// Deep reports whether the image has 16 bits per sample.
+func (m *Image) Deep() bool {
+	return m.depth == 16
+}
+
+// Alpha reports whether the image has an alpha channel.
+func (m *Image) Alpha() bool {
+	return m.colorType == ctGrayAlpha || m.colorType == ctRGBA
+}
+
+// ColorModel returns the color model of the images returned by ReadRows,
+// the same image/png decodes the image with.
+func (m *Image) ColorModel() color.Model {
+	switch {
+	case m.colorType == ctGray && m.Deep():
+		return color.Gray16Model
+	case m.colorType == ctGray:
+		return color.GrayModel
+	case m.Alpha() && m.Deep():
+		return color.NRGBA64Model
+	case m.Alpha():
+		return color.NRGBAModel
+	case m.Deep():
+		return color.RGBA64Model
+	default:
+		return color.RGBAModel
+	}
+}
+
+// ReadRows decodes the rows from y0 to y1 excluded, returned with the
+// same coordinates they have in the full image. Rows must be read in
+// order: y0 is the first row not read yet.
+func (m *Image) ReadRows(y0, y1 int) (image.Image, error) {
+	r := image.Rect(0, y0, m.width, y1)
+	if r.Empty() || !r.In(m.Bounds()) || y0 != m.y {
+		return nil, fmt.Errorf("pngstream: rows %d to %d out of order", y0, y1)
+	}
+	if m.zr == nil {
+		zr, err := zlib.NewReader(m.r)
+		if err != nil {
+			return nil, err
+		}
+		m.zr = zr
+	}
+
+	var (
+		img    image.Image
+		pix    []uint8
+		stride int
+	)
+	switch m.ColorModel() {
+	case color.Gray16Model:
+		g := image.NewGray16(r)
+		img, pix, stride = g, g.Pix, g.Stride
+	case color.GrayModel:
+		g := image.NewGray(r)
+		img, pix, stride = g, g.Pix, g.Stride
+	case color.NRGBA64Model:
+		n := image.NewNRGBA64(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	case color.NRGBAModel:
+		n := image.NewNRGBA(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	case color.RGBA64Model:
+		n := image.NewRGBA64(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	default:
+		n := image.NewRGBA(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	}
+
+	for y := y0; y < y1; y++ {
+		if err := m.readRow(); err != nil {
+			return nil, fmt.Errorf("pngstream: row %d: %w", y, err)
+		}
+		m.convert(pix[(y-y0)*stride:])
+		m.y++
+	}
+	return img, nil
+}
+
+// readRow reads and unfilters the next row into m.cur.
+func (m *Image) readRow() error {
+	m.cur, m.pre = m.pre, m.cur
+	if _, err := io.ReadFull(m.zr, m.cur); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	bpp := max(1, m.depth*m.channels()/8)
+	cur, pre := m.cur[1:], m.pre[1:]
+	// The row above the first one is all zeros.
+	if m.y == 0 {
+		clear(pre)
+	}
+
+	switch m.cur[0] {
+	case ftNone:
+	case ftSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case ftUp:
+		for i, p := range pre {
+			cur[i] += p
+		}
+	case ftAverage:
+		for i := range bpp {
+			cur[i] += pre[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(pre[i])) / 2)
+		}
+	case ftPaeth:
+		for i := range bpp {
+			cur[i] += pre[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += paeth(cur[i-bpp], pre[i], pre[i-bpp])
+		}
+	default:
+		return ErrInvalid
+	}
+	return nil
+}
+
+// convert stores the current row in dst, laid out as the pixels of the
+// image returned by ReadRows.
+func (m *Image) convert(dst []byte) {
+	row := m.cur[1:]
+	switch m.colorType {
+	case ctGray, ctRGBA:
+		copy(dst, row)
+		return
+	}
+
+	// Samples are copied whole, one or two bytes each.
+	n := m.depth / 8
+	for x := range m.width {
+		switch m.colorType {
+		case ctRGB:
+			copy(dst[x*4*n:], row[x*3*n:(x+1)*3*n])
+			dst[x*4*n+3*n] = 0xff
+			if n == 2 {
+				dst[x*4*n+3*n+1] = 0xff
+			}
+		case ctGrayAlpha:
+			g, a := row[x*2*n:x*2*n+n], row[x*2*n+n:(x+1)*2*n]
+			p := dst[x*4*n:]
+			copy(p, g)
+			copy(p[n:], g)
+			copy(p[2*n:], g)
+			copy(p[3*n:], a)
+		}
+	}
+}
+
+// paeth implements the Paeth predictor of the PNG specification.
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	default:
+		return c
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// idatReader reads the chunks of a PNG stream and the concatenated data
+// of its IDAT chunks, checking their CRC.
+type idatReader struct {
+	r    *bufio.Reader
+	data []byte
+	done bool
+}
+
+// next reads the following chunk.
+func (ir *idatReader) next() (string, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(ir.r, hdr[:]); err != nil {
+		return "", nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n > 1<<31-1 {
+		return "", nil, ErrInvalid
+	}
+
+	data := make([]byte, n+4)
+	if _, err := io.ReadFull(ir.r, data); err != nil {
+		return "", nil, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data[:n])
+	if crc.Sum32() != binary.BigEndian.Uint32(data[n:]) {
+		return "", nil, fmt.Errorf("%w: bad CRC in %s chunk", ErrInvalid, hdr[4:])
+	}
+	return string(hdr[4:]), data[:n], nil
+}
+
+func (ir *idatReader) Read(p []byte) (int, error) {
+	for len(ir.data) == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		typ, data, err := ir.next()
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			// Pixel data is contiguous, anything else marks its end.
+			ir.done = true
+			continue
+		}
+		ir.data = data
+	}
+	n := copy(p, ir.data)
+	ir.data = ir.data[n:]
+	return n, nil
+}
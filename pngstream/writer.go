@@ -0,0 +1,251 @@
+package pngstream
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Encoder writes a PNG image a few rows at a time, so that images too
+// large for memory can be written as they are produced.
+type Encoder struct {
+	w      io.Writer
+	bw     *bufio.Writer
+	zw     *zlib.Writer
+	width  int
+	height int
+	deep   bool
+	alpha  bool
+	spp    int // Samples per pixel.
+	bpp    int // Bytes per pixel.
+
+	// Current and previous rows, and the row filtered with each filter
+	// type, all prefixed by the filter byte.
+	cur, pre []byte
+	filtered [nFilter][]byte
+	y        int
+	err      error
+}
+
+// NewEncoder writes the header of a width by height RGB image to w, with
+// 16 bits per sample if deep and a straight alpha channel if alpha. The
+// pixels are then written with WriteRows.
+func NewEncoder(w io.Writer, width, height int, deep, alpha bool) (*Encoder, error) {
+	return NewEncoderLevel(w, width, height, deep, alpha, png.DefaultCompression)
+}
+
+// NewEncoderLevel is like NewEncoder, compressing the rows at the given
+// level, from png.BestSpeed for previews to png.BestCompression for
+// files kept and shared.
+func NewEncoderLevel(w io.Writer, width, height int, deep, alpha bool, level png.CompressionLevel) (*Encoder, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("pngstream: invalid size %dx%d", width, height)
+	}
+
+	e := &Encoder{w: w, width: width, height: height, deep: deep, alpha: alpha}
+	depth, ct := byte(8), byte(ctRGB)
+	e.spp = 3
+	if deep {
+		depth = 16
+	}
+	if alpha {
+		ct, e.spp = ctRGBA, 4
+	}
+	e.bpp = e.spp * int(depth) / 8
+
+	if _, err := io.WriteString(w, signature); err != nil {
+		return nil, err
+	}
+	var ihdr [13]byte
+	binary.BigEndian.PutUint32(ihdr[:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = depth, ct
+	e.writeChunk("IHDR", ihdr[:])
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	rowSize := 1 + width*e.bpp
+	e.cur, e.pre = make([]byte, rowSize), make([]byte, rowSize)
+	for i := range e.filtered {
+		e.filtered[i] = make([]byte, rowSize)
+		e.filtered[i][0] = byte(i)
+	}
+
+	// Buffered data is flushed as IDAT chunks.
+	e.bw = bufio.NewWriterSize(chunkWriter{e}, 1<<15)
+	e.zw, _ = zlib.NewWriterLevel(e.bw, zlibLevel(level))
+	return e, nil
+}
+
+// zlibLevel returns the zlib level of a PNG compression level.
+func zlibLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return zlib.NoCompression
+	case png.BestSpeed:
+		return zlib.BestSpeed
+	case png.BestCompression:
+		return zlib.BestCompression
+	default:
+		return zlib.DefaultCompression
+	}
+}
+
+// WriteProfile embeds the ICC profile in the image, in an iCCP chunk. It
+// must be called before any row is written.
+func (e *Encoder) WriteProfile(profile []byte) error {
+	if e.y > 0 {
+		return fmt.Errorf("pngstream: profile written after the rows")
+	}
+
+	var data bytes.Buffer
+	data.WriteString("ICC profile\x00\x00")
+	zw := zlib.NewWriter(&data)
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	e.writeChunk("iCCP", data.Bytes())
+	return e.err
+}
+
+// WriteRows writes every row of img, which must be as wide as the image
+// and follow the rows already written.
+func (e *Encoder) WriteRows(img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != e.width || e.y+b.Dy() > e.height {
+		return fmt.Errorf("pngstream: %dx%d rows do not fit the image", b.Dx(), b.Dy())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		e.cur, e.pre = e.pre, e.cur
+		e.encodeRow(img, y)
+		if _, err := e.zw.Write(e.filter()); err != nil {
+			return err
+		}
+		e.y++
+	}
+	return e.err
+}
+
+// Close writes the end of the image, failing if some of its rows were
+// not written.
+func (e *Encoder) Close() error {
+	if e.y != e.height {
+		return fmt.Errorf("pngstream: %d of %d rows written", e.y, e.height)
+	}
+	if err := e.zw.Close(); err != nil {
+		return err
+	}
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+	e.writeChunk("IEND", nil)
+	return e.err
+}
+
+// encodeRow fills e.cur with the non-premultiplied samples of row y.
+func (e *Encoder) encodeRow(img image.Image, y int) {
+	b := img.Bounds()
+	row := e.cur[1:]
+
+	// Straight 8-bit samples are copied as they are.
+	if m, ok := img.(*image.NRGBA); ok && !e.deep && e.alpha {
+		copy(row, m.Pix[m.PixOffset(b.Min.X, y):])
+		return
+	}
+
+	i := 0
+	for x := b.Min.X; x < b.Max.X; x++ {
+		c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		samples := [4]uint16{c.R, c.G, c.B, c.A}
+
+		for _, s := range samples[:e.spp] {
+			if e.deep {
+				binary.BigEndian.PutUint16(row[i:], s)
+				i += 2
+			} else {
+				row[i] = uint8(s >> 8)
+				i++
+			}
+		}
+	}
+}
+
+// filter returns the current row filtered with the type giving the
+// smallest sum of absolute differences, as image/png chooses it.
+func (e *Encoder) filter() []byte {
+	cur, pre := e.cur[1:], e.pre[1:]
+	if e.y == 0 {
+		clear(pre)
+	}
+	bpp := e.bpp
+
+	sub := e.filtered[ftSub][1:]
+	up := e.filtered[ftUp][1:]
+	avg := e.filtered[ftAverage][1:]
+	pth := e.filtered[ftPaeth][1:]
+	for i := range cur {
+		var a, c uint8
+		if i >= bpp {
+			a, c = cur[i-bpp], pre[i-bpp]
+		}
+		sub[i] = cur[i] - a
+		up[i] = cur[i] - pre[i]
+		avg[i] = cur[i] - uint8((int(a)+int(pre[i]))/2)
+		pth[i] = cur[i] - paeth(a, pre[i], c)
+	}
+
+	best, bestSum := e.cur, sum(cur)
+	e.cur[0] = ftNone
+	for _, f := range e.filtered[ftSub:] {
+		if s := sum(f[1:]); s < bestSum {
+			best, bestSum = f, s
+		}
+	}
+	return best
+}
+
+// sum returns the sum of the absolute values of the filtered bytes.
+func sum(row []byte) int {
+	s := 0
+	for _, v := range row {
+		s += abs(int(int8(v)))
+	}
+	return s
+}
+
+// writeChunk writes a chunk with its length and CRC.
+func (e *Encoder) writeChunk(typ string, data []byte) {
+	if e.err != nil {
+		return
+	}
+	buf := make([]byte, 0, 12+len(data))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, typ...)
+	buf = append(buf, data...)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	_, e.err = e.w.Write(buf)
+}
+
+// chunkWriter writes the compressed pixel data as IDAT chunks.
+type chunkWriter struct {
+	e *Encoder
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	cw.e.writeChunk("IDAT", p)
+	if cw.e.err != nil {
+		return 0, cw.e.err
+	}
+	return len(p), nil
+}
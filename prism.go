@@ -0,0 +1,166 @@
+// Package prism loads LUTs in any format it can tell, applies them to
+// images, blends them and converts them between formats. It is the
+// library the prism command is built on; the cube, hald and lut
+// packages hold the LUTs themselves.
+package prism
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NicoNex/prism/cube"
+	"github.com/NicoNex/prism/hald"
+	"github.com/NicoNex/prism/lut"
+)
+
+// SniffLen is the number of bytes from the start of a file Detect needs
+// to tell its LUT format.
+const SniffLen = 4096
+
+var (
+	// ErrUnknownFormat is returned when neither the contents nor the
+	// name of a file tell its LUT format.
+	ErrUnknownFormat = errors.New("unrecognized LUT format")
+	// ErrUnsupportedFormat is returned for files in known formats that
+	// prism does not read as LUTs, such as GIF images.
+	ErrUnsupportedFormat = errors.New("unsupported LUT format")
+)
+
+// Detect tells the LUT format of a file from its first bytes, at least
+// SniffLen of them when the file is that long, and its name, used only
+// when the contents are not recognized. The format is the extension of
+// its files: .cube for CUBEs, in text or binary form, .png for HALDs,
+// in PNG, JPEG or TIFF images, and the extension of the formats added
+// with lut.RegisterFormat.
+func Detect(head []byte, name string) (string, error) {
+	typ, err := sniff(head)
+	if typ != "" {
+		return typ, nil
+	}
+	// Registered formats may be built on formats prism does not read as
+	// LUTs, such as XML.
+	if f, ok := lut.SniffFormat(head); ok {
+		return f.Ext, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if _, ok := lut.LookupFormat(ext); ok || ext == ".cube" || ext == ".png" {
+		return ext, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// sniff tells the LUT format of a file from its first bytes. It returns
+// an empty type for contents it does not recognize and an error for
+// known formats prism does not read.
+func sniff(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")),
+		bytes.HasPrefix(head, []byte("\xff\xd8\xff")),
+		bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return ".png", nil
+	case cube.IsBinary(head):
+		return ".cube", nil
+	case bytes.HasPrefix(head, []byte("GIF8")):
+		return "", fmt.Errorf("%w: GIF image, HALDs must be PNG, JPEG or TIFF", ErrUnsupportedFormat)
+	}
+
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if bytes.HasPrefix(text, []byte("<")) {
+		return "", fmt.Errorf("%w: XML", ErrUnsupportedFormat)
+	}
+
+	// A CUBE starts with its keywords, possibly after comments.
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch strings.ToUpper(strings.Fields(line)[0]) {
+		case "TITLE", "LUT_3D_SIZE", "DOMAIN_MIN", "DOMAIN_MAX", "LUT_3D_INPUT_RANGE", "LUT_IN_VIDEO_RANGE", "LUT_OUT_VIDEO_RANGE":
+			return ".cube", nil
+		default:
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// Load loads a LUT read from r in the format Detect tells from its
+// contents and, failing that, from name, which may be empty.
+func Load(r io.Reader, name string) (lut.LUT, error) {
+	br := bufio.NewReaderSize(r, SniffLen)
+	head, err := br.Peek(SniffLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	typ, err := Detect(head, name)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(br, typ)
+}
+
+// Decode decodes a LUT of the format typ, as returned by Detect, read
+// from r.
+func Decode(r io.Reader, typ string) (lut.LUT, error) {
+	if typ == ".png" {
+		h, err := hald.Load(r)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	if format, ok := lut.LookupFormat(typ); ok {
+		return format.Load(r)
+	}
+	if typ != ".cube" {
+		return nil, ErrUnknownFormat
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var c cube.Cube
+	if cube.IsBinary(data) {
+		err = c.UnmarshalBinary(data)
+	} else {
+		c, err = cube.Load(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile loads the LUT at path, as Load does.
+func LoadFile(path string) (lut.LUT, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f, path)
+}
+
+// LoadFS loads the LUT at path in fsys, as Load does.
+func LoadFS(fsys fs.FS, path string) (lut.LUT, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f, path)
+}
@@ -0,0 +1,41 @@
+package sheet
+
+import (
+	"image"
+	"image/draw"
+)
+
+// newLike returns a blank image with bounds r at the depth of img: 16
+// bits per channel for 16-bit images and 8 otherwise.
+func newLike(img image.Image, r image.Rectangle) draw.Image {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64:
+		return image.NewRGBA64(r)
+	default:
+		return image.NewRGBA(r)
+	}
+}
+
+// Split returns an image as large as after showing before on its left
+// half and after on its right half, divided by a vertical line.
+func Split(before, after image.Image) image.Image {
+	b := after.Bounds()
+	out := newLike(after, image.Rect(0, 0, b.Dx(), b.Dy()))
+	mid := b.Dx() / 2
+	draw.Draw(out, image.Rect(0, 0, mid, b.Dy()), before, before.Bounds().Min, draw.Src)
+	draw.Draw(out, image.Rect(mid, 0, b.Dx(), b.Dy()), after, b.Min.Add(image.Pt(mid, 0)), draw.Src)
+
+	line := max(1, b.Dx()/500)
+	draw.Draw(out, image.Rect(mid-line/2, 0, mid-line/2+line, b.Dy()), image.NewUniform(Foreground), image.Point{}, draw.Src)
+	return out
+}
+
+// SideBySide returns before and after next to each other, before on the
+// left.
+func SideBySide(before, after image.Image) image.Image {
+	bb, ab := before.Bounds(), after.Bounds()
+	out := newLike(after, image.Rect(0, 0, bb.Dx()+ab.Dx(), max(bb.Dy(), ab.Dy())))
+	draw.Draw(out, image.Rect(0, 0, bb.Dx(), bb.Dy()), before, bb.Min, draw.Src)
+	draw.Draw(out, image.Rect(bb.Dx(), 0, bb.Dx()+ab.Dx(), ab.Dy()), after, ab.Min, draw.Src)
+	return out
+}
@@ -0,0 +1,120 @@
+// Package sheet composes labeled images into grids, for contact sheets
+// and before and after comparisons.
+package sheet
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode/utf8"
+)
+
+// Size of the glyphs of the built-in font, in pixels at scale 1, and
+// the distance between the start of two characters.
+const (
+	glyphW  = 5
+	glyphH  = 7
+	advance = glyphW + 1
+)
+
+// Colors of the sheet background and of the labels.
+var (
+	Background = color.RGBA{0x1e, 0x1e, 0x1e, 0xff}
+	Foreground = color.RGBA{0xe6, 0xe6, 0xe6, 0xff}
+)
+
+// Tile is an image of a sheet with its label.
+type Tile struct {
+	Image image.Image
+	Label string
+}
+
+// TextWidth returns the width in pixels of s drawn at the given scale.
+func TextWidth(s string, scale int) int {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+	return (n*advance - 1) * scale
+}
+
+// TextHeight returns the height in pixels of a line of text drawn at
+// the given scale.
+func TextHeight(scale int) int {
+	return glyphH * scale
+}
+
+// Truncate shortens s with an ellipsis so that it is at most width
+// pixels wide at the given scale.
+func Truncate(s string, width, scale int) string {
+	if TextWidth(s, scale) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for n := len(runes) - 1; n > 0; n-- {
+		if t := string(runes[:n]) + "..."; TextWidth(t, scale) <= width {
+			return t
+		}
+	}
+	return ""
+}
+
+// DrawText draws s on dst with its top left corner at (x, y), in the
+// built-in 5x7 font enlarged scale times. Characters outside printable
+// ASCII are drawn as question marks.
+func DrawText(dst draw.Image, x, y int, s string, scale int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		for col, bits := range font[r-' '] {
+			for row := range glyphH {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(dst, px, src, image.Point{}, draw.Src)
+			}
+		}
+		x += advance * scale
+	}
+}
+
+// LabelScale returns the scale of the labels of tiles of the given
+// width, so that they stay legible on large tiles.
+func LabelScale(width int) int {
+	return max(1, width/200)
+}
+
+// Grid lays the tiles out in rows of cols, left to right and top to
+// bottom, on the Background. Each tile is centered in a cell as large as
+// the largest tile, with its label below it.
+func Grid(tiles []Tile, cols int) *image.RGBA {
+	cols = max(1, min(cols, len(tiles)))
+	rows := (len(tiles) + cols - 1) / cols
+
+	var cellW, cellH int
+	for _, t := range tiles {
+		b := t.Image.Bounds()
+		cellW, cellH = max(cellW, b.Dx()), max(cellH, b.Dy())
+	}
+	scale := LabelScale(cellW)
+	gap := 4 * scale
+	labelH := TextHeight(scale) + 2*gap
+	stepX, stepY := cellW+gap, cellH+labelH
+
+	out := image.NewRGBA(image.Rect(0, 0, gap+cols*stepX, gap+rows*stepY))
+	draw.Draw(out, out.Bounds(), image.NewUniform(Background), image.Point{}, draw.Src)
+
+	for i, t := range tiles {
+		x0, y0 := gap+(i%cols)*stepX, gap+(i/cols)*stepY
+		b := t.Image.Bounds()
+		at := image.Pt(x0+(cellW-b.Dx())/2, y0+(cellH-b.Dy())/2)
+		draw.Draw(out, b.Sub(b.Min).Add(at), t.Image, b.Min, draw.Src)
+
+		label := Truncate(t.Label, cellW, scale)
+		DrawText(out, x0+(cellW-TextWidth(label, scale))/2, y0+cellH+gap, label, scale, Foreground)
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package termimg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/NicoNex/prism/transform"
+)
+
+// Protocol is a terminal inline image protocol.
+type Protocol int
+
+const (
+	None Protocol = iota
+	Kitty
+	ITerm
+	Sixel
+)
+
+// Maximum size in pixels of the images shown in the terminal.
+const (
+	MaxWidth  = 800
+	MaxHeight = 600
+)
+
+var ErrUnsupported = errors.New("no inline image protocol detected for this terminal (set PRISM_IMAGE_PROTOCOL to kitty, iterm or sixel)")
+
+// Detect returns the image protocol supported by the terminal prism is
+// running in, looking at the environment. PRISM_IMAGE_PROTOCOL overrides
+// the detection.
+func Detect() Protocol {
+	switch strings.ToLower(os.Getenv("PRISM_IMAGE_PROTOCOL")) {
+	case "kitty":
+		return Kitty
+	case "iterm", "iterm2":
+		return ITerm
+	case "sixel":
+		return Sixel
+	}
+
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "",
+		strings.Contains(term, "kitty"),
+		strings.Contains(term, "ghostty"):
+		return Kitty
+
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app",
+		os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return ITerm
+
+	case strings.Contains(term, "sixel"),
+		strings.HasPrefix(term, "foot"),
+		strings.HasPrefix(term, "mlterm"),
+		strings.HasPrefix(term, "yaft"):
+		return Sixel
+
+	default:
+		return None
+	}
+}
+
+// Show displays the image inline in the terminal, downscaling it to fit
+// within MaxWidth x MaxHeight.
+func Show(w io.Writer, img *image.RGBA) error {
+	img = transform.Fit(img, MaxWidth, MaxHeight)
+
+	switch Detect() {
+	case Kitty:
+		return writeKitty(w, img)
+	case ITerm:
+		return writeITerm(w, img)
+	case Sixel:
+		return writeSixel(w, img)
+	default:
+		return ErrUnsupported
+	}
+}
+
+// writeKitty writes the image using the kitty graphics protocol.
+func writeKitty(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	// Payloads are transmitted in chunks of at most 4096 bytes.
+	const chunk = 4096
+	for i := 0; i < len(data); i += chunk {
+		end := min(i+chunk, len(data))
+		more := 0
+		if end < len(data) {
+			more = 1
+		}
+
+		var err error
+		if i == 0 {
+			_, err = fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, data[i:end])
+		} else {
+			_, err = fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, data[i:end])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// writeITerm writes the image using the iTerm2 inline images protocol.
+func writeITerm(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		"\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n",
+		buf.Len(),
+		base64.StdEncoding.EncodeToString(buf.Bytes()),
+	)
+	return err
+}
+
+// writeSixel writes the image as DEC sixel graphics, dithered to the
+// 216 colors web safe palette.
+func writeSixel(w io.Writer, img *image.RGBA) error {
+	b := img.Bounds()
+	pal := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, pal.Bounds(), img, b.Min)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "\x1bPq\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i, c := range pal.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	width, height := b.Dx(), b.Dy()
+	row := make([]byte, width)
+	for band := 0; band < height; band += 6 {
+		used := make(map[uint8]bool)
+		for y := band; y < min(band+6, height); y++ {
+			for x := range width {
+				used[pal.ColorIndexAt(x, y)] = true
+			}
+		}
+
+		first := true
+		for idx := range len(pal.Palette) {
+			if !used[uint8(idx)] {
+				continue
+			}
+			if !first {
+				bw.WriteByte('$')
+			}
+			first = false
+
+			for x := range width {
+				var bits byte
+				for dy := range 6 {
+					y := band + dy
+					if y < height && pal.ColorIndexAt(x, y) == uint8(idx) {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(bw, "#%d", idx)
+			writeRLE(bw, row)
+		}
+		bw.WriteByte('-')
+	}
+
+	bw.WriteString("\x1b\\\n")
+	return bw.Flush()
+}
+
+// writeRLE writes a row of sixel characters using run length encoding.
+func writeRLE(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			for range n {
+				w.WriteByte(row[i])
+			}
+		}
+		i = j
+	}
+}
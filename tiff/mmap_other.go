@@ -0,0 +1,19 @@
+//go:build !unix
+
+package tiff
+
+import (
+	"errors"
+	"os"
+)
+
+// canMap reports whether files can be mapped in memory.
+const canMap = false
+
+func mapRange(f *os.File, off, n int64) ([]byte, int, error) {
+	return nil, 0, errors.ErrUnsupported
+}
+
+func unmap(data []byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package tiff
+
+import (
+	"os"
+	"syscall"
+)
+
+// canMap reports whether files can be mapped in memory.
+const canMap = true
+
+// mapRange maps read-only the n bytes of f from off, which must lie
+// within the file. It returns the mapping, starting at the page holding
+// off, and the index of off in it.
+func mapRange(f *os.File, off, n int64) ([]byte, int, error) {
+	page := int64(os.Getpagesize())
+	start := off &^ (page - 1)
+	data, err := syscall.Mmap(int(f.Fd()), start, int(off-start+n), syscall.PROT_READ, syscall.MAP_SHARED)
+	return data, int(off - start), err
+}
+
+// unmap releases a mapping made by mapRange.
+func unmap(data []byte) error {
+	return syscall.Munmap(data)
+}
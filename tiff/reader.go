@@ -0,0 +1,455 @@
+package tiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+)
+
+// Compression schemes supported by Image.
+const (
+	compressionNone       = 1
+	compressionDeflate    = 8
+	compressionOldDeflate = 32946
+)
+
+// Alpha channel kinds of the ExtraSamples tag.
+const (
+	alphaNone         = 0
+	alphaAssociated   = 1
+	alphaUnassociated = 2
+)
+
+var ErrUnsupported = errors.New("unsupported TIFF image")
+
+func init() {
+	image.RegisterFormat("tiff", "II*\x00", Decode, DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00*", Decode, DecodeConfig)
+}
+
+// Image gives access to the pixels of the first image of a baseline RGB
+// or grayscale TIFF file, strip by strip, without reading it whole.
+// Strips may be uncompressed or Deflate compressed, with or without
+// horizontal differencing. An Image is not safe for concurrent use.
+type Image struct {
+	r  io.ReaderAt
+	bo binary.ByteOrder
+
+	width, height int
+	bps, spp      int
+	gray          bool
+	alpha         uint32
+
+	compression  uint32
+	predictor    uint32
+	rowsPerStrip int
+	offsets      []uint32
+	counts       []uint32
+
+	// File mapped strip by strip, with its size and the mapping of the
+	// current strip, and the function releasing the file.
+	file    *os.File
+	size    int64
+	window  []byte
+	release func() error
+
+	// Last strip decoded, kept for the following rows.
+	strip int
+	data  []byte
+}
+
+// Open reads the layout of the first image of the TIFF file read from r.
+func Open(r io.ReaderAt) (*Image, error) {
+	t, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	ifd, err := t.IFD(t.First)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Image{
+		r:           r,
+		bo:          t.ByteOrder,
+		width:       int(ifd.Uint(TagImageWidth, 0)),
+		height:      int(ifd.Uint(TagImageLength, 0)),
+		spp:         int(ifd.Uint(TagSamplesPerPixel, 1)),
+		compression: ifd.Uint(TagCompression, compressionNone),
+		predictor:   ifd.Uint(TagPredictor, 1),
+		offsets:     ifd.Uints(TagStripOffsets),
+		counts:      ifd.Uints(TagStripByteCounts),
+		strip:       -1,
+	}
+	if m.width <= 0 || m.height <= 0 || m.offsets == nil || m.counts == nil {
+		return nil, ErrMissingTag
+	}
+
+	switch {
+	case ifd.Has(TagTileWidth):
+		return nil, fmt.Errorf("%w: tiled layout", ErrUnsupported)
+	case ifd.Uint(TagPlanarConfiguration, 1) != 1:
+		return nil, fmt.Errorf("%w: planar layout", ErrUnsupported)
+	case ifd.Uint(TagSampleFormat, 1) != 1:
+		return nil, fmt.Errorf("%w: non integer samples", ErrUnsupported)
+	case m.compression != compressionNone && m.compression != compressionDeflate && m.compression != compressionOldDeflate:
+		return nil, fmt.Errorf("%w: compression %d", ErrUnsupported, m.compression)
+	case m.predictor != 1 && m.predictor != 2:
+		return nil, fmt.Errorf("%w: predictor %d", ErrUnsupported, m.predictor)
+	}
+
+	colors := 3
+	switch p := ifd.Uint(TagPhotometricInterpretation, 2); p {
+	case 1:
+		m.gray, colors = true, 1
+	case 2:
+	default:
+		return nil, fmt.Errorf("%w: photometric interpretation %d", ErrUnsupported, p)
+	}
+	if m.spp < colors {
+		return nil, ErrInvalidIFD
+	}
+	if m.spp > colors {
+		m.alpha = ifd.Uint(TagExtraSamples, alphaNone)
+	}
+
+	bps := ifd.Uints(TagBitsPerSample)
+	if len(bps) == 0 {
+		bps = []uint32{1}
+	}
+	for _, b := range bps {
+		if b != bps[0] || (b != 8 && b != 16) {
+			return nil, fmt.Errorf("%w: %d bits per sample", ErrUnsupported, b)
+		}
+	}
+	m.bps = int(bps[0])
+
+	m.rowsPerStrip = int(min(ifd.Uint(TagRowsPerStrip, uint32(m.height)), uint32(m.height)))
+	nstrips := (m.height + m.rowsPerStrip - 1) / m.rowsPerStrip
+	if m.rowsPerStrip == 0 || len(m.offsets) < nstrips || len(m.counts) < nstrips {
+		return nil, ErrInvalidIFD
+	}
+	return m, nil
+}
+
+// OpenFile opens the TIFF file name like Open. Where the platform
+// supports it, each strip is mapped in memory while its rows are read,
+// so that only the pages of the current strip are resident and
+// uncompressed strips are read without copies. The Image must be closed
+// after use.
+func OpenFile(name string) (*Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m, err := Open(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if canMap {
+		m.file, m.size = f, fi.Size()
+	}
+	m.release = f.Close
+	return m, nil
+}
+
+// Close releases the file of an Image opened with OpenFile. The Image
+// must not be used afterwards, but the images returned by ReadRows
+// remain valid.
+func (m *Image) Close() error {
+	m.unmapStrip()
+	if m.release == nil {
+		return nil
+	}
+	release := m.release
+	m.file, m.release = nil, nil
+	return release()
+}
+
+// unmapStrip releases the mapping of the current strip.
+func (m *Image) unmapStrip() {
+	if m.window != nil {
+		unmap(m.window)
+		m.window = nil
+	}
+	m.strip, m.data = -1, nil
+}
+
+// Bounds returns the bounds of the image.
+func (m *Image) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.width, m.height)
+}
+
+// Deep reports whether the image has 16 bits per sample.
+func (m *Image) Deep() bool {
+	return m.bps == 16
+}
+
+// Alpha reports whether the image has an alpha channel.
+func (m *Image) Alpha() bool {
+	return m.alpha == alphaAssociated || m.alpha == alphaUnassociated
+}
+
+// ColorModel returns the color model of the images returned by ReadRows.
+func (m *Image) ColorModel() color.Model {
+	switch {
+	case m.gray && !m.Alpha() && m.Deep():
+		return color.Gray16Model
+	case m.gray && !m.Alpha():
+		return color.GrayModel
+	case m.alpha == alphaUnassociated && m.Deep():
+		return color.NRGBA64Model
+	case m.alpha == alphaUnassociated:
+		return color.NRGBAModel
+	case m.Deep():
+		return color.RGBA64Model
+	default:
+		return color.RGBAModel
+	}
+}
+
+// ReadRows decodes the rows from y0 to y1 excluded, returned with the
+// same coordinates they have in the full image. The image is an
+// *image.RGBA, *image.RGBA64, *image.Gray or *image.Gray16, or an
+// *image.NRGBA or *image.NRGBA64 for unassociated alpha, following
+// ColorModel.
+func (m *Image) ReadRows(y0, y1 int) (image.Image, error) {
+	r := image.Rect(0, y0, m.width, y1)
+	if r.Empty() || !r.In(m.Bounds()) {
+		return nil, fmt.Errorf("tiff: rows %d to %d out of bounds", y0, y1)
+	}
+
+	var (
+		img    image.Image
+		pix    []uint8
+		stride int
+	)
+	switch m.ColorModel() {
+	case color.Gray16Model:
+		g := image.NewGray16(r)
+		img, pix, stride = g, g.Pix, g.Stride
+	case color.GrayModel:
+		g := image.NewGray(r)
+		img, pix, stride = g, g.Pix, g.Stride
+	case color.NRGBA64Model:
+		n := image.NewNRGBA64(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	case color.NRGBAModel:
+		n := image.NewNRGBA(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	case color.RGBA64Model:
+		n := image.NewRGBA64(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	default:
+		n := image.NewRGBA(r)
+		img, pix, stride = n, n.Pix, n.Stride
+	}
+
+	for y := y0; y < y1; y++ {
+		row, err := m.row(y)
+		if err != nil {
+			return nil, err
+		}
+		m.convert(pix[(y-y0)*stride:], row)
+	}
+	return img, nil
+}
+
+// convert stores the samples of a row in dst, laid out as the pixels of
+// the image returned by ReadRows with 16-bit values in big endian.
+func (m *Image) convert(dst, row []byte) {
+	bytes := m.bps / 8
+	sample := func(x, c int) uint16 {
+		i := (x*m.spp + c) * bytes
+		if bytes == 2 {
+			return m.bo.Uint16(row[i:])
+		}
+		return uint16(row[i])
+	}
+	put := func(i int, v uint16) {
+		if bytes == 2 {
+			binary.BigEndian.PutUint16(dst[i*2:], v)
+		} else {
+			dst[i] = uint8(v)
+		}
+	}
+
+	if m.gray && !m.Alpha() {
+		for x := range m.width {
+			put(x, sample(x, 0))
+		}
+		return
+	}
+
+	max := uint16(1<<m.bps - 1)
+	for x := range m.width {
+		var r, g, b, a uint16
+		if m.gray {
+			r = sample(x, 0)
+			g, b, a = r, r, sample(x, 1)
+		} else {
+			r, g, b, a = sample(x, 0), sample(x, 1), sample(x, 2), max
+			if m.Alpha() {
+				a = sample(x, 3)
+			}
+		}
+		put(x*4, r)
+		put(x*4+1, g)
+		put(x*4+2, b)
+		put(x*4+3, a)
+	}
+}
+
+// row returns the samples of row y, decoding its strip if needed.
+func (m *Image) row(y int) ([]byte, error) {
+	rowSize := m.width * m.spp * m.bps / 8
+	s := y / m.rowsPerStrip
+	if s != m.strip {
+		// Nothing else refers to the previous strip.
+		m.unmapStrip()
+		rows := min(m.rowsPerStrip, m.height-s*m.rowsPerStrip)
+		data, err := m.readStrip(s, rows*rowSize)
+		if err != nil {
+			return nil, err
+		}
+		if m.predictor == 2 {
+			for i := 0; i < len(data); i += rowSize {
+				m.undifference(data[i : i+rowSize])
+			}
+		}
+		m.strip, m.data = s, data
+	}
+
+	i := (y - s*m.rowsPerStrip) * rowSize
+	return m.data[i : i+rowSize], nil
+}
+
+// readStrip reads and decompresses strip s, of size bytes. Strips of
+// mapped files are read in place, and only copied to be undifferenced.
+func (m *Image) readStrip(s, size int) ([]byte, error) {
+	raw, err := m.rawStrip(s)
+	if err != nil {
+		return nil, fmt.Errorf("tiff: strip %d: %w", s, err)
+	}
+	if m.compression == compressionNone {
+		if len(raw) < size {
+			return nil, fmt.Errorf("tiff: strip %d: short data", s)
+		}
+		// Mapped memory is read-only.
+		if m.window != nil && m.predictor != 1 {
+			return bytes.Clone(raw[:size]), nil
+		}
+		return raw[:size], nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("tiff: strip %d: %w", s, err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, fmt.Errorf("tiff: strip %d: %w", s, err)
+	}
+	return data, nil
+}
+
+// rawStrip returns the stored bytes of strip s, truncated if the file
+// ends before them.
+func (m *Image) rawStrip(s int) ([]byte, error) {
+	off, n := int64(m.offsets[s]), int64(m.counts[s])
+	if m.file != nil && off < m.size && n > 0 {
+		win, i, err := mapRange(m.file, off, min(n, m.size-off))
+		if err != nil {
+			return nil, err
+		}
+		m.window = win
+		return win[i:], nil
+	}
+
+	raw := make([]byte, n)
+	k, err := m.r.ReadAt(raw, off)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return raw[:k], err
+}
+
+// undifference reverses the horizontal differencing of a row.
+func (m *Image) undifference(row []byte) {
+	if m.bps == 8 {
+		for i := m.spp; i < len(row); i++ {
+			row[i] += row[i-m.spp]
+		}
+		return
+	}
+	for i := m.spp * 2; i < len(row); i += 2 {
+		m.bo.PutUint16(row[i:], m.bo.Uint16(row[i:])+m.bo.Uint16(row[i-m.spp*2:]))
+	}
+}
+
+// Decode reads a TIFF image from r.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Open(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return m.ReadRows(0, m.height)
+}
+
+// DecodeConfig returns the color model and dimensions of the TIFF image
+// read from r, reading it only up to its first IFD.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	m, err := Open(&buffer{r: r})
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: m.ColorModel(), Width: m.width, Height: m.height}, nil
+}
+
+// buffer reads from r as far as needed to serve ReadAt calls.
+type buffer struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (b *buffer) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidIFD
+	}
+	// Grow in steps, so that bogus offsets past the end of the data do
+	// not allocate their whole extent.
+	for end := off + int64(len(p)); end > int64(len(b.buf)) && b.r != nil; {
+		n := len(b.buf)
+		b.buf = append(b.buf, make([]byte, min(end-int64(n), 1<<20))...)
+		m, err := io.ReadFull(b.r, b.buf[n:])
+		b.buf = b.buf[:n+m]
+		if err != nil {
+			b.r = nil
+		}
+	}
+	if off >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,263 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// Field types defined by the TIFF 6.0 specification.
+const (
+	TypeByte      = 1
+	TypeASCII     = 2
+	TypeShort     = 3
+	TypeLong      = 4
+	TypeRational  = 5
+	TypeSByte     = 6
+	TypeUndefined = 7
+	TypeSShort    = 8
+	TypeSLong     = 9
+	TypeSRational = 10
+	TypeFloat     = 11
+	TypeDouble    = 12
+	TypeIFD       = 13
+)
+
+// Baseline and extension tags used across the prism packages.
+const (
+	TagNewSubfileType            = 254
+	TagImageWidth                = 256
+	TagImageLength               = 257
+	TagBitsPerSample             = 258
+	TagCompression               = 259
+	TagPhotometricInterpretation = 262
+	TagStripOffsets              = 273
+	TagOrientation               = 274
+	TagSamplesPerPixel           = 277
+	TagRowsPerStrip              = 278
+	TagStripByteCounts           = 279
+	TagXResolution               = 282
+	TagYResolution               = 283
+	TagPlanarConfiguration       = 284
+	TagResolutionUnit            = 296
+	TagSoftware                  = 305
+	TagPredictor                 = 317
+	TagTileWidth                 = 322
+	TagTileLength                = 323
+	TagTileOffsets               = 324
+	TagTileByteCounts            = 325
+	TagSubIFDs                   = 330
+	TagExtraSamples              = 338
+	TagSampleFormat              = 339
+	TagICCProfile                = 34675
+)
+
+var typeSizes = [...]int{
+	TypeByte:      1,
+	TypeASCII:     1,
+	TypeShort:     2,
+	TypeLong:      4,
+	TypeRational:  8,
+	TypeSByte:     1,
+	TypeUndefined: 1,
+	TypeSShort:    2,
+	TypeSLong:     4,
+	TypeSRational: 8,
+	TypeFloat:     4,
+	TypeDouble:    8,
+	TypeIFD:       4,
+}
+
+var (
+	ErrInvalidHeader = errors.New("invalid TIFF header")
+	ErrInvalidIFD    = errors.New("invalid TIFF IFD")
+	ErrMissingTag    = errors.New("missing TIFF tag")
+)
+
+// Entry is a single field of an IFD with its raw value bytes.
+type Entry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Data  []byte
+}
+
+// IFD is an image file directory.
+type IFD struct {
+	ByteOrder binary.ByteOrder
+	Entries   map[uint16]Entry
+	Next      uint32
+}
+
+// Reader gives access to the IFDs of a TIFF structured file.
+type Reader struct {
+	r         io.ReaderAt
+	ByteOrder binary.ByteOrder
+	First     uint32
+}
+
+// NewReader parses the TIFF header read from r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	var hdr [8]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	var bo binary.ByteOrder
+	switch string(hdr[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if bo.Uint16(hdr[2:]) != 42 {
+		return nil, ErrInvalidHeader
+	}
+
+	return &Reader{r: r, ByteOrder: bo, First: bo.Uint32(hdr[4:])}, nil
+}
+
+// IFD reads the image file directory stored at the given offset.
+func (t *Reader) IFD(offset uint32) (IFD, error) {
+	bo := t.ByteOrder
+	d := IFD{ByteOrder: bo, Entries: make(map[uint16]Entry)}
+
+	var cnt [2]byte
+	if _, err := t.r.ReadAt(cnt[:], int64(offset)); err != nil {
+		return d, ErrInvalidIFD
+	}
+
+	n := int(bo.Uint16(cnt[:]))
+	buf := make([]byte, n*12+4)
+	if _, err := t.r.ReadAt(buf, int64(offset)+2); err != nil {
+		return d, ErrInvalidIFD
+	}
+
+	for i := range n {
+		raw := buf[i*12 : i*12+12]
+		e := Entry{
+			Tag:   bo.Uint16(raw[0:]),
+			Type:  bo.Uint16(raw[2:]),
+			Count: bo.Uint32(raw[4:]),
+		}
+		if int(e.Type) >= len(typeSizes) || typeSizes[e.Type] == 0 {
+			// Unknown types are skipped as mandated by the spec.
+			continue
+		}
+
+		size := int64(typeSizes[e.Type]) * int64(e.Count)
+		if size <= 4 {
+			e.Data = raw[8 : 8+size]
+		} else {
+			e.Data = make([]byte, size)
+			if _, err := t.r.ReadAt(e.Data, int64(bo.Uint32(raw[8:]))); err != nil {
+				return d, ErrInvalidIFD
+			}
+		}
+		d.Entries[e.Tag] = e
+	}
+
+	d.Next = bo.Uint32(buf[n*12:])
+	return d, nil
+}
+
+// Has reports whether the tag is present in the IFD.
+func (d IFD) Has(tag uint16) bool {
+	_, ok := d.Entries[tag]
+	return ok
+}
+
+// Uints returns the values of an integer field.
+func (d IFD) Uints(tag uint16) []uint32 {
+	e, ok := d.Entries[tag]
+	if !ok {
+		return nil
+	}
+
+	vals := make([]uint32, e.Count)
+	for i := range vals {
+		switch e.Type {
+		case TypeByte, TypeUndefined:
+			vals[i] = uint32(e.Data[i])
+		case TypeSByte:
+			vals[i] = uint32(int8(e.Data[i]))
+		case TypeShort:
+			vals[i] = uint32(d.ByteOrder.Uint16(e.Data[i*2:]))
+		case TypeSShort:
+			vals[i] = uint32(int16(d.ByteOrder.Uint16(e.Data[i*2:])))
+		case TypeLong, TypeSLong, TypeIFD:
+			vals[i] = d.ByteOrder.Uint32(e.Data[i*4:])
+		default:
+			return nil
+		}
+	}
+	return vals
+}
+
+// Uint returns the first value of an integer field, or def if absent.
+func (d IFD) Uint(tag uint16, def uint32) uint32 {
+	if v := d.Uints(tag); len(v) > 0 {
+		return v[0]
+	}
+	return def
+}
+
+// Floats returns the values of a numeric field of any type as float64.
+func (d IFD) Floats(tag uint16) []float64 {
+	e, ok := d.Entries[tag]
+	if !ok {
+		return nil
+	}
+
+	bo := d.ByteOrder
+	vals := make([]float64, e.Count)
+	switch e.Type {
+	case TypeRational, TypeSRational:
+		for i := range vals {
+			num, den := bo.Uint32(e.Data[i*8:]), bo.Uint32(e.Data[i*8+4:])
+			if e.Type == TypeSRational {
+				vals[i] = float64(int32(num)) / float64(int32(den))
+			} else {
+				vals[i] = float64(num) / float64(den)
+			}
+		}
+	case TypeFloat:
+		for i := range vals {
+			vals[i] = float64(math.Float32frombits(bo.Uint32(e.Data[i*4:])))
+		}
+	case TypeDouble:
+		for i := range vals {
+			vals[i] = math.Float64frombits(bo.Uint64(e.Data[i*8:]))
+		}
+	default:
+		ints := d.Uints(tag)
+		if ints == nil {
+			return nil
+		}
+		for i, v := range ints {
+			if e.Type == TypeSByte || e.Type == TypeSShort || e.Type == TypeSLong {
+				vals[i] = float64(int32(v))
+			} else {
+				vals[i] = float64(v)
+			}
+		}
+	}
+	return vals
+}
+
+// String returns the value of an ASCII field without the NUL terminator.
+func (d IFD) String(tag uint16) string {
+	e, ok := d.Entries[tag]
+	if !ok {
+		return ""
+	}
+
+	s := e.Data
+	for len(s) > 0 && s[len(s)-1] == 0 {
+		s = s[:len(s)-1]
+	}
+	return string(s)
+}
@@ -0,0 +1,258 @@
+package tiff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// pattern returns a w x h image of the given model with every channel
+// varying across it, and alpha too for the models having it.
+func pattern(model color.Model, w, h int) image.Image {
+	r := image.Rect(0, 0, w, h)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch model {
+	case color.RGBA64Model:
+		img = image.NewRGBA64(r)
+	case color.NRGBAModel:
+		img = image.NewNRGBA(r)
+	case color.NRGBA64Model:
+		img = image.NewNRGBA64(r)
+	default:
+		img = image.NewRGBA(r)
+	}
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA64{
+				R: uint16(x * 0xffff / max(1, w-1)),
+				G: uint16(y * 0xffff / max(1, h-1)),
+				B: uint16((x*7919 + y*104729) % 0x10000),
+				A: 0xffff,
+			}
+			if model == color.NRGBAModel || model == color.NRGBA64Model {
+				c.A = uint16((x + y) * 0xffff / max(1, w+h-2))
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// equal fails the test at the first pixel of got differing from want,
+// compared in the color model of got.
+func equal(t *testing.T, want, got image.Image) {
+	t.Helper()
+	b := got.Bounds()
+	if !b.In(want.Bounds()) {
+		t.Fatalf("got bounds %v, out of %v", b, want.Bounds())
+	}
+	model := got.ColorModel()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := got.At(x, y), model.Convert(want.At(x, y)); g != w {
+				t.Fatalf("pixel (%d, %d): got %v, want %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	models := []color.Model{
+		color.RGBAModel,
+		color.RGBA64Model,
+		color.NRGBAModel,
+		color.NRGBA64Model,
+	}
+	sizes := []image.Point{{1, 1}, {5, 3}, {37, 29}}
+
+	for _, model := range models {
+		for _, size := range sizes {
+			img := pattern(model, size.X, size.Y)
+			var buf bytes.Buffer
+			if err := Encode(&buf, img); err != nil {
+				t.Fatal(err)
+			}
+
+			dec, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dec.ColorModel() != model {
+				t.Errorf("%v: got model %v, want %v", size, dec.ColorModel(), model)
+			}
+			if dec.Bounds() != img.Bounds() {
+				t.Fatalf("got bounds %v, want %v", dec.Bounds(), img.Bounds())
+			}
+			equal(t, img, dec)
+		}
+	}
+}
+
+func TestEncodeTags(t *testing.T) {
+	profile := []byte("not quite an ICC profile")
+	tests := []struct {
+		model    color.Model
+		bps, spp uint32
+		profile  []byte
+	}{
+		{color.RGBAModel, 8, 3, nil},
+		{color.RGBA64Model, 16, 3, nil},
+		{color.NRGBAModel, 8, 4, profile},
+		{color.NRGBA64Model, 16, 4, profile},
+	}
+
+	for _, tt := range tests {
+		const w, h = 300, 200
+		var buf bytes.Buffer
+		if err := EncodeProfile(&buf, pattern(tt.model, w, h), tt.profile); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ifd, err := r.IFD(r.First)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		uints := []struct {
+			tag  uint16
+			want uint32
+		}{
+			{TagImageWidth, w},
+			{TagImageLength, h},
+			{TagCompression, 1},
+			{TagPhotometricInterpretation, 2},
+			{TagSamplesPerPixel, tt.spp},
+			{TagPlanarConfiguration, 1},
+			{TagResolutionUnit, 2},
+		}
+		for _, u := range uints {
+			if got := ifd.Uint(u.tag, 0); got != u.want {
+				t.Errorf("%v: tag %d: got %d, want %d", tt.model, u.tag, got, u.want)
+			}
+		}
+		if got, want := ifd.Uints(TagBitsPerSample), slices.Repeat([]uint32{tt.bps}, int(tt.spp)); !slices.Equal(got, want) {
+			t.Errorf("%v: got BitsPerSample %v, want %v", tt.model, got, want)
+		}
+		if got := ifd.Floats(TagXResolution); !slices.Equal(got, []float64{72}) {
+			t.Errorf("%v: got XResolution %v, want 72", tt.model, got)
+		}
+		if got := ifd.Uints(TagExtraSamples); tt.spp == 4 && !slices.Equal(got, []uint32{alphaUnassociated}) || tt.spp == 3 && got != nil {
+			t.Errorf("%v: got ExtraSamples %v", tt.model, got)
+		}
+		if got := ifd.Entries[TagICCProfile].Data; !bytes.Equal(got, tt.profile) {
+			t.Errorf("%v: got profile %q, want %q", tt.model, got, tt.profile)
+		}
+
+		// Strips hold whole rows, follow each other and end the file.
+		rowSize := w * tt.spp * tt.bps / 8
+		rowsPerStrip := ifd.Uint(TagRowsPerStrip, 0)
+		offsets, counts := ifd.Uints(TagStripOffsets), ifd.Uints(TagStripByteCounts)
+		if rowsPerStrip == 0 || rowsPerStrip*rowSize > stripSize {
+			t.Errorf("%v: got %d rows per strip of %d bytes", tt.model, rowsPerStrip, rowSize)
+		}
+		if n := (h + rowsPerStrip - 1) / rowsPerStrip; len(offsets) != int(n) || len(counts) != int(n) {
+			t.Fatalf("%v: got %d offsets and %d counts, want %d", tt.model, len(offsets), len(counts), n)
+		}
+		for i := range counts {
+			if want := min(rowsPerStrip, h-uint32(i)*rowsPerStrip) * rowSize; counts[i] != want {
+				t.Errorf("%v: strip %d: got %d bytes, want %d", tt.model, i, counts[i], want)
+			}
+			if i > 0 && offsets[i] != offsets[i-1]+counts[i-1] {
+				t.Errorf("%v: strip %d at %d, want %d", tt.model, i, offsets[i], offsets[i-1]+counts[i-1])
+			}
+		}
+		if end := offsets[len(offsets)-1] + counts[len(counts)-1]; int(end) != buf.Len() {
+			t.Errorf("%v: strips end at %d, in a file of %d bytes", tt.model, end, buf.Len())
+		}
+	}
+}
+
+func TestReadRowsStrips(t *testing.T) {
+	// 8-bit RGB rows of 300 bytes make strips of 218 rows.
+	img := pattern(color.RGBAModel, 100, 500)
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "strips.tif")
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opens := map[string]func() (*Image, error){
+		"Open":     func() (*Image, error) { return Open(bytes.NewReader(buf.Bytes())) },
+		"OpenFile": func() (*Image, error) { return OpenFile(name) },
+	}
+	for open, fn := range opens {
+		for _, strip := range []int{1, 7, 218, 219, 500} {
+			t.Run(fmt.Sprintf("%s/%d", open, strip), func(t *testing.T) {
+				m, err := fn()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer m.Close()
+				if m.rowsPerStrip != 218 {
+					t.Fatalf("got %d rows per strip, want 218", m.rowsPerStrip)
+				}
+				for y := 0; y < 500; y += strip {
+					rows, err := m.ReadRows(y, min(y+strip, 500))
+					if err != nil {
+						t.Fatal(err)
+					}
+					equal(t, img, rows)
+				}
+				// Strips already read can be read again.
+				rows, err := m.ReadRows(210, 230)
+				if err != nil {
+					t.Fatal(err)
+				}
+				equal(t, img, rows)
+			})
+		}
+	}
+}
+
+func TestEncoderRows(t *testing.T) {
+	var buf bytes.Buffer
+	e, err := NewEncoder(&buf, 4, 4, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WriteRows(pattern(color.RGBAModel, 5, 2)); err == nil {
+		t.Error("wrote rows wider than the image")
+	}
+	if err := e.WriteRows(pattern(color.RGBAModel, 4, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err == nil {
+		t.Error("closed the image with 2 of 4 rows written")
+	}
+}
+
+func TestReadRowsOutOfBounds(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, pattern(color.RGBAModel, 4, 4)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]int{{-1, 2}, {2, 5}, {3, 3}} {
+		if _, err := m.ReadRows(r[0], r[1]); err == nil {
+			t.Errorf("read rows %d to %d of 4", r[0], r[1])
+		}
+	}
+}
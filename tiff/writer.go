@@ -0,0 +1,283 @@
+package tiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"slices"
+)
+
+// stripSize is the target size in bytes of each strip written by Encode.
+const stripSize = 64 * 1024
+
+// field is an IFD entry to be written.
+type field struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shortField(tag uint16, vals ...uint16) field {
+	data := make([]byte, 2*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint16(data[i*2:], v)
+	}
+	return field{tag: tag, typ: TypeShort, count: uint32(len(vals)), data: data}
+}
+
+func longField(tag uint16, vals ...uint32) field {
+	data := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(data[i*4:], v)
+	}
+	return field{tag: tag, typ: TypeLong, count: uint32(len(vals)), data: data}
+}
+
+func rationalField(tag uint16, num, den uint32) field {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data, num)
+	binary.LittleEndian.PutUint32(data[4:], den)
+	return field{tag: tag, typ: TypeRational, count: 1, data: data}
+}
+
+// is16 reports whether the image carries more than 8 bits per channel.
+func is16(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	default:
+		return false
+	}
+}
+
+// isOpaque reports whether every pixel of the image is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// Encode writes the image to w as an uncompressed baseline TIFF.
+// 16-bit images are written with 16 bits per sample, everything else
+// with 8. An unassociated alpha channel is written for non-opaque images.
+func Encode(w io.Writer, img image.Image) error {
+	return EncodeProfile(w, img, nil)
+}
+
+// EncodeProfile is like Encode, embedding the ICC profile in the image
+// unless it is nil.
+func EncodeProfile(w io.Writer, img image.Image, profile []byte) error {
+	b := img.Bounds()
+	e, err := NewEncoderProfile(w, b.Dx(), b.Dy(), is16(img), !isOpaque(img), profile)
+	if err != nil {
+		return err
+	}
+	if err := e.WriteRows(img); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// Encoder writes an uncompressed baseline TIFF a few rows at a time, so
+// that images too large for memory can be written as they are produced.
+type Encoder struct {
+	bw       *bufio.Writer
+	width    int
+	height   int
+	spp, bps int
+	row      []byte
+	y        int
+}
+
+// NewEncoder writes the header of a width by height TIFF image to w, with
+// 16 bits per sample if deep and an unassociated alpha channel if alpha.
+// The pixels are then written with WriteRows.
+func NewEncoder(w io.Writer, width, height int, deep, alpha bool) (*Encoder, error) {
+	return NewEncoderProfile(w, width, height, deep, alpha, nil)
+}
+
+// NewEncoderProfile is like NewEncoder, embedding the ICC profile in the
+// image unless it is nil.
+func NewEncoderProfile(w io.Writer, width, height int, deep, alpha bool, profile []byte) (*Encoder, error) {
+	bps := 8
+	if deep {
+		bps = 16
+	}
+	spp := 3
+	if alpha {
+		spp = 4
+	}
+
+	rowSize := width * spp * bps / 8
+	rowsPerStrip := max(1, min(height, stripSize/max(1, rowSize)))
+	nstrips := (height + rowsPerStrip - 1) / rowsPerStrip
+
+	bpsVals := make([]uint16, spp)
+	for i := range bpsVals {
+		bpsVals[i] = uint16(bps)
+	}
+
+	offsets := make([]uint32, nstrips)
+	counts := make([]uint32, nstrips)
+	for i := range nstrips {
+		rows := min(rowsPerStrip, height-i*rowsPerStrip)
+		counts[i] = uint32(rows * rowSize)
+	}
+
+	fields := []field{
+		longField(TagImageWidth, uint32(width)),
+		longField(TagImageLength, uint32(height)),
+		shortField(TagBitsPerSample, bpsVals...),
+		shortField(TagCompression, 1),
+		shortField(TagPhotometricInterpretation, 2),
+		longField(TagStripOffsets, offsets...),
+		shortField(TagSamplesPerPixel, uint16(spp)),
+		longField(TagRowsPerStrip, uint32(rowsPerStrip)),
+		longField(TagStripByteCounts, counts...),
+		rationalField(TagXResolution, 72, 1),
+		rationalField(TagYResolution, 72, 1),
+		shortField(TagPlanarConfiguration, 1),
+		shortField(TagResolutionUnit, 2),
+	}
+	if spp == 4 {
+		fields = append(fields, shortField(TagExtraSamples, 2))
+	}
+	if profile != nil {
+		fields = append(fields, field{tag: TagICCProfile, typ: TypeUndefined, count: uint32(len(profile)), data: profile})
+	}
+
+	// Pixel data follows the header, the IFD and its out-of-line values.
+	dataStart := 8 + ifdSize(fields)
+	for i := range offsets {
+		offsets[i] = uint32(dataStart)
+		dataStart += int(counts[i])
+	}
+	fields[5] = longField(TagStripOffsets, offsets...)
+
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, fields); err != nil {
+		return nil, err
+	}
+	return &Encoder{
+		bw:     bw,
+		width:  width,
+		height: height,
+		spp:    spp,
+		bps:    bps,
+		row:    make([]byte, rowSize),
+	}, nil
+}
+
+// WriteRows writes every row of img, which must be as wide as the image
+// and follow the rows already written.
+func (e *Encoder) WriteRows(img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != e.width || e.y+b.Dy() > e.height {
+		return fmt.Errorf("tiff: %dx%d rows do not fit the image", b.Dx(), b.Dy())
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		encodeRow(e.row, img, y, e.spp, e.bps)
+		if _, err := e.bw.Write(e.row); err != nil {
+			return err
+		}
+	}
+	e.y += b.Dy()
+	return nil
+}
+
+// Close flushes the image, failing if some of its rows were not written.
+func (e *Encoder) Close() error {
+	if e.y != e.height {
+		return fmt.Errorf("tiff: %d of %d rows written", e.y, e.height)
+	}
+	return e.bw.Flush()
+}
+
+// ifdSize returns the size in bytes of an IFD holding the given fields,
+// including its out-of-line values.
+func ifdSize(fields []field) int {
+	size := 2 + 12*len(fields) + 4
+	for _, f := range fields {
+		if len(f.data) > 4 {
+			size += len(f.data) + len(f.data)%2
+		}
+	}
+	return size
+}
+
+// writeHeader writes the little-endian TIFF header followed by a single
+// IFD holding the given fields.
+func writeHeader(w io.Writer, fields []field) error {
+	slices.SortFunc(fields, func(a, b field) int {
+		return int(a.tag) - int(b.tag)
+	})
+
+	le := binary.LittleEndian
+	hdr := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	ifd := le.AppendUint16(nil, uint16(len(fields)))
+
+	var extra []byte
+	extraOff := 8 + 2 + 12*len(fields) + 4
+	for _, f := range fields {
+		ifd = le.AppendUint16(ifd, f.tag)
+		ifd = le.AppendUint16(ifd, f.typ)
+		ifd = le.AppendUint32(ifd, f.count)
+
+		if len(f.data) <= 4 {
+			var val [4]byte
+			copy(val[:], f.data)
+			ifd = append(ifd, val[:]...)
+			continue
+		}
+
+		ifd = le.AppendUint32(ifd, uint32(extraOff+len(extra)))
+		extra = append(extra, f.data...)
+		if len(f.data)%2 != 0 {
+			extra = append(extra, 0)
+		}
+	}
+	// No further IFDs.
+	ifd = le.AppendUint32(ifd, 0)
+
+	for _, chunk := range [][]byte{hdr, ifd, extra} {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeRow fills buf with the non-premultiplied samples of row y.
+func encodeRow(buf []byte, img image.Image, y, spp, bps int) {
+	b := img.Bounds()
+	i := 0
+
+	// Straight 8-bit samples are copied as they are.
+	if m, ok := img.(*image.NRGBA); ok && bps == 8 {
+		pix := m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := range b.Dx() {
+			i += copy(buf[i:i+spp], pix[x*4:])
+		}
+		return
+	}
+
+	for x := b.Min.X; x < b.Max.X; x++ {
+		c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+		samples := [4]uint16{c.R, c.G, c.B, c.A}
+
+		for _, s := range samples[:spp] {
+			if bps == 16 {
+				binary.LittleEndian.PutUint16(buf[i:], s)
+				i += 2
+			} else {
+				buf[i] = uint8(s >> 8)
+				i++
+			}
+		}
+	}
+}
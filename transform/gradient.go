@@ -0,0 +1,71 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Direction is the direction a Gradient runs in.
+type Direction int
+
+const (
+	LeftRight Direction = iota
+	RightLeft
+	TopBottom
+	BottomTop
+	// Radial runs from the center to the corners.
+	Radial
+)
+
+// Gradient is a grayscale image ramping linearly from white at its start
+// to black at its end, computed on the fly.
+type Gradient struct {
+	rect image.Rectangle
+	dir  Direction
+}
+
+// NewGradient returns a gradient with bounds r running in the direction
+// dir.
+func NewGradient(r image.Rectangle, dir Direction) *Gradient {
+	return &Gradient{rect: r, dir: dir}
+}
+
+func (g *Gradient) ColorModel() color.Model {
+	return color.Gray16Model
+}
+
+func (g *Gradient) Bounds() image.Rectangle {
+	return g.rect
+}
+
+func (g *Gradient) At(x, y int) color.Color {
+	return g.Gray16At(x, y)
+}
+
+// Gray16At returns the gray level of the pixel at (x, y).
+func (g *Gradient) Gray16At(x, y int) color.Gray16 {
+	t := max(0, min(1, g.pos(x, y)))
+	return color.Gray16{Y: uint16(math.Round((1 - t) * 0xffff))}
+}
+
+// pos returns the position of the center of the pixel at (x, y) along
+// the gradient, from 0 at its start to 1 at its end.
+func (g *Gradient) pos(x, y int) float64 {
+	w, h := float64(g.rect.Dx()), float64(g.rect.Dy())
+	fx := (float64(x-g.rect.Min.X) + 0.5) / w
+	fy := (float64(y-g.rect.Min.Y) + 0.5) / h
+
+	switch g.dir {
+	case RightLeft:
+		return 1 - fx
+	case TopBottom:
+		return fy
+	case BottomTop:
+		return 1 - fy
+	case Radial:
+		return math.Hypot((fx-0.5)*w, (fy-0.5)*h) / (math.Hypot(w, h) / 2)
+	default:
+		return fx
+	}
+}
@@ -0,0 +1,228 @@
+package transform
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ToRGBA returns the image as an *image.RGBA, converting it if needed.
+func ToRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out
+}
+
+// Buffer is an image stored as interleaved RGBA samples, at 8 or 16
+// bits per channel.
+type Buffer interface {
+	*image.RGBA | *image.NRGBA | *image.RGBA64 | *image.NRGBA64
+	image.Image
+	PixOffset(x, y int) int
+}
+
+// pix returns the samples of img and the size in bytes of a pixel.
+func pix[T Buffer](img T) ([]uint8, int) {
+	switch i := any(img).(type) {
+	case *image.RGBA:
+		return i.Pix, 4
+	case *image.NRGBA:
+		return i.Pix, 4
+	case *image.RGBA64:
+		return i.Pix, 8
+	case *image.NRGBA64:
+		return i.Pix, 8
+	}
+	panic("unreachable")
+}
+
+// newLike returns a new image of the same type as img with bounds r.
+func newLike[T Buffer](img T, r image.Rectangle) T {
+	var out any
+	switch any(img).(type) {
+	case *image.RGBA:
+		out = image.NewRGBA(r)
+	case *image.NRGBA:
+		out = image.NewNRGBA(r)
+	case *image.RGBA64:
+		out = image.NewRGBA64(r)
+	case *image.NRGBA64:
+		out = image.NewNRGBA64(r)
+	}
+	return out.(T)
+}
+
+// Rotate90 rotates the image by 90 degrees clockwise.
+func Rotate90[T Buffer](img T) T {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := newLike(img, image.Rect(0, 0, h, w))
+
+	for y := range h {
+		for x := range w {
+			copyPixel(out, h-1-y, x, img, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return out
+}
+
+// Rotate180 rotates the image by 180 degrees.
+func Rotate180[T Buffer](img T) T {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := newLike(img, image.Rect(0, 0, w, h))
+
+	for y := range h {
+		for x := range w {
+			copyPixel(out, w-1-x, h-1-y, img, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return out
+}
+
+// Rotate270 rotates the image by 270 degrees clockwise (90 counter-clockwise).
+func Rotate270[T Buffer](img T) T {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := newLike(img, image.Rect(0, 0, h, w))
+
+	for y := range h {
+		for x := range w {
+			copyPixel(out, y, w-1-x, img, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return out
+}
+
+// FlipH mirrors the image horizontally.
+func FlipH[T Buffer](img T) T {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := newLike(img, image.Rect(0, 0, w, h))
+
+	for y := range h {
+		for x := range w {
+			copyPixel(out, w-1-x, y, img, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return out
+}
+
+// FlipV mirrors the image vertically.
+func FlipV[T Buffer](img T) T {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := newLike(img, image.Rect(0, 0, w, h))
+
+	src, n := pix(img)
+	dst, _ := pix(out)
+	for y := range h {
+		srcOff := img.PixOffset(b.Min.X, b.Min.Y+y)
+		dstOff := out.PixOffset(0, h-1-y)
+		copy(dst[dstOff:dstOff+w*n], src[srcOff:srcOff+w*n])
+	}
+	return out
+}
+
+// Rotate rotates the image clockwise by the given amount of degrees,
+// which must be a multiple of 90.
+func Rotate[T Buffer](img T, degrees int) T {
+	switch ((degrees % 360) + 360) % 360 {
+	case 90:
+		return Rotate90(img)
+	case 180:
+		return Rotate180(img)
+	case 270:
+		return Rotate270(img)
+	default:
+		return img
+	}
+}
+
+// Orient applies the transformation described by an EXIF orientation
+// value (1-8) so that the image is displayed upright.
+func Orient[T Buffer](img T, orientation int) T {
+	switch orientation {
+	case 2:
+		return FlipH(img)
+	case 3:
+		return Rotate180(img)
+	case 4:
+		return FlipV(img)
+	case 5:
+		return FlipH(Rotate90(img))
+	case 6:
+		return Rotate90(img)
+	case 7:
+		return FlipH(Rotate270(img))
+	case 8:
+		return Rotate270(img)
+	default:
+		return img
+	}
+}
+
+// copyPixel copies a single pixel from src at (sx, sy) to dst at (dx, dy).
+func copyPixel[T Buffer](dst T, dx, dy int, src T, sx, sy int) {
+	d := dst.PixOffset(dx, dy)
+	s := src.PixOffset(sx, sy)
+	dp, n := pix(dst)
+	sp, _ := pix(src)
+	copy(dp[d:d+n], sp[s:s+n])
+}
+
+// Resize scales the image to w x h pixels averaging the source pixels
+// covered by each destination pixel.
+func Resize(img *image.RGBA, w, h int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	if sw == 0 || sh == 0 {
+		return out
+	}
+
+	for y := range h {
+		y0 := y * sh / h
+		y1 := max(y0+1, (y+1)*sh/h)
+
+		for x := range w {
+			x0 := x * sw / w
+			x1 := max(x0+1, (x+1)*sw/w)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				off := img.PixOffset(b.Min.X+x0, b.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := range 4 {
+						sum[c] += int(img.Pix[off+c])
+					}
+					off += 4
+				}
+			}
+
+			n := (x1 - x0) * (y1 - y0)
+			d := out.PixOffset(x, y)
+			for c := range 4 {
+				out.Pix[d+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return out
+}
+
+// Fit downscales the image to fit within maxW x maxH pixels keeping its
+// aspect ratio. Images already fitting are returned unchanged.
+func Fit(img *image.RGBA, maxW, maxH int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxW && h <= maxH {
+		return img
+	}
+
+	scale := min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	return Resize(img, max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale)))
+}